@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"snc/internal/synchronizer"
+)
+
+// runCheck executes the check subcommand and returns the process exit code
+func runCheck(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	// Keep stdout reserved for the diff listing
+	logger.SetOutput(os.Stderr)
+
+	sn := synchronizer.NewSynchronizer(cfgProvider)
+	diffs, err := sn.Check(ctx)
+	if printErr := printDifferences(os.Stdout, diffs, cfgProvider.Config()); printErr != nil {
+		logger.Error("MAIN", "Failed to print differences: %v", printErr)
+		return 1
+	}
+	if err != nil {
+		logger.Error("MAIN", "Check completed with errors: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// runAudit executes the audit subcommand and returns the process exit code:
+// 0 if the target is a faithful mirror, 1 if it differs and 2 if the
+// comparison failed
+func runAudit(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	// Keep stdout reserved for the diff listing
+	logger.SetOutput(os.Stderr)
+
+	sn := synchronizer.NewSynchronizer(cfgProvider)
+	diffs, err := sn.Audit(ctx)
+	if printErr := printDifferences(os.Stdout, diffs, cfgProvider.Config()); printErr != nil {
+		logger.Error("MAIN", "Failed to print differences: %v", printErr)
+		return 2
+	}
+	if err != nil {
+		logger.Error("MAIN", "Audit could not be completed: %v", err)
+		return 2
+	}
+
+	if len(diffs) > 0 {
+		logger.Warn("MAIN", "Target differs from source: %d differences", len(diffs))
+		return 1
+	}
+	logger.Success("MAIN", "Target is a faithful mirror of source")
+	return 0
+}
+
+// printDifferences writes the itemized diff to w as a JSON array with
+// cfg.JSON, otherwise as aligned text lines with escaped paths, or ended
+// by NUL with unescaped paths with cfg.Print0
+func printDifferences(w io.Writer, diffs []stream.Difference, cfg *config.Config) error {
+	if cfg.JSON {
+		if diffs == nil {
+			diffs = []stream.Difference{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	}
+
+	for _, d := range diffs {
+		line := fmt.Sprintf("%-16s %s\n", d.Kind, logger.EscapePath(d.Path))
+		if cfg.Print0 {
+			line = fmt.Sprintf("%-16s %s\x00", d.Kind, d.Path)
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
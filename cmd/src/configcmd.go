@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+)
+
+// runConfig executes the config show and config init subcommands and
+// returns the process exit code
+func runConfig(provider *config.MultiProvider) int {
+	// Keep stdout reserved for the settings
+	logger.SetOutput(os.Stderr)
+
+	var err error
+	if provider.Config().Command == config.CommandConfigInit {
+		err = config.WriteTemplate(os.Stdout, provider.Settings())
+	} else {
+		err = printSettings(os.Stdout, provider.Settings(), provider.Config().JSON)
+	}
+	if err != nil {
+		logger.Error("MAIN", "Failed to print configuration: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// printSettings writes the effective settings to w, either as lines in the
+// config file format annotated with their source or as a JSON array.
+// Secrets such as webhook URLs are redacted.
+func printSettings(w io.Writer, settings []config.Setting, asJSON bool) error {
+	redacted := make([]config.Setting, len(settings))
+	for i, s := range settings {
+		redacted[i] = s.Redacted()
+	}
+	settings = redacted
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(settings)
+	}
+
+	for _, s := range settings {
+		line := fmt.Sprintf("%s = %s", s.Name, s.Value)
+		if _, err := fmt.Fprintf(w, "%-40s # %s\n", line, s.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"snc/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestPrintSettingsRedactsWebhooks(t *testing.T) {
+	provider, err := config.Load([]string{"config", "show", "--webhook", "https://hooks.slack.com/services/T000/B000/secret?token=x", "/src", "/dst"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, asJSON := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := printSettings(&buf, provider.Settings(), asJSON); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		out := buf.String()
+		if strings.Contains(out, "/services/") || strings.Contains(out, "secret") || strings.Contains(out, "token") {
+			t.Errorf("Expected the webhook path and query to be left out (JSON %v), got:\n%s", asJSON, out)
+		}
+		if !strings.Contains(out, "hooks.slack.com") {
+			t.Errorf("Expected the webhook host to be shown (JSON %v), got:\n%s", asJSON, out)
+		}
+	}
+}
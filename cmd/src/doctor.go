@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"snc/internal/synchronizer"
+	"strings"
+)
+
+// runDoctor executes the doctor subcommand and returns the process exit
+// code: 0 if all checks passed, 1 if some gave warnings and 2 if they
+// could not be run
+func runDoctor(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	// Keep stdout reserved for the findings
+	logger.SetOutput(os.Stderr)
+
+	sn := synchronizer.NewSynchronizer(cfgProvider)
+	findings, err := sn.Doctor(ctx)
+	if printErr := printFindings(os.Stdout, findings, cfgProvider.Config().JSON); printErr != nil {
+		logger.Error("MAIN", "Failed to print findings: %v", printErr)
+		return 2
+	}
+	if err != nil {
+		logger.Error("MAIN", "Doctor could not be completed: %v", err)
+		return 2
+	}
+
+	warnings := 0
+	for _, f := range findings {
+		if f.Status == stream.DoctorWarning {
+			warnings++
+		}
+	}
+	if warnings > 0 {
+		logger.Warn("MAIN", "%d checks found problems, see above before syncing", warnings)
+		return 1
+	}
+	logger.Success("MAIN", "No problems found")
+	return 0
+}
+
+// printFindings writes the findings to w as a JSON array with asJSON,
+// otherwise as one aligned line per check
+func printFindings(w io.Writer, findings []stream.Finding, asJSON bool) error {
+	if asJSON {
+		if findings == nil {
+			findings = []stream.Finding{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(findings)
+	}
+
+	for _, f := range findings {
+		line := fmt.Sprintf("%-8s %-17s %s\n", strings.ToUpper(string(f.Status)), f.Check, f.Message)
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,35 +1,217 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"os"
+	"os/signal"
 	"snc/internal/config"
+	"snc/internal/control"
+	"snc/internal/daemon"
 	"snc/internal/logger"
+	"snc/internal/metrics"
+	"snc/internal/priority"
 	"snc/internal/synchronizer"
+	"snc/internal/watch"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	cfgProvider, err := config.ParseFlags()
+	cfgProvider, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
 	if err != nil {
 		logger.Error("MAIN", "Failed to parse configuration: %v", err)
 		os.Exit(2)
 	}
 
-	// Set log level from config if available
-	if cfgProvider.Config().LogLevel != "" {
-		logger.SetLevelFromString(cfgProvider.Config().LogLevel)
+	applyLogSettings(cfgProvider.Config())
+	for _, s := range cfgProvider.Settings() {
+		s = s.Redacted()
+		logger.Debug("CONFIG", "%s = %q (from %s)", s.Name, s.Value, s.Source)
+	}
+
+	if cfgProvider.Config().Nice {
+		if err := priority.Lower(); err != nil {
+			logger.Warn("MAIN", "Failed to lower the process priority: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch cfgProvider.Config().Command {
+	case config.CommandCheck:
+		os.Exit(runCheck(ctx, cfgProvider))
+	case config.CommandAudit:
+		os.Exit(runAudit(ctx, cfgProvider))
+	case config.CommandDoctor:
+		os.Exit(runDoctor(ctx, cfgProvider))
+	case config.CommandVerifyLog:
+		os.Exit(runVerifyLog(cfgProvider.Config()))
+	case config.CommandApply:
+		os.Exit(runApply(ctx, cfgProvider))
+	case config.CommandScrub:
+		os.Exit(runScrub(ctx, cfgProvider))
+	case config.CommandConfigShow, config.CommandConfigInit:
+		os.Exit(runConfig(cfgProvider))
+	case config.CommandServiceInstall, config.CommandServiceUninstall, config.CommandServiceStart,
+		config.CommandServiceStop, config.CommandServiceRun:
+		os.Exit(runService(ctx, cfgProvider))
+	case config.CommandRetry:
+		sn := synchronizer.NewSynchronizer(cfgProvider)
+		if err := sn.Retry(ctx); err != nil {
+			logger.Error("MAIN", "Retry completed with errors: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case config.CommandDecrypt:
+		sn := synchronizer.NewSynchronizer(cfgProvider)
+		if err := sn.Decrypt(ctx); err != nil {
+			logger.Error("MAIN", "Decryption completed with errors: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if cfgProvider.Config().DryRun {
+		os.Exit(runDryRun(ctx, cfgProvider))
+	}
+
+	if cfgProvider.Config().JSON && cfgProvider.Config().Interval == 0 {
+		// Keep stdout reserved for the summary
+		logger.SetOutput(os.Stderr)
 	}
 
 	logger.Info("MAIN", "Starting file synchronization tool")
 	logger.Info("MAIN", "Source: %s, Target: %s, Delete missing: %v",
 		cfgProvider.Config().Source,
-		cfgProvider.Config().Target,
+		strings.Join(cfgProvider.Config().Targets, ", "),
 		cfgProvider.Config().DeleteMissing)
 
-	sn := synchronizer.NewSynchronizer(cfgProvider)
-	if err := sn.Sync(); err != nil {
+	if addr := cfgProvider.Config().MetricsAddr; addr != "" {
+		if err := metrics.Serve(addr); err != nil {
+			logger.Error("MAIN", "Failed to start metrics endpoint: %v", err)
+			os.Exit(2)
+		}
+	}
+
+	if cfgProvider.Config().Interval > 0 {
+		os.Exit(runDaemon(ctx, cfgProvider))
+	}
+
+	opts := deleteConfirmation(cfgProvider.Config())
+	codes := newErrorCodeSink()
+	if cfgProvider.Config().JSON {
+		opts = append(opts, synchronizer.WithEventSink(codes))
+	}
+
+	start := time.Now()
+	sn := synchronizer.NewSynchronizer(cfgProvider, opts...)
+	err = sn.Sync(ctx)
+	if cfgProvider.Config().Notify {
+		notifyDesktop(cfgProvider.Config(), sn.Stats(), time.Since(start), err)
+	}
+	if cfgProvider.Config().JSON {
+		if printErr := printSummary(os.Stdout, sn.Stats(), codes, time.Since(start), err == nil); printErr != nil {
+			logger.Error("MAIN", "Failed to print summary: %v", printErr)
+		}
+	}
+	if err != nil {
 		logger.Error("MAIN", "Sync completed with errors: %v", err)
 		os.Exit(1)
 	}
 
 	logger.Success("MAIN", "Synchronization completed successfully")
 }
+
+// applyLogSettings configures the global logger from cfg
+func applyLogSettings(cfg *config.Config) {
+	// Set log level from config if available
+	if cfg.LogLevel != "" {
+		logger.SetLevelFromString(cfg.LogLevel)
+	}
+	logger.SetItemize(cfg.Itemize)
+	logger.SetPrint0(cfg.Print0)
+	logger.SetErrorLimit(cfg.LogErrorLimit)
+	if cfg.NoColor {
+		logger.DisableColor()
+	}
+}
+
+// runDaemon repeats the sync on the configured interval until ctx is
+// cancelled and returns the process exit code
+func runDaemon(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	cfg := cfgProvider.Config()
+
+	if cfg.PIDFile != "" {
+		removePIDFile, err := daemon.WritePIDFile(cfg.PIDFile)
+		if err != nil {
+			logger.Error("MAIN", "Failed to start daemon: %v", err)
+			return 2
+		}
+		defer removePIDFile()
+	}
+
+	activity := &daemon.Activity{}
+	opts := daemon.Options{Interval: cfg.Interval, Jitter: cfg.Jitter, Activity: activity}
+	syncOpts := []synchronizer.Option{synchronizer.WithEventSink(activity)}
+	var feed *control.Feed
+	if cfg.ControlSocket != "" {
+		opts.Control = daemon.NewControl()
+		feed = control.NewFeed()
+		server, err := control.Listen(cfg.ControlSocket, opts.Control, feed)
+		if err != nil {
+			logger.Error("MAIN", "Failed to start daemon: %v", err)
+			return 2
+		}
+		defer server.Close()
+		go server.Serve()
+		syncOpts = append(syncOpts, synchronizer.WithEventSink(feed))
+		logger.Info("MAIN", "Control socket listening at %s", cfg.ControlSocket)
+	}
+
+	job := func(ctx context.Context) error {
+		if feed != nil {
+			feed.Reset()
+		}
+		return synchronizer.NewSynchronizer(cfgProvider, syncOpts...).Sync(ctx)
+	}
+	if cfg.Watch {
+		// Watching starts before the first run, so nothing changed during
+		// it is missed
+		watcher, err := watch.New(cfg.Source)
+		if err != nil {
+			logger.Error("MAIN", "Failed to start daemon: %v", err)
+			return 2
+		}
+		defer watcher.Close()
+		opts.Changes = watcher
+		opts.Debounce = cfg.Debounce
+		opts.SyncChanges = func(ctx context.Context, paths []string) error {
+			return synchronizer.NewSynchronizer(cfgProvider, syncOpts...).SyncChanges(ctx, paths)
+		}
+	}
+	reload := func() error {
+		reloader, ok := cfgProvider.(config.Reloader)
+		if !ok {
+			logger.Info("MAIN", "Configuration cannot be reloaded, nothing to do")
+			return nil
+		}
+		if err := reloader.Reload(); err != nil {
+			return err
+		}
+		applyLogSettings(cfgProvider.Config())
+		return nil
+	}
+
+	if err := daemon.Run(ctx, opts, job, reload); err != nil {
+		logger.Error("MAIN", "Daemon stopped with error: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"snc/internal/config"
+	"snc/internal/desktop"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"strings"
+	"time"
+)
+
+// notifyDesktop shows the outcome of a sync as a desktop notification
+func notifyDesktop(cfg *config.Config, stats *stream.Stats, elapsed time.Duration, err error) {
+	title := "snc: sync completed"
+	message := fmt.Sprintf("%s → %s\n%s in %s", cfg.Source, strings.Join(cfg.Targets, ", "), stats, elapsed.Round(time.Second))
+	if err != nil {
+		title = "snc: sync failed"
+		message += "\n" + err.Error()
+	}
+	if notifyErr := desktop.Notify(title, message, err != nil); notifyErr != nil {
+		logger.Warn("MAIN", "Failed to show desktop notification: %v", notifyErr)
+	}
+}
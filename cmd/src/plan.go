@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"snc/internal/synchronizer"
+)
+
+// runDryRun executes a sync with --dry-run and returns the process exit
+// code: the planned changes are printed, and written to the plan file if
+// one was given
+func runDryRun(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	// Keep stdout reserved for the planned changes
+	logger.SetOutput(os.Stderr)
+
+	sn := synchronizer.NewSynchronizer(cfgProvider)
+	plan, err := sn.DryRun(ctx)
+	if plan != nil {
+		if printErr := printPlan(os.Stdout, plan, cfgProvider.Config()); printErr != nil {
+			logger.Error("MAIN", "Failed to print the plan: %v", printErr)
+			return 1
+		}
+	}
+	if err != nil {
+		logger.Error("MAIN", "Dry run completed with errors: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// runApply executes the apply subcommand and returns the process exit code
+func runApply(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	sn := synchronizer.NewSynchronizer(cfgProvider)
+	if err := sn.Apply(ctx); err != nil {
+		logger.Error("MAIN", "Apply completed with errors: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// printPlan writes the changes of plan to w like printDifferences: as JSON
+// with cfg.JSON, otherwise as one line per change
+func printPlan(w io.Writer, plan *stream.Plan, cfg *config.Config) error {
+	if cfg.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	for _, e := range plan.Entries {
+		line := fmt.Sprintf("%-6s %s\n", e.Action, logger.EscapePath(e.Path))
+		if cfg.Print0 {
+			line = fmt.Sprintf("%-6s %s\x00", e.Action, e.Path)
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
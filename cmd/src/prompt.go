@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"snc/internal/synchronizer"
+	"strings"
+)
+
+// maxPromptFiles limits the files listed in the delete confirmation
+const maxPromptFiles = 10
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// deleteConfirmation returns the synchronizer options that make a sync ask
+// before deleting missing files. Nobody is asked with --yes or when stdin
+// or stderr is not a terminal, e.g. in cron jobs.
+func deleteConfirmation(cfg *config.Config) []synchronizer.Option {
+	if !cfg.DeleteMissing || cfg.Yes || !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return nil
+	}
+	in := bufio.NewReader(os.Stdin)
+	return []synchronizer.Option{synchronizer.WithDeleteConfirmation(func(plan *stream.DeletePlan) (bool, error) {
+		if len(plan.Files) <= cfg.ConfirmThreshold {
+			return true, nil
+		}
+		return confirmDelete(in, os.Stderr, plan)
+	})}
+}
+
+// confirmDelete lists the files in plan on out and reads a yes or no
+// answer from in. Anything but yes declines.
+func confirmDelete(in *bufio.Reader, out io.Writer, plan *stream.DeletePlan) (bool, error) {
+	fmt.Fprintf(out, "%d of %d files in %s do not exist in the source and will be deleted:\n", len(plan.Files), plan.Checked, plan.Target)
+	for i, file := range plan.Files {
+		if i == maxPromptFiles {
+			fmt.Fprintf(out, "  ... and %d more\n", len(plan.Files)-maxPromptFiles)
+			break
+		}
+		fmt.Fprintf(out, "  %s\n", logger.EscapePath(file))
+	}
+	fmt.Fprint(out, "Delete these files? [y/N] ")
+
+	answer, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"snc/internal/config"
+	"snc/internal/daemon"
+	"snc/internal/logger"
+	"snc/internal/synchronizer"
+)
+
+// runScrub executes the scrub subcommand, on every interval if one is set,
+// and returns the process exit code: 0 if no file was corrupted, 1 if
+// some were and 2 if the scrub failed
+func runScrub(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	cfg := cfgProvider.Config()
+	if cfg.Interval > 0 {
+		job := func(ctx context.Context) error {
+			_, err := scrub(ctx, cfgProvider)
+			return err
+		}
+		opts := daemon.Options{Interval: cfg.Interval, Jitter: cfg.Jitter}
+		if err := daemon.Run(ctx, opts, job, func() error { return nil }); err != nil {
+			logger.Error("MAIN", "Daemon stopped with error: %v", err)
+			return 1
+		}
+		return 0
+	}
+
+	corrupted, err := scrub(ctx, cfgProvider)
+	switch {
+	case corrupted:
+		return 1
+	case err != nil:
+		return 2
+	}
+	return 0
+}
+
+// scrub runs a single scrub and reports whether corrupted files were found,
+// which is also returned as an error
+func scrub(ctx context.Context, cfgProvider config.ConfigProvider) (bool, error) {
+	result, err := synchronizer.NewSynchronizer(cfgProvider).Scrub(ctx)
+	if err != nil {
+		logger.Error("MAIN", "Scrub could not be completed: %v", err)
+		return false, err
+	}
+	if n := len(result.Corrupted); n > 0 {
+		for _, rel := range result.Corrupted {
+			logger.Error("MAIN", "Corrupted: %s", logger.EscapePath(rel))
+		}
+		logger.Error("MAIN", "%d files in %s are corrupted, restore them from the source", n, cfgProvider.Config().Target)
+		return true, fmt.Errorf("%d corrupted files", n)
+	}
+	if result.Errors > 0 {
+		logger.Error("MAIN", "%d files in %s could not be hashed", result.Errors, cfgProvider.Config().Target)
+		return false, fmt.Errorf("%d files could not be hashed", result.Errors)
+	}
+	logger.Success("MAIN", "No corrupted files in %s", cfgProvider.Config().Target)
+	return false, nil
+}
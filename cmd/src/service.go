@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/logger"
+)
+
+// runService executes the service commands and returns the process exit
+// code
+func runService(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	cfg := cfgProvider.Config()
+	if cfg.Command == config.CommandServiceRun {
+		return runAsService(ctx, cfgProvider)
+	}
+
+	var err error
+	switch cfg.Command {
+	case config.CommandServiceInstall:
+		var args []string
+		if args, err = serviceRunArgs(cfg, os.Args[1:]); err == nil {
+			err = installService(cfg, args)
+		}
+	case config.CommandServiceUninstall:
+		err = uninstallService(cfg.ServiceName)
+	case config.CommandServiceStart:
+		err = startService(cfg.ServiceName)
+	case config.CommandServiceStop:
+		err = stopService(cfg.ServiceName)
+	}
+	if err != nil {
+		logger.Error("MAIN", "%s failed: %v", cfg.Command, err)
+		return 1
+	}
+	logger.Success("MAIN", "%s completed for service %s", cfg.Command, cfg.ServiceName)
+	return 0
+}
+
+// serviceRunArgs returns the arguments the service manager starts the
+// service with: those of service install, args, with service run in its
+// place. Services start in the system directory, so the paths to sync must
+// be absolute.
+func serviceRunArgs(cfg *config.Config, args []string) ([]string, error) {
+	paths := append([]string{cfg.Source}, cfg.Targets...)
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return nil, fmt.Errorf("path %s must be absolute for a service", path)
+		}
+	}
+	return append([]string{"service", "run"}, args[2:]...), nil
+}
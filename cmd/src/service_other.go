@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"snc/internal/config"
+	"snc/internal/logger"
+)
+
+// errServiceUnsupported is returned by the service commands outside Windows
+var errServiceUnsupported = errors.New("services are only supported on Windows; run snc --interval under systemd or launchd instead")
+
+func installService(cfg *config.Config, args []string) error {
+	return errServiceUnsupported
+}
+
+func uninstallService(name string) error {
+	return errServiceUnsupported
+}
+
+func startService(name string) error {
+	return errServiceUnsupported
+}
+
+func stopService(name string) error {
+	return errServiceUnsupported
+}
+
+func runAsService(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	logger.Error("MAIN", "Failed to run as a service: %v", errServiceUnsupported)
+	return 2
+}
@@ -0,0 +1,225 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/metrics"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// stopTimeout is how long service stop waits for the current run to end
+const stopTimeout = 2 * time.Minute
+
+// installService registers the service with the arguments args, started
+// automatically at boot and restarted by the service manager if it fails,
+// and its Event Log source
+func installService(cfg *config.Config, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate the executable: %w", err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(cfg.ServiceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", cfg.ServiceName)
+	}
+	s, err := m.CreateService(cfg.ServiceName, exe, mgr.Config{
+		DisplayName: "snc " + cfg.ServiceName,
+		Description: fmt.Sprintf("Mirrors %s to %s every %v", cfg.Source, strings.Join(cfg.Targets, ", "), cfg.Interval),
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: time.Minute}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		logger.Warn("MAIN", "Failed to set the restart on failure: %v", err)
+	} else if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		logger.Warn("MAIN", "Failed to restart on runs exiting with errors: %v", err)
+	}
+
+	if err := eventlog.InstallAsEventCreate(cfg.ServiceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("failed to register the Event Log source: %w", err)
+	}
+	return nil
+}
+
+// uninstallService removes the service and its Event Log source
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	if err := eventlog.Remove(name); err != nil {
+		logger.Warn("MAIN", "Failed to remove the Event Log source: %v", err)
+	}
+	return nil
+}
+
+// startService starts the installed service
+func startService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+	return nil
+}
+
+// stopService stops the service and waits until it has stopped
+func stopService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service did not stop within %v", stopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("failed to query service status: %w", err)
+		}
+	}
+	return nil
+}
+
+// runAsService runs the daemon under the service manager, logging to the
+// Event Log, and returns the process exit code
+func runAsService(ctx context.Context, cfgProvider config.ConfigProvider) int {
+	cfg := cfgProvider.Config()
+	if elog, err := eventlog.Open(cfg.ServiceName); err != nil {
+		logger.Warn("MAIN", "Failed to open the Event Log: %v", err)
+	} else {
+		defer elog.Close()
+		logger.DisableColor()
+		logger.SetOutput(&eventLogWriter{log: elog})
+	}
+
+	if addr := cfg.MetricsAddr; addr != "" {
+		if err := metrics.Serve(addr); err != nil {
+			logger.Error("MAIN", "Failed to start metrics endpoint: %v", err)
+			return 2
+		}
+	}
+
+	h := &serviceHandler{ctx: ctx, cfgProvider: cfgProvider}
+	if err := svc.Run(cfg.ServiceName, h); err != nil {
+		logger.Error("MAIN", "Failed to run as a service: %v", err)
+		return 2
+	}
+	return h.code
+}
+
+// serviceHandler runs the daemon until the service manager stops it
+type serviceHandler struct {
+	ctx         context.Context
+	cfgProvider config.ConfigProvider
+	// code is the exit code of the daemon
+	code int
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	done := make(chan int, 1)
+	go func() {
+		done <- runDaemon(ctx, h.cfgProvider)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case h.code = <-done:
+			return false, uint32(h.code)
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				h.code = <-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// eventLogID is the event id of all messages, which carry their text
+// themselves
+const eventLogID = 1
+
+// eventLogWriter writes log lines to the Event Log as errors, warnings or
+// information by the level in the line
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w *eventLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\r\n")
+	// Lines start with "[timestamp] LEVEL"
+	_, rest, _ := strings.Cut(line, "] ")
+	level, _, _ := strings.Cut(rest, " ")
+
+	var err error
+	switch level {
+	case "ERROR", "FATAL":
+		err = w.log.Error(eventLogID, line)
+	case "WARN":
+		err = w.log.Warning(eventLogID, line)
+	default:
+		err = w.log.Info(eventLogID, line)
+	}
+	return len(p), err
+}
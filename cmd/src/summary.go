@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"snc/internal/stream"
+	"time"
+)
+
+// syncSummary is the JSON summary printed after a sync with --json
+type syncSummary struct {
+	Files     int   `json:"files"`
+	Copied    int   `json:"copied"`
+	Updated   int   `json:"updated"`
+	Unchanged int   `json:"unchanged"`
+	Locked    int   `json:"locked"`
+	Special   int   `json:"special"`
+	Dangling  int   `json:"dangling"`
+	Linked    int   `json:"linked"`
+	Protected int   `json:"protected"`
+	Deferred  int   `json:"deferred"`
+	Deleted   int   `json:"deleted"`
+	Errors    int   `json:"errors"`
+	Bytes     int64 `json:"bytes"`
+	// DeferredBytes is the size of the files left for the next run by
+	// --max-transfer
+	DeferredBytes int64 `json:"deferred_bytes"`
+	// ErrorCodes counts the failed files by error code
+	ErrorCodes      map[errors.Code]int `json:"error_codes"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	// PhaseSeconds holds the duration of the validate, scan, copy and
+	// delete phases
+	PhaseSeconds       map[string]float64 `json:"phase_seconds"`
+	BytesPerSecond     float64            `json:"bytes_per_second"`
+	AverageFileSeconds float64            `json:"average_file_seconds"`
+	Slowest            []slowFile         `json:"slowest"`
+	Success            bool               `json:"success"`
+}
+
+// slowFile is one of the files that took longest in the JSON summary
+type slowFile struct {
+	Path            string  `json:"path"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Bytes           int64   `json:"bytes"`
+}
+
+// errorCodeSink counts the errors reported by the engine by error code
+type errorCodeSink struct {
+	events.Nop
+	codes map[errors.Code]int
+}
+
+func newErrorCodeSink() *errorCodeSink {
+	return &errorCodeSink{codes: map[errors.Code]int{}}
+}
+
+func (s *errorCodeSink) Error(ev events.ErrorEvent) {
+	s.codes[errors.CodeOf(ev.Err)]++
+}
+
+// printSummary writes the results of a sync to w as JSON
+func printSummary(w io.Writer, stats *stream.Stats, codes *errorCodeSink, elapsed time.Duration, success bool) error {
+	summary := syncSummary{ErrorCodes: codes.codes, DurationSeconds: elapsed.Seconds(), Slowest: []slowFile{}, Success: success}
+	if stats != nil {
+		summary.Files = stats.Files
+		summary.Copied = stats.Copied
+		summary.Updated = stats.Updated
+		summary.Unchanged = stats.Skipped
+		summary.Locked = stats.Locked
+		summary.Special = stats.Special
+		summary.Dangling = stats.Dangling
+		summary.Linked = stats.Linked
+		summary.Protected = stats.Protected
+		summary.Deferred = stats.Deferred
+		summary.DeferredBytes = stats.DeferredBytes
+		summary.Deleted = stats.Deleted
+		summary.Errors = stats.Errors
+		summary.Bytes = stats.Bytes
+		summary.PhaseSeconds = map[string]float64{
+			"validate": stats.ValidateDuration.Seconds(),
+			"scan":     stats.ScanDuration.Seconds(),
+			"copy":     stats.CopyDuration.Seconds(),
+			"delete":   stats.DeleteDuration.Seconds(),
+		}
+		summary.BytesPerSecond = stats.Throughput()
+		summary.AverageFileSeconds = stats.AverageFileDuration().Seconds()
+		for _, t := range stats.Slowest {
+			summary.Slowest = append(summary.Slowest, slowFile{Path: t.Path, DurationSeconds: t.Duration.Seconds(), Bytes: t.Bytes})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(summary)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"snc/internal/auditlog"
+	"snc/internal/config"
+	"snc/internal/logger"
+)
+
+// runVerifyLog checks the hash chain of the audit log and returns the
+// process exit code: 0 if it is intact and 1 otherwise
+func runVerifyLog(cfg *config.Config) int {
+	n, err := auditlog.Verify(cfg.AuditLog)
+	if err != nil {
+		logger.Error("MAIN", "Audit log %s is not intact after %d valid records: %v", cfg.AuditLog, n, err)
+		return 1
+	}
+	logger.Success("MAIN", "Audit log %s is intact: %d records", cfg.AuditLog, n)
+	return 0
+}
@@ -0,0 +1,257 @@
+// Package auditlog appends a hash-chained record of every file copied,
+// updated or deleted to a log file. Each record holds the hash of the one
+// before, so records changed, removed or reordered later are detected by
+// Verify.
+package auditlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"snc/internal/events"
+	"snc/internal/logger"
+	"sync"
+	"time"
+)
+
+// Operations recorded in Record.Op
+const (
+	OpCopy   = "copy"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Record is a single operation in the log, written as one JSON line
+type Record struct {
+	// Seq numbers the records of a log from 1
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Op   string    `json:"op"`
+	// Path is relative to the source root, or the target root for
+	// deletions
+	Path string `json:"path"`
+	// Target is the file written or deleted
+	Target string `json:"target"`
+	Size   int64  `json:"size,omitempty"`
+	// SHA256 is the checksum of the target file as written
+	SHA256 string `json:"sha256,omitempty"`
+	// Prev is the hash of the previous record, empty for the first one
+	Prev string `json:"prev"`
+}
+
+// hashSuffix matches the hash appended to the JSON of a record to form
+// its line
+var hashSuffix = regexp.MustCompile(`,"hash":"([0-9a-f]{64})"}$`)
+
+// Sink is an events.EventSink appending a record for every file copied,
+// updated or deleted
+type Sink struct {
+	events.Nop
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	prev string
+	// err is the first error writing the log
+	err error
+}
+
+// Open opens the log at path for appending, continuing the chain of the
+// records in it
+func Open(path string) (*Sink, error) {
+	seq, prev, err := tail(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Sink{f: f, seq: seq, prev: prev}, nil
+}
+
+// tail returns the sequence number and hash of the last record in the log
+// at path, or zero values if there is none
+func tail(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			last = append(last[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, "", fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if last == nil {
+		return 0, "", nil
+	}
+	rec, hash, err := parseLine(last)
+	if err != nil {
+		return 0, "", fmt.Errorf("audit log %s: last record: %w", path, err)
+	}
+	return rec.Seq, hash, nil
+}
+
+// FileCopied records a copy or an update
+func (s *Sink) FileCopied(ev events.FileEvent) {
+	op := OpCopy
+	if ev.Update {
+		op = OpUpdate
+	}
+	sum, err := fileSHA256(ev.DstPath)
+	if err != nil {
+		logger.Warn("AUDIT", "Failed to checksum %s for the audit log: %v", ev.DstPath, err)
+	}
+	s.append(Record{Op: op, Path: ev.Path, Target: ev.DstPath, Size: ev.Bytes, SHA256: sum})
+}
+
+// FileDeleted records a deletion
+func (s *Sink) FileDeleted(ev events.FileEvent) {
+	s.append(Record{Op: OpDelete, Path: ev.Path, Target: ev.DstPath})
+}
+
+// append chains rec to the previous record and writes it
+func (s *Sink) append(rec Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return
+	}
+
+	rec.Seq = s.seq + 1
+	rec.Time = time.Now().UTC()
+	rec.Prev = s.prev
+	line, hash, err := formatLine(&rec)
+	if err == nil {
+		_, err = s.f.Write(line)
+	}
+	if err != nil {
+		s.err = fmt.Errorf("failed to write audit log: %w", err)
+		logger.Error("AUDIT", "%v; no further operations are recorded", s.err)
+		return
+	}
+	s.seq, s.prev = rec.Seq, hash
+}
+
+// Close flushes the log to disk and returns the first error writing it
+func (s *Sink) Close() error {
+	syncErr := s.f.Sync()
+	closeErr := s.f.Close()
+	switch {
+	case s.err != nil:
+		return s.err
+	case syncErr != nil:
+		return fmt.Errorf("failed to write audit log: %w", syncErr)
+	case closeErr != nil:
+		return fmt.Errorf("failed to write audit log: %w", closeErr)
+	}
+	return nil
+}
+
+// formatLine returns the line of rec: its JSON with the hash of that JSON
+// added as the last field, and the hash
+func formatLine(rec *Record) ([]byte, string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	line := append(data[:len(data)-1], `,"hash":"`+hash+"\"}\n"...)
+	return line, hash, nil
+}
+
+// parseLine returns the record of line and the hash it holds, after
+// checking the hash against the rest of the line
+func parseLine(line []byte) (*Record, string, error) {
+	m := hashSuffix.FindSubmatchIndex(line)
+	if m == nil {
+		return nil, "", fmt.Errorf("record has no hash")
+	}
+	hash := string(line[m[2]:m[3]])
+	data := append(append([]byte{}, line[:m[0]]...), '}')
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, "", fmt.Errorf("record does not match its hash")
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, "", fmt.Errorf("invalid record: %w", err)
+	}
+	return &rec, hash, nil
+}
+
+// Verify checks the chain of the log at path and returns the number of
+// records in it. It fails at the first record that was changed, or that
+// does not follow the one before because records were removed, inserted
+// or reordered. Records removed from the end cannot be detected from the
+// log alone; compare the count with a copy kept elsewhere.
+func Verify(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer f.Close()
+
+	var seq int64
+	prev := ""
+	r := bufio.NewReader(f)
+	for lineNo := 1; ; lineNo++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return seq, nil
+		}
+		if err != nil && err != io.EOF {
+			return seq, fmt.Errorf("failed to read audit log: %w", err)
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+
+		rec, hash, parseErr := parseLine(line)
+		switch {
+		case parseErr != nil:
+			return seq, fmt.Errorf("line %d: %w", lineNo, parseErr)
+		case rec.Seq != seq+1 || rec.Prev != prev:
+			return seq, fmt.Errorf("line %d: record %d does not follow record %d", lineNo, rec.Seq, seq)
+		}
+		seq, prev = rec.Seq, hash
+	}
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 checksum of the file at path,
+// or "" if it is not a regular file, such as a symlink or a FIFO, which
+// must not be opened
+func fileSHA256(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
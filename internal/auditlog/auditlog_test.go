@@ -0,0 +1,77 @@
+package auditlog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"strings"
+	"testing"
+)
+
+func TestSinkChainsRecords(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	file := filepath.Join(dir, "file.txt")
+	os.WriteFile(file, []byte("content"), 0644)
+
+	// Records of two runs form a single chain
+	for run := 0; run < 2; run++ {
+		sink, err := Open(logPath)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		sink.FileCopied(events.FileEvent{Path: "file.txt", DstPath: file, Bytes: 7, Update: run > 0})
+		sink.FileDeleted(events.FileEvent{Path: "old.txt", DstPath: filepath.Join(dir, "old.txt")})
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	n, err := Verify(logPath)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("Expected 4 records, got %d", n)
+	}
+
+	data, _ := os.ReadFile(logPath)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	rec, _, err := parseLine([]byte(lines[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// SHA-256 of "content"
+	const sum = "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+	if rec.Op != OpUpdate || rec.SHA256 != sum || rec.Size != 7 {
+		t.Errorf("Expected an update with checksum and size, got %+v", rec)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.log")
+	sink, err := Open(logPath)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		sink.FileDeleted(events.FileEvent{Path: name, DstPath: filepath.Join(dir, name)})
+	}
+	sink.Close()
+	data, _ := os.ReadFile(logPath)
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	tampered := map[string][]byte{
+		"changed": bytes.Replace(data, []byte(`"path":"b"`), []byte(`"path":"x"`), 1),
+		"removed": append(append([]byte{}, lines[0]...), lines[2]...),
+		"swapped": append(append(append([]byte{}, lines[1]...), lines[0]...), lines[2]...),
+	}
+	for name, content := range tampered {
+		os.WriteFile(logPath, content, 0600)
+		if _, err := Verify(logPath); err == nil {
+			t.Errorf("Expected Verify to detect a %s record", name)
+		}
+	}
+}
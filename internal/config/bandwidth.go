@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BandwidthWindow limits the transfer rate during a time of day
+type BandwidthWindow struct {
+	// Start and End are offsets from midnight, local time. A window whose
+	// End is not after its Start spans midnight.
+	Start, End time.Duration
+	// Rate is in bytes per second; 0 means no limit
+	Rate int64
+}
+
+// Contains reports whether the time of day of t falls into the window
+func (w BandwidthWindow) Contains(t time.Time) bool {
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.Start < w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// ParseBandwidthSchedule parses comma-separated windows of the form
+// "HH:MM-HH:MM=RATE", e.g. "09:00-18:00=5M,18:00-09:00=0". Rates take
+// the units of ParseSize, per second.
+func ParseBandwidthSchedule(s string) ([]BandwidthWindow, error) {
+	var windows []BandwidthWindow
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		span, rate, ok := strings.Cut(spec, "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid window %q (expected HH:MM-HH:MM=RATE)", spec)
+		}
+
+		var w BandwidthWindow
+		var err error
+		if w.Start, err = parseTimeOfDay(from); err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		if w.End, err = parseTimeOfDay(to); err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		if w.Start == w.End {
+			return nil, fmt.Errorf("invalid window %q: start and end are the same", spec)
+		}
+		if w.Rate, err = ParseSize(rate); err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses "HH:MM" into the offset from midnight; "24:00"
+// is midnight at the end of the day
+func parseTimeOfDay(s string) (time.Duration, error) {
+	if strings.TrimSpace(s) == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
@@ -1,13 +1,290 @@
 package config
 
+import "time"
+
+// Commands supported by the CLI. CommandSync is the default when no
+// subcommand is given.
+const (
+	CommandSync    = "sync"
+	CommandCheck   = "check"
+	CommandDecrypt = "decrypt"
+	CommandAudit   = "audit"
+	CommandRetry   = "retry"
+	CommandDoctor  = "doctor"
+	// CommandVerifyLog checks the hash chain of the audit log
+	CommandVerifyLog = "verify-log"
+	// CommandApply makes the changes of a plan written with --dry-run
+	CommandApply = "apply"
+	// CommandScrub rehashes the target against a manifest of checksums
+	CommandScrub = "scrub"
+
+	// CommandConfigShow prints the effective settings and CommandConfigInit
+	// prints a commented config file
+	CommandConfigShow = "config show"
+	CommandConfigInit = "config init"
+
+	// The service commands manage a Windows service repeating the sync on
+	// an interval; the service manager starts it with CommandServiceRun
+	CommandServiceInstall   = "service install"
+	CommandServiceUninstall = "service uninstall"
+	CommandServiceStart     = "service start"
+	CommandServiceStop      = "service stop"
+	CommandServiceRun       = "service run"
+)
+
+// Delete modes control when missing files are removed relative to copying
+const (
+	DeleteBefore = "before"
+	DeleteAfter  = "after"
+	DeleteDuring = "during"
+)
+
+// Directions of a sync between the two paths given
+const (
+	// DirectionPush copies from the first path to the second
+	DirectionPush = "push"
+	// DirectionPull copies from the second path to the first
+	DirectionPull = "pull"
+)
+
+// Policies for target files modified outside snc since the last sync
+const (
+	TargetChangesOverwrite = "overwrite"
+	TargetChangesSkip      = "skip"
+	TargetChangesError     = "error"
+)
+
+// Policies for devices, FIFOs and sockets in the source
+const (
+	SpecialFilesSkip     = "skip"
+	SpecialFilesRecreate = "recreate"
+	SpecialFilesError    = "error"
+)
+
+// Policies for symbolic links in the source whose target does not exist
+const (
+	DanglingSymlinksSkip  = "skip"
+	DanglingSymlinksCopy  = "copy"
+	DanglingSymlinksError = "error"
+)
+
+// Policies for files that cannot be read or written for lack of
+// permission
+const (
+	PermissionErrorsSkip  = "skip"
+	PermissionErrorsAbort = "abort"
+)
+
+// When the summary of a run is posted to webhooks
+const (
+	WebhookAlways  = "always"
+	WebhookFailure = "failure"
+)
+
+// CompressZstd stores target files zstd-compressed
+const CompressZstd = "zstd"
+
+// Orders in which files are processed
+const (
+	OrderAlpha         = "alpha"
+	OrderLargestFirst  = "largest-first"
+	OrderSmallestFirst = "smallest-first"
+	OrderRandom        = "random"
+)
+
 type Config struct {
-	Source        string
-	Target        string
+	Command string
+	Source  string
+	Target  string
+	// Targets lists every target a sync mirrors the source to, starting
+	// with Target
+	Targets       []string
 	DeleteMissing bool
-	LogLevel      string
-	UpdateMethod  string
+	// Direction is DirectionPush or DirectionPull; Source and Target are
+	// already swapped for pull
+	Direction string
+	LogLevel  string
+	NoColor   bool
+	// LogErrorLimit caps the file errors logged per run; 0 is unlimited
+	LogErrorLimit    int
+	UpdateMethod     string
+	StrategyMap      string
+	JSON             bool
+	Itemize          bool
+	MetricsAddr      string
+	Interval         time.Duration
+	Jitter           time.Duration
+	Watch            bool
+	Debounce         time.Duration
+	PIDFile          string
+	EncryptKey       string
+	EncryptNames     bool
+	Chown            string
+	Chmod            string
+	SkipLocked       bool
+	RetryLocked      bool
+	Exclude          []string
+	DeleteExcluded   bool
+	DeleteMode       string
+	MaxDelete        int
+	MaxDeletePercent float64
+	ForceDelete      bool
+	// Yes skips the confirmation before deleting missing files
+	Yes bool
+	// ConfirmThreshold is the number of deletions allowed without asking
+	ConfirmThreshold int
+	Prescan          bool
+	Order            string
+	FileTimeout      time.Duration
+	StallTimeout     time.Duration
+	WalkWorkers      int
+	// BufferSize is the copy buffer size in bytes; 0 uses the default
+	BufferSize int
+	NoCache    bool
+	// Preallocate reserves the space of each file before copying it
+	Preallocate bool
+	// TempDir holds temporary files while they are written; empty writes
+	// them next to their target file
+	TempDir string
+	// ErrorReport is the file the failures of a sync are written to; a
+	// .csv extension selects CSV, anything else JSON
+	ErrorReport string
+	// StateFile remembers the synced files between runs so unchanged ones
+	// can be skipped without checking the target; empty disables it
+	StateFile string
+	// TargetChanges is the policy for target files modified since the last
+	// sync according to StateFile; empty does not check for them
+	TargetChanges string
+	// SnapshotCommand takes a snapshot of the source before syncing and
+	// prints the directory to sync from instead; empty syncs the live source
+	SnapshotCommand string
+	// SnapshotRelease removes the snapshot after syncing
+	SnapshotRelease string
+	// StoreCompressed is CompressZstd to store target files compressed, or
+	// empty
+	StoreCompressed string
+	// Subpaths restricts syncing, checking and deleting to these subtrees,
+	// given relative to the source and target roots; empty covers the
+	// whole tree
+	Subpaths []string
+	// Print0 ends the lines of itemized output and difference listings
+	// with NUL and prints paths unescaped
+	Print0 bool
+	// FilesFrom names a file, or "-" for stdin, listing the paths to sync
+	// instead of walking the source
+	FilesFrom string
+	// FilterFile names a file of rsync include/exclude rules, applied after
+	// the Exclude patterns
+	FilterFile string
+	// SkipHidden excludes dotfiles and dot-directories, and on Windows
+	// files with the hidden attribute
+	SkipHidden bool
+	// IncludeJunk stops OS and office junk files such as .DS_Store
+	// and Thumbs.db from being excluded
+	IncludeJunk bool
+	// DeleteJunk deletes junk files matching the default excludes from the
+	// target with DeleteMissing
+	DeleteJunk bool
+	// MaxTransfer is the number of bytes after which no more copies are
+	// started in a run, per target; 0 means no limit
+	MaxTransfer int64
+	// SmallFiles is the size up to which files are copied in batches per
+	// source directory; 0 disables batching
+	SmallFiles int64
+	// BwLimit is the rate in bytes per second copies read their sources
+	// at, together across all copies; 0 means no limit
+	BwLimit int64
+	// BwSchedule overrides BwLimit during times of day
+	BwSchedule []BandwidthWindow
+	// MaxDuration stops a sync run gracefully once it ran this long; missing
+	// files are not deleted then. 0 means no limit.
+	MaxDuration time.Duration
+	// Nice lowers the CPU and I/O priority of the process
+	Nice bool
+	// AuditLog is the file a hash-chained record of every file copied,
+	// updated or deleted is appended to
+	AuditLog string
+	// Notify shows a desktop notification when a sync finishes or fails
+	Notify bool
+	// Webhooks are chat webhook URLs the summary of every run is posted
+	// to, formatted as WebhookFormat, a format of package webhook
+	Webhooks      []string
+	WebhookFormat string
+	// WebhookOn is WebhookAlways or WebhookFailure
+	WebhookOn string
+	// ControlSocket is the path of the socket that drives the daemon
+	ControlSocket string
+	// ServiceName is the name of the Windows service the service commands
+	// manage
+	ServiceName string
+	// StageDeletes moves the files deleted by delete-missing aside
+	// and only deletes them for good once the whole run completed without
+	// errors; otherwise they are moved back
+	StageDeletes bool
+	// StageWrites writes new and updated files to a staging directory in
+	// the target and moves them into place at the end of the run
+	StageWrites bool
+	// DryRun compares source and target and reports the changes a sync
+	// would make, without making them
+	DryRun bool
+	// Manifest is the file scrub keeps the checksums of the target in
+	Manifest string
+	// VerifyReport is the file the result of comparing every target with
+	// the source again after a sync is written to; empty skips the check
+	VerifyReport string
+	// WriteChecksums is the file the SHA-256 checksums of the files copied
+	// or found up to date are listed in, in the format of sha256sum and
+	// relative to the target; empty writes none
+	WriteChecksums string
+	// SourceChecksums trusts the .sha256 sidecar files and SHA256SUMS
+	// manifests of the source instead of hashing source files for the
+	// sha256 update method
+	SourceChecksums bool
+	// Plan is the plan file a dry run writes, or apply reads
+	Plan string
+	// LinkDest is a previous snapshot of the source that unchanged files
+	// are hardlinked to instead of copied; relative to Target if relative
+	LinkDest string
+	// Dedupe stores identical target files once, hardlinked to an object
+	// in the .snc-objects directory of the target
+	Dedupe bool
+	// FileProgress is the size from which the progress of copying a single
+	// file is logged; 0 disables it
+	FileProgress int64
+	// ChangeRetries is how often a file that changed while it was copied
+	// is copied again before it is reported
+	ChangeRetries int
+	// SpecialFiles is the policy for devices, FIFOs and sockets, which
+	// cannot be copied by reading them
+	SpecialFiles string
+	// DanglingSymlinks is the policy for symbolic links in the source whose
+	// target does not exist
+	DanglingSymlinks string
+	// PermissionErrors is the policy for source files that cannot be read
+	// and target paths that cannot be written for lack of permission
+	PermissionErrors string
+	// ForceTypeReplace replaces target directories where the source has a
+	// file, and target files where it has a directory, instead of
+	// reporting the conflict
+	ForceTypeReplace bool
+	// TimeOffset is how far the target stores modification times off from
+	// those of the source, e.g. on network filesystems applying a timezone
+	TimeOffset time.Duration
+	// DetectTimeOffset measures TimeOffset on the target before syncing
+	DetectTimeOffset bool
+	// RetryFrom is the error report whose failed files the retry command
+	// re-attempts
+	RetryFrom string
+	// ConfigFile is the settings file the config was read from, if any
+	ConfigFile string
 }
 
 type ConfigProvider interface {
 	Config() *Config
 }
+
+// Reloader is implemented by providers that can re-read their configuration
+// while the process is running (e.g. on SIGHUP in daemon mode)
+type Reloader interface {
+	Reload() error
+}
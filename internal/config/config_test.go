@@ -1,9 +1,14 @@
 package config
 
 import (
+	"bytes"
 	"flag"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -72,6 +77,20 @@ func TestParseFlags(t *testing.T) {
 				DeleteMissing: true,
 				LogLevel:      "debug",
 				UpdateMethod:  "sha256",
+				Command:       CommandSync,
+			},
+			expectError: false,
+		},
+		{
+			name: "itemized output",
+			args: []string{"--itemize", "/source", "/target"},
+			expectedConfig: &Config{
+				Source:       "/source",
+				Target:       "/target",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+				Command:      CommandSync,
+				Itemize:      true,
 			},
 			expectError: false,
 		},
@@ -84,9 +103,303 @@ func TestParseFlags(t *testing.T) {
 				DeleteMissing: false,
 				LogLevel:      "info",
 				UpdateMethod:  "modtime",
+				Command:       CommandSync,
+			},
+			expectError: false,
+		},
+		{
+			name: "check subcommand",
+			args: []string{"check", "--json", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:      CommandCheck,
+				Source:       "/source",
+				Target:       "/target",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+				JSON:         true,
+			},
+			expectError: false,
+		},
+		{
+			name: "doctor subcommand",
+			args: []string{"doctor", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:      CommandDoctor,
+				Source:       "/source",
+				Target:       "/target",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name: "retry without paths",
+			args: []string{"retry", "--from", "errors.json"},
+			expectedConfig: &Config{
+				Command:      CommandRetry,
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name: "delete junk implies delete missing",
+			args: []string{"--delete-junk", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:       CommandSync,
+				Source:        "/source",
+				Target:        "/target",
+				LogLevel:      "info",
+				UpdateMethod:  "modtime",
+				DeleteMissing: true,
+				DeleteJunk:    true,
+			},
+			expectError: false,
+		},
+		{
+			name:        "delete junk without default excludes",
+			args:        []string{"--delete-junk", "--no-default-excludes", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "notify in daemon mode",
+			args:        []string{"--notify", "--interval", "15m", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name: "verify-log without paths",
+			args: []string{"verify-log", "--audit-log", "audit.log"},
+			expectedConfig: &Config{
+				Command:      CommandVerifyLog,
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name: "scrub with a target",
+			args: []string{"scrub", "--manifest", "m.json", "/target"},
+			expectedConfig: &Config{
+				Command:      CommandScrub,
+				Target:       "/target",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name:        "chmod with the meta update method",
+			args:        []string{"--chmod", "0644", "--update-method", "meta", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "scrub without manifest",
+			args:        []string{"scrub", "/target"},
+			expectError: true,
+		},
+		{
+			name: "apply with a plan file",
+			args: []string{"apply", "plan.json"},
+			expectedConfig: &Config{
+				Command:      CommandApply,
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name:        "apply without a plan file",
+			args:        []string{"apply"},
+			expectError: true,
+		},
+		{
+			name:        "plan without dry run",
+			args:        []string{"--plan", "plan.json", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "dry run in daemon mode",
+			args:        []string{"--dry-run", "--interval", "15m", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "service install without interval",
+			args:        []string{"service", "install", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name: "service stop without paths",
+			args: []string{"service", "stop"},
+			expectedConfig: &Config{
+				Command:      CommandServiceStop,
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+			},
+			expectError: false,
+		},
+		{
+			name:        "retry without report",
+			args:        []string{"retry", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name: "repeated excludes with delete-excluded",
+			args: []string{"--exclude", "*.tmp", "--exclude", "cache", "--delete-excluded", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:        CommandSync,
+				Source:         "/source",
+				Target:         "/target",
+				DeleteMissing:  true,
+				LogLevel:       "info",
+				UpdateMethod:   "modtime",
+				Exclude:        []string{"*.tmp", "cache"},
+				DeleteExcluded: true,
+			},
+			expectError: false,
+		},
+		{
+			name: "pull direction swaps paths",
+			args: []string{"--direction", "pull", "/local", "/backup"},
+			expectedConfig: &Config{
+				Command:      CommandSync,
+				Source:       "/backup",
+				Target:       "/local",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
 			},
 			expectError: false,
 		},
+		{
+			name:        "pull from encrypted target",
+			args:        []string{"--direction", "pull", "--encrypt-key", "key", "/local", "/backup"},
+			expectError: true,
+		},
+		{
+			name: "time offset",
+			args: []string{"--time-offset", "-1h", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:      CommandSync,
+				Source:       "/source",
+				Target:       "/target",
+				LogLevel:     "info",
+				UpdateMethod: "modtime",
+				TimeOffset:   -time.Hour,
+			},
+			expectError: false,
+		},
+		{
+			name: "detected time offset",
+			args: []string{"--time-offset", "auto", "/source", "/target"},
+			expectedConfig: &Config{
+				Command:          CommandSync,
+				Source:           "/source",
+				Target:           "/target",
+				LogLevel:         "info",
+				UpdateMethod:     "modtime",
+				DetectTimeOffset: true,
+			},
+			expectError: false,
+		},
+		{
+			name:        "unknown special files policy",
+			args:        []string{"--special-files", "copy", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "unknown special files policy",
+			args:        []string{"--special-files", "copy", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "unknown dangling symlinks policy",
+			args:        []string{"--dangling-symlinks", "follow", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "unknown permission errors policy",
+			args:        []string{"--permission-errors", "sudo", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "watch without interval",
+			args:        []string{"--watch", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "negative debounce",
+			args:        []string{"--interval", "1h", "--watch", "--debounce", "-1s", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "invalid file progress size",
+			args:        []string{"--file-progress", "big", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "negative change retries",
+			args:        []string{"--change-retries", "-1", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "unknown compression",
+			args:        []string{"--store-compressed", "gzip", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "compression with encryption",
+			args:        []string{"--store-compressed", "zstd", "--encrypt-key", "/key", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "dedupe with pull",
+			args:        []string{"--dedupe", "--direction", "pull", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "link dest with compression",
+			args:        []string{"--link-dest", "../previous", "--store-compressed", "zstd", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "subpath outside the tree",
+			args:        []string{"--subpath", "../other", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "overlapping subpaths",
+			args:        []string{"--subpath", "docs", "--subpath", "docs/old", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "files from with delete missing",
+			args:        []string{"--files-from", "-", "--delete-missing", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "snapshot release without snapshot",
+			args:        []string{"--snapshot-release-cmd", "true", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "snapshot with check",
+			args:        []string{"check", "--snapshot-cmd", "true", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "invalid time offset",
+			args:        []string{"--time-offset", "an hour", "/source", "/target"},
+			expectError: true,
+		},
+		{
+			name:        "unknown direction",
+			args:        []string{"--direction", "sideways", "/local", "/backup"},
+			expectError: true,
+		},
+		{
+			name:        "target-changes without state file",
+			args:        []string{"--target-changes", "skip", "/source", "/target"},
+			expectError: true,
+		},
 		{
 			name:        "missing source argument",
 			args:        []string{"/target"},
@@ -159,6 +472,24 @@ func TestParseFlags(t *testing.T) {
 			if config.UpdateMethod != tt.expectedConfig.UpdateMethod {
 				t.Errorf("Expected UpdateMethod '%s', got '%s'", tt.expectedConfig.UpdateMethod, config.UpdateMethod)
 			}
+			if config.Command != tt.expectedConfig.Command {
+				t.Errorf("Expected Command '%s', got '%s'", tt.expectedConfig.Command, config.Command)
+			}
+			if config.JSON != tt.expectedConfig.JSON {
+				t.Errorf("Expected JSON %v, got %v", tt.expectedConfig.JSON, config.JSON)
+			}
+			if config.Itemize != tt.expectedConfig.Itemize {
+				t.Errorf("Expected Itemize %v, got %v", tt.expectedConfig.Itemize, config.Itemize)
+			}
+			if strings.Join(config.Exclude, ",") != strings.Join(tt.expectedConfig.Exclude, ",") {
+				t.Errorf("Expected Exclude %v, got %v", tt.expectedConfig.Exclude, config.Exclude)
+			}
+			if config.DeleteExcluded != tt.expectedConfig.DeleteExcluded {
+				t.Errorf("Expected DeleteExcluded %v, got %v", tt.expectedConfig.DeleteExcluded, config.DeleteExcluded)
+			}
+			if config.TimeOffset != tt.expectedConfig.TimeOffset || config.DetectTimeOffset != tt.expectedConfig.DetectTimeOffset {
+				t.Errorf("Expected time offset %v (detect %v), got %v (detect %v)", tt.expectedConfig.TimeOffset, tt.expectedConfig.DetectTimeOffset, config.TimeOffset, config.DetectTimeOffset)
+			}
 		})
 	}
 }
@@ -186,3 +517,250 @@ func TestParseFlagsWithInvalidUpdateMethod(t *testing.T) {
 		t.Errorf("Expected UpdateMethod 'invalid', got '%s'", config.UpdateMethod)
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    int64
+		expectError bool
+	}{
+		{input: "65536", expected: 65536},
+		{input: "512K", expected: 512 << 10},
+		{input: "4M", expected: 4 << 20},
+		{input: "4MiB", expected: 4 << 20},
+		{input: "1g", expected: 1 << 30},
+		{input: "2TB", expected: 2 << 40},
+		{input: "", expectError: true},
+		{input: "-1K", expectError: true},
+		{input: "1.5M", expectError: true},
+		{input: "4X", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSize(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q, got %d", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	windows, err := ParseBandwidthSchedule("09:00-18:00=5M, 18:00-09:00=0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []BandwidthWindow{
+		{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 5 << 20},
+		{Start: 18 * time.Hour, End: 9 * time.Hour, Rate: 0},
+	}
+	if !reflect.DeepEqual(windows, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, windows)
+	}
+
+	day := func(hour, minute int) time.Time { return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local) }
+	if !windows[0].Contains(day(9, 0)) || windows[0].Contains(day(18, 0)) {
+		t.Error("Day window should contain its start but not its end")
+	}
+	if !windows[1].Contains(day(23, 30)) || !windows[1].Contains(day(3, 0)) || windows[1].Contains(day(12, 0)) {
+		t.Error("Night window should span midnight")
+	}
+
+	for _, invalid := range []string{"", "09:00-18:00", "09:00=5M", "9-18=5M", "25:00-01:00=1M", "09:00-09:00=1M", "09:00-18:00=fast"} {
+		if _, err := ParseBandwidthSchedule(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snc.conf")
+	content := `# settings for the nightly backup
+source = /file/source
+target = "/file/target"
+log-level = debug
+update-method = size
+exclude = *.tmp
+exclude = *.bak
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SNC_CONFIG", path)
+	t.Setenv("SNC_UPDATE_METHOD", "sha256")
+	t.Setenv("SNC_DELETE_MISSING", "true")
+
+	provider, err := Load([]string{"--update-method", "md5"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg := provider.Config()
+	if cfg.Source != "/file/source" || cfg.Target != "/file/target" {
+		t.Errorf("Expected paths from the file, got %q and %q", cfg.Source, cfg.Target)
+	}
+	if cfg.UpdateMethod != "md5" {
+		t.Errorf("Expected UpdateMethod 'md5' from flags, got '%s'", cfg.UpdateMethod)
+	}
+	if !cfg.DeleteMissing {
+		t.Error("Expected DeleteMissing from the environment")
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("Expected LogLevel 'debug' from the file, got '%s'", cfg.LogLevel)
+	}
+	if strings.Join(cfg.Exclude, ",") != "*.tmp,*.bak" {
+		t.Errorf("Expected Exclude from the file, got %v", cfg.Exclude)
+	}
+
+	sources := map[string]string{}
+	for _, s := range provider.Settings() {
+		sources[s.Name] = s.Source
+	}
+	expected := map[string]string{
+		"source":         path,
+		"update-method":  "flags",
+		"delete-missing": "env",
+		"config":         "env",
+		"log-level":      path,
+		"order":          SourceDefault,
+	}
+	for name, source := range expected {
+		if sources[name] != source {
+			t.Errorf("Expected %s to come from %s, got %s", name, source, sources[name])
+		}
+	}
+
+	// Flags keep their precedence when the file changes on reload
+	if err := os.WriteFile(path, []byte("source = /a\ntarget = /b\nupdate-method = crc32c\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Reload(); err != nil {
+		t.Fatalf("Unexpected error on reload: %v", err)
+	}
+	if cfg := provider.Config(); cfg.Source != "/a" || cfg.UpdateMethod != "md5" || cfg.LogLevel != "info" {
+		t.Errorf("Unexpected config after reload: %+v", cfg)
+	}
+}
+
+func TestLoadTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snc.conf")
+	if err := os.WriteFile(path, []byte("source = /src\ntarget = /one\ntarget = /two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{name: "positional only", args: []string{"/src", "/a"}, expected: []string{"/a"}},
+		{name: "positional and flags", args: []string{"--target", "/b", "--target", "/c", "/src", "/a"}, expected: []string{"/a", "/b", "/c"}},
+		{name: "config file", args: []string{"--config", path}, expected: []string{"/one", "/two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			cfg := provider.Config()
+			if strings.Join(cfg.Targets, ",") != strings.Join(tt.expected, ",") || cfg.Target != tt.expected[0] {
+				t.Errorf("Expected targets %v, got %q and %v", tt.expected, cfg.Target, cfg.Targets)
+			}
+		})
+	}
+
+	if _, err := Load([]string{"check", "--target", "/b", "/src", "/a"}); err == nil {
+		t.Error("Expected an error for several targets with check")
+	}
+	if _, err := Load([]string{"--write-checksums", "/sums", "--target", "/b", "/src", "/a"}); err == nil {
+		t.Error("Expected an error for several targets with --write-checksums")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+	}{
+		{name: "unknown setting", content: "source = /s\ntarget = /t\nverbose = true\n"},
+		{name: "invalid value", content: "source = /s\ntarget = /t\nwalk-workers = many\n"},
+		{name: "missing equals", content: "source /s\n"},
+		{name: "nested config", content: "config = other.conf\n"},
+		{name: "missing target", content: "source = /s\n"},
+		{name: "single path", content: "", args: []string{"/s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".conf")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(append([]string{"--config", path}, tt.args...)); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	provider, err := Load([]string{"config", "init", "--exclude", "*.tmp", "--exclude", " padded", "--walk-workers", "4", "/src", "/dst"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTemplate(&buf, provider.Settings()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "#update-method = modtime\n") {
+		t.Errorf("Expected defaults to be commented out, got:\n%s", buf.String())
+	}
+
+	// The template reads back into the same config
+	path := filepath.Join(t.TempDir(), "snc.conf")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewMultiProvider(CommandSync, FileSource{Path: path})
+	if err != nil {
+		t.Fatalf("Failed to load the template: %v", err)
+	}
+	cfg := reloaded.Config()
+	if cfg.Source != "/src" || cfg.Target != "/dst" || cfg.WalkWorkers != 4 {
+		t.Errorf("Unexpected config from template: %+v", cfg)
+	}
+	if strings.Join(cfg.Exclude, "|") != "*.tmp| padded" {
+		t.Errorf("Expected Exclude to survive the round trip, got %q", cfg.Exclude)
+	}
+}
+
+func TestWriteTemplateRedactsWebhooks(t *testing.T) {
+	provider, err := Load([]string{"config", "init", "--webhook", "https://hooks.slack.com/services/T000/B000/secret?token=x", "/src", "/dst"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTemplate(&buf, provider.Settings()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(buf.String(), "secret") || strings.Contains(buf.String(), "token") {
+		t.Errorf("Expected the webhook secret to be left out, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "#webhook = https://hooks.slack.com/<redacted>\n") {
+		t.Errorf("Expected the webhook to be commented out with its host, got:\n%s", buf.String())
+	}
+}
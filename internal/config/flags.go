@@ -4,6 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // FlagConfig implements ConfigProvider using CLI flags
@@ -16,31 +19,559 @@ func (f *FlagConfig) Config() *Config {
 	return f.cfg
 }
 
+// isCommand reports whether arg names a subcommand
+func isCommand(arg string) bool {
+	switch arg {
+	case CommandSync, CommandCheck, CommandDecrypt, CommandAudit, CommandRetry, CommandDoctor, CommandVerifyLog, CommandApply, CommandScrub:
+		return true
+	}
+	return false
+}
+
+// isTwoWordCommand reports whether group and name form a subcommand such
+// as "config show"
+func isTwoWordCommand(group, name string) bool {
+	switch group + " " + name {
+	case CommandConfigShow, CommandConfigInit,
+		CommandServiceInstall, CommandServiceUninstall, CommandServiceStart, CommandServiceStop, CommandServiceRun:
+		return true
+	}
+	return false
+}
+
+// syncsFiles reports whether command runs a sync or describes the settings
+// of one, so that sync-only options are accepted
+func syncsFiles(command string) bool {
+	switch command {
+	case CommandSync, CommandConfigShow, CommandConfigInit, CommandServiceInstall, CommandServiceRun:
+		return true
+	}
+	return false
+}
+
 // ParseFlags parses CLI flags and returns a FlagConfig
 func ParseFlags() (*FlagConfig, error) {
-	usage := func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [--delete-missing] [--log-level LEVEL] <source> <target>\n", os.Args[0])
-		flag.PrintDefaults()
+	build := defineFlags(flag.CommandLine)
+	command, args, err := parseArgs(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return nil, err
 	}
-	flag.Usage = usage
+	cfg, err := build(command, args)
+	if err != nil {
+		return nil, err
+	}
+	return &FlagConfig{cfg: cfg}, nil
+}
 
-	deleteMissing := flag.Bool("delete-missing", false, "Delete files from target that do not exist in source")
-	logLevel := flag.String("log-level", "info", "Set logging level (error, warn, info, debug)")
-	updateMethod := flag.String("update-method", "modtime", "Method for detecting file updates (modtime, sha256)")
-	flag.Parse()
+// parseArgs splits an optional subcommand off args and parses the rest
+// with fs. It returns the command and the remaining positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) (string, []string, error) {
+	command := CommandSync
+	if len(args) > 1 && isTwoWordCommand(args[0], args[1]) {
+		command = args[0] + " " + args[1]
+		args = args[2:]
+	} else if len(args) > 0 && isCommand(args[0]) {
+		command = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	return command, fs.Args(), nil
+}
 
-	args := flag.Args()
-	if len(args) != 2 {
-		return nil, fmt.Errorf("invalid arguments: source and target paths are required")
+// defineFlags registers all options on fs. The returned function validates
+// the parsed values and builds the Config from them and the source and
+// target paths in args, which are optional for the config commands and
+// for retry, where they default to those in the error report.
+func defineFlags(fs *flag.FlagSet) func(command string, args []string) (*Config, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [check|audit|decrypt|retry|doctor|verify-log|apply|scrub|config show|config init|service install|service start|service stop|service uninstall] [--config FILE] [--delete-missing] [--log-level LEVEL] <source> <target>\n", os.Args[0])
+		fs.PrintDefaults()
 	}
 
-	cfg := &Config{
-		Source:        args[0],
-		Target:        args[1],
-		DeleteMissing: *deleteMissing,
-		LogLevel:      *logLevel,
-		UpdateMethod:  *updateMethod,
+	configFile := fs.String("config", "", "Read settings from this file of \"name = value\" lines (flags and SNC_* environment variables take precedence)")
+	direction := fs.String("direction", DirectionPush, "Copy from source to target (push) or from target back to source (pull)")
+	deleteMissing := fs.Bool("delete-missing", false, "Delete files from target that do not exist in source")
+	logLevel := fs.String("log-level", "info", "Set logging level (error, warn, info, debug, trace)")
+	noColor := fs.Bool("no-color", false, "Disable colored log output (also disabled by the NO_COLOR environment variable)")
+	logErrorLimit := fs.Int("log-error-limit", 1000, "Log at most this many file errors per run, plus a count of the rest (0 = no limit)")
+	updateMethod := fs.String("update-method", "modtime", "Method for detecting file updates (modtime, sha256, size, md5, crc32c, sample, meta)")
+	strategyMap := fs.String("strategy-map", "", "Per-pattern update methods, e.g. \"*.iso=size,*.db=sha256,default=modtime\"")
+	fileTimeout := fs.Duration("file-timeout", 0, "Abort copying a single file after this duration and move on (0 = no limit)")
+	stallTimeout := fs.Duration("stall-timeout", 0, "Abort copying a file when no data was transferred for this duration (0 = no limit)")
+	order := fs.String("order", OrderAlpha, "Order in which files are processed (alpha, largest-first, smallest-first, random)")
+	bufferSize := fs.String("buffer-size", "1M", "Size of the buffer used to copy each file, e.g. 256K or 4M")
+	tempDir := fs.String("temp-dir", "", "Write files to this directory before moving them into the target (default: next to the target file)")
+	preallocate := fs.Bool("preallocate", false, "Reserve the space of each file in the target before copying it")
+	noCache := fs.Bool("no-cache", false, "Keep copied data out of the page cache (Linux only)")
+	nice := fs.Bool("nice", false, "Run with the lowest CPU and I/O priority, so large syncs do not slow down other work")
+	walkWorkers := fs.Int("walk-workers", 1, "Number of directories read in parallel while walking a tree")
+	prescan := fs.Bool("prescan", false, "Scan the source before copying to report totals, progress with ETA and check free space")
+	stateFile := fs.String("state-file", "", "Remember synced files in this file and skip files unchanged since the last sync without checking the target")
+	targetChanges := fs.String("target-changes", "", "Check files known from --state-file for changes made in the target since the last sync and overwrite, skip or error on them")
+	maxTransfer := fs.String("max-transfer", "0", "Stop starting copies once this much data was written to a target, e.g. 50G, and leave the rest for the next run (0 = no limit)")
+	smallFiles := fs.String("small-files", "0", "Copy files up to this size in batches per directory that open it once, e.g. 64K (0 = off)")
+	bwLimit := fs.String("bwlimit", "0", "Limit the rate copies read at, in bytes per second across all copies, e.g. 10M (0 = no limit)")
+	bwSchedule := fs.String("bwlimit-schedule", "", "Rate limits by time of day, e.g. \"09:00-18:00=5M,18:00-09:00=0\"; --bwlimit applies outside the windows")
+	maxDuration := fs.Duration("max-duration", 0, "Stop gracefully after this duration, e.g. 2h, finishing the files in flight and leaving the rest for the next run (0 = no limit)")
+	fileProgress := fs.String("file-progress", "1G", "Log the progress of copying files of at least this size every 10 seconds (0 = never)")
+	changeRetries := fs.Int("change-retries", 2, "Copy a file that changed while it was copied again up to this many times before reporting it")
+	snapshotCmd := fs.String("snapshot-cmd", "", "Shell command that snapshots the source (in SNC_SOURCE) and prints the directory to sync from instead")
+	snapshotReleaseCmd := fs.String("snapshot-release-cmd", "", "Shell command that removes the snapshot (in SNC_SNAPSHOT) after syncing")
+	specialFiles := fs.String("special-files", SpecialFilesSkip, "What to do with devices, FIFOs and sockets in the source (skip, recreate, error)")
+	danglingSymlinks := fs.String("dangling-symlinks", DanglingSymlinksError, "What to do with symbolic links in the source pointing to missing files (skip, copy, error)")
+	permissionErrors := fs.String("permission-errors", PermissionErrorsSkip, "What to do when a file cannot be read or written for lack of permission (skip, abort)")
+	forceTypeReplace := fs.Bool("force-type-replace", false, "Replace a target directory where the source has a file, or a target file where it has a directory, instead of reporting a type conflict")
+	timeOffset := fs.String("time-offset", "", "Expect target modification times to be off from the source by this duration, e.g. -1h, or \"auto\" to measure it on the target")
+	errorReport := fs.String("error-report", "", "Write every failed file of a sync to this file, as CSV if it ends in .csv and as JSON otherwise")
+	auditLog := fs.String("audit-log", "", "Append a hash-chained record of every file copied, updated or deleted, with its checksum, to this file")
+	retryFrom := fs.String("from", "", "Error report written by --error-report whose failed files retry re-attempts")
+	jsonOutput := fs.Bool("json", false, "Print check and audit results, and a summary of the sync, as JSON")
+	interval := fs.Duration("interval", 0, "Keep running and repeat the sync on this interval (e.g. 15m)")
+	jitter := fs.Duration("jitter", 0, "Random delay of up to this duration added to every interval")
+	debounce := fs.Duration("debounce", 2*time.Second, "With --watch, sync changes once none arrived for this long, so bursts are synced at once; 0 syncs every change right away")
+	watch := fs.Bool("watch", false, "With --interval, also sync changed source paths as soon as they change, without walking the whole tree (Linux only)")
+	deleteAfterConfirm := fs.Bool("delete-after-confirm", false, "Stage the files deleted by --delete-missing and only delete them once the run completed without errors, restoring them otherwise")
+	stageWrites := fs.Bool("stage-writes", false, "Write new and updated files to .snc-staging in the target and move them into place at the end of the run, so readers never see a partly updated tree")
+	dryRun := fs.Bool("dry-run", false, "Report the changes a sync would make without making them")
+	manifest := fs.String("manifest", "", "Manifest file scrub keeps the checksums of the target files in; created by the first scrub")
+	verifyReport := fs.String("verify-report", "", "After a sync, compare the target with the source again with the update method and write a pass/fail report to this file")
+	writeChecksums := fs.String("write-checksums", "", "List the SHA-256 of every file copied or found up to date in this file, relative to the target, for sha256sum -c")
+	sourceChecksums := fs.Bool("source-checksums", false, "With the sha256 update method, trust the checksums in FILE.sha256 sidecar files and SHA256SUMS manifests of the source instead of hashing source files")
+	planFile := fs.String("plan", "", "With --dry-run, write the changes as a plan file for apply")
+	controlSocket := fs.String("control-socket", "", "Serve a JSON-RPC control socket at this path in daemon mode to start, stop and pause runs and follow their progress")
+	serviceName := fs.String("service-name", "snc", "Name of the Windows service managed by the service commands")
+	pidFile := fs.String("pid-file", "", "Write the process id to this file in daemon mode")
+	metricsAddr := fs.String("metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9090)")
+	notify := fs.Bool("notify", false, "Show a desktop notification with the summary when the sync finishes or fails")
+	var webhooks []string
+	fs.Func("webhook", "Post the summary of every run to this Slack, Discord or Teams webhook URL (repeatable)", func(url string) error {
+		webhooks = append(webhooks, url)
+		return nil
+	})
+	webhookFormat := fs.String("webhook-format", "auto", "Message format of the webhooks (auto, slack, discord, teams); auto picks it by the URL")
+	webhookOn := fs.String("webhook-on", WebhookAlways, "When to post to the webhooks (always, failure)")
+	itemize := fs.Bool("itemize", false, "Print an itemized change line for every file copied, updated or deleted")
+	print0 := fs.Bool("print0", false, "End itemized lines and check listings with NUL instead of newline and print paths unescaped")
+	var moreTargets []string
+	fs.Func("target", "Also mirror the source to this target in the same run (repeatable)", func(path string) error {
+		moreTargets = append(moreTargets, path)
+		return nil
+	})
+	var exclude []string
+	fs.Func("exclude", "Exclude files and directories matching this glob pattern (repeatable)", func(pattern string) error {
+		exclude = append(exclude, pattern)
+		return nil
+	})
+	filterFile := fs.String("filter-file", "", "Include and exclude files by the rules in this rsync filter file, e.g. \"- *.tmp\" or \"+ */\"")
+	var subpaths []string
+	fs.Func("subpath", "Only sync this subtree of the source to the same place in the target (repeatable)", func(path string) error {
+		subpaths = append(subpaths, path)
+		return nil
+	})
+	filesFrom := fs.String("files-from", "", "Only sync the paths listed one per line or NUL-separated in this file (- for stdin) instead of walking the source")
+	deleteMode := fs.String("delete-mode", DeleteAfter, "When to remove missing files with --delete-missing (before, after, during)")
+	maxDelete := fs.Int("max-delete", 0, "Abort deletion if more than this many files would be removed (0 = no limit)")
+	maxDeletePercent := fs.Float64("max-delete-percent", 0, "Abort deletion if more than this percentage of target files would be removed (0 = no limit)")
+	forceDelete := fs.Bool("force-delete", false, "Delete missing files even if the source is missing, unreadable or empty")
+	yes := fs.Bool("yes", false, "Delete missing files without asking for confirmation on a terminal")
+	confirmThreshold := fs.Int("confirm-threshold", 0, "Only ask for confirmation when more than this many files would be deleted")
+	skipHidden := fs.Bool("skip-hidden", false, "Exclude dotfiles and dot-directories, and on Windows files with the hidden attribute")
+	deleteExcluded := fs.Bool("delete-excluded", false, "Also delete excluded files from the target (implies --delete-missing)")
+	noDefaultExcludes := fs.Bool("no-default-excludes", false, "Do not exclude OS and office junk files such as .DS_Store, Thumbs.db, desktop.ini and ~$* by default")
+	deleteJunk := fs.Bool("delete-junk", false, "Also delete junk files matching the default excludes from the target (implies --delete-missing)")
+	skipLocked := fs.Bool("skip-locked", false, "Skip files locked by other processes and report them instead of failing")
+	retryLocked := fs.Bool("retry-locked", false, "Retry locked files once at the end of the sync (implies --skip-locked)")
+	encryptKey := fs.String("encrypt-key", "", "Encrypt target files with the hex-encoded 256-bit key in this file")
+	chown := fs.String("chown", "", "Set the owner of every file and directory created in the target (user:group, user or :group)")
+	chmod := fs.String("chmod", "", "Set the permissions of every file created in the target, e.g. 0644 or F644,D755 for files and directories")
+	storeCompressed := fs.String("store-compressed", "", "Store target files compressed with this algorithm (zstd), as NAME.zst")
+	linkDest := fs.String("link-dest", "", "Hardlink files unchanged since this previous snapshot of the source instead of copying them; relative to the target")
+	dedupe := fs.Bool("dedupe", false, "Store identical target files once, hardlinked to an object in .snc-objects in the target")
+	encryptNames := fs.Bool("encrypt-names", false, "Also encrypt file and directory names in the target (requires --encrypt-key)")
+
+	return func(command string, args []string) (*Config, error) {
+		var source, target string
+		if len(args) == 2 {
+			source, target = args[0], args[1]
+		}
+		switch command {
+		case CommandConfigShow, CommandConfigInit, CommandServiceUninstall, CommandServiceStart, CommandServiceStop:
+		case CommandVerifyLog:
+			if *auditLog == "" {
+				return nil, fmt.Errorf("invalid arguments: --audit-log is required with verify-log")
+			}
+		case CommandApply:
+			if len(args) == 1 && *planFile == "" {
+				*planFile = args[0]
+			}
+			if *planFile == "" {
+				return nil, fmt.Errorf("invalid arguments: apply requires a plan file")
+			}
+		case CommandScrub:
+			if len(args) == 1 {
+				target = args[0]
+			}
+			if target == "" || *manifest == "" {
+				return nil, fmt.Errorf("invalid arguments: scrub requires a target path and --manifest")
+			}
+		case CommandRetry:
+			if *retryFrom == "" {
+				return nil, fmt.Errorf("invalid arguments: --from is required with retry")
+			}
+			if (source == "") != (target == "") {
+				return nil, fmt.Errorf("invalid arguments: source and target paths must be given together")
+			}
+		default:
+			if source == "" || target == "" {
+				return nil, fmt.Errorf("invalid arguments: source and target paths are required")
+			}
+		}
+
+		switch *direction {
+		case DirectionPush:
+		case DirectionPull:
+			switch {
+			case len(moreTargets) > 0:
+				return nil, fmt.Errorf("invalid arguments: --direction pull is not supported with several targets")
+			case command == CommandDecrypt || command == CommandScrub:
+				return nil, fmt.Errorf("invalid arguments: --direction pull is not supported with %s", command)
+			case *encryptKey != "":
+				return nil, fmt.Errorf("invalid arguments: --direction pull cannot read encrypted targets, use decrypt")
+			}
+			source, target = target, source
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --direction %q (supported: push, pull)", *direction)
+		}
+
+		var targets []string
+		if target != "" {
+			targets = append(targets, target)
+		}
+		targets = append(targets, moreTargets...)
+		if len(targets) > 1 {
+			switch {
+			case !syncsFiles(command):
+				return nil, fmt.Errorf("invalid arguments: several targets are only supported by sync")
+			case *deleteMode == DeleteDuring:
+				return nil, fmt.Errorf("invalid arguments: --delete-mode during is not supported with several targets")
+			case *stateFile != "":
+				return nil, fmt.Errorf("invalid arguments: --state-file is not supported with several targets")
+			case *tempDir != "":
+				return nil, fmt.Errorf("invalid arguments: --temp-dir is not supported with several targets")
+			case *writeChecksums != "":
+				return nil, fmt.Errorf("invalid arguments: --write-checksums is not supported with several targets")
+			}
+		}
+
+		if *interval < 0 || *jitter < 0 {
+			return nil, fmt.Errorf("invalid arguments: --interval and --jitter must not be negative")
+		}
+		if *controlSocket != "" && *interval == 0 {
+			return nil, fmt.Errorf("invalid arguments: --control-socket requires --interval")
+		}
+		if *debounce < 0 {
+			return nil, fmt.Errorf("invalid arguments: --debounce must not be negative")
+		}
+		if *watch && (*interval == 0 || len(moreTargets) > 0 || *filesFrom != "") {
+			return nil, fmt.Errorf("invalid arguments: --watch requires --interval and is not supported with --target or --files-from")
+		}
+		if *deleteAfterConfirm && !*deleteMissing {
+			return nil, fmt.Errorf("invalid arguments: --delete-after-confirm requires --delete-missing")
+		}
+		if *dryRun && (*interval > 0 || len(moreTargets) > 0 || command != CommandSync) {
+			return nil, fmt.Errorf("invalid arguments: --dry-run is only supported by a single sync without --interval")
+		}
+		if *planFile != "" && !*dryRun && command != CommandApply {
+			return nil, fmt.Errorf("invalid arguments: --plan requires --dry-run or apply")
+		}
+		if *notify && *interval > 0 {
+			return nil, fmt.Errorf("invalid arguments: --notify is not supported with --interval")
+		}
+		if (command == CommandServiceInstall || command == CommandServiceRun) && *interval == 0 {
+			return nil, fmt.Errorf("invalid arguments: --interval is required with %s", command)
+		}
+
+		bufSize, err := ParseSize(*bufferSize)
+		if err != nil || bufSize < 4<<10 || bufSize > 1<<30 {
+			return nil, fmt.Errorf("invalid arguments: --buffer-size must be between 4K and 1G")
+		}
+
+		if *walkWorkers < 1 {
+			return nil, fmt.Errorf("invalid arguments: --walk-workers must be at least 1")
+		}
+
+		if *fileTimeout < 0 || *stallTimeout < 0 {
+			return nil, fmt.Errorf("invalid arguments: --file-timeout and --stall-timeout must not be negative")
+		}
+
+		if *maxDuration < 0 {
+			return nil, fmt.Errorf("invalid arguments: --max-duration must not be negative")
+		}
+
+		if *logErrorLimit < 0 {
+			return nil, fmt.Errorf("invalid arguments: --log-error-limit must not be negative")
+		}
+
+		if *confirmThreshold < 0 {
+			return nil, fmt.Errorf("invalid arguments: --confirm-threshold must not be negative")
+		}
+
+		if *maxDelete < 0 || *maxDeletePercent < 0 || *maxDeletePercent > 100 {
+			return nil, fmt.Errorf("invalid arguments: --max-delete must not be negative and --max-delete-percent must be between 0 and 100")
+		}
+
+		switch *order {
+		case OrderAlpha, OrderLargestFirst, OrderSmallestFirst, OrderRandom:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --order %q (supported: alpha, largest-first, smallest-first, random)", *order)
+		}
+
+		switch *deleteMode {
+		case DeleteBefore, DeleteAfter, DeleteDuring:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --delete-mode %q (supported: before, after, during)", *deleteMode)
+		}
+
+		switch *targetChanges {
+		case "":
+		case TargetChangesOverwrite, TargetChangesSkip, TargetChangesError:
+			if *stateFile == "" {
+				return nil, fmt.Errorf("invalid arguments: --target-changes requires --state-file")
+			}
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --target-changes %q (supported: overwrite, skip, error)", *targetChanges)
+		}
+
+		progressSize, err := ParseSize(*fileProgress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments: --file-progress: %w", err)
+		}
+
+		smallLimit, err := ParseSize(*smallFiles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments: --small-files: %w", err)
+		}
+
+		rateLimit, err := ParseSize(*bwLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments: --bwlimit: %w", err)
+		}
+		var schedule []BandwidthWindow
+		if *bwSchedule != "" {
+			if schedule, err = ParseBandwidthSchedule(*bwSchedule); err != nil {
+				return nil, fmt.Errorf("invalid arguments: --bwlimit-schedule: %w", err)
+			}
+		}
+
+		transferLimit, err := ParseSize(*maxTransfer)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arguments: --max-transfer: %w", err)
+		}
+
+		if *changeRetries < 0 {
+			return nil, fmt.Errorf("invalid arguments: --change-retries must not be negative")
+		}
+
+		if *snapshotReleaseCmd != "" && *snapshotCmd == "" {
+			return nil, fmt.Errorf("invalid arguments: --snapshot-release-cmd requires --snapshot-cmd")
+		}
+		if *snapshotCmd != "" && !syncsFiles(command) {
+			return nil, fmt.Errorf("invalid arguments: --snapshot-cmd is only supported by sync")
+		}
+
+		if *deleteJunk && *noDefaultExcludes {
+			return nil, fmt.Errorf("invalid arguments: --delete-junk cannot be used with --no-default-excludes")
+		}
+
+		if *filesFrom != "" {
+			if !syncsFiles(command) {
+				return nil, fmt.Errorf("invalid arguments: --files-from is only supported by sync")
+			}
+			if len(subpaths) > 0 || *deleteMissing || *deleteExcluded || *deleteJunk {
+				return nil, fmt.Errorf("invalid arguments: --files-from is not supported with --subpath or --delete-missing")
+			}
+		}
+
+		switch *webhookFormat {
+		case "auto", "slack", "discord", "teams":
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --webhook-format %q (supported: auto, slack, discord, teams)", *webhookFormat)
+		}
+		switch *webhookOn {
+		case WebhookAlways, WebhookFailure:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --webhook-on %q (supported: always, failure)", *webhookOn)
+		}
+
+		switch *specialFiles {
+		case SpecialFilesSkip, SpecialFilesRecreate, SpecialFilesError:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --special-files %q (supported: skip, recreate, error)", *specialFiles)
+		}
+
+		switch *danglingSymlinks {
+		case DanglingSymlinksSkip, DanglingSymlinksCopy, DanglingSymlinksError:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --dangling-symlinks %q (supported: skip, copy, error)", *danglingSymlinks)
+		}
+
+		switch *permissionErrors {
+		case PermissionErrorsSkip, PermissionErrorsAbort:
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --permission-errors %q (supported: skip, abort)", *permissionErrors)
+		}
+
+		var offset time.Duration
+		detectOffset := *timeOffset == "auto"
+		if *timeOffset != "" && !detectOffset {
+			if offset, err = time.ParseDuration(*timeOffset); err != nil {
+				return nil, fmt.Errorf("invalid arguments: unsupported --time-offset %q (supported: a duration like -1h, auto)", *timeOffset)
+			}
+		}
+
+		switch *storeCompressed {
+		case "":
+		case CompressZstd:
+			if *encryptKey != "" || *direction == DirectionPull {
+				return nil, fmt.Errorf("invalid arguments: --store-compressed is not supported with --encrypt-key or --direction pull")
+			}
+		default:
+			return nil, fmt.Errorf("invalid arguments: unsupported --store-compressed %q (supported: zstd)", *storeCompressed)
+		}
+
+		if (*chmod != "" || *chown != "") && (*updateMethod == "meta" || strings.Contains(*strategyMap, "=meta")) {
+			return nil, fmt.Errorf("invalid arguments: --chmod and --chown are not supported with the meta update method, which keeps the metadata of the source")
+		}
+
+		if *dedupe && (*encryptKey != "" || *direction == DirectionPull) {
+			return nil, fmt.Errorf("invalid arguments: --dedupe is not supported with --encrypt-key or --direction pull")
+		}
+
+		cleaned, err := cleanSubpaths(subpaths)
+		if err != nil {
+			return nil, err
+		}
+
+		if *linkDest != "" && (*storeCompressed != "" || *direction == DirectionPull) {
+			return nil, fmt.Errorf("invalid arguments: --link-dest is not supported with --store-compressed or --direction pull")
+		}
+
+		if *encryptKey == "" && (*encryptNames || command == CommandDecrypt) {
+			return nil, fmt.Errorf("invalid arguments: --encrypt-key is required with --encrypt-names and decrypt")
+		}
+
+		cfg := &Config{
+			Command:          command,
+			Source:           source,
+			Target:           target,
+			Targets:          targets,
+			DeleteMissing:    *deleteMissing || *deleteExcluded || *deleteJunk,
+			Direction:        *direction,
+			LogLevel:         *logLevel,
+			NoColor:          *noColor,
+			LogErrorLimit:    *logErrorLimit,
+			UpdateMethod:     *updateMethod,
+			StrategyMap:      *strategyMap,
+			JSON:             *jsonOutput,
+			Itemize:          *itemize,
+			MetricsAddr:      *metricsAddr,
+			Interval:         *interval,
+			Jitter:           *jitter,
+			Watch:            *watch,
+			Debounce:         *debounce,
+			PIDFile:          *pidFile,
+			EncryptKey:       *encryptKey,
+			EncryptNames:     *encryptNames,
+			StoreCompressed:  *storeCompressed,
+			Dedupe:           *dedupe,
+			LinkDest:         *linkDest,
+			Chown:            *chown,
+			Chmod:            *chmod,
+			SkipLocked:       *skipLocked || *retryLocked,
+			RetryLocked:      *retryLocked,
+			Exclude:          exclude,
+			Subpaths:         cleaned,
+			FilesFrom:        *filesFrom,
+			FilterFile:       *filterFile,
+			SkipHidden:       *skipHidden,
+			IncludeJunk:      *noDefaultExcludes,
+			DeleteJunk:       *deleteJunk,
+			MaxTransfer:      transferLimit,
+			MaxDuration:      *maxDuration,
+			SmallFiles:       smallLimit,
+			BwLimit:          rateLimit,
+			BwSchedule:       schedule,
+			Nice:             *nice,
+			ServiceName:      *serviceName,
+			DryRun:           *dryRun,
+			StageDeletes:     *deleteAfterConfirm,
+			StageWrites:      *stageWrites,
+			Plan:             *planFile,
+			VerifyReport:     *verifyReport,
+			WriteChecksums:   *writeChecksums,
+			SourceChecksums:  *sourceChecksums,
+			Manifest:         *manifest,
+			ControlSocket:    *controlSocket,
+			Notify:           *notify,
+			AuditLog:         *auditLog,
+			Webhooks:         webhooks,
+			WebhookFormat:    *webhookFormat,
+			WebhookOn:        *webhookOn,
+			Print0:           *print0,
+			DeleteExcluded:   *deleteExcluded,
+			DeleteMode:       *deleteMode,
+			MaxDelete:        *maxDelete,
+			MaxDeletePercent: *maxDeletePercent,
+			ForceDelete:      *forceDelete,
+			Yes:              *yes,
+			ConfirmThreshold: *confirmThreshold,
+			Prescan:          *prescan,
+			Order:            *order,
+			FileTimeout:      *fileTimeout,
+			StallTimeout:     *stallTimeout,
+			WalkWorkers:      *walkWorkers,
+			BufferSize:       int(bufSize),
+			NoCache:          *noCache,
+			Preallocate:      *preallocate,
+			TempDir:          *tempDir,
+			ErrorReport:      *errorReport,
+			RetryFrom:        *retryFrom,
+			StateFile:        *stateFile,
+			TargetChanges:    *targetChanges,
+			FileProgress:     progressSize,
+			ChangeRetries:    *changeRetries,
+			SnapshotCommand:  *snapshotCmd,
+			SnapshotRelease:  *snapshotReleaseCmd,
+			SpecialFiles:     *specialFiles,
+			DanglingSymlinks: *danglingSymlinks,
+			PermissionErrors: *permissionErrors,
+			ForceTypeReplace: *forceTypeReplace,
+			TimeOffset:       offset,
+			DetectTimeOffset: detectOffset,
+			ConfigFile:       *configFile,
+		}
+
+		return cfg, nil
 	}
+}
 
-	return &FlagConfig{cfg: cfg}, nil
+// cleanSubpaths normalizes the --subpath values. They must be relative,
+// stay inside the tree and not overlap, so no file is synced twice.
+func cleanSubpaths(paths []string) ([]string, error) {
+	var cleaned []string
+	for _, path := range paths {
+		path = filepath.Clean(filepath.FromSlash(path))
+		if filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("invalid arguments: --subpath %q must be a relative path inside the source", path)
+		}
+		for _, other := range cleaned {
+			if path == other || strings.HasPrefix(path, other+string(filepath.Separator)) || strings.HasPrefix(other, path+string(filepath.Separator)) {
+				return nil, fmt.Errorf("invalid arguments: --subpath %q overlaps with %q", path, other)
+			}
+		}
+		cleaned = append(cleaned, path)
+	}
+	return cleaned, nil
 }
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"snc/internal/webhook"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the settings that hold the source and target paths. They are
+// positional arguments on the command line but named everywhere else.
+const (
+	settingSource = "source"
+	settingTarget = "target"
+)
+
+// listSettings can be given several times. In environment variables their
+// values are separated by commas.
+var listSettings = map[string]bool{
+	"exclude":     true,
+	"subpath":     true,
+	"webhook":     true,
+	settingTarget: true,
+}
+
+// SourceDefault is reported as the source of settings no source has set
+const SourceDefault = "default"
+
+// SettingsSource supplies raw setting values by flag name, e.g. from the
+// command line, the environment or a config file
+type SettingsSource interface {
+	// Name describes the source in messages, e.g. "env" or a file path
+	Name() string
+	// Settings returns the values of all settings the source sets. List
+	// settings may have several values, all others use the last one.
+	Settings() (map[string][]string, error)
+}
+
+// Setting is the effective value of a single setting and the source it
+// came from
+type Setting struct {
+	Name string `json:"name"`
+	// Value is the effective value; the values of list settings are
+	// joined by commas
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Values holds the raw values the source set, if any
+	Values []string `json:"-"`
+}
+
+// secretSettings hold secrets in their values, which are never printed or
+// logged in full: the path and query of a webhook URL authorize posting
+var secretSettings = map[string]bool{
+	"webhook": true,
+}
+
+// Secret reports whether the values of s hold secrets
+func (s Setting) Secret() bool {
+	return secretSettings[s.Name]
+}
+
+// Redacted returns s with the secrets in its values hidden, for showing it
+func (s Setting) Redacted() Setting {
+	if !s.Secret() || s.Source == SourceDefault {
+		return s
+	}
+	values := make([]string, len(s.Values))
+	for i, v := range s.Values {
+		values[i] = webhook.Redact(v)
+	}
+	s.Values = values
+	s.Value = strings.Join(values, ",")
+	return s
+}
+
+// MultiProvider implements ConfigProvider and Reloader by merging several
+// settings sources. For every setting the values of the first source that
+// sets it are used and all later sources are ignored, so sources are given
+// in order of precedence. Settings no source sets keep their defaults.
+type MultiProvider struct {
+	command  string
+	sources  []SettingsSource
+	cfg      *Config
+	settings []Setting
+}
+
+// NewMultiProvider reads the sources, highest precedence first, and builds
+// the config for command from them
+func NewMultiProvider(command string, sources ...SettingsSource) (*MultiProvider, error) {
+	p := &MultiProvider{command: command, sources: sources}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Load builds the config from the command-line arguments, SNC_* environment
+// variables and the config file named by --config or SNC_CONFIG, in that
+// order of precedence
+func Load(args []string) (*MultiProvider, error) {
+	flags, err := NewFlagSource(args)
+	if err != nil {
+		return nil, err
+	}
+	sources := []SettingsSource{flags, EnvSource{}}
+
+	path := ""
+	for _, src := range sources {
+		settings, err := src.Settings()
+		if err != nil {
+			return nil, err
+		}
+		if v := settings["config"]; len(v) > 0 {
+			path = v[len(v)-1]
+			break
+		}
+	}
+	if path != "" {
+		sources = append(sources, FileSource{Path: path})
+	}
+
+	return NewMultiProvider(flags.Command(), sources...)
+}
+
+// Config returns the merged config
+func (p *MultiProvider) Config() *Config {
+	return p.cfg
+}
+
+// Settings returns the effective value of every setting and its source,
+// sorted by name with the source and target paths first
+func (p *MultiProvider) Settings() []Setting {
+	return p.settings
+}
+
+// Reload re-reads all sources and rebuilds the config. The previous config
+// is kept if a source cannot be read or the result is invalid.
+func (p *MultiProvider) Reload() error {
+	layers := make([]map[string][]string, len(p.sources))
+	for i, src := range p.sources {
+		settings, err := src.Settings()
+		if err != nil {
+			return err
+		}
+		layers[i] = settings
+	}
+
+	fs := flag.NewFlagSet("snc", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	build := defineFlags(fs)
+
+	names := settingNames(fs)
+	for i, layer := range layers {
+		for _, name := range sortedSettingNames(layer) {
+			if !slices.Contains(names, name) {
+				return fmt.Errorf("%s: unknown setting %q", p.sources[i].Name(), name)
+			}
+		}
+	}
+
+	var settings []Setting
+	paths := make([]string, 2)
+	for _, name := range names {
+		setting := Setting{Name: name, Source: SourceDefault}
+		if f := fs.Lookup(name); f != nil {
+			setting.Value = f.DefValue
+		}
+		for i, layer := range layers {
+			values, ok := layer[name]
+			if !ok || len(values) == 0 {
+				continue
+			}
+			// The first target is the positional one, the others are
+			// given by the --target flag
+			flagValues := values
+			if name == settingTarget {
+				flagValues = values[1:]
+			}
+			if f := fs.Lookup(name); f != nil {
+				for _, v := range flagValues {
+					if err := fs.Set(name, v); err != nil {
+						return fmt.Errorf("%s: invalid value %q for %s: %w", p.sources[i].Name(), v, name, err)
+					}
+				}
+			}
+			setting.Value = strings.Join(values, ",")
+			if !listSettings[name] {
+				setting.Value = values[len(values)-1]
+			}
+			setting.Source = p.sources[i].Name()
+			setting.Values = values
+			break
+		}
+		switch name {
+		case settingSource:
+			paths[0] = setting.Value
+		case settingTarget:
+			if len(setting.Values) > 0 {
+				paths[1] = setting.Values[0]
+			}
+		}
+		settings = append(settings, setting)
+	}
+
+	cfg, err := build(p.command, paths)
+	if err != nil {
+		return err
+	}
+	p.cfg = cfg
+	p.settings = settings
+	return nil
+}
+
+// FlagSource reads settings from command-line arguments
+type FlagSource struct {
+	command  string
+	settings map[string][]string
+}
+
+// NewFlagSource parses args, which may start with a subcommand. The source
+// and target paths are optional but must be given together.
+func NewFlagSource(args []string) (*FlagSource, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	defineFlags(fs)
+
+	settings := map[string][]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		f.Value = &recordingValue{Value: f.Value, name: f.Name, settings: settings}
+	})
+	// Print the usage from unwrapped flags to keep their value types
+	fs.Usage = func() {
+		plain := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		defineFlags(plain)
+		plain.SetOutput(fs.Output())
+		plain.Usage()
+	}
+
+	command, paths, err := parseArgs(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(paths) == 0:
+	case len(paths) == 1 && command == CommandApply:
+		// The plan file names source and target
+		settings["plan"] = paths
+	case len(paths) == 1 && command == CommandScrub:
+		// Scrub reads the target alone
+		settings[settingTarget] = append(paths, settings[settingTarget]...)
+	case len(paths) == 2:
+		settings[settingSource] = paths[:1]
+		settings[settingTarget] = append(paths[1:], settings[settingTarget]...)
+	default:
+		return nil, fmt.Errorf("invalid arguments: source and target paths are required")
+	}
+	return &FlagSource{command: command, settings: settings}, nil
+}
+
+// Name implements SettingsSource
+func (*FlagSource) Name() string {
+	return "flags"
+}
+
+// Settings implements SettingsSource
+func (f *FlagSource) Settings() (map[string][]string, error) {
+	return f.settings, nil
+}
+
+// Command returns the subcommand given on the command line
+func (f *FlagSource) Command() string {
+	return f.command
+}
+
+// recordingValue wraps a flag value and records every raw value it is set to
+type recordingValue struct {
+	flag.Value
+	name     string
+	settings map[string][]string
+}
+
+func (v *recordingValue) Set(s string) error {
+	if err := v.Value.Set(s); err != nil {
+		return err
+	}
+	v.settings[v.name] = append(v.settings[v.name], s)
+	return nil
+}
+
+// IsBoolFlag keeps boolean flags usable without a value
+func (v *recordingValue) IsBoolFlag() bool {
+	b, ok := v.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// EnvSource reads settings from SNC_* environment variables. The variable
+// name is the setting name in upper case with dashes replaced by
+// underscores, e.g. SNC_DELETE_MISSING=true or SNC_EXCLUDE="*.tmp, *.bak".
+type EnvSource struct{}
+
+// Name implements SettingsSource
+func (EnvSource) Name() string {
+	return "env"
+}
+
+// Settings implements SettingsSource
+func (EnvSource) Settings() (map[string][]string, error) {
+	fs := flag.NewFlagSet("snc", flag.ContinueOnError)
+	defineFlags(fs)
+
+	settings := map[string][]string{}
+	for _, name := range settingNames(fs) {
+		v, ok := os.LookupEnv(envName(name))
+		if !ok {
+			continue
+		}
+		if listSettings[name] {
+			for _, item := range strings.Split(v, ",") {
+				settings[name] = append(settings[name], strings.TrimSpace(item))
+			}
+		} else {
+			settings[name] = []string{v}
+		}
+	}
+	return settings, nil
+}
+
+// envName returns the environment variable for a setting
+func envName(setting string) string {
+	return "SNC_" + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// FileSource reads settings from a file of "name = value" lines. Blank lines
+// and lines starting with # are ignored, values may be double-quoted and
+// list settings may be repeated.
+type FileSource struct {
+	Path string
+}
+
+// Name implements SettingsSource
+func (f FileSource) Name() string {
+	return f.Path
+}
+
+// Settings implements SettingsSource
+func (f FileSource) Settings() (map[string][]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer file.Close()
+
+	settings := map[string][]string{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected \"name = value\"", f.Path, line)
+		}
+		if name == "config" {
+			return nil, fmt.Errorf("%s:%d: config files cannot include other config files", f.Path, line)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid quoted value for %s", f.Path, line, name)
+			}
+		}
+		settings[name] = append(settings[name], value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return settings, nil
+}
+
+// settingNames returns the source and target settings followed by the
+// flags defined on fs in lexical order
+func settingNames(fs *flag.FlagSet) []string {
+	names := []string{settingSource, settingTarget}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != settingTarget {
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+// sortedSettingNames returns the names set in settings in lexical order
+func sortedSettingNames(settings map[string][]string) []string {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte count with an optional binary unit suffix, e.g.
+// "65536", "512K", "4M" or "1GiB"
+func ParseSize(s string) (int64, error) {
+	spec := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(spec); n > 0 {
+		switch spec[n-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			spec = spec[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid size %q (expected a byte count like 65536, 512K, 4M or 1G)", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteTemplate writes a config file to w that documents every setting.
+// Settings taken from a source are written with their values, all others
+// are commented out with their defaults. Values holding secrets are
+// commented out with the secret redacted, so it never ends up in the file.
+func WriteTemplate(w io.Writer, settings []Setting) error {
+	fs := flag.NewFlagSet("snc", flag.ContinueOnError)
+	defineFlags(fs)
+	usage := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { usage[f.Name] = f.Usage })
+	usage[settingSource] = "Source directory path"
+	usage[settingTarget] = "Target directory path (repeatable to mirror to several targets)"
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# snc configuration file")
+	fmt.Fprintln(bw, "#")
+	fmt.Fprintln(bw, "# Use with --config FILE or SNC_CONFIG=FILE. Every line is \"name = value\";")
+	fmt.Fprintln(bw, "# values may be double-quoted and repeatable settings may appear several")
+	fmt.Fprintln(bw, "# times. Command-line flags and SNC_* environment variables take precedence.")
+
+	for _, s := range settings {
+		// A config file cannot name another config file
+		if s.Name == "config" {
+			continue
+		}
+		fmt.Fprintf(bw, "\n# %s\n", usage[s.Name])
+		if s.Source == SourceDefault {
+			fmt.Fprintln(bw, strings.TrimSpace(fmt.Sprintf("#%s = %s", s.Name, quoteValue(s.Value))))
+			continue
+		}
+		if s.Secret() {
+			for _, v := range s.Redacted().Values {
+				fmt.Fprintf(bw, "#%s = %s\n", s.Name, quoteValue(v))
+			}
+			continue
+		}
+		for _, v := range s.Values {
+			fmt.Fprintf(bw, "%s = %s\n", s.Name, quoteValue(v))
+		}
+	}
+	return bw.Flush()
+}
+
+// quoteValue quotes v if the config file parser would not read it back
+// unchanged
+func quoteValue(v string) string {
+	if v != strings.TrimSpace(v) || strings.HasPrefix(v, `"`) {
+		return strconv.Quote(v)
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package control
+
+import (
+	"fmt"
+	"snc/internal/events"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer is the number of events queued for a subscriber; a
+// subscriber reading slower than that misses events rather than slowing
+// the sync down
+const subscriberBuffer = 256
+
+// Feed is an events.EventSink counting the files of the current run and
+// passing its events on to subscribers
+type Feed struct {
+	mu          sync.Mutex
+	progress    Progress
+	subscribers map[chan Event]bool
+}
+
+// NewFeed returns an empty Feed
+func NewFeed() *Feed {
+	return &Feed{subscribers: make(map[chan Event]bool)}
+}
+
+// Reset clears the counts at the start of a run
+func (f *Feed) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.progress = Progress{}
+}
+
+// Counts returns the counts of the current or last run
+func (f *Feed) Counts() Progress {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.progress
+}
+
+// subscribe returns a channel receiving every event from now on
+func (f *Feed) subscribe() chan Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan Event, subscriberBuffer)
+	f.subscribers[ch] = true
+	return ch
+}
+
+// unsubscribe stops sending events to ch
+func (f *Feed) unsubscribe(ch chan Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, ch)
+}
+
+// publish counts ev with count and sends it to the subscribers
+func (f *Feed) publish(ev Event, count func(*Progress)) {
+	ev.Time = time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	count(&f.progress)
+	for ch := range f.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (f *Feed) FileCopied(ev events.FileEvent) {
+	f.publish(Event{Type: "copied", Path: ev.Path, Bytes: ev.Bytes, Update: ev.Update}, func(p *Progress) {
+		p.Copied++
+		p.Bytes += ev.Bytes
+	})
+}
+
+func (f *Feed) FileSkipped(ev events.FileEvent) {
+	f.publish(Event{Type: "skipped", Path: ev.Path}, func(p *Progress) { p.Skipped++ })
+}
+
+func (f *Feed) FileDeleted(ev events.FileEvent) {
+	f.publish(Event{Type: "deleted", Path: ev.Path}, func(p *Progress) { p.Deleted++ })
+}
+
+func (f *Feed) Error(ev events.ErrorEvent) {
+	msg := fmt.Sprintf("%s: %v", ev.Message, ev.Err)
+	f.publish(Event{Type: "error", Path: ev.Path, Message: msg}, func(p *Progress) { p.Errors++ })
+}
+
+// Progress passes on messages up to the info level; debug and trace
+// messages would flood subscribers
+func (f *Feed) Progress(ev events.ProgressEvent) {
+	if ev.Level > events.LevelInfo {
+		return
+	}
+	f.publish(Event{Type: "progress", Message: ev.Message}, func(*Progress) {})
+}
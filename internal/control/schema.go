@@ -0,0 +1,99 @@
+// Package control serves a local control socket for the daemon, speaking
+// JSON-RPC 2.0 with one JSON message per line, so that a GUI or scripts
+// can drive snc: start, stop, pause and resume runs, query the state and
+// progress, and follow the events of runs as they happen.
+package control
+
+import (
+	"encoding/json"
+	"snc/internal/daemon"
+	"time"
+)
+
+// Methods of the control socket. None of them take params.
+const (
+	// MethodStatus returns a StatusResult
+	MethodStatus = "status"
+	// MethodStart starts a run right away and returns true
+	MethodStart = "start"
+	// MethodStop cancels the current run and returns whether there was one
+	MethodStop = "stop"
+	// MethodPause keeps scheduled runs from starting and returns true
+	MethodPause = "pause"
+	// MethodResume restarts the schedule and returns true
+	MethodResume = "resume"
+	// MethodSubscribe returns true and then sends an EventNotification
+	// for every event of a run over the same connection
+	MethodSubscribe = "subscribe"
+)
+
+// NotificationEvent is the method of the notifications carrying events
+const NotificationEvent = "event"
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+)
+
+// Request is a JSON-RPC request; without ID it is a notification, which
+// gets no response
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response, holding either Result or Error
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// StatusResult is the result of MethodStatus
+type StatusResult struct {
+	daemon.Status
+	// Progress counts the files of the current or last run
+	Progress Progress `json:"progress"`
+}
+
+// Progress counts the files processed by a run
+type Progress struct {
+	Copied  int64 `json:"copied"`
+	Skipped int64 `json:"skipped"`
+	Deleted int64 `json:"deleted"`
+	Errors  int64 `json:"errors"`
+	// Bytes is the amount of data written to the target
+	Bytes int64 `json:"bytes"`
+}
+
+// Event is an event of a run, sent to subscribers
+type Event struct {
+	Time time.Time `json:"time"`
+	// Type is copied, skipped, deleted, error or progress
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+	// Bytes written to the target by a copy
+	Bytes int64 `json:"bytes,omitempty"`
+	// Update is set for copies overwriting a target file
+	Update bool `json:"update,omitempty"`
+	// Message is the text of progress and error events
+	Message string `json:"message,omitempty"`
+}
+
+// EventNotification is the JSON-RPC notification sent to subscribers
+type EventNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  Event  `json:"params"`
+}
@@ -0,0 +1,172 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"snc/internal/daemon"
+	"snc/internal/logger"
+	"sync"
+)
+
+// maxRequestSize limits a request line
+const maxRequestSize = 64 << 10
+
+// Server serves the control socket
+type Server struct {
+	listener net.Listener
+	path     string
+	ctl      *daemon.Control
+	feed     *Feed
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// Listen creates the control socket at path, readable and writable by the
+// owner only. A socket left behind by a daemon that is gone is replaced.
+func Listen(path string, ctl *daemon.Control, feed *Feed) (*Server, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("control socket %s is in use by another instance", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot remove stale control socket %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("cannot restrict control socket: %w", err)
+	}
+	return &Server{listener: listener, path: path, ctl: ctl, feed: feed, conns: make(map[net.Conn]bool)}, nil
+}
+
+// Serve accepts connections until Close is called
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.Warn("CONTROL", "Failed to accept connection: %v", err)
+			continue
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections, closes the open ones and removes the
+// socket
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	os.Remove(s.path)
+	return err
+}
+
+// serveConn answers the requests on conn, one JSON message per line
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	// Responses and events are written from different goroutines
+	var writeMu sync.Mutex
+	enc := json.NewEncoder(conn)
+	write := func(v any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return enc.Encode(v)
+	}
+
+	var events chan Event
+	done := make(chan struct{})
+	defer close(done)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxRequestSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			write(&Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: codeParseError, Message: "parse error"}})
+			continue
+		}
+
+		result, rpcErr := s.call(&req)
+		if req.ID != nil {
+			if err := write(&Response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}); err != nil {
+				return
+			}
+		}
+		// Events follow the response to the subscription
+		if req.Method == MethodSubscribe && rpcErr == nil && events == nil {
+			events = s.feed.subscribe()
+			defer s.feed.unsubscribe(events)
+			go forwardEvents(events, done, write)
+		}
+	}
+}
+
+// call runs the method of req and returns its result or error
+func (s *Server) call(req *Request) (any, *Error) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, &Error{Code: codeInvalidRequest, Message: "invalid request"}
+	}
+	switch req.Method {
+	case MethodStatus:
+		return &StatusResult{Status: s.ctl.Status(), Progress: s.feed.Counts()}, nil
+	case MethodStart:
+		logger.Info("CONTROL", "Run requested over the control socket")
+		s.ctl.Start()
+		return true, nil
+	case MethodStop:
+		stopped := s.ctl.Stop()
+		if stopped {
+			logger.Info("CONTROL", "Stopping the current run as requested over the control socket")
+		}
+		return stopped, nil
+	case MethodPause:
+		logger.Info("CONTROL", "Pausing the schedule as requested over the control socket")
+		s.ctl.Pause()
+		return true, nil
+	case MethodResume:
+		logger.Info("CONTROL", "Resuming the schedule as requested over the control socket")
+		s.ctl.Resume()
+		return true, nil
+	case MethodSubscribe:
+		return true, nil
+	}
+	return nil, &Error{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q not found", req.Method)}
+}
+
+// forwardEvents writes the events from ch as notifications until done is
+// closed or writing fails
+func forwardEvents(ch <-chan Event, done <-chan struct{}, write func(any) error) {
+	for {
+		select {
+		case <-done:
+			return
+		case ev := <-ch:
+			if err := write(&EventNotification{JSONRPC: "2.0", Method: NotificationEvent, Params: ev}); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"snc/internal/daemon"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+// dial connects to the server at path and returns a function sending a
+// request and one reading the next message
+func dial(t *testing.T, path string) (func(method string), func() map[string]any) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	scanner := bufio.NewScanner(conn)
+	id := 0
+	send := func(method string) {
+		id++
+		req, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method})
+		if _, err := conn.Write(append(req, '\n')); err != nil {
+			t.Fatalf("Failed to send %s: %v", method, err)
+		}
+	}
+	read := func() map[string]any {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if !scanner.Scan() {
+			t.Fatalf("Failed to read: %v", scanner.Err())
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("Invalid message %q: %v", scanner.Bytes(), err)
+		}
+		return msg
+	}
+	return send, read
+}
+
+func TestServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ctl := daemon.NewControl()
+	feed := NewFeed()
+	server, err := Listen(path, ctl, feed)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Close()
+	go server.Serve()
+
+	if _, err := Listen(path, ctl, feed); err == nil {
+		t.Error("Expected a second server on the same socket to be refused")
+	}
+
+	send, read := dial(t, path)
+	send(MethodPause)
+	if msg := read(); msg["result"] != true {
+		t.Errorf("Expected pause to succeed, got %v", msg)
+	}
+	feed.FileCopied(events.FileEvent{Path: "a.txt", Bytes: 10})
+	send(MethodStatus)
+	result, _ := read()["result"].(map[string]any)
+	progress, _ := result["progress"].(map[string]any)
+	if result["state"] != daemon.StatePaused || progress["copied"] != float64(1) || progress["bytes"] != float64(10) {
+		t.Errorf("Expected a paused state with one copied file, got %v", result)
+	}
+
+	send("restart")
+	if msg := read(); msg["error"] == nil {
+		t.Errorf("Expected an error for an unknown method, got %v", msg)
+	}
+
+	send(MethodSubscribe)
+	if msg := read(); msg["result"] != true {
+		t.Fatalf("Expected subscribe to succeed, got %v", msg)
+	}
+	feed.FileDeleted(events.FileEvent{Path: "old.txt"})
+	msg := read()
+	params, _ := msg["params"].(map[string]any)
+	if msg["method"] != NotificationEvent || params["type"] != "deleted" || params["path"] != "old.txt" {
+		t.Errorf("Expected the deletion as an event notification, got %v", msg)
+	}
+}
@@ -0,0 +1,275 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Encrypted files are written as a header followed by a sequence of
+// AES-256-GCM sealed chunks (the STREAM construction):
+//
+//	magic (8 bytes) | nonce prefix (7 bytes) | chunk...
+//
+// Every chunk holds up to chunkSize bytes of plaintext plus a 16 byte tag.
+// Its nonce is the prefix, a 4 byte big-endian counter and a final-chunk
+// flag, so reordered, duplicated or truncated chunks fail authentication.
+const (
+	magic      = "SNCENC1\n"
+	prefixSize = 7
+	headerSize = len(magic) + prefixSize
+	chunkSize  = 64 * 1024
+	tagSize    = 16
+	KeySize    = 32
+)
+
+// Cipher encrypts file contents and names with keys derived from a single
+// 256-bit user key
+type Cipher struct {
+	content cipher.AEAD
+	names   cipher.AEAD
+	sivKey  []byte
+}
+
+// LoadKey reads a 256-bit key stored as 64 hexadecimal characters, e.g.
+// generated with `openssl rand -hex 32`
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read key file %s: %w", path, err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("key file %s must contain %d hex-encoded bytes", path, KeySize)
+	}
+	return key, nil
+}
+
+// New creates a Cipher from a 256-bit key
+func New(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+
+	content, err := newAEAD(deriveKey(key, "snc content"))
+	if err != nil {
+		return nil, err
+	}
+	names, err := newAEAD(deriveKey(key, "snc names"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{content: content, names: names, sivKey: deriveKey(key, "snc names siv")}, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedSize returns the size of the encrypted form of n plaintext bytes
+func EncryptedSize(n int64) int64 {
+	chunks := (n + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(headerSize) + n + chunks*tagSize
+}
+
+// NewWriter returns a writer encrypting everything written to it into w.
+// Close must be called to write the final chunk; it does not close w.
+func (c *Cipher) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("cannot generate nonce: %w", err)
+	}
+
+	header := append([]byte(magic), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{aead: c.content, w: w, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+// NewReader returns a reader decrypting the contents of r. Read returns an
+// error if the data was modified or truncated.
+func (c *Cipher) NewReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("not an encrypted file: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not an encrypted file: bad header")
+	}
+
+	return &decryptReader{
+		aead:   c.content,
+		r:      bufio.NewReaderSize(r, chunkSize+tagSize),
+		prefix: header[len(magic):],
+		chunk:  make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, prefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("write to closed encrypt writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// Keep a full chunk buffered until more data arrives, so the last
+		// chunk can always be flagged as final on Close
+		if len(e.buf) == chunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):chunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.counter, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+type decryptReader struct {
+	aead    cipher.AEAD
+	r       *bufio.Reader
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the following chunk. A chunk is final when no data
+// follows it.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.chunk)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		d.done = true
+	case err != nil:
+		return err
+	default:
+		if _, peekErr := d.r.Peek(1); peekErr == io.EOF {
+			d.done = true
+		} else if peekErr != nil {
+			return peekErr
+		}
+	}
+
+	sealed := d.chunk[:n]
+	if len(sealed) < tagSize {
+		return fmt.Errorf("encrypted file is truncated")
+	}
+
+	plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.counter, d.done), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt chunk %d: file is corrupted, truncated or encrypted with another key", d.counter)
+	}
+	d.counter++
+	d.plain = plain
+	return nil
+}
+
+// EncryptName deterministically encrypts a single path component, so the
+// same name always maps to the same target name across runs. The result is
+// URL-safe base64 and never contains path separators.
+func (c *Cipher) EncryptName(name string) string {
+	mac := hmac.New(sha256.New, c.sivKey)
+	mac.Write([]byte(name))
+	nonce := mac.Sum(nil)[:c.names.NonceSize()]
+
+	sealed := c.names.Seal(nil, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(append(nonce, sealed...))
+}
+
+// DecryptName reverses EncryptName
+func (c *Cipher) DecryptName(encrypted string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < c.names.NonceSize()+tagSize {
+		return "", fmt.Errorf("%q is not an encrypted name", encrypted)
+	}
+
+	nonceSize := c.names.NonceSize()
+	plain, err := c.names.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt name %q: %w", encrypted, err)
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,156 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	c, err := New(key)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	return c
+}
+
+func encrypt(t *testing.T, c *Cipher, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(c *Cipher, data []byte) ([]byte, error) {
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+
+	sizes := []int{0, 1, 100, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17}
+	for _, size := range sizes {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		data := encrypt(t, c, plain)
+		if int64(len(data)) != EncryptedSize(int64(size)) {
+			t.Errorf("size %d: expected %d encrypted bytes, got %d", size, EncryptedSize(int64(size)), len(data))
+		}
+
+		got, err := decrypt(c, data)
+		if err != nil {
+			t.Fatalf("size %d: decrypt failed: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: decrypted content does not match", size)
+		}
+	}
+}
+
+func TestTamperDetection(t *testing.T) {
+	c := newTestCipher(t)
+	plain := bytes.Repeat([]byte("x"), 2*chunkSize+10)
+	data := encrypt(t, c, plain)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"flipped bit", func() []byte {
+			d := bytes.Clone(data)
+			d[headerSize+5] ^= 1
+			return d
+		}()},
+		{"truncated at chunk boundary", data[:headerSize+2*(chunkSize+tagSize)]},
+		{"truncated mid chunk", data[:len(data)-3]},
+		{"wrong key", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cipher, input := c, tt.data
+			if input == nil {
+				cipher, input = newTestCipher(t), data
+			}
+			if _, err := decrypt(cipher, input); err == nil {
+				t.Error("Expected decryption to fail")
+			}
+		})
+	}
+}
+
+func TestNotEncrypted(t *testing.T) {
+	c := newTestCipher(t)
+	if _, err := decrypt(c, []byte("plain text file contents")); err == nil {
+		t.Error("Expected error for a file without the encryption header")
+	}
+}
+
+func TestNames(t *testing.T) {
+	c := newTestCipher(t)
+
+	for _, name := range []string{"a", "report.pdf", "with space.txt", ".hidden"} {
+		enc := c.EncryptName(name)
+		if strings.ContainsAny(enc, "/\\") {
+			t.Errorf("Encrypted name %q contains a path separator", enc)
+		}
+		if enc != c.EncryptName(name) {
+			t.Errorf("Encrypting %q is not deterministic", name)
+		}
+
+		dec, err := c.DecryptName(enc)
+		if err != nil {
+			t.Fatalf("DecryptName(%q) failed: %v", enc, err)
+		}
+		if dec != name {
+			t.Errorf("Expected %q, got %q", name, dec)
+		}
+	}
+
+	if _, err := c.DecryptName("not-encrypted.txt"); err == nil {
+		t.Error("Expected error for a plain name")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid")
+	os.WriteFile(valid, []byte(strings.Repeat("ab", KeySize)+"\n"), 0600)
+	if key, err := LoadKey(valid); err != nil || len(key) != KeySize {
+		t.Errorf("Expected valid key, got %v (%d bytes)", err, len(key))
+	}
+
+	short := filepath.Join(dir, "short")
+	os.WriteFile(short, []byte("abcd"), 0600)
+	if _, err := LoadKey(short); err == nil {
+		t.Error("Expected error for short key")
+	}
+
+	if _, err := LoadKey(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected error for missing key file")
+	}
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// States of the loop reported in Status.State
+const (
+	StateIdle    = "idle"
+	StateRunning = "running"
+	StatePaused  = "paused"
+)
+
+// Status describes the state of the loop
+type Status struct {
+	State string `json:"state"`
+	// Run is the number of the current or last run, 0 before the first
+	Run        int       `json:"run"`
+	RunStarted time.Time `json:"run_started,omitzero"`
+	// NextRun is when the next run is due, zero while running
+	NextRun time.Time `json:"next_run,omitzero"`
+	// LastRun is the outcome of the last finished run
+	LastRun *RunResult `json:"last_run,omitempty"`
+}
+
+// RunResult is the outcome of a finished run
+type RunResult struct {
+	Run      int       `json:"run"`
+	Finished time.Time `json:"finished"`
+	Seconds  float64   `json:"seconds"`
+	// Error is the error the run failed with, empty on success
+	Error string `json:"error,omitempty"`
+}
+
+// Control lets other goroutines drive the loop, e.g. a control socket:
+// start a run now, cancel the current run, pause and resume the schedule,
+// and query the state. Given in Options, it is used by a single loop.
+type Control struct {
+	start  chan struct{}
+	resume chan struct{}
+
+	mu     sync.Mutex
+	status Status
+	paused bool
+	// cancel cancels the current run, nil between runs
+	cancel context.CancelFunc
+}
+
+// NewControl returns a Control for Options
+func NewControl() *Control {
+	return &Control{
+		start:  make(chan struct{}, 1),
+		resume: make(chan struct{}, 1),
+		status: Status{State: StateIdle},
+	}
+}
+
+// Start starts a run right away, even while paused; it is ignored while a
+// run is in progress
+func (c *Control) Start() {
+	select {
+	case c.start <- struct{}{}:
+	default:
+	}
+}
+
+// Stop cancels the current run and reports whether there was one. The
+// schedule goes on with the next run.
+func (c *Control) Stop() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cancel == nil {
+		return false
+	}
+	c.cancel()
+	return true
+}
+
+// Pause stops scheduled runs from starting until Resume; a run in
+// progress is finished
+func (c *Control) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	if c.status.State == StateIdle {
+		c.status.State = StatePaused
+	}
+}
+
+// Resume restarts the schedule. A run that came due while paused starts
+// right away.
+func (c *Control) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = false
+	if c.status.State == StatePaused {
+		c.status.State = StateIdle
+	}
+	select {
+	case c.resume <- struct{}{}:
+	default:
+	}
+}
+
+// Status returns the current state of the loop
+func (c *Control) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// The methods below are called by the loop; they do nothing on a nil
+// Control, and its nil channels never deliver
+
+func (c *Control) startChan() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.start
+}
+
+func (c *Control) resumeChan() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.resume
+}
+
+func (c *Control) isPaused() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// runStarted records the start of run, which cancel cancels
+func (c *Control) runStarted(run int, cancel context.CancelFunc) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Drop a start requested while the previous run was in progress
+	select {
+	case <-c.start:
+	default:
+	}
+	c.cancel = cancel
+	c.status.State = StateRunning
+	c.status.Run = run
+	c.status.RunStarted = time.Now()
+	c.status.NextRun = time.Time{}
+}
+
+// runFinished records the outcome of the current run and when the next
+// one is due
+func (c *Control) runFinished(err error, next time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := &RunResult{
+		Run:      c.status.Run,
+		Finished: time.Now(),
+		Seconds:  time.Since(c.status.RunStarted).Seconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	c.cancel = nil
+	c.status.State = StateIdle
+	if c.paused {
+		c.status.State = StatePaused
+	}
+	c.status.RunStarted = time.Time{}
+	c.status.NextRun = next
+	c.status.LastRun = result
+}
@@ -0,0 +1,253 @@
+package daemon
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"os/signal"
+	"snc/internal/logger"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Options configures the scheduling loop
+type Options struct {
+	// Interval between the start of two consecutive runs
+	Interval time.Duration
+	// Jitter is the upper bound of a random delay added to every interval,
+	// spreading the load of several daemons started at the same time
+	Jitter time.Duration
+	// Activity, if set, receives the events of every run; under systemd
+	// the watchdog is then only fed while runs make progress
+	Activity *Activity
+	// Control, if set, lets other goroutines start, stop and pause runs
+	Control *Control
+	// Changes, if set, reports source paths changed between runs, which
+	// are passed to SyncChanges right away instead of waiting for the
+	// next run
+	Changes     Changes
+	SyncChanges func(ctx context.Context, paths []string) error
+	// Debounce, if set, is the quiet period after the last change before
+	// the changes are synced, so a burst of changes is synced at once
+	Debounce time.Duration
+}
+
+// Changes is a source of changed paths, such as a watch.Watcher
+type Changes interface {
+	// Ready is signalled when changes are pending
+	Ready() <-chan struct{}
+	// Take returns the pending changes and clears them. missed reports
+	// that changes were lost, which a full run recovers from.
+	Take() (paths []string, missed bool)
+}
+
+// Run executes job immediately and then on every interval until ctx is
+// cancelled. Every run gets a context of its own, cancelled by
+// Control.Stop.
+//
+// Runs never overlap: a run that takes longer than the interval delays the
+// next one, and all ticks missed in the meantime are coalesced into a single
+// run started right after it. On SIGHUP, reload is called between runs and a
+// new run is started immediately.
+//
+// Started by systemd with Type=notify, readiness and the state of every run
+// are reported to it, and the watchdog is fed if WatchdogSec is set.
+func Run(ctx context.Context, opts Options, job func(context.Context) error, reload func() error) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	return loop(ctx, opts, hup, job, reload)
+}
+
+func loop(ctx context.Context, opts Options, hup <-chan os.Signal, job func(context.Context) error, reload func() error) error {
+	logger.Info("DAEMON", "Running every %v (jitter up to %v)", opts.Interval, opts.Jitter)
+
+	notify := newNotifier()
+	defer notify.close()
+	notify.notify("READY=1", "MAINPID="+strconv.Itoa(os.Getpid()))
+	defer notify.notify("STOPPING=1")
+
+	var running atomic.Bool
+	if timeout := watchdogInterval(); notify != nil && timeout > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go feedWatchdog(notify, timeout, opts.Activity, &running, stop)
+	}
+
+	for run := 1; ; run++ {
+		start := time.Now()
+		logger.Info("DAEMON", "Starting run #%d", run)
+		notify.status("Run #%d in progress", run)
+		if opts.Activity != nil {
+			opts.Activity.touch()
+		}
+		runCtx, cancel := context.WithCancel(ctx)
+		opts.Control.runStarted(run, cancel)
+		running.Store(true)
+		err := job(runCtx)
+		running.Store(false)
+		switch {
+		case err != nil && ctx.Err() == nil && runCtx.Err() != nil:
+			logger.Warn("DAEMON", "Run #%d was stopped", run)
+		case err != nil:
+			logger.Warn("DAEMON", "Run #%d failed: %v", run, err)
+		}
+		cancel()
+
+		elapsed := time.Since(start)
+		wait := nextDelay(opts) - elapsed
+		if wait < 0 {
+			logger.Warn("DAEMON", "Run #%d took %v, longer than the %v interval; starting next run now",
+				run, elapsed.Round(time.Millisecond), opts.Interval)
+			wait = 0
+		} else {
+			logger.Debug("DAEMON", "Next run in %v", wait.Round(time.Second))
+		}
+		if err != nil {
+			notify.status("Run #%d failed: %v; next run at %s", run, err, time.Now().Add(wait).Format(time.TimeOnly))
+		} else {
+			notify.status("Run #%d completed in %v; next run at %s", run, elapsed.Round(time.Second), time.Now().Add(wait).Format(time.TimeOnly))
+		}
+
+		opts.Control.runFinished(err, time.Now().Add(wait))
+
+		timer := time.NewTimer(wait)
+		tick := timer.C
+		debounce := &debouncer{quiet: opts.Debounce}
+		var quiet <-chan time.Time
+		for due := false; !due; {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				logger.Info("DAEMON", "Shutting down after %d runs", run)
+				return nil
+			case <-hup:
+				timer.Stop()
+				logger.Info("DAEMON", "Received SIGHUP, reloading configuration")
+				notify.status("Reloading configuration")
+				if err := reload(); err != nil {
+					logger.Error("DAEMON", "Reload failed, keeping previous configuration: %v", err)
+				}
+				due = true
+			case <-opts.Control.startChan():
+				timer.Stop()
+				logger.Info("DAEMON", "Run requested, starting it now")
+				due = true
+			case <-opts.Control.resumeChan():
+				// A run that came due while paused starts now, otherwise
+				// the changes made while paused are synced
+				due = tick == nil
+				if !due {
+					due = syncChanges(ctx, opts, &running)
+				}
+			case <-changesReady(opts.Changes):
+				if opts.Debounce > 0 {
+					quiet = debounce.changed()
+					continue
+				}
+				if !opts.Control.isPaused() {
+					due = syncChanges(ctx, opts, &running)
+				}
+			case <-quiet:
+				quiet = nil
+				debounce.stop()
+				if !opts.Control.isPaused() {
+					due = syncChanges(ctx, opts, &running)
+				}
+			case <-tick:
+				if opts.Control.isPaused() {
+					logger.Info("DAEMON", "Paused, run #%d waits until resumed", run+1)
+					notify.status("Paused")
+					tick = nil
+					continue
+				}
+				due = true
+			}
+		}
+		// The run covers the changes still waiting for a quiet period
+		timer.Stop()
+		debounce.stop()
+	}
+}
+
+// nextDelay returns the interval plus a random jitter
+func nextDelay(opts Options) time.Duration {
+	if opts.Jitter <= 0 {
+		return opts.Interval
+	}
+	return opts.Interval + time.Duration(rand.Int63n(int64(opts.Jitter)+1))
+}
+
+// changesReady returns the channel signalled when c has changes pending,
+// or nil without c
+func changesReady(c Changes) <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.Ready()
+}
+
+// syncChanges passes the changes pending, if any, to opts.SyncChanges. It
+// reports whether changes were missed instead, so a full run is needed.
+func syncChanges(ctx context.Context, opts Options, running *atomic.Bool) bool {
+	if opts.Changes == nil {
+		return false
+	}
+	paths, missed := opts.Changes.Take()
+	if missed {
+		logger.Warn("DAEMON", "Changes were missed, starting a full run")
+		return true
+	}
+	if len(paths) == 0 {
+		return false
+	}
+
+	logger.Info("DAEMON", "Syncing %d changed paths", len(paths))
+	if opts.Activity != nil {
+		opts.Activity.touch()
+	}
+	running.Store(true)
+	err := opts.SyncChanges(ctx, paths)
+	running.Store(false)
+	if err != nil && ctx.Err() == nil {
+		logger.Warn("DAEMON", "Syncing changed paths failed: %v", err)
+	}
+	return false
+}
+
+// maxDebounce limits how many quiet periods changes wait for, so a steady
+// stream of changes is still synced
+const maxDebounce = 10
+
+// debouncer delays syncing changes until none arrived for a quiet period,
+// or at most maxDebounce quiet periods after the first of them
+type debouncer struct {
+	quiet time.Duration
+	timer *time.Timer
+	first time.Time
+}
+
+// changed starts the quiet period again and returns the channel signalled
+// when it ends
+func (d *debouncer) changed() <-chan time.Time {
+	now := time.Now()
+	if d.timer == nil {
+		d.first = now
+		d.timer = time.NewTimer(d.quiet)
+		return d.timer.C
+	}
+	wait := min(d.quiet, d.first.Add(maxDebounce*d.quiet).Sub(now))
+	d.timer.Reset(max(wait, 0))
+	return d.timer.C
+}
+
+// stop forgets the changes waiting for a quiet period
+func (d *debouncer) stop() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
@@ -0,0 +1,379 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoopRunsUntilCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := 0
+	job := func(context.Context) error {
+		runs++
+		if runs == 3 {
+			cancel()
+		}
+		return nil
+	}
+	reload := func() error {
+		t.Error("Unexpected reload")
+		return nil
+	}
+
+	opts := Options{Interval: time.Millisecond}
+	if err := loop(ctx, opts, make(chan os.Signal), job, reload); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if runs != 3 {
+		t.Errorf("Expected 3 runs, got %d", runs)
+	}
+}
+
+func TestLoopReloadsOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hup := make(chan os.Signal, 1)
+	runs, reloads := 0, 0
+	job := func(context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			// Signal arrives while the run is in progress
+			hup <- syscall.SIGHUP
+		case 2:
+			cancel()
+		}
+		return nil
+	}
+	reload := func() error {
+		reloads++
+		return nil
+	}
+
+	// The interval is far away, so the second run must be triggered by the reload
+	opts := Options{Interval: time.Hour}
+	if err := loop(ctx, opts, hup, job, reload); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if runs != 2 {
+		t.Errorf("Expected 2 runs, got %d", runs)
+	}
+	if reloads != 1 {
+		t.Errorf("Expected 1 reload, got %d", reloads)
+	}
+}
+
+// fakeChanges hands out a fixed list of changed paths once
+type fakeChanges struct {
+	ready  chan struct{}
+	paths  []string
+	missed bool
+}
+
+func (f *fakeChanges) Ready() <-chan struct{} { return f.ready }
+
+func (f *fakeChanges) Take() ([]string, bool) {
+	paths, missed := f.paths, f.missed
+	f.paths, f.missed = nil, false
+	return paths, missed
+}
+
+func TestLoopSyncsChangesBetweenRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := &fakeChanges{ready: make(chan struct{}, 1), paths: []string{"a.txt", "sub"}}
+	runs := 0
+	job := func(context.Context) error {
+		runs++
+		changes.ready <- struct{}{}
+		return nil
+	}
+	var synced []string
+	opts := Options{
+		Interval: time.Hour,
+		Changes:  changes,
+		SyncChanges: func(ctx context.Context, paths []string) error {
+			synced = append(synced, paths...)
+			cancel()
+			return nil
+		},
+	}
+	if err := loop(ctx, opts, make(chan os.Signal), job, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if runs != 1 {
+		t.Errorf("Expected the changes to be synced without another run, got %d runs", runs)
+	}
+	if strings.Join(synced, ",") != "a.txt,sub" {
+		t.Errorf("Expected the changed paths to be synced, got %v", synced)
+	}
+}
+
+func TestLoopRunsWhenChangesMissed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := &fakeChanges{ready: make(chan struct{}, 1)}
+	runs := 0
+	job := func(context.Context) error {
+		runs++
+		if runs == 1 {
+			changes.paths, changes.missed = []string{"a.txt"}, true
+			changes.ready <- struct{}{}
+		} else {
+			cancel()
+		}
+		return nil
+	}
+	opts := Options{
+		Interval: time.Hour,
+		Changes:  changes,
+		SyncChanges: func(ctx context.Context, paths []string) error {
+			t.Errorf("Expected missed changes to start a full run, got %v synced", paths)
+			return nil
+		},
+	}
+	if err := loop(ctx, opts, make(chan os.Signal), job, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if runs != 2 {
+		t.Errorf("Expected a full run after missed changes, got %d runs", runs)
+	}
+}
+
+func TestLoopDebouncesChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := &fakeChanges{ready: make(chan struct{}, 1), paths: []string{"a.txt"}}
+	job := func(context.Context) error {
+		// A burst of changes, all synced once it is over
+		go func() {
+			for i := 0; i < 5; i++ {
+				changes.ready <- struct{}{}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+		return nil
+	}
+	calls := 0
+	opts := Options{
+		Interval: time.Hour,
+		Changes:  changes,
+		Debounce: 50 * time.Millisecond,
+		SyncChanges: func(ctx context.Context, paths []string) error {
+			calls++
+			time.AfterFunc(100*time.Millisecond, cancel)
+			return nil
+		},
+	}
+	if err := loop(ctx, opts, make(chan os.Signal), job, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected the burst of changes to be synced once, got %d syncs", calls)
+	}
+}
+
+func TestDebouncerLimitsWait(t *testing.T) {
+	d := &debouncer{quiet: time.Second}
+	d.changed()
+	defer d.stop()
+
+	// Long after the first change, the next one does not wait a full
+	// quiet period again
+	d.first = d.first.Add(-maxDebounce * time.Second)
+	select {
+	case <-d.changed():
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Expected changes to be synced after at most maxDebounce quiet periods")
+	}
+}
+
+func TestNextDelay(t *testing.T) {
+	opts := Options{Interval: time.Minute}
+	if d := nextDelay(opts); d != time.Minute {
+		t.Errorf("Expected delay of 1m without jitter, got %v", d)
+	}
+
+	opts.Jitter = 10 * time.Second
+	for i := 0; i < 100; i++ {
+		d := nextDelay(opts)
+		if d < time.Minute || d > time.Minute+10*time.Second {
+			t.Fatalf("Expected delay between 1m and 1m10s, got %v", d)
+		}
+	}
+}
+
+func TestWritePIDFile(t *testing.T) {
+	// Create temporary test directory
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pidFile := tempDir + "/snc.pid"
+
+	remove, err := WritePIDFile(pidFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(pidFile); err != nil {
+		t.Fatalf("Expected pid file to exist: %v", err)
+	}
+	remove()
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Error("Expected pid file to be removed")
+	}
+
+	// A stale pid file is replaced
+	os.WriteFile(pidFile, []byte("999999999\n"), 0644)
+	remove, err = WritePIDFile(pidFile)
+	if err != nil {
+		t.Fatalf("Expected stale pid file to be replaced, got: %v", err)
+	}
+	remove()
+
+	// A pid file of a live process is refused
+	os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getppid())+"\n"), 0644)
+	if _, err := WritePIDFile(pidFile); err == nil {
+		t.Error("Expected error for pid file of a running process")
+	}
+}
+
+func TestLoopNotifiesSystemd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd notifications need Unix datagram sockets")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job := func(context.Context) error {
+		// Long enough for a few watchdog pings
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		return nil
+	}
+	opts := Options{Interval: time.Hour}
+	if err := loop(ctx, opts, make(chan os.Signal), job, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var messages []string
+	buf := make([]byte, 4096)
+	for {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		messages = append(messages, string(buf[:n]))
+	}
+	all := strings.Join(messages, "\n")
+	for _, want := range []string{"READY=1", "STATUS=Run #1 in progress", "STATUS=Run #1 completed", "WATCHDOG=1", "STOPPING=1"} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q among the notifications, got %q", want, messages)
+		}
+	}
+}
+
+func TestWatchdogStopsWhenRunHangs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("systemd notifications need Unix datagram sockets")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	n := newNotifier()
+	defer n.close()
+
+	// The last activity is long past, so a running job must not be pinged for
+	activity := &Activity{}
+	var running atomic.Bool
+	running.Store(true)
+	stop := make(chan struct{})
+	go feedWatchdog(n, 10*time.Millisecond, activity, &running, stop)
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Error("Expected no watchdog ping while the run makes no progress")
+	}
+}
+
+func TestLoopControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctl := NewControl()
+	runs := 0
+	job := func(runCtx context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			// The next run would be due at once, but is held back
+			ctl.Pause()
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				if runs != 1 {
+					t.Error("Expected no run while paused")
+				}
+				ctl.Start()
+			}()
+		case 2:
+			// Stop cancels the run in progress only
+			if !ctl.Stop() {
+				t.Error("Expected Stop to find the run in progress")
+			}
+			if runCtx.Err() == nil {
+				t.Error("Expected the run to be cancelled")
+			}
+			cancel()
+		}
+		return runCtx.Err()
+	}
+
+	opts := Options{Interval: time.Millisecond, Control: ctl}
+	if err := loop(ctx, opts, make(chan os.Signal), job, func() error { return nil }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if runs != 2 {
+		t.Errorf("Expected 2 runs, got %d", runs)
+	}
+	if status := ctl.Status(); status.State != StatePaused || status.LastRun == nil || status.LastRun.Error == "" {
+		t.Errorf("Expected a paused state after a stopped run, got %+v", status)
+	}
+}
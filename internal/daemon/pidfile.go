@@ -0,0 +1,52 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"snc/internal/logger"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// WritePIDFile records the current process id in path and returns a function
+// removing it again. It refuses to overwrite a PID file that belongs to a
+// process which is still running, so two daemons never mirror concurrently.
+func WritePIDFile(path string) (func(), error) {
+	if data, err := os.ReadFile(path); err == nil {
+		pid, convErr := strconv.Atoi(strings.TrimSpace(string(data)))
+		if convErr == nil && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("another instance is already running (pid %d, pid file %s)", pid, path)
+		}
+		logger.Warn("DAEMON", "Removing stale pid file %s", path)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read pid file %s: %w", path, err)
+	}
+
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("cannot write pid file %s: %w", path, err)
+	}
+
+	return func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logger.Warn("DAEMON", "Failed to remove pid file %s: %v", path, err)
+		}
+	}, nil
+}
+
+// processAlive reports whether a process with the given pid exists
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// FindProcess only succeeds for live processes on Windows; elsewhere
+	// probe with the null signal
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
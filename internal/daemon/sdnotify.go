@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"snc/internal/events"
+	"snc/internal/logger"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// notifier reports the state of the daemon to systemd over the socket in
+// NOTIFY_SOCKET, like sd_notify(3), for services of Type=notify. A nil
+// notifier, outside systemd, does nothing.
+type notifier struct {
+	conn net.Conn
+}
+
+// newNotifier connects to the notification socket, or returns nil if
+// there is none
+func newNotifier() *notifier {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Names starting with @ are abstract sockets, which net handles
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		logger.Warn("DAEMON", "Failed to connect to the systemd notification socket: %v", err)
+		return nil
+	}
+	return &notifier{conn: conn}
+}
+
+// notify sends the newline-separated assignments, e.g. "READY=1"
+func (n *notifier) notify(assignments ...string) {
+	if n == nil {
+		return
+	}
+	if _, err := n.conn.Write([]byte(strings.Join(assignments, "\n"))); err != nil {
+		logger.Debug("DAEMON", "Failed to notify systemd: %v", err)
+	}
+}
+
+// status sends a status line shown by systemctl status
+func (n *notifier) status(format string, args ...any) {
+	if n == nil {
+		return
+	}
+	// Status lines end at the first newline
+	msg := strings.ReplaceAll(fmt.Sprintf(format, args...), "\n", " ")
+	n.notify("STATUS=" + msg)
+}
+
+func (n *notifier) close() {
+	if n != nil {
+		n.conn.Close()
+	}
+}
+
+// watchdogInterval returns the watchdog timeout systemd set for this
+// process, or 0 if the watchdog is disabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Activity is an events.EventSink recording when the engine last reported
+// anything. Given in Options, the systemd watchdog is only fed while runs
+// keep reporting, so a hung run gets the service restarted.
+type Activity struct {
+	last atomic.Int64
+}
+
+func (a *Activity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+// idle returns how long ago the last event was reported
+func (a *Activity) idle() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+func (a *Activity) FileCopied(events.FileEvent)   { a.touch() }
+func (a *Activity) FileSkipped(events.FileEvent)  { a.touch() }
+func (a *Activity) FileDeleted(events.FileEvent)  { a.touch() }
+func (a *Activity) Error(events.ErrorEvent)       { a.touch() }
+func (a *Activity) Progress(events.ProgressEvent) { a.touch() }
+
+// feedWatchdog pings the systemd watchdog at half its timeout until stop
+// is closed. While a run is in progress, pings stop once activity has been
+// idle for the whole timeout.
+func feedWatchdog(n *notifier, timeout time.Duration, activity *Activity, running *atomic.Bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	stalled := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if running.Load() && activity != nil && activity.idle() >= timeout {
+				if !stalled {
+					logger.Warn("DAEMON", "Run made no progress for %v, no longer feeding the systemd watchdog", timeout)
+					stalled = true
+				}
+				continue
+			}
+			stalled = false
+			n.notify("WATCHDOG=1")
+		}
+	}
+}
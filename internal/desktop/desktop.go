@@ -0,0 +1,36 @@
+// Package desktop shows desktop notifications with the tools of the
+// platform: notify-send on Linux and the BSDs, osascript on macOS and a
+// PowerShell toast on Windows.
+package desktop
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// appName identifies the notifications as coming from snc
+const appName = "snc"
+
+// Notify shows a notification with title and message. Urgent ones, e.g.
+// for failures, stay on screen where the platform supports it.
+func Notify(title, message string, urgent bool) error {
+	cmd := command(title, message, urgent)
+	if cmd == nil {
+		return fmt.Errorf("desktop notifications are not supported on this platform")
+	}
+	out, err := cmd.CombinedOutput()
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		// The error names the missing program already
+		return err
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build darwin
+
+package desktop
+
+import "os/exec"
+
+// command returns osascript showing the notification. Title and message
+// are passed as arguments, so they need no quoting in the script. macOS
+// has no urgency for script notifications.
+func command(title, message string, urgent bool) *exec.Cmd {
+	return exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message)
+}
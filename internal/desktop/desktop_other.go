@@ -0,0 +1,9 @@
+//go:build !linux && !freebsd && !openbsd && !netbsd && !dragonfly && !darwin && !windows
+
+package desktop
+
+import "os/exec"
+
+func command(title, message string, urgent bool) *exec.Cmd {
+	return nil
+}
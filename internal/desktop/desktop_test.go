@@ -0,0 +1,21 @@
+package desktop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommandPassesText(t *testing.T) {
+	cmd := command("Sync failed", `3 errors in "photos"`, true)
+	if cmd == nil {
+		t.Skip("Desktop notifications are not supported on this platform")
+	}
+	// The text is passed as arguments or in the environment, never in a
+	// script where it would need quoting
+	all := strings.Join(append(cmd.Args, cmd.Env...), "\n")
+	for _, want := range []string{"Sync failed", `3 errors in "photos"`} {
+		if !strings.Contains(all, want) {
+			t.Errorf("Expected %q to be passed to %s, got %q", want, cmd.Path, cmd.Args)
+		}
+	}
+}
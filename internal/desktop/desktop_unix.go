@@ -0,0 +1,14 @@
+//go:build linux || freebsd || openbsd || netbsd || dragonfly
+
+package desktop
+
+import "os/exec"
+
+// command returns notify-send showing the notification
+func command(title, message string, urgent bool) *exec.Cmd {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+	return exec.Command("notify-send", "--app-name="+appName, "--urgency="+urgency, "--", title, message)
+}
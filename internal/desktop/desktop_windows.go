@@ -0,0 +1,32 @@
+//go:build windows
+
+package desktop
+
+import (
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast with the text in TOAST_TITLE and TOAST_MESSAGE,
+// attributed to PowerShell since snc has no registered app id
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+[Windows.Data.Xml.Dom.XmlDocument, Windows.Data.Xml.Dom.XmlDocument, ContentType = WindowsRuntime] | Out-Null
+$text = "<text>" + [Security.SecurityElement]::Escape($env:TOAST_TITLE) + "</text><text>" + [Security.SecurityElement]::Escape($env:TOAST_MESSAGE) + "</text>"
+$xml = New-Object Windows.Data.Xml.Dom.XmlDocument
+$xml.LoadXml("<toast scenario='" + $env:TOAST_SCENARIO + "'><visual><binding template='ToastGeneric'>" + $text + "</binding></visual></toast>")
+$app = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($app).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+// command returns PowerShell showing the notification as a toast. The text
+// is passed in the environment, so it needs no quoting in the script.
+func command(title, message string, urgent bool) *exec.Cmd {
+	scenario := "default"
+	if urgent {
+		scenario = "reminder"
+	}
+	cmd := exec.Command("powershell.exe", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "TOAST_TITLE="+title, "TOAST_MESSAGE="+message, "TOAST_SCENARIO="+scenario)
+	return cmd
+}
@@ -1,40 +1,83 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+)
+
+// Code is a stable, machine-readable identifier of an error kind, e.g. for
+// JSON output. Codes never change once released.
+type Code string
+
+// Category groups related error codes
+type Category string
+
+const (
+	CategoryDirectory Category = "directory"
+	CategoryFile      Category = "file"
+	CategorySync      Category = "sync"
+)
+
+// Codes of errors that do not come from this package
+const (
+	CodeUnknown          Code = "unknown"
+	CodeNotFound         Code = "not_found"
+	CodePermissionDenied Code = "permission_denied"
 )
 
 // Error types for different categories
 var (
 	// Directory-related errors
-	ErrNotADirectory          = NewError("path is not a directory")
-	ErrDirectoryNotAccessible = NewError("path is not accessible")
-	ErrCannotCreateDirectory  = NewError("cannot create directory")
-	ErrSourceDirValidation    = NewError("source directory validation failed")
-	ErrTargetDirValidation    = NewError("target directory validation failed")
+	ErrNotADirectory          = newSentinel(CategoryDirectory, "not_a_directory", "path is not a directory")
+	ErrDirectoryNotAccessible = newSentinel(CategoryDirectory, "directory_not_accessible", "path is not accessible")
+	ErrDirectoryNotReadable   = newSentinel(CategoryDirectory, "directory_not_readable", "directory cannot be listed")
+	ErrDirectoryNotWritable   = newSentinel(CategoryDirectory, "directory_not_writable", "directory is not writable")
+	ErrCannotCreateDirectory  = newSentinel(CategoryDirectory, "cannot_create_directory", "cannot create directory")
+	ErrSourceDirValidation    = newSentinel(CategoryDirectory, "source_dir_invalid", "source directory validation failed")
+	ErrTargetDirValidation    = newSentinel(CategoryDirectory, "target_dir_invalid", "target directory validation failed")
 
 	// File-related errors
-	ErrFileNotAccessible = NewError("file is not accessible")
-	ErrCannotOpenFile    = NewError("cannot open file")
-	ErrCannotCreateFile  = NewError("cannot create file")
-	ErrCannotReadFile    = NewError("cannot read file")
-	ErrCannotWriteFile   = NewError("cannot write file")
-	ErrCannotCloseFile   = NewError("cannot close file")
-	ErrFileCopyFailed    = NewError("file copy failed")
-	ErrFileNotFound      = NewError("file not found")
-	ErrCannotDeleteFile  = NewError("cannot delete file")
+	ErrFileNotAccessible = newSentinel(CategoryFile, "file_not_accessible", "file is not accessible")
+	ErrCannotOpenFile    = newSentinel(CategoryFile, "cannot_open_file", "cannot open file")
+	ErrCannotCreateFile  = newSentinel(CategoryFile, "cannot_create_file", "cannot create file")
+	ErrCannotReadFile    = newSentinel(CategoryFile, "cannot_read_file", "cannot read file")
+	ErrCannotWriteFile   = newSentinel(CategoryFile, "cannot_write_file", "cannot write file")
+	ErrCannotCloseFile   = newSentinel(CategoryFile, "cannot_close_file", "cannot close file")
+	ErrFileCopyFailed    = newSentinel(CategoryFile, "copy_failed", "file copy failed")
+	ErrFileNotFound      = newSentinel(CategoryFile, "file_not_found", "file not found")
+	ErrCannotDeleteFile  = newSentinel(CategoryFile, "cannot_delete_file", "cannot delete file")
+	ErrTargetModified    = newSentinel(CategoryFile, "target_modified", "target file was modified since the last sync")
+	ErrSpecialFile       = newSentinel(CategoryFile, "special_file", "file is a device, FIFO or socket")
+	ErrDanglingSymlink   = newSentinel(CategoryFile, "dangling_symlink", "symbolic link points to a missing file")
+	ErrSourceChanged     = newSentinel(CategoryFile, "source_changed", "source file changed while it was copied")
+	ErrTypeConflict      = newSentinel(CategoryFile, "type_conflict", "path is a file on one side and a directory on the other")
+	ErrBitRot            = newSentinel(CategoryFile, "bit_rot", "file contents changed without a change of size or modification time")
 
 	// Sync-related errors
-	ErrSyncFailed                = NewError("sync operation failed")
-	ErrCannotComputeRelativePath = NewError("cannot compute relative path")
-	ErrCannotCreateParentDir     = NewError("cannot create parent directory")
-	ErrCannotStatFile            = NewError("cannot get file information")
+	ErrSyncFailed                = newSentinel(CategorySync, "sync_failed", "sync operation failed")
+	ErrCannotComputeRelativePath = newSentinel(CategorySync, "relative_path_failed", "cannot compute relative path")
+	ErrCannotCreateParentDir     = newSentinel(CategorySync, "cannot_create_parent_dir", "cannot create parent directory")
+	ErrCannotStatFile            = newSentinel(CategorySync, "cannot_stat_file", "cannot get file information")
+	ErrDeleteLimitExceeded       = newSentinel(CategorySync, "delete_limit_exceeded", "delete limit exceeded")
+	ErrUnsafeDelete              = newSentinel(CategorySync, "unsafe_delete", "refusing to delete from target")
+	ErrInsufficientSpace         = newSentinel(CategorySync, "insufficient_space", "not enough free space on target")
+	ErrTargetProtected           = newSentinel(CategorySync, "target_protected", "target is write-protected")
+	ErrPlanOutdated              = newSentinel(CategorySync, "plan_outdated", "files changed since the plan was made")
 )
 
-// Error represents a custom error with context
+// Error represents a custom error with context. Errors created from a
+// sentinel by the helper functions carry its code, so errors.Is matches
+// them against the sentinel, and unwrap to their cause.
 type Error struct {
-	message string
-	context map[string]interface{}
+	code     Code
+	category Category
+	message  string
+	context  map[string]interface{}
+
+	// subject is the path or operation the error is about
+	subject string
+	cause   error
 }
 
 // NewError creates a new error with a message
@@ -45,27 +88,65 @@ func NewError(message string) *Error {
 	}
 }
 
+// newSentinel creates a package-level error with a code and category
+func newSentinel(category Category, code Code, message string) *Error {
+	e := NewError(message)
+	e.code, e.category = code, category
+	return e
+}
+
 // Error implements the error interface
 func (e *Error) Error() string {
-	if len(e.context) == 0 {
-		return e.message
+	msg := e.message
+	if len(e.context) > 0 {
+		contextStr := ""
+		for key, value := range e.context {
+			if contextStr != "" {
+				contextStr += ", "
+			}
+			contextStr += fmt.Sprintf("%s: %v", key, value)
+		}
+		msg = fmt.Sprintf("%s (%s)", msg, contextStr)
 	}
 
-	contextStr := ""
-	for key, value := range e.context {
-		if contextStr != "" {
-			contextStr += ", "
-		}
-		contextStr += fmt.Sprintf("%s: %v", key, value)
+	if e.subject != "" {
+		msg = e.subject + ": " + msg
+	}
+	if e.cause != nil {
+		msg += ": " + e.cause.Error()
 	}
+	return msg
+}
 
-	return fmt.Sprintf("%s (%s)", e.message, contextStr)
+// Code returns the machine-readable code, or "" for errors created with
+// NewError
+func (e *Error) Code() Code {
+	return e.code
 }
 
-// WithContext adds context to the error
+// Category returns the category of the error's code
+func (e *Error) Category() Category {
+	return e.category
+}
+
+// Unwrap returns the cause of the error, if any
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether target is an *Error with the same code, so that an
+// error created from a sentinel matches the sentinel
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.code != "" && t.code == e.code
+}
+
+// WithContext returns a copy of the error with additional context. The
+// error itself is never modified, so sentinels can be shared safely.
 func (e *Error) WithContext(key string, value interface{}) *Error {
-	e.context[key] = value
-	return e
+	c := e.clone()
+	c.context[key] = value
+	return c
 }
 
 // WithPath adds a path context to the error
@@ -83,31 +164,84 @@ func (e *Error) WithTargetPath(path string) *Error {
 	return e.WithContext("target", path)
 }
 
-// Wrap wraps an existing error with additional context
+// Wrap returns a copy of the error caused by err
 func (e *Error) Wrap(err error) *Error {
-	return e.WithContext("wrapped_error", err.Error())
+	return e.wrap("", err)
+}
+
+// wrap returns a copy of e about subject and caused by cause
+func (e *Error) wrap(subject string, cause error) *Error {
+	c := e.clone()
+	c.subject, c.cause = subject, cause
+	return c
+}
+
+// clone returns a copy of e that shares nothing mutable with it
+func (e *Error) clone() *Error {
+	c := *e
+	c.context = make(map[string]interface{}, len(e.context)+1)
+	for k, v := range e.context {
+		c.context[k] = v
+	}
+	return &c
+}
+
+// CodeOf returns the code of the first *Error with a code in err's chain.
+// Common system errors without one are classified as well; anything else
+// is CodeUnknown.
+func CodeOf(err error) Code {
+	if e := find(err); e != nil {
+		return e.code
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return CodeNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return CodePermissionDenied
+	}
+	return CodeUnknown
+}
+
+// CategoryOf returns the category of the first *Error with a code in err's
+// chain, or "" if there is none
+func CategoryOf(err error) Category {
+	if e := find(err); e != nil {
+		return e.category
+	}
+	return ""
+}
+
+// find returns the first *Error with a code in err's chain
+func find(err error) *Error {
+	var e *Error
+	for chain := err; errors.As(chain, &e); chain = e.cause {
+		if e.code != "" {
+			return e
+		}
+	}
+	return nil
 }
 
 // Helper functions for common error patterns
 
 // NewDirectoryError creates a directory-related error with path context
 func NewDirectoryError(baseErr *Error, path string, cause error) error {
-	return fmt.Errorf("%s: %w: %v", path, baseErr, cause)
+	return baseErr.wrap(path, cause)
 }
 
 // NewFileError creates a file-related error with path context
 func NewFileError(baseErr *Error, path string, cause error) error {
-	return fmt.Errorf("%s: %w: %v", path, baseErr, cause)
+	return baseErr.wrap(path, cause)
 }
 
 // NewSyncError creates a sync-related error with context
 func NewSyncError(baseErr *Error, context string, cause error) error {
-	return fmt.Errorf("%s: %w: %v", context, baseErr, cause)
+	return baseErr.wrap(context, cause)
 }
 
 // NewValidationError creates a validation error with context
 func NewValidationError(baseErr *Error, context string, cause error) error {
-	return fmt.Errorf("%s: %w: %v", context, baseErr, cause)
+	return baseErr.wrap(context, cause)
 }
 
 // NewFileAccessError creates a formatted error message for file access issues
@@ -2,6 +2,10 @@ package errors
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"syscall"
 	"testing"
 )
 
@@ -58,9 +62,65 @@ func TestErrorWrap(t *testing.T) {
 	err = err.Wrap(originalErr)
 
 	errorStr := err.Error()
-	if errorStr != "wrapper error (wrapped_error: original error)" {
+	if errorStr != "wrapper error: original error" {
 		t.Errorf("Expected wrapped error, got '%s'", errorStr)
 	}
+	if !errors.Is(err, originalErr) {
+		t.Error("Expected wrapped error to unwrap to the original error")
+	}
+}
+
+func TestErrorChain(t *testing.T) {
+	cause := &fs.PathError{Op: "open", Path: "/test/file", Err: syscall.EACCES}
+	err := fmt.Errorf("processing failed: %w", NewFileError(ErrCannotOpenFile, "/test/file", cause))
+
+	if err.Error() != "processing failed: /test/file: cannot open file: open /test/file: permission denied" {
+		t.Errorf("Unexpected message: %s", err)
+	}
+	if !errors.Is(err, ErrCannotOpenFile) {
+		t.Error("Expected errors.Is to match the sentinel")
+	}
+	if errors.Is(err, ErrCannotCreateFile) {
+		t.Error("Expected errors.Is not to match another sentinel")
+	}
+	if !errors.Is(err, syscall.EACCES) || !errors.Is(err, fs.ErrPermission) {
+		t.Error("Expected errors.Is to match the cause")
+	}
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) || pathErr.Path != "/test/file" {
+		t.Error("Expected errors.As to find the cause")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.Code() != "cannot_open_file" || e.Category() != CategoryFile {
+		t.Errorf("Expected errors.As to find the code and category, got %v", e)
+	}
+}
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want Code
+	}{
+		{NewFileError(ErrCannotOpenFile, "/f", fs.ErrNotExist), "cannot_open_file"},
+		{fmt.Errorf("outer: %w", NewSyncError(ErrFileCopyFailed, "copy operation", syscall.EIO)), "copy_failed"},
+		{NewError("plain").Wrap(NewFileError(ErrCannotStatFile, "/f", syscall.EIO)), "cannot_stat_file"},
+		{&fs.PathError{Op: "remove", Path: "/f", Err: syscall.ENOENT}, CodeNotFound},
+		{&fs.PathError{Op: "remove", Path: "/f", Err: syscall.EPERM}, CodePermissionDenied},
+		{errors.New("something else"), CodeUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := CodeOf(tt.err); got != tt.want {
+			t.Errorf("CodeOf(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+
+	if got := CategoryOf(fmt.Errorf("outer: %w", NewFileError(ErrCannotDeleteFile, "/f", syscall.EIO))); got != CategoryFile {
+		t.Errorf("Expected category %q, got %q", CategoryFile, got)
+	}
+	if got := CategoryOf(syscall.EIO); got != "" {
+		t.Errorf("Expected no category for a system error, got %q", got)
+	}
 }
 
 func TestHelperFunctions(t *testing.T) {
@@ -177,3 +237,39 @@ func TestErrorTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestWithContextDoesNotModifySentinel(t *testing.T) {
+	err := NewSyncError(ErrFileCopyFailed.WithSourcePath("/a").WithTargetPath("/b"), "copy operation", errors.New("disk full"))
+	if ErrFileCopyFailed.Error() != "file copy failed" {
+		t.Errorf("Expected the sentinel to stay unchanged, got '%s'", ErrFileCopyFailed.Error())
+	}
+	if !errors.Is(err, ErrFileCopyFailed) {
+		t.Error("Expected the copy to match the sentinel")
+	}
+
+	other := ErrFileCopyFailed.WithSourcePath("/c")
+	if other.Error() != "file copy failed (source: /c)" {
+		t.Errorf("Expected no context from other errors, got '%s'", other.Error())
+	}
+}
+
+func TestWithContextConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src := fmt.Sprintf("/src/%d", i)
+			err := ErrFileCopyFailed.WithSourcePath(src).WithTargetPath("/dst")
+			if got := err.Error(); got != fmt.Sprintf("file copy failed (source: %s, target: /dst)", src) &&
+				got != fmt.Sprintf("file copy failed (target: /dst, source: %s)", src) {
+				t.Errorf("Unexpected message: %s", got)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if ErrFileCopyFailed.Error() != "file copy failed" {
+		t.Errorf("Expected the sentinel to stay unchanged, got '%s'", ErrFileCopyFailed.Error())
+	}
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"fmt"
+)
+
+// Level is the severity of a progress message
+type Level int
+
+const (
+	LevelWarn Level = iota
+	LevelInfo
+	LevelDebug
+	// LevelTrace carries per-file timings and decisions
+	LevelTrace
+)
+
+// FileEvent describes a file copied, skipped or deleted by the engine
+type FileEvent struct {
+	// Path is relative to the source (or target, for deletions) root
+	Path    string
+	SrcPath string
+	DstPath string
+	// Bytes written to the target
+	Bytes int64
+	// Update is true when an existing target file was overwritten
+	Update bool
+	// Itemize is the rsync-style change code describing what was done and why
+	Itemize string
+}
+
+// Operations reported in ErrorEvent.Op
+const (
+	// OpWalk is reading a directory or an entry while walking a tree
+	OpWalk = "walk"
+	// OpName is mapping a path between source and target
+	OpName    = "name"
+	OpStat    = "stat"
+	OpCompare = "compare"
+	OpCopy    = "copy"
+	OpDelete  = "delete"
+	OpDecrypt = "decrypt"
+)
+
+// ErrorEvent describes a failed file operation
+type ErrorEvent struct {
+	Component string
+	// Message describes the failed operation, e.g. "Failed to process file"
+	Message string
+	// Op is the kind of operation that failed, one of the Op constants
+	Op   string
+	Path string
+	Err  error
+}
+
+// ProgressEvent is a free-form status message from the engine
+type ProgressEvent struct {
+	Level     Level
+	Component string
+	Message   string
+}
+
+// EventSink receives the events emitted by the engine. Implementations are
+// called synchronously and one event at a time, from the goroutine running
+// the sync or, when syncing to several targets, from one of the goroutines
+// copying to them.
+type EventSink interface {
+	FileCopied(ev FileEvent)
+	FileSkipped(ev FileEvent)
+	FileDeleted(ev FileEvent)
+	Error(ev ErrorEvent)
+	Progress(ev ProgressEvent)
+}
+
+// Nop is an EventSink that discards all events
+type Nop struct{}
+
+func (Nop) FileCopied(FileEvent)   {}
+func (Nop) FileSkipped(FileEvent)  {}
+func (Nop) FileDeleted(FileEvent)  {}
+func (Nop) Error(ErrorEvent)       {}
+func (Nop) Progress(ProgressEvent) {}
+
+// Multi fans events out to several sinks in order
+type Multi []EventSink
+
+func (m Multi) FileCopied(ev FileEvent) {
+	for _, s := range m {
+		s.FileCopied(ev)
+	}
+}
+
+func (m Multi) FileSkipped(ev FileEvent) {
+	for _, s := range m {
+		s.FileSkipped(ev)
+	}
+}
+
+func (m Multi) FileDeleted(ev FileEvent) {
+	for _, s := range m {
+		s.FileDeleted(ev)
+	}
+}
+
+func (m Multi) Error(ev ErrorEvent) {
+	for _, s := range m {
+		s.Error(ev)
+	}
+}
+
+func (m Multi) Progress(ev ProgressEvent) {
+	for _, s := range m {
+		s.Progress(ev)
+	}
+}
+
+// Infof emits an info-level progress message
+func Infof(sink EventSink, component, format string, args ...interface{}) {
+	sink.Progress(ProgressEvent{Level: LevelInfo, Component: component, Message: fmt.Sprintf(format, args...)})
+}
+
+// Debugf emits a debug-level progress message
+func Debugf(sink EventSink, component, format string, args ...interface{}) {
+	sink.Progress(ProgressEvent{Level: LevelDebug, Component: component, Message: fmt.Sprintf(format, args...)})
+}
+
+// Tracef emits a trace-level progress message
+func Tracef(sink EventSink, component, format string, args ...interface{}) {
+	sink.Progress(ProgressEvent{Level: LevelTrace, Component: component, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warnf emits a warning progress message
+func Warnf(sink EventSink, component, format string, args ...interface{}) {
+	sink.Progress(ProgressEvent{Level: LevelWarn, Component: component, Message: fmt.Sprintf(format, args...)})
+}
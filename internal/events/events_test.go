@@ -0,0 +1,59 @@
+package events
+
+import (
+	"testing"
+)
+
+// countingSink counts the events it receives
+type countingSink struct {
+	copied, skipped, deleted, errors, progress int
+}
+
+func (c *countingSink) FileCopied(FileEvent)      { c.copied++ }
+func (c *countingSink) FileSkipped(FileEvent)     { c.skipped++ }
+func (c *countingSink) FileDeleted(FileEvent)     { c.deleted++ }
+func (c *countingSink) Error(ErrorEvent)          { c.errors++ }
+func (c *countingSink) Progress(ev ProgressEvent) { c.progress++ }
+
+func TestMulti(t *testing.T) {
+	a, b := &countingSink{}, &countingSink{}
+	m := Multi{a, b, Nop{}}
+
+	m.FileCopied(FileEvent{Path: "a"})
+	m.FileSkipped(FileEvent{Path: "b"})
+	m.FileDeleted(FileEvent{Path: "c"})
+	m.Error(ErrorEvent{Path: "d"})
+	Infof(m, "TEST", "message %d", 1)
+
+	for _, s := range []*countingSink{a, b} {
+		if s.copied != 1 || s.skipped != 1 || s.deleted != 1 || s.errors != 1 || s.progress != 1 {
+			t.Errorf("Expected one event of each kind, got %+v", s)
+		}
+	}
+}
+
+// lastProgressSink remembers the last progress message
+type lastProgressSink struct {
+	Nop
+	last ProgressEvent
+}
+
+func (l *lastProgressSink) Progress(ev ProgressEvent) { l.last = ev }
+
+func TestProgressHelpers(t *testing.T) {
+	sink := &lastProgressSink{}
+
+	Warnf(sink, "STREAM", "failed %s", "x")
+	if sink.last.Level != LevelWarn || sink.last.Message != "failed x" || sink.last.Component != "STREAM" {
+		t.Errorf("Unexpected warning event: %+v", sink.last)
+	}
+
+	Debugf(sink, "DELETE", "checking %d", 3)
+	if sink.last.Level != LevelDebug || sink.last.Message != "checking 3" {
+		t.Errorf("Unexpected debug event: %+v", sink.last)
+	}
+	Tracef(sink, "STREAM", "stat took %s", "1ms")
+	if sink.last.Level != LevelTrace || sink.last.Message != "stat took 1ms" {
+		t.Errorf("Unexpected trace event: %+v", sink.last)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package logger
+
+import "os"
+
+// enableVirtualTerminal prepares the terminal f for ANSI escape sequences,
+// which all supported terminals outside Windows understand
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
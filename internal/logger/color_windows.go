@@ -0,0 +1,32 @@
+//go:build windows
+
+package logger
+
+import (
+	"os"
+	"syscall"
+)
+
+// enableVirtualTerminalProcessing makes Windows consoles interpret ANSI
+// escape sequences
+const enableVirtualTerminalProcessing = 0x0004
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+// enableVirtualTerminal prepares the console f for ANSI escape sequences
+// and reports whether it supports them. Consoles before Windows 10 do not.
+func enableVirtualTerminal(f *os.File) bool {
+	var mode uint32
+	h := syscall.Handle(f.Fd())
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := procSetConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}
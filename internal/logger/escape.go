@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EscapePath makes a path safe to print on a line of its own, the way
+// rsync does: control characters, including newlines, and bytes that are
+// not valid UTF-8 are written as \#ooo with their octal value, and so is
+// a backslash that would otherwise start such an escape.
+func EscapePath(path string) string {
+	if !needsEscape(path) {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); {
+		r, size := utf8.DecodeRuneInString(path[i:])
+		switch {
+		case r == utf8.RuneError && size == 1, unicode.IsControl(r):
+			for _, c := range []byte(path[i : i+size]) {
+				fmt.Fprintf(&b, `\#%03o`, c)
+			}
+		case r == '\\' && strings.HasPrefix(path[i+1:], "#"):
+			b.WriteString(`\#134`)
+		default:
+			b.WriteString(path[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// needsEscape reports whether EscapePath changes path
+func needsEscape(path string) bool {
+	if !utf8.ValidString(path) || strings.Contains(path, `\#`) {
+		return true
+	}
+	return strings.IndexFunc(path, unicode.IsControl) >= 0
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"plain/file name.txt", "plain/file name.txt"},
+		{"übung/日本.txt", "übung/日本.txt"},
+		{"line\nbreak", `line\#012break`},
+		{"tab\there", `tab\#011here`},
+		{"esc\x1b[31m", `esc\#033[31m`},
+		{"c1\u009b", `c1\#302\#233`},
+		{"bad\xffbyte", `bad\#377byte`},
+		{`back\slash`, `back\slash`},
+		{`looks\#012like`, `looks\#134#012like`},
+	}
+
+	for _, tt := range tests {
+		if got := EscapePath(tt.path); got != tt.expected {
+			t.Errorf("EscapePath(%q): expected %q, got %q", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestItemizePrint0(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	SetItemize(true)
+	defer SetItemize(false)
+
+	Itemize(">f+++++++++", "new\nline")
+	if got, want := buf.String(), ">f+++++++++ new\\#012line\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	SetPrint0(true)
+	defer SetPrint0(false)
+	Itemize(">f+++++++++", "new\nline")
+	Itemize("*deleting  ", "old")
+	if got, want := buf.String(), ">f+++++++++ new\nline\x00*deleting   old\x00"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
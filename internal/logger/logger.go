@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -15,15 +16,71 @@ const (
 	WARN
 	INFO
 	DEBUG
+	TRACE
 )
 
 var (
 	currentLevel LogLevel = INFO
-	logger       *log.Logger
+	itemize      bool
+	// print0 ends itemized lines with NUL instead of escaping paths
+	print0 bool
+	logger *log.Logger
+
+	// noColor is set by DisableColor or the NO_COLOR environment variable
+	noColor bool
+	// color enables ANSI colors for the current output
+	color bool
 )
 
+// ANSI escape sequences used to color messages by level
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorGreen  = "\x1b[32m"
+	colorDim    = "\x1b[2m"
+)
+
+var levelColors = map[string]string{
+	"ERROR":   colorRed,
+	"FATAL":   colorRed,
+	"WARN":    colorYellow,
+	"SUCCESS": colorGreen,
+	"DEBUG":   colorDim,
+	"TRACE":   colorDim,
+}
+
 func init() {
 	logger = log.New(os.Stdout, "", 0)
+	// See https://no-color.org
+	noColor = os.Getenv("NO_COLOR") != ""
+	color = !noColor && isTerminal(os.Stdout)
+}
+
+// SetOutput sets the destination for log messages. Messages are colored
+// if w is a terminal and colors were not disabled.
+func SetOutput(w io.Writer) {
+	logger.SetOutput(w)
+	color = !noColor && isTerminal(w)
+}
+
+// DisableColor turns off colored output, e.g. for --no-color
+func DisableColor() {
+	noColor = true
+	color = false
+}
+
+// isTerminal reports whether w is a terminal that can display colors
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return enableVirtualTerminal(f)
 }
 
 // SetLevel sets the logging level
@@ -42,18 +99,37 @@ func SetLevelFromString(level string) {
 		SetLevel(INFO)
 	case "debug":
 		SetLevel(DEBUG)
+	case "trace":
+		SetLevel(TRACE)
 	default:
 		SetLevel(INFO)
 	}
 }
 
-// formatMessage formats a log message with timestamp and level
+// SetItemize enables or disables itemized change output
+func SetItemize(enabled bool) {
+	itemize = enabled
+}
+
+// SetPrint0 makes itemized lines end with a NUL byte and hold paths as they
+// are, instead of ending with a newline and escaping paths, for scripts
+// that handle any file name
+func SetPrint0(enabled bool) {
+	print0 = enabled
+}
+
+// formatMessage formats a log message with timestamp and level, colored by
+// level when colors are enabled
 func formatMessage(level string, component, message string) string {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	line := fmt.Sprintf("[%s] %s %s", timestamp, level, message)
 	if component != "" {
-		return fmt.Sprintf("[%s] %s [%s] %s", timestamp, level, component, message)
+		line = fmt.Sprintf("[%s] %s [%s] %s", timestamp, level, component, message)
 	}
-	return fmt.Sprintf("[%s] %s %s", timestamp, level, message)
+	if c, ok := levelColors[level]; ok && color {
+		return c + line + colorReset
+	}
+	return line
 }
 
 // Error logs an error message
@@ -88,6 +164,14 @@ func Debug(component, message string, args ...interface{}) {
 	}
 }
 
+// Trace logs a trace message, e.g. per-file timings
+func Trace(component, message string, args ...interface{}) {
+	if currentLevel >= TRACE {
+		msg := fmt.Sprintf(message, args...)
+		logger.Println(formatMessage("TRACE", component, msg))
+	}
+}
+
 // Fatal logs a fatal error and exits
 func Fatal(component, message string, args ...interface{}) {
 	msg := fmt.Sprintf(message, args...)
@@ -120,3 +204,17 @@ func Success(component, message string, args ...interface{}) {
 		logger.Println(formatMessage("SUCCESS", component, msg))
 	}
 }
+
+// Itemize prints an undecorated itemized change line (e.g. ">f+++++++++ path")
+// when itemized output is enabled, regardless of the log level
+func Itemize(code, path string) {
+	if !itemize {
+		return
+	}
+	if print0 {
+		// The logger would end the line with a newline
+		fmt.Fprintf(logger.Writer(), "%s %s\x00", code, path)
+		return
+	}
+	logger.Printf("%s %s\n", code, EscapePath(path))
+}
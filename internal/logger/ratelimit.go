@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+)
+
+// errorLimiter collapses runs of identical errors into a single "repeated"
+// line and caps the number of error lines logged per run, so that a dead
+// mount does not produce one line per file
+type errorLimiter struct {
+	mu sync.Mutex
+	// limit is the maximum number of error lines per run; 0 is unlimited
+	limit  int
+	logged int
+
+	// lastKey identifies the last error logged and repeats counts the
+	// identical errors suppressed since
+	lastKey       string
+	lastComponent string
+	repeats       int
+
+	// dropped counts errors suppressed because limit was reached
+	dropped int
+}
+
+var errorLimit = &errorLimiter{}
+
+// SetErrorLimit caps the number of file errors logged until the next call
+// to FlushErrors; 0 logs all of them
+func SetErrorLimit(limit int) {
+	errorLimit.mu.Lock()
+	defer errorLimit.mu.Unlock()
+	errorLimit.limit = limit
+}
+
+// allow reports whether an error should be logged. Errors with the same
+// component, message and root cause as the previous one are counted
+// instead. At debug level and above every error is logged.
+func (l *errorLimiter) allow(component, message string, err error) bool {
+	if currentLevel >= DEBUG {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := component + "\x00" + message + "\x00" + rootCause(err)
+	if key == l.lastKey {
+		l.repeats++
+		return false
+	}
+	l.flushRepeats()
+	l.lastKey, l.lastComponent = key, component
+
+	if l.limit > 0 && l.logged >= l.limit {
+		l.dropped++
+		return false
+	}
+	l.logged++
+	return true
+}
+
+// flushRepeats logs how often the last error was repeated. l.mu must be held.
+func (l *errorLimiter) flushRepeats() {
+	if l.repeats > 0 {
+		Error(l.lastComponent, "Previous error repeated %d more times", l.repeats)
+	}
+	l.repeats = 0
+}
+
+// FlushErrors logs the errors suppressed since the last call and resets the
+// limit for the next run
+func FlushErrors() {
+	l := errorLimit
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushRepeats()
+	if l.dropped > 0 {
+		Warn("LOG", "%d further errors were not logged after reaching the limit of %d (see --log-error-limit)", l.dropped, l.limit)
+	}
+	l.logged, l.dropped = 0, 0
+	l.lastKey, l.lastComponent = "", ""
+}
+
+// rootCause returns the last part of an error message, which for file
+// errors is the system error without the path, e.g. "transport endpoint is
+// not connected" for "open /mnt/a: transport endpoint is not connected"
+func rootCause(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		return msg[i+2:]
+	}
+	return msg
+}
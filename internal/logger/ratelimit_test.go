@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"snc/internal/events"
+	"strings"
+	"testing"
+)
+
+func TestErrorLimit(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+	SetLevel(INFO)
+	SetErrorLimit(3)
+	defer SetErrorLimit(0)
+
+	dead := fmt.Errorf("open /mnt/a: transport endpoint is not connected")
+	for i := 0; i < 5; i++ {
+		Sink{}.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to process", Path: fmt.Sprintf("/mnt/%d", i), Err: dead})
+	}
+	Sink{}.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to process", Path: "/mnt/x", Err: fmt.Errorf("permission denied")})
+	for i := 0; i < 4; i++ {
+		Sink{}.Error(events.ErrorEvent{Component: "DELETE", Message: fmt.Sprintf("Failed to delete %d", i), Err: dead})
+	}
+	FlushErrors()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	expected := []string{
+		"Failed to process /mnt/0",
+		"Previous error repeated 4 more times",
+		"Failed to process /mnt/x",
+		"Failed to delete 0",
+		"3 further errors were not logged after reaching the limit of 3",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected), buf.String())
+	}
+	for i, want := range expected {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("Expected line %d to contain %q, got %q", i, want, lines[i])
+		}
+	}
+
+	// The limit starts over with the next run
+	buf.Reset()
+	Sink{}.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to process", Err: dead})
+	if !strings.Contains(buf.String(), "Failed to process") {
+		t.Errorf("Expected errors to be logged after FlushErrors, got %q", buf.String())
+	}
+	FlushErrors()
+}
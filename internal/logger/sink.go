@@ -0,0 +1,53 @@
+package logger
+
+import (
+	"snc/internal/events"
+)
+
+// Sink renders engine events through the global logger
+type Sink struct{}
+
+// FileCopied logs a new or updated file and its itemized change line
+func (Sink) FileCopied(ev events.FileEvent) {
+	if ev.Update {
+		Progress("STREAM", "UPDATE", "Modified file: %s", ev.Path)
+	} else {
+		Progress("STREAM", "COPY", "New file: %s", ev.Path)
+	}
+	Success("STREAM", "Copied %s -> %s (%d bytes)", ev.SrcPath, ev.DstPath, ev.Bytes)
+	Itemize(ev.Itemize, ev.Path)
+}
+
+// FileSkipped logs an unchanged file at debug level
+func (Sink) FileSkipped(ev events.FileEvent) {
+	Debug("STREAM", "Skipping unchanged file: %s", ev.Path)
+}
+
+// FileDeleted logs a file removed from the target
+func (Sink) FileDeleted(ev events.FileEvent) {
+	Progress("DELETE", "REMOVE", "Deleted missing file: %s", ev.Path)
+	Itemize(ev.Itemize, ev.Path)
+}
+
+// Error logs a failed file operation. Repeated identical errors are
+// collapsed and capped, see SetErrorLimit.
+func (Sink) Error(ev events.ErrorEvent) {
+	if !errorLimit.allow(ev.Component, ev.Message, ev.Err) {
+		return
+	}
+	Error(ev.Component, "%s %s: %v", ev.Message, ev.Path, ev.Err)
+}
+
+// Progress logs a status message at the matching level
+func (Sink) Progress(ev events.ProgressEvent) {
+	switch ev.Level {
+	case events.LevelWarn:
+		Warn(ev.Component, "%s", ev.Message)
+	case events.LevelInfo:
+		Info(ev.Component, "%s", ev.Message)
+	case events.LevelTrace:
+		Trace(ev.Component, "%s", ev.Message)
+	default:
+		Debug(ev.Component, "%s", ev.Message)
+	}
+}
@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"snc/internal/logger"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds (in seconds) of the sync duration histogram
+var durationBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600}
+
+// Registry holds the counters exposed on the /metrics endpoint
+type Registry struct {
+	mu sync.Mutex
+
+	filesCopied      uint64
+	filesSkipped     uint64
+	bytesTransferred uint64
+	errors           uint64
+	syncsSucceeded   uint64
+	syncsFailed      uint64
+	lastSync         time.Time
+	lastSuccess      time.Time
+
+	durationCounts []uint64
+	durationSum    float64
+	durationCount  uint64
+
+	// lastRunFiles and lastRunPhases describe the most recent sync run
+	lastRunFiles  map[string]int
+	lastRunPhases map[string]time.Duration
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		durationCounts: make([]uint64, len(durationBuckets)),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// FileCopied records a file written to the target
+func (r *Registry) FileCopied(bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filesCopied++
+	r.bytesTransferred += uint64(bytes)
+}
+
+// FileSkipped records a file that was already up to date in the target
+func (r *Registry) FileSkipped() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.filesSkipped++
+}
+
+// AddErrors records n failed file operations
+func (r *Registry) AddErrors(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors += uint64(n)
+}
+
+// SyncFinished records the outcome and duration of a complete sync run
+func (r *Registry) SyncFinished(duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.lastSync = now
+	if err != nil {
+		r.syncsFailed++
+	} else {
+		r.syncsSucceeded++
+		r.lastSuccess = now
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			r.durationCounts[i]++
+		}
+	}
+	r.durationSum += seconds
+	r.durationCount++
+}
+
+// LastRun records the file counts by result and the duration of each phase
+// of the most recent sync run, replacing those of the previous run
+func (r *Registry) LastRun(files map[string]int, phases map[string]time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastRunFiles = files
+	r.lastRunPhases = phases
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ew := &errWriter{w: w}
+
+	ew.metric("snc_files_copied_total", "counter", "Files copied or updated in the target.", float64(r.filesCopied))
+	ew.metric("snc_files_skipped_total", "counter", "Unchanged files left as they were in the target.", float64(r.filesSkipped))
+	ew.metric("snc_bytes_transferred_total", "counter", "Bytes written to the target.", float64(r.bytesTransferred))
+	ew.metric("snc_errors_total", "counter", "Failed file operations.", float64(r.errors))
+
+	ew.header("snc_syncs_total", "counter", "Completed sync runs by result.")
+	ew.printf("snc_syncs_total{result=\"success\"} %d\n", r.syncsSucceeded)
+	ew.printf("snc_syncs_total{result=\"failure\"} %d\n", r.syncsFailed)
+
+	ew.metric("snc_last_sync_timestamp_seconds", "gauge", "Unix time of the last completed sync run.", unixSeconds(r.lastSync))
+	ew.metric("snc_last_success_timestamp_seconds", "gauge", "Unix time of the last successful sync run.", unixSeconds(r.lastSuccess))
+
+	if r.lastRunFiles != nil {
+		ew.header("snc_last_sync_files", "gauge", "Files handled by the last sync run by result.")
+		for _, result := range sortedKeys(r.lastRunFiles) {
+			ew.printf("snc_last_sync_files{result=\"%s\"} %d\n", result, r.lastRunFiles[result])
+		}
+	}
+	if r.lastRunPhases != nil {
+		ew.header("snc_last_sync_phase_duration_seconds", "gauge", "Duration of the phases of the last sync run.")
+		for _, phase := range sortedKeys(r.lastRunPhases) {
+			ew.printf("snc_last_sync_phase_duration_seconds{phase=\"%s\"} %s\n", phase, formatFloat(r.lastRunPhases[phase].Seconds()))
+		}
+	}
+
+	ew.header("snc_sync_duration_seconds", "histogram", "Duration of sync runs.")
+	for i, bound := range durationBuckets {
+		ew.printf("snc_sync_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), r.durationCounts[i])
+	}
+	ew.printf("snc_sync_duration_seconds_bucket{le=\"+Inf\"} %d\n", r.durationCount)
+	ew.printf("snc_sync_duration_seconds_sum %s\n", formatFloat(r.durationSum))
+	ew.printf("snc_sync_duration_seconds_count %d\n", r.durationCount)
+
+	return ew.n, ew.err
+}
+
+// SyncFinished records a complete sync run in the default registry
+func SyncFinished(duration time.Duration, err error) {
+	defaultRegistry.SyncFinished(duration, err)
+}
+
+// LastRun records the most recent sync run in the default registry
+func LastRun(files map[string]int, phases map[string]time.Duration) {
+	defaultRegistry.LastRun(files, phases)
+}
+
+// Handler returns an http.Handler serving the default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := defaultRegistry.WriteTo(w); err != nil {
+			logger.Warn("METRICS", "Failed to write metrics response: %v", err)
+		}
+	})
+}
+
+// Serve starts serving /metrics on addr in the background. It returns an
+// error if the address cannot be bound.
+func Serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logger.Error("METRICS", "Metrics server stopped: %v", err)
+		}
+	}()
+
+	logger.Info("METRICS", "Serving metrics on http://%s/metrics", ln.Addr())
+	return nil
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// errWriter accumulates the first write error so the exposition code can
+// stay linear
+type errWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
+
+func (ew *errWriter) header(name, kind, help string) {
+	ew.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (ew *errWriter) metric(name, kind, help string, value float64) {
+	ew.header(name, kind, help)
+	ew.printf("%s %s\n", name, formatFloat(value))
+}
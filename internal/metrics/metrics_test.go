@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	r.FileCopied(100)
+	r.FileCopied(50)
+	r.FileSkipped()
+	r.AddErrors(2)
+	r.LastRun(map[string]int{"copied": 2, "skipped": 5}, map[string]time.Duration{"copy": 1500 * time.Millisecond})
+	r.SyncFinished(3*time.Second, nil)
+	r.SyncFinished(120*time.Second, errors.New("failed"))
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := buf.String()
+
+	expectedLines := []string{
+		"# TYPE snc_files_copied_total counter",
+		"snc_files_copied_total 2",
+		"snc_files_skipped_total 1",
+		"snc_bytes_transferred_total 150",
+		"snc_errors_total 2",
+		`snc_last_sync_files{result="copied"} 2`,
+		`snc_last_sync_files{result="skipped"} 5`,
+		`snc_last_sync_phase_duration_seconds{phase="copy"} 1.5`,
+		`snc_syncs_total{result="success"} 1`,
+		`snc_syncs_total{result="failure"} 1`,
+		`snc_sync_duration_seconds_bucket{le="1"} 0`,
+		`snc_sync_duration_seconds_bucket{le="5"} 1`,
+		`snc_sync_duration_seconds_bucket{le="300"} 2`,
+		`snc_sync_duration_seconds_bucket{le="+Inf"} 2`,
+		"snc_sync_duration_seconds_sum 123",
+		"snc_sync_duration_seconds_count 2",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+
+	if strings.Contains(out, "snc_last_success_timestamp_seconds 0\n") {
+		t.Error("Expected last success timestamp to be set")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rec.Code != 200 {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected text/plain content type, got '%s'", rec.Header().Get("Content-Type"))
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "snc_sync_duration_seconds_count") {
+		t.Error("Expected histogram in metrics output")
+	}
+}
+
+func TestServeInvalidAddress(t *testing.T) {
+	if err := Serve("invalid-address"); err == nil {
+		t.Error("Expected error for invalid listen address")
+	}
+}
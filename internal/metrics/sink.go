@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"snc/internal/events"
+)
+
+// Sink records engine events in the default registry
+type Sink struct{}
+
+func (Sink) FileCopied(ev events.FileEvent) {
+	defaultRegistry.FileCopied(ev.Bytes)
+}
+
+func (Sink) FileSkipped(events.FileEvent) {
+	defaultRegistry.FileSkipped()
+}
+
+func (Sink) FileDeleted(events.FileEvent) {}
+
+func (Sink) Error(events.ErrorEvent) {
+	defaultRegistry.AddErrors(1)
+}
+
+func (Sink) Progress(events.ProgressEvent) {}
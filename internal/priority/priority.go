@@ -0,0 +1,15 @@
+// Package priority lowers the scheduling priority of the process, so that
+// long syncs leave CPU time and disk bandwidth to interactive work.
+package priority
+
+// niceness is the nice value set on Unix systems, the lowest priority
+const niceness = 19
+
+// Lower lowers the CPU and I/O priority of the running process. Linux
+// gets the highest nice value and the idle I/O scheduling class, macOS the
+// background policy that throttles CPU and I/O alike, and Windows the
+// background processing mode. The BSDs only get the nice value; other
+// systems return an error.
+func Lower() error {
+	return lower()
+}
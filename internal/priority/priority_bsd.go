@@ -0,0 +1,16 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+
+package priority
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func lower() error {
+	if err := unix.Setpriority(unix.PRIO_PROCESS, 0, niceness); err != nil {
+		return fmt.Errorf("failed to set nice value: %w", err)
+	}
+	return nil
+}
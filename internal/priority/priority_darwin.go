@@ -0,0 +1,23 @@
+//go:build darwin
+
+package priority
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Arguments of setpriority(2) selecting the background policy, which
+// x/sys/unix does not define
+const (
+	prioDarwinProcess = 4
+	prioDarwinBG      = 0x1000
+)
+
+func lower() error {
+	if err := unix.Setpriority(prioDarwinProcess, 0, prioDarwinBG); err != nil {
+		return fmt.Errorf("failed to enter background mode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package priority
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// I/O priority values of ioprio_set(2), which x/sys/unix does not define
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lower sets the priorities on every thread, since Linux keeps both the
+// nice value and the I/O priority per thread. Threads the runtime starts
+// later inherit them from the thread starting them.
+func lower() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		// Threads may exit while the list is walked
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, niceness); err != nil && err != unix.ESRCH {
+			return fmt.Errorf("failed to set nice value: %w", err)
+		}
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && errno != unix.ESRCH {
+			return fmt.Errorf("failed to set I/O priority: %w", errno)
+		}
+	}
+	return nil
+}
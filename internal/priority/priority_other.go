@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows && !freebsd && !openbsd && !netbsd && !dragonfly
+
+package priority
+
+import "errors"
+
+func lower() error {
+	return errors.New("lowering the priority is not supported on this platform")
+}
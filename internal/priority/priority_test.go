@@ -0,0 +1,17 @@
+package priority
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestLower(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows", "freebsd", "openbsd", "netbsd", "dragonfly":
+	default:
+		t.Skip("Lowering the priority is not supported on", runtime.GOOS)
+	}
+	if err := Lower(); err != nil {
+		t.Fatalf("Lower failed: %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package priority
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// lower enters the background processing mode, which lowers the CPU, I/O
+// and memory priority of the process
+func lower() error {
+	if err := windows.SetPriorityClass(windows.CurrentProcess(), windows.PROCESS_MODE_BACKGROUND_BEGIN); err != nil {
+		return fmt.Errorf("failed to enter background mode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Package report collects failed file operations into an error report
+// that can be written as JSON or CSV after a run
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Failure is a single failed file operation
+type Failure struct {
+	Path string `json:"path"`
+	// Operation is the kind of operation that failed, e.g. "stat", "copy"
+	// or "delete"
+	Operation string          `json:"operation"`
+	Code      errors.Code     `json:"code"`
+	Category  errors.Category `json:"category,omitempty"`
+	// OSError is the underlying system error, if any
+	OSError string `json:"os_error,omitempty"`
+	Error   string `json:"error"`
+}
+
+// Report is the content of an error report file
+type Report struct {
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Created  time.Time `json:"created"`
+	Failures []Failure `json:"failures"`
+}
+
+// Sink is an events.EventSink that records every error event
+type Sink struct {
+	events.Nop
+	failures []Failure
+}
+
+// Error records a failed operation
+func (s *Sink) Error(ev events.ErrorEvent) {
+	s.failures = append(s.failures, Failure{
+		Path:      ev.Path,
+		Operation: ev.Op,
+		Code:      errors.CodeOf(ev.Err),
+		Category:  errors.CategoryOf(ev.Err),
+		OSError:   osError(ev.Err),
+		Error:     ev.Err.Error(),
+	})
+}
+
+// Failures returns the failures recorded so far
+func (s *Sink) Failures() []Failure {
+	return s.failures
+}
+
+// osError returns the message of the system error in err's chain, if any
+func osError(err error) string {
+	var errno syscall.Errno
+	if stderrors.As(err, &errno) {
+		return errno.Error()
+	}
+	return ""
+}
+
+// Write writes the failures recorded for a run from source to target to
+// path, as CSV if path ends in .csv and as JSON otherwise. The file is
+// replaced as a whole, so it never holds a partial report.
+func (s *Sink) Write(path, source, target string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = writeCSV(tmp, s.failures)
+	} else {
+		r := Report{Source: source, Target: target, Created: time.Now().UTC(), Failures: s.failures}
+		if r.Failures == nil {
+			r.Failures = []Failure{}
+		}
+		enc := json.NewEncoder(tmp)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write error report: %w", err)
+	}
+	return nil
+}
+
+// Read reads a report written by Write. CSV reports do not record the
+// source and target, which are left empty.
+func Read(path string) (*Report, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error report: %w", err)
+	}
+	defer f.Close()
+
+	r := &Report{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		r.Failures, err = readCSV(f)
+	} else {
+		err = json.NewDecoder(f).Decode(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Paths returns the distinct paths of the failures, in report order. Paths
+// inside the report's source or target are moved to the given source and
+// target, so a report stays usable when the trees were mounted elsewhere.
+func (r *Report) Paths(source, target string) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, fail := range r.Failures {
+		if fail.Path == "" {
+			continue
+		}
+		path := rebase(fail.Path, r.Source, source)
+		if path == fail.Path {
+			path = rebase(fail.Path, r.Target, target)
+		}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// rebase moves path from below root from to below root to. Paths outside
+// from are returned unchanged.
+func rebase(path, from, to string) string {
+	if from == "" || to == "" {
+		return path
+	}
+	rel, err := filepath.Rel(from, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.Join(to, rel)
+}
+
+// csvHeader names the columns of a CSV report
+var csvHeader = []string{"path", "operation", "code", "category", "os_error", "error"}
+
+func writeCSV(f *os.File, failures []Failure) error {
+	w := csv.NewWriter(f)
+	if err := w.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, fail := range failures {
+		record := []string{fail.Path, fail.Operation, string(fail.Code), string(fail.Category), fail.OSError, fail.Error}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func readCSV(f *os.File) ([]Failure, error) {
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 || len(records[0]) != len(csvHeader) || records[0][0] != csvHeader[0] {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+
+	failures := make([]Failure, 0, len(records)-1)
+	for _, record := range records[1:] {
+		failures = append(failures, Failure{
+			Path:      record[0],
+			Operation: record[1],
+			Code:      errors.Code(record[2]),
+			Category:  errors.Category(record[3]),
+			OSError:   record[4],
+			Error:     record[5],
+		})
+	}
+	return failures, nil
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"syscall"
+	"testing"
+)
+
+func newTestSink() *Sink {
+	s := &Sink{}
+	s.Error(events.ErrorEvent{
+		Component: "STREAM", Op: events.OpCopy, Path: "/src/a.txt",
+		Err: errors.NewFileError(errors.ErrCannotOpenFile, "/src/a.txt", &fs.PathError{Op: "open", Path: "/src/a.txt", Err: syscall.EIO}),
+	})
+	s.Error(events.ErrorEvent{
+		Component: "DELETE", Op: events.OpDelete, Path: "/dst/b.txt",
+		Err: &fs.PathError{Op: "remove", Path: "/dst/b.txt", Err: syscall.EACCES},
+	})
+	return s
+}
+
+func TestSinkRecordsFailures(t *testing.T) {
+	failures := newTestSink().Failures()
+	if len(failures) != 2 {
+		t.Fatalf("Expected 2 failures, got %d", len(failures))
+	}
+
+	copyFail := failures[0]
+	if copyFail.Operation != "copy" || copyFail.Code != "cannot_open_file" || copyFail.Category != errors.CategoryFile {
+		t.Errorf("Unexpected copy failure: %+v", copyFail)
+	}
+	if copyFail.OSError != syscall.EIO.Error() {
+		t.Errorf("Expected OS error %q, got %q", syscall.EIO.Error(), copyFail.OSError)
+	}
+
+	deleteFail := failures[1]
+	if deleteFail.Operation != "delete" || deleteFail.Code != errors.CodePermissionDenied || deleteFail.Category != "" {
+		t.Errorf("Unexpected delete failure: %+v", deleteFail)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.json")
+	if err := newTestSink().Write(path, "/src", "/dst"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("Invalid JSON report: %v", err)
+	}
+	if r.Source != "/src" || r.Target != "/dst" || len(r.Failures) != 2 || r.Failures[1].Path != "/dst/b.txt" {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+
+	// An empty report replaces the previous one
+	if err := (&Sink{}).Write(path, "/src", "/dst"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	r = Report{}
+	if err := json.Unmarshal(data, &r); err != nil || r.Failures == nil || len(r.Failures) != 0 {
+		t.Errorf("Expected an empty failure list, got %s", data)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.csv")
+	if err := newTestSink().Write(path, "/src", "/dst"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Invalid CSV report: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "path" || records[1][0] != "/src/a.txt" || records[2][1] != "delete" {
+		t.Errorf("Unexpected CSV report: %v", records)
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"errors.json", "errors.csv"} {
+		path := filepath.Join(dir, name)
+		if err := newTestSink().Write(path, "/src", "/dst"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		r, err := Read(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if len(r.Failures) != 2 || r.Failures[0].Code != "cannot_open_file" || r.Failures[1].Path != "/dst/b.txt" {
+			t.Errorf("Unexpected failures in %s: %+v", name, r.Failures)
+		}
+	}
+
+	if _, err := Read(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected an error for a missing report")
+	}
+}
+
+func TestReportPaths(t *testing.T) {
+	r := &Report{Source: "/src", Target: "/dst", Failures: []Failure{
+		{Path: "/src/a.txt", Operation: "stat"},
+		{Path: "/src/a.txt", Operation: "copy"},
+		{Path: "/dst/b.txt", Operation: "delete"},
+		{Path: "/elsewhere/c.txt", Operation: "copy"},
+		{Path: "", Operation: "walk"},
+	}}
+
+	paths := r.Paths("/mnt/src", "/mnt/dst")
+	expected := []string{
+		filepath.Join("/mnt/src", "a.txt"),
+		filepath.Join("/mnt/dst", "b.txt"),
+		"/elsewhere/c.txt",
+	}
+	if len(paths) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("Expected path %d to be %q, got %q", i, expected[i], paths[i])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Package snapshot runs the commands that take and release a point-in-time
+// snapshot of the source, e.g. a Btrfs or LVM snapshot or a Windows shadow
+// copy, so a sync copies a consistent state of a directory that changes
+// while it is copied
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Snapshot is a snapshot of the source taken by a command
+type Snapshot struct {
+	// Path is the directory to sync from, the source as of the snapshot
+	Path string
+
+	source  string
+	release string
+}
+
+// Take runs command to snapshot source. The command gets the source in
+// SNC_SOURCE and prints the directory the source can be read from in the
+// snapshot as the last line of its output. release, if set, is the command
+// run by Release to remove the snapshot again.
+func Take(ctx context.Context, command, release, source string) (*Snapshot, error) {
+	out, err := run(ctx, command, "SNC_SOURCE="+source)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot command failed: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	path := strings.TrimSpace(lines[len(lines)-1])
+	if path == "" {
+		return nil, fmt.Errorf("snapshot command printed no path")
+	}
+	if info, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("snapshot path: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("snapshot path %s is not a directory", path)
+	}
+	return &Snapshot{Path: path, source: source, release: release}, nil
+}
+
+// Release runs the release command, with the source in SNC_SOURCE and the
+// snapshot path in SNC_SNAPSHOT. It does nothing without a release command.
+func (s *Snapshot) Release(ctx context.Context) error {
+	if s.release == "" {
+		return nil
+	}
+	if _, err := run(ctx, s.release, "SNC_SOURCE="+s.source, "SNC_SNAPSHOT="+s.Path); err != nil {
+		return fmt.Errorf("snapshot release command failed: %w", err)
+	}
+	return nil
+}
+
+// run runs command in the system shell with env added to the environment
+// and returns its standard output. The error output is included in the
+// error if the command fails.
+func run(ctx context.Context, command string, env ...string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
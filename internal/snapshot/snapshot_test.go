@@ -0,0 +1,66 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTakeAndRelease(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands need a POSIX shell")
+	}
+	dir := t.TempDir()
+	snapDir := filepath.Join(dir, "snap")
+	released := filepath.Join(dir, "released")
+
+	snap, err := Take(context.Background(),
+		`mkdir "`+snapDir+`" && echo "snapshotting $SNC_SOURCE" && echo "`+snapDir+`"`,
+		`echo "$SNC_SOURCE $SNC_SNAPSHOT" > "`+released+`"`,
+		"/data")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if snap.Path != snapDir {
+		t.Errorf("Expected snapshot path %s, got %s", snapDir, snap.Path)
+	}
+
+	if err := snap.Release(context.Background()); err != nil {
+		t.Fatalf("Unexpected release error: %v", err)
+	}
+	data, err := os.ReadFile(released)
+	if err != nil || strings.TrimSpace(string(data)) != "/data "+snapDir {
+		t.Errorf("Expected the release command to get source and snapshot, got %q, %v", data, err)
+	}
+}
+
+func TestTakeErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands need a POSIX shell")
+	}
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0644)
+
+	tests := []struct {
+		name    string
+		command string
+		expect  string
+	}{
+		{name: "failing command", command: "echo no space left >&2; exit 1", expect: "no space left"},
+		{name: "no output", command: "true", expect: "printed no path"},
+		{name: "missing path", command: "echo " + filepath.Join(dir, "missing"), expect: "no such file"},
+		{name: "not a directory", command: "echo " + file, expect: "not a directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Take(context.Background(), tt.command, "", dir)
+			if err == nil || !strings.Contains(err.Error(), tt.expect) {
+				t.Errorf("Expected an error containing %q, got %v", tt.expect, err)
+			}
+		})
+	}
+}
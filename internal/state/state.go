@@ -0,0 +1,141 @@
+// Package state keeps the metadata of the files in a target as of the last
+// sync, so later syncs can skip files that did not change without looking
+// at the target
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// version is the format of the state file; files of other versions are
+// ignored
+const version = 1
+
+// FileState is the last known state of a synced file
+type FileState struct {
+	// Size and ModTime are the source file's metadata when it was synced
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	// TargetSize and TargetModTime are the target file's metadata right
+	// after it was written or checked
+	TargetSize    int64     `json:"target_size"`
+	TargetModTime time.Time `json:"target_mtime"`
+}
+
+// DB is the state of a target, keyed by path relative to the source root
+type DB struct {
+	Version int    `json:"version"`
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	// Storage describes how files are stored in the target, e.g. whether
+	// they are encrypted
+	Storage string               `json:"storage"`
+	Files   map[string]FileState `json:"files"`
+
+	// seen holds the files recorded or found unchanged in this run
+	seen map[string]bool
+}
+
+// New returns an empty state for the given source, target and storage
+func New(source, target, storage string) *DB {
+	return &DB{
+		Version: version,
+		Source:  source,
+		Target:  target,
+		Storage: storage,
+		Files:   map[string]FileState{},
+		seen:    map[string]bool{},
+	}
+}
+
+// Load reads the state file at path. A missing file yields an empty
+// state, and so does a damaged one or one written for another source,
+// target or storage, in which case reset is true.
+func Load(path, source, target, storage string) (db *DB, reset bool, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(source, target, storage), false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	db = &DB{}
+	if err := json.Unmarshal(data, db); err != nil || db.Version != version || db.Source != source || db.Target != target || db.Storage != storage || db.Files == nil {
+		return New(source, target, storage), true, nil
+	}
+	db.seen = map[string]bool{}
+	return db, false, nil
+}
+
+// Unchanged reports whether the source file rel still has the size and
+// modification time it had when it was last synced. A file found
+// unchanged is kept in the state.
+func (db *DB) Unchanged(rel string, src os.FileInfo) bool {
+	fs, ok := db.Files[rel]
+	if !ok || fs.Size != src.Size() || !fs.ModTime.Equal(src.ModTime()) {
+		return false
+	}
+	db.seen[rel] = true
+	return true
+}
+
+// Lookup returns the recorded state of rel
+func (db *DB) Lookup(rel string) (FileState, bool) {
+	fs, ok := db.Files[rel]
+	return fs, ok
+}
+
+// Keep keeps the recorded state of rel as it is, e.g. for a file that was
+// left alone in this run
+func (db *DB) Keep(rel string) {
+	if _, ok := db.Files[rel]; ok {
+		db.seen[rel] = true
+	}
+}
+
+// Record remembers that the source file rel with metadata src is in sync
+// with the target file with metadata dst
+func (db *DB) Record(rel string, src, dst os.FileInfo) {
+	db.Files[rel] = FileState{
+		Size:          src.Size(),
+		ModTime:       src.ModTime(),
+		TargetSize:    dst.Size(),
+		TargetModTime: dst.ModTime(),
+	}
+	db.seen[rel] = true
+}
+
+// Save writes the state to path, replacing the file as a whole. With
+// prune, files that were not recorded or found unchanged since Load are
+// dropped; it should only be set after a complete walk of the source.
+func (db *DB) Save(path string, prune bool) error {
+	if prune {
+		for rel := range db.Files {
+			if !db.seen[rel] {
+				delete(db.Files, rel)
+			}
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = json.NewEncoder(tmp).Encode(db)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
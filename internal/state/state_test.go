@@ -0,0 +1,98 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path string, content string, mtime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 600, time.UTC)
+	src := writeFile(t, filepath.Join(dir, "a"), "content", mtime)
+
+	db, reset, err := Load(path, "/src", "/dst", "plain")
+	if err != nil || reset || len(db.Files) != 0 {
+		t.Fatalf("Expected an empty state for a missing file, got %v, %v, %v", db, reset, err)
+	}
+	if db.Unchanged("a", src) {
+		t.Error("Expected unknown file to be changed")
+	}
+	db.Record("a", src, src)
+	db.Record("b", src, src)
+	if err := db.Save(path, false); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	db, reset, err = Load(path, "/src", "/dst", "plain")
+	if err != nil || reset || len(db.Files) != 2 {
+		t.Fatalf("Expected 2 files in the loaded state, got %v, %v, %v", db, reset, err)
+	}
+	if !db.Unchanged("a", src) {
+		t.Error("Expected recorded file to be unchanged")
+	}
+	if fs, ok := db.Lookup("a"); !ok || fs.TargetSize != src.Size() || !fs.TargetModTime.Equal(mtime) {
+		t.Errorf("Unexpected target state: %+v", fs)
+	}
+
+	touched := writeFile(t, filepath.Join(dir, "a"), "content", mtime.Add(time.Second))
+	if db.Unchanged("a", touched) {
+		t.Error("Expected file with a new modification time to be changed")
+	}
+
+	// b was not seen since loading and is dropped by pruning
+	if err := db.Save(path, true); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	db, _, _ = Load(path, "/src", "/dst", "plain")
+	if _, ok := db.Lookup("b"); ok || len(db.Files) != 1 {
+		t.Errorf("Expected only a to be kept, got %v", db.Files)
+	}
+}
+
+func TestLoadReset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	info := writeFile(t, filepath.Join(dir, "a"), "content", time.Now())
+
+	db := New("/src", "/dst", "plain")
+	db.Record("a", info, info)
+	if err := db.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct{ source, target, storage string }{
+		{"/other", "/dst", "plain"},
+		{"/src", "/other", "plain"},
+		{"/src", "/dst", "encrypted contents"},
+	} {
+		db, reset, err := Load(path, tt.source, tt.target, tt.storage)
+		if err != nil || !reset || len(db.Files) != 0 {
+			t.Errorf("Expected an empty state for %v, got %v, %v", tt, reset, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if db, reset, err := Load(path, "/src", "/dst", "plain"); err != nil || !reset || len(db.Files) != 0 {
+		t.Errorf("Expected a damaged state file to be reset, got %v, %v", reset, err)
+	}
+}
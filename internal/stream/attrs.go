@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"snc/internal/config"
+	"strconv"
+	"strings"
+)
+
+// targetAttrs holds the ownership and permission overrides applied to
+// everything snc creates in the target. A value of -1 (uid, gid) or 0
+// (modes) leaves the attribute as created.
+type targetAttrs struct {
+	uid, gid int
+	fileMode os.FileMode
+	dirMode  os.FileMode
+}
+
+// defaultAttrs leaves ownership and permissions as created
+var defaultAttrs = &targetAttrs{uid: -1, gid: -1}
+
+// newTargetAttrs parses the --chown and --chmod settings of cfg
+func newTargetAttrs(cfg *config.Config) (*targetAttrs, error) {
+	if cfg.Chown == "" && cfg.Chmod == "" {
+		return defaultAttrs, nil
+	}
+
+	attrs := &targetAttrs{uid: -1, gid: -1}
+	var err error
+	if cfg.Chown != "" {
+		if attrs.uid, attrs.gid, err = parseOwner(cfg.Chown); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Chmod != "" {
+		if attrs.fileMode, attrs.dirMode, err = parseChmod(cfg.Chmod); err != nil {
+			return nil, err
+		}
+	}
+	return attrs, nil
+}
+
+// parseOwner parses "user:group", "user" or ":group". Names are resolved
+// through the system user database; numeric ids are used as they are.
+func parseOwner(spec string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(spec, ":")
+	uid, gid = -1, -1
+
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, lookupErr := user.Lookup(userName)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("invalid --chown user %q: %w", userName, lookupErr)
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("invalid --chown user %q: non-numeric uid %s", userName, u.Uid)
+			}
+		}
+	}
+
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return 0, 0, fmt.Errorf("invalid --chown group %q: %w", groupName, lookupErr)
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("invalid --chown group %q: non-numeric gid %s", groupName, g.Gid)
+			}
+		}
+	}
+
+	if uid < -1 || gid < -1 || (uid == -1 && gid == -1) {
+		return 0, 0, fmt.Errorf("invalid --chown %q (expected user:group, user or :group)", spec)
+	}
+	return uid, gid, nil
+}
+
+// parseChmod parses an octal mode applied to files, or comma-separated
+// F<mode> and D<mode> entries for files and directories, e.g. "F644,D755"
+func parseChmod(spec string) (fileMode, dirMode os.FileMode, err error) {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		target := &fileMode
+		switch {
+		case strings.HasPrefix(entry, "F"):
+			entry = entry[1:]
+		case strings.HasPrefix(entry, "D"):
+			entry, target = entry[1:], &dirMode
+		}
+
+		mode, parseErr := strconv.ParseUint(entry, 8, 32)
+		if parseErr != nil || mode > 0o7777 {
+			return 0, 0, fmt.Errorf("invalid --chmod %q (expected an octal mode or F<mode>,D<mode>)", spec)
+		}
+		*target = os.FileMode(mode)&os.ModePerm | unixModeBits(mode)
+	}
+	return fileMode, dirMode, nil
+}
+
+// unixModeBits converts setuid, setgid and sticky bits of a numeric mode to
+// their os.FileMode counterparts
+func unixModeBits(mode uint64) os.FileMode {
+	var m os.FileMode
+	if mode&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// applyFile applies the overrides to a file written to the target
+func (a *targetAttrs) applyFile(path string) error {
+	return a.apply(path, a.fileMode)
+}
+
+func (a *targetAttrs) apply(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if a.uid != -1 || a.gid != -1 {
+		if err := os.Lchown(path, a.uid, a.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirAll creates dir and any missing parents, applying the overrides to
+// every directory it creates. Existing directories are left untouched.
+func (a *targetAttrs) mkdirAll(dir string) error {
+	if a == defaultAttrs {
+		return os.MkdirAll(dir, 0755)
+	}
+
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := a.apply(missing[i], a.dirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expectedPerm returns the permissions a target copy of a file with
+// srcPerm is expected to have
+func (a *targetAttrs) expectedPerm(srcPerm os.FileMode) os.FileMode {
+	if a.fileMode != 0 {
+		return a.fileMode.Perm()
+	}
+	return srcPerm
+}
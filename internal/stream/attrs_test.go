@@ -0,0 +1,132 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strconv"
+	"testing"
+)
+
+func TestParseChmod(t *testing.T) {
+	tests := []struct {
+		spec        string
+		fileMode    os.FileMode
+		dirMode     os.FileMode
+		expectError bool
+	}{
+		{spec: "0644", fileMode: 0644},
+		{spec: "640", fileMode: 0640},
+		{spec: "F600,D700", fileMode: 0600, dirMode: 0700},
+		{spec: "D2775", dirMode: 0775 | os.ModeSetgid},
+		{spec: "u+w", expectError: true},
+		{spec: "F999", expectError: true},
+		{spec: "17777", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			fileMode, dirMode, err := parseChmod(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if fileMode != tt.fileMode || dirMode != tt.dirMode {
+				t.Errorf("Expected file %v dir %v, got file %v dir %v", tt.fileMode, tt.dirMode, fileMode, dirMode)
+			}
+		})
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		spec        string
+		uid, gid    int
+		expectError bool
+	}{
+		{spec: "1000:1000", uid: 1000, gid: 1000},
+		{spec: "33", uid: 33, gid: -1},
+		{spec: ":50", uid: -1, gid: 50},
+		{spec: ":", expectError: true},
+		{spec: "no-such-user-snc-test", expectError: true},
+		{spec: ":no-such-group-snc-test", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			uid, gid, err := parseOwner(tt.spec)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %q", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if uid != tt.uid || gid != tt.gid {
+				t.Errorf("Expected %d:%d, got %d:%d", tt.uid, tt.gid, uid, gid)
+			}
+		})
+	}
+}
+
+func TestSyncAppliesAttrs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ownership and permission bits are not supported on Windows")
+	}
+
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "sub", "file.txt"), "content")
+	os.MkdirAll(dstDir, 0755)
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		UpdateMethod: "modtime",
+		// Our own ids, so the test does not need to run as root
+		Chown: strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid()),
+		Chmod: "F600,D750",
+	}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	fileInfo, err := os.Stat(filepath.Join(dstDir, "sub", "file.txt"))
+	if err != nil {
+		t.Fatalf("Target file missing: %v", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600, got %v", fileInfo.Mode().Perm())
+	}
+
+	dirInfo, _ := os.Stat(filepath.Join(dstDir, "sub"))
+	if dirInfo.Mode().Perm() != 0750 {
+		t.Errorf("Expected created directory mode 0750, got %v", dirInfo.Mode().Perm())
+	}
+	rootInfo, _ := os.Stat(dstDir)
+	if rootInfo.Mode().Perm() != 0755 {
+		t.Errorf("Existing target root should keep mode 0755, got %v", rootInfo.Mode().Perm())
+	}
+
+	// The overridden mode is expected and not reported as a difference
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Expected no differences, got %v", diffs)
+	}
+}
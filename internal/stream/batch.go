@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// dirBatch copies the small files of one source directory one after the
+// other, as a batch. The source directory is opened once and its files
+// are opened relative to that handle, so the kernel does not resolve the
+// whole path of every file, and the target directory is only created
+// once. For trees of millions of tiny files, these lookups take longer
+// than copying the data.
+type dirBatch struct {
+	// limit is the size up to which files are batched
+	limit int64
+	// files holds the small files found in dir so far
+	dir   string
+	files []pendingFile
+
+	// handle is the source directory of the batch being copied, nil where
+	// files cannot be opened relative to it
+	handle *os.File
+	// made is the target directory known to exist
+	made string
+}
+
+// newDirBatch returns the batch for files up to limit bytes, or nil if
+// files are not batched
+func newDirBatch(limit int64) *dirBatch {
+	if limit <= 0 {
+		return nil
+	}
+	return &dirBatch{limit: limit}
+}
+
+// add queues f if it is small enough to be batched and returns the batch
+// of another directory to be copied first, if any. It returns false if f
+// is to be copied on its own.
+func (b *dirBatch) add(f pendingFile) (ready []pendingFile, added bool) {
+	if b == nil || !f.entry.Type().IsRegular() {
+		return nil, false
+	}
+	info, err := f.entry.Info()
+	if err != nil || info.Size() > b.limit {
+		return nil, false
+	}
+
+	dir := filepath.Dir(f.path)
+	if dir != b.dir {
+		ready = b.take()
+		b.dir = dir
+	}
+	b.files = append(b.files, f)
+	return ready, true
+}
+
+// take returns the queued files and empties the batch
+func (b *dirBatch) take() []pendingFile {
+	if b == nil {
+		return nil
+	}
+	files := b.files
+	b.files = nil
+	return files
+}
+
+// begin opens the source directory of files for copying them; end must
+// be called once they are copied
+func (b *dirBatch) begin(files []pendingFile) {
+	if len(files) > 0 {
+		b.handle = openDirHandle(filepath.Dir(files[0].path))
+	}
+}
+
+// end closes the source directory of the batch
+func (b *dirBatch) end() {
+	if b.handle != nil {
+		b.handle.Close()
+		b.handle = nil
+	}
+	b.made = ""
+}
+
+// open opens the source file at path, relative to the source directory
+// of the batch being copied if path is inside it
+func (b *dirBatch) open(path string) (*os.File, error) {
+	if b != nil && b.handle != nil && filepath.Dir(path) == b.handle.Name() {
+		return openInDir(b.handle, filepath.Base(path), path)
+	}
+	return os.Open(path)
+}
+
+// mkdirAll creates the target directory dir with attrs, unless the batch
+// being copied already did
+func (b *dirBatch) mkdirAll(attrs *targetAttrs, dir string) error {
+	if b != nil && b.handle != nil && dir == b.made {
+		return nil
+	}
+	if err := attrs.mkdirAll(dir); err != nil {
+		return err
+	}
+	if b != nil && b.handle != nil {
+		b.made = dir
+	}
+	return nil
+}
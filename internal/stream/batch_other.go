@@ -0,0 +1,16 @@
+//go:build !unix
+
+package stream
+
+import "os"
+
+// openDirHandle cannot open files relative to a directory on platforms
+// other than Unix, so batches open every file by its path
+func openDirHandle(dir string) *os.File {
+	return nil
+}
+
+// openInDir is never called without a directory handle
+func openInDir(dir *os.File, name, path string) (*os.File, error) {
+	return os.Open(path)
+}
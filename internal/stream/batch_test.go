@@ -0,0 +1,99 @@
+package stream
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+)
+
+func TestSyncSmallFileBatches(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	for _, dir := range []string{"a", "a/b", "c"} {
+		os.MkdirAll(filepath.Join(srcDir, dir), 0755)
+	}
+	files := map[string]string{
+		"top.txt":   "top",
+		"a/1.txt":   "one",
+		"a/b/2.txt": "two",
+		"a/z.txt":   "after the subdirectory",
+		"c/big.bin": strings.Repeat("x", 4096),
+		"c/3.txt":   "three",
+	}
+	for name, content := range files {
+		createTestFile(t, filepath.Join(srcDir, name), content)
+	}
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", SmallFiles: 1024}
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats.Files != len(files) || stats.Copied != len(files) || stats.Errors != 0 {
+		t.Errorf("Expected %d files copied, got %+v", len(files), stats)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dstDir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("Target %s: got %q, %v", name, data, err)
+		}
+	}
+
+	stats, err = Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if stats.Skipped != len(files) {
+		t.Errorf("Expected all files unchanged, got %+v", stats)
+	}
+}
+
+func TestDirBatchAdd(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dir, "a.txt"), "a")
+	createTestFile(t, filepath.Join(dir, "big.bin"), strings.Repeat("x", 100))
+	createTestFile(t, filepath.Join(dir, "sub", "b.txt"), "b")
+
+	entry := func(path string) pendingFile {
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pendingFile{path: path, entry: fs.FileInfoToDirEntry(info)}
+	}
+
+	b := newDirBatch(10)
+	if ready, added := b.add(entry(filepath.Join(dir, "a.txt"))); !added || len(ready) != 0 {
+		t.Errorf("Expected the small file to be queued, got %v, %v", ready, added)
+	}
+	if _, added := b.add(entry(filepath.Join(dir, "big.bin"))); added {
+		t.Error("Large files should not be batched")
+	}
+	ready, added := b.add(entry(filepath.Join(dir, "sub", "b.txt")))
+	if !added || len(ready) != 1 || filepath.Base(ready[0].path) != "a.txt" {
+		t.Errorf("Expected the batch of the previous directory, got %v", ready)
+	}
+	if rest := b.take(); len(rest) != 1 || filepath.Base(rest[0].path) != "b.txt" {
+		t.Errorf("Expected the last batch, got %v", rest)
+	}
+
+	// Files of the batch being copied are opened relative to its directory
+	b.begin(ready)
+	f, err := b.open(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	f.Close()
+	b.end()
+
+	if newDirBatch(0) != nil {
+		t.Error("Expected no batch without a limit")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package stream
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openDirHandle opens the directory dir for opening its files relative to
+// it, or returns nil if it cannot be opened
+func openDirHandle(dir string) *os.File {
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	return os.NewFile(uintptr(fd), dir)
+}
+
+// openInDir opens the file name in the directory dir for reading, as
+// os.Open would open path
+func openInDir(dir *os.File, name, path string) (*os.File, error) {
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
@@ -0,0 +1,20 @@
+package stream
+
+import (
+	"os"
+	"snc/internal/events"
+)
+
+// preserveBirthTime sets the creation time of dst to that of the source
+// file described by srcInfo, on platforms that record and allow setting
+// it (Windows and macOS). Failures are not fatal, like those of preserving
+// the modification time.
+func preserveBirthTime(dst string, srcInfo os.FileInfo, sink events.EventSink) {
+	btime, ok := birthTime(srcInfo)
+	if !ok {
+		return
+	}
+	if err := setBirthTime(dst, btime); err != nil {
+		events.Debugf(sink, "STREAM", "Failed to preserve creation time for %s: %v", dst, err)
+	}
+}
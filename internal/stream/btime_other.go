@@ -0,0 +1,19 @@
+//go:build !darwin && !windows
+
+package stream
+
+import (
+	"os"
+	"time"
+)
+
+// birthTime is not preserved on platforms other than Windows and macOS,
+// which either do not record it or do not allow setting it
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// setBirthTime is not supported on platforms other than Windows and macOS
+func setBirthTime(path string, btime time.Time) error {
+	return nil
+}
@@ -0,0 +1,38 @@
+//go:build darwin || windows
+
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+func TestCopyFilePreservesBirthTime(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "source.txt")
+	dst := filepath.Join(tempDir, "target.txt")
+	createTestFile(t, src, "contents")
+
+	// Make the target's own creation time distinguishable
+	time.Sleep(20 * time.Millisecond)
+	if _, err := copyFile(src, dst, defaultCopyOptions, events.Nop{}); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := birthTime(srcInfo)
+	got, ok := birthTime(dstInfo)
+	if !ok || !got.Equal(want) {
+		t.Errorf("Expected creation time %s, got %s", want, got)
+	}
+}
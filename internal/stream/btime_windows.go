@@ -0,0 +1,39 @@
+//go:build windows
+
+package stream
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// birthTime returns the creation time recorded by the filesystem
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, data.CreationTime.Nanoseconds()), true
+}
+
+// setBirthTime sets the creation time of path with SetFileTime
+func setBirthTime(path string, btime time.Time) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	h, err := syscall.CreateFile(p, syscall.FILE_WRITE_ATTRIBUTES,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	defer syscall.CloseHandle(h)
+
+	ft := syscall.NsecToFiletime(btime.UnixNano())
+	if err := syscall.SetFileTime(h, &ft, nil, nil); err != nil {
+		return &os.PathError{Op: "setfiletime", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package stream
+
+import (
+	"snc/internal/events"
+	"sync/atomic"
+)
+
+// transferBudget limits the bytes copied to a target in one run. A copy
+// that starts within the budget is finished even if it exceeds it; later
+// files are deferred to the next run.
+type transferBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// newTransferBudget returns the budget for limit bytes, or nil for no limit
+func newTransferBudget(limit int64) *transferBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &transferBudget{limit: limit}
+}
+
+// exhausted reports whether no more copies may be started
+func (b *transferBudget) exhausted() bool {
+	return b != nil && b.used.Load() >= b.limit
+}
+
+// spend records n bytes written to the target
+func (b *transferBudget) spend(n int64) {
+	if b != nil {
+		b.used.Add(n)
+	}
+}
+
+// reportDeferred logs the files left for the next run once the transfer
+// limit of target was reached
+func reportDeferred(target string, limit int64, stats *Stats, sink events.EventSink) {
+	if stats.Deferred > 0 {
+		events.Warnf(sink, "STREAM", "Transfer limit of %s reached for %s, %d files (%s) left for the next run",
+			formatBytes(limit), target, stats.Deferred, formatBytes(stats.DeferredBytes))
+	}
+}
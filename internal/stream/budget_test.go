@@ -0,0 +1,41 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestMaxTransfer(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	os.MkdirAll(srcDir, 0755)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		createTestFile(t, filepath.Join(srcDir, name), "0123456789")
+	}
+
+	// The second copy starts within the budget and is finished
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", MaxTransfer: 15}
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats.Copied != 2 || stats.Bytes != 20 || stats.Deferred != 1 || stats.DeferredBytes != 10 {
+		t.Errorf("Expected 2 files copied and 1 deferred, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "c.txt")); !os.IsNotExist(err) {
+		t.Errorf("Deferred file should not be copied: %v", err)
+	}
+
+	stats, err = Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if stats.Copied != 1 || stats.Skipped != 2 || stats.Deferred != 0 {
+		t.Errorf("Expected the deferred file to be copied next, got %+v", stats)
+	}
+}
@@ -0,0 +1,48 @@
+package stream
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultBufferSize is the copy buffer size used when none is configured.
+// Large buffers mean fewer system calls per file, which matters on fast
+// local disks and on network filesystems with high per-request latency.
+const defaultBufferSize = 1 << 20
+
+// bufferPool hands out reusable copy buffers of a fixed size
+type bufferPool struct {
+	pool sync.Pool
+}
+
+func newBufferPool(size int) *bufferPool {
+	if size <= 0 {
+		size = defaultBufferSize
+	}
+	return &bufferPool{pool: sync.Pool{New: func() interface{} {
+		buf := make([]byte, size)
+		return &buf
+	}}}
+}
+
+// get returns a buffer; a nil pool returns nil, letting io.CopyBuffer
+// allocate its default buffer
+func (p *bufferPool) get() []byte {
+	if p == nil {
+		return nil
+	}
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *bufferPool) put(buf []byte) {
+	if p != nil && buf != nil {
+		p.pool.Put(&buf)
+	}
+}
+
+// readerOnly hides any io.WriterTo implementation of the wrapped reader.
+// *os.File implements WriteTo, which io.CopyBuffer would use instead of the
+// buffer it is given.
+type readerOnly struct {
+	io.Reader
+}
@@ -0,0 +1,239 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"sort"
+	"strings"
+)
+
+// DiffKind describes how a file differs between source and target
+type DiffKind string
+
+const (
+	DiffOnlyInSource DiffKind = "only-in-source"
+	DiffOnlyInTarget DiffKind = "only-in-target"
+	DiffContent      DiffKind = "content-differs"
+	DiffMetadata     DiffKind = "metadata-differs"
+	// DiffTypeConflict is a file on one side whose path is a directory on
+	// the other
+	DiffTypeConflict DiffKind = "type-conflict"
+)
+
+// Difference is a single entry of the itemized diff produced by Check
+type Difference struct {
+	Path string   `json:"path"`
+	Kind DiffKind `json:"kind"`
+}
+
+// Check compares the source and target trees without modifying either of them.
+//
+// Unlike a sync, the comparison is symmetric: files that only exist in the
+// target are always reported, whether or not delete-missing is enabled.
+// A file is reported as content-differs when the configured update strategy
+// would copy it, and as metadata-differs when the strategy considers it
+// unchanged but its modification time or permissions differ. A file whose
+// path is a directory on the other side is reported as type-conflict.
+func Check(ctx context.Context, cfg *config.Config, sink events.EventSink) ([]Difference, error) {
+	events.Infof(sink, "CHECK", "Comparing %s with %s", cfg.Source, cfg.Target)
+
+	selector, err := NewStrategySelector(cfg.StrategyMap, cfg.UpdateMethod)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "update strategy creation", err)
+	}
+	events.Infof(sink, "CHECK", "Using update method: %s", selector)
+	if cfg.DetectTimeOffset {
+		events.Warnf(sink, "CHECK", "Not detecting the modification time offset, check does not write to the target")
+	}
+	// Modification times are compared like the modtime strategy does, also
+	// for files that the selected strategy considers unchanged
+	times := &ModTimeStrategy{Offset: cfg.TimeOffset, Tolerance: newNFSTarget(cfg.Target).mtimeTolerance()}
+	selector.SetTimeOffset(times.Offset)
+	selector.SetTimeTolerance(times.Tolerance)
+	selector.trustSidecars(cfg.SourceChecksums, cfg.Source)
+
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target encryption setup", err)
+	}
+	attrs, err := newTargetAttrs(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target attribute overrides", err)
+	}
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "exclude filter", err)
+	}
+
+	if err := checkSubpaths(cfg.Source, cfg.Subpaths); err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "subpath", err)
+	}
+
+	srcFiles, err := listFiles(ctx, cfg.Source, cfg.Subpaths, cfg.WalkWorkers, filter)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "source listing", err)
+	}
+	dstFiles, err := listFiles(ctx, cfg.Target, codec.encodeSubpaths(cfg.Subpaths), cfg.WalkWorkers, nil)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target listing", err)
+	}
+
+	var diffs []Difference
+	var errorCount int
+
+	// Target names are decoded before filtering, so excluded files are
+	// recognized in encrypted targets too. They are only reported when
+	// delete-excluded would remove them.
+	delete(dstFiles, manifestName)
+	for rel := range dstFiles {
+		if strings.HasPrefix(rel, stagingDir+string(filepath.Separator)) || strings.HasPrefix(rel, writeStagingDir+string(filepath.Separator)) {
+			delete(dstFiles, rel)
+		}
+	}
+	if cfg.Dedupe {
+		for rel := range dstFiles {
+			if strings.HasPrefix(rel, objectsDir+string(filepath.Separator)) {
+				delete(dstFiles, rel)
+			}
+		}
+	}
+	if codec.names || codec.manifest != nil || (filter != nil && !cfg.DeleteExcluded) {
+		decoded := make(map[string]os.FileInfo, len(dstFiles))
+		for rel, info := range dstFiles {
+			srcRel, err := codec.decodeFile(rel)
+			if err != nil {
+				errorCount++
+				sink.Error(events.ErrorEvent{Component: "CHECK", Message: "Unknown encrypted name", Op: events.OpName, Path: rel, Err: err})
+				continue
+			}
+			if excluded, remove := excludedTarget(cfg, filter, rel, srcRel); excluded && !remove {
+				continue
+			}
+			decoded[srcRel] = info
+		}
+		dstFiles = decoded
+	}
+
+	for rel, srcInfo := range srcFiles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		dstInfo, ok := dstFiles[rel]
+		if !ok {
+			kind := DiffOnlyInSource
+			if isDir(filepath.Join(cfg.Target, codec.encodeFile(rel))) {
+				kind = DiffTypeConflict
+			}
+			diffs = append(diffs, Difference{Path: rel, Kind: kind})
+			continue
+		}
+
+		kind, err := classify(codec.wrap(selector.Select(rel)), filepath.Join(cfg.Source, rel), filepath.Join(cfg.Target, codec.encodeFile(rel)), srcInfo, dstInfo, attrs, times)
+		if err != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "CHECK", Message: "Failed to compare", Op: events.OpCompare, Path: rel, Err: err})
+			continue
+		}
+		if kind != "" {
+			diffs = append(diffs, Difference{Path: rel, Kind: kind})
+		}
+	}
+
+	for rel := range dstFiles {
+		if _, ok := srcFiles[rel]; !ok {
+			kind := DiffOnlyInTarget
+			if isDir(filepath.Join(cfg.Source, rel)) {
+				kind = DiffTypeConflict
+			}
+			diffs = append(diffs, Difference{Path: rel, Kind: kind})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return comparePaths(diffs[i].Path, diffs[j].Path) < 0
+	})
+
+	events.Infof(sink, "CHECK", "Comparison completed: %d source files, %d target files, %d differences, %d errors",
+		len(srcFiles), len(dstFiles), len(diffs), errorCount)
+
+	if errorCount > 0 {
+		return diffs, errors.NewSyncError(errors.ErrSyncFailed, "check operation",
+			fmt.Errorf("%d files could not be compared", errorCount))
+	}
+	return diffs, nil
+}
+
+// classify returns the kind of difference between two existing files, or an
+// empty kind if they are considered identical. Permissions are compared
+// with those expected after the overrides in attrs, modification times
+// allowing for the target's offset and tolerance as set in times.
+func classify(strategy UpdateStrategy, srcPath, dstPath string, srcInfo, dstInfo os.FileInfo, attrs *targetAttrs, times *ModTimeStrategy) (DiffKind, error) {
+	needsUpdate, err := strategy.NeedsUpdate(srcPath, dstPath)
+	if err != nil {
+		return "", err
+	}
+	if needsUpdate {
+		return DiffContent, nil
+	}
+
+	if times.modTimeDiffers(srcInfo, dstInfo) || attrs.expectedPerm(srcInfo.Mode().Perm()) != dstInfo.Mode().Perm() {
+		return DiffMetadata, nil
+	}
+	return "", nil
+}
+
+// listFiles walks root, or its subtrees named by subpaths, with the given
+// number of workers and returns the regular files not excluded by filter,
+// keyed by their path relative to root
+func listFiles(ctx context.Context, root string, subpaths []string, workers int, filter *Filter) (map[string]os.FileInfo, error) {
+	files := make(map[string]os.FileInfo)
+
+	err := walkRoots(root, subpaths, workers, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.NewFileAccessError(path, err)
+		}
+
+		rel, relErr := filepath.Rel(root, path)
+		if relErr != nil {
+			return errors.NewRelativePathError(path, relErr)
+		}
+
+		if filter.Excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Special files are not compared, reading a FIFO would block
+		if d.IsDir() || isSpecial(d.Type()) {
+			return nil
+		}
+
+		info, infoErr := d.Info()
+		if infoErr != nil {
+			return errors.NewFileStatError(path, infoErr)
+		}
+		files[rel] = info
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
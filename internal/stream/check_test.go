@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	// Create temporary test directories
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(filepath.Join(srcDir, "subdir"), 0755)
+	os.MkdirAll(dstDir, 0755)
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// Identical file
+	createTestFile(t, filepath.Join(srcDir, "same.txt"), "same")
+	createTestFile(t, filepath.Join(dstDir, "same.txt"), "same")
+	os.Chtimes(filepath.Join(srcDir, "same.txt"), modTime, modTime)
+	os.Chtimes(filepath.Join(dstDir, "same.txt"), modTime, modTime)
+
+	// Same content, different modtime
+	createTestFile(t, filepath.Join(srcDir, "touched.txt"), "touched")
+	createTestFile(t, filepath.Join(dstDir, "touched.txt"), "touched")
+	os.Chtimes(filepath.Join(dstDir, "touched.txt"), modTime, modTime)
+
+	// Different content
+	createTestFile(t, filepath.Join(srcDir, "changed.txt"), "new content")
+	createTestFile(t, filepath.Join(dstDir, "changed.txt"), "old")
+
+	// Files present on one side only
+	createTestFile(t, filepath.Join(srcDir, "subdir", "new.txt"), "new")
+	createTestFile(t, filepath.Join(dstDir, "extra.txt"), "extra")
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		UpdateMethod: "sha256",
+	}
+
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := []Difference{
+		{Path: "changed.txt", Kind: DiffContent},
+		{Path: "extra.txt", Kind: DiffOnlyInTarget},
+		{Path: filepath.Join("subdir", "new.txt"), Kind: DiffOnlyInSource},
+		{Path: "touched.txt", Kind: DiffMetadata},
+	}
+
+	if len(diffs) != len(expected) {
+		t.Fatalf("Expected %d differences, got %d: %v", len(expected), len(diffs), diffs)
+	}
+	for i, want := range expected {
+		if diffs[i] != want {
+			t.Errorf("Difference %d: expected %v, got %v", i, want, diffs[i])
+		}
+	}
+
+	// Check must never write to the target
+	if _, err := os.Stat(filepath.Join(dstDir, "subdir", "new.txt")); !os.IsNotExist(err) {
+		t.Error("Expected check to leave the target untouched")
+	}
+}
+
+func TestCheckWithInvalidStrategy(t *testing.T) {
+	cfg := &config.Config{
+		Source:       os.TempDir(),
+		Target:       os.TempDir(),
+		UpdateMethod: "invalid",
+	}
+
+	if _, err := Check(context.Background(), cfg, events.Nop{}); err == nil {
+		t.Error("Expected error for invalid update method")
+	}
+}
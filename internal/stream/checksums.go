@@ -0,0 +1,188 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checksumList is the list of SHA-256 checksums of the target files
+// written to cfg.WriteChecksums, in the format of sha256sum, so a target
+// can be verified with `sha256sum -c` from its root
+type checksumList struct {
+	mu   sync.Mutex
+	path string
+	root string
+	// staging is checked for copies not moved into place yet
+	staging *writeStaging
+	// sums holds the hex checksum of every file, keyed by its path
+	// relative to the target root
+	sums  map[string]string
+	dirty bool
+}
+
+// loadChecksumList reads the checksum list of cfg, or returns nil if none
+// is written. The checksums of a previous run are kept for the files
+// found up to date, so they still describe the contents as written.
+func loadChecksumList(cfg *config.Config) (*checksumList, error) {
+	if cfg.WriteChecksums == "" {
+		return nil, nil
+	}
+	l := &checksumList{path: cfg.WriteChecksums, root: filepath.Clean(cfg.Target), staging: newWriteStaging(cfg), sums: map[string]string{}}
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read checksum list: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		sum, rel, ok := parseChecksumLine(scanner.Text())
+		if !ok {
+			return nil, fmt.Errorf("damaged checksum list %s: line %d is not a sha256sum line", l.path, n)
+		}
+		l.sums[rel] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checksum list: %w", err)
+	}
+	return l, nil
+}
+
+// parseChecksumLine splits a line of sha256sum output into the checksum
+// and the path, undoing the escaping of paths with backslashes or newlines
+func parseChecksumLine(line string) (sum, rel string, ok bool) {
+	escaped := strings.HasPrefix(line, `\`)
+	if escaped {
+		line = line[1:]
+	}
+	// A '*' instead of the second space marks binary mode
+	if len(line) < 66 || line[64] != ' ' || (line[65] != ' ' && line[65] != '*') {
+		return "", "", false
+	}
+	sum, rel = line[:64], line[66:]
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", "", false
+	}
+	if escaped {
+		rel = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r").Replace(rel)
+	}
+	return sum, rel, true
+}
+
+// formatChecksumLine returns the sha256sum line of rel, escaped like
+// sha256sum does for paths with backslashes or newlines
+func formatChecksumLine(sum, rel string) string {
+	if strings.ContainsAny(rel, "\\\n\r") {
+		return `\` + sum + "  " + strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(rel) + "\n"
+	}
+	return sum + "  " + rel + "\n"
+}
+
+// key returns the list key of the target file dstPath
+func (l *checksumList) key(dstPath string) string {
+	rel, err := filepath.Rel(l.root, dstPath)
+	if err != nil {
+		return dstPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// record remembers sum as the checksum of the target file dstPath, which
+// was just written
+func (l *checksumList) record(dstPath string, sum []byte) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sums[l.key(dstPath)] = hex.EncodeToString(sum)
+	l.dirty = true
+}
+
+// verified makes sure the target file dstPath, found up to date, is
+// listed. A file listed by a previous run keeps its checksum, others are
+// hashed as stored.
+func (l *checksumList) verified(dstPath string, sink events.EventSink) {
+	if l == nil {
+		return
+	}
+	key := l.key(dstPath)
+	l.mu.Lock()
+	_, ok := l.sums[key]
+	l.mu.Unlock()
+	if ok {
+		return
+	}
+
+	sum, err := calculateSHA256(dstPath)
+	if err != nil {
+		events.Warnf(sink, "STREAM", "Failed to hash %s for the checksum list: %v", dstPath, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sums[key] = sum
+	l.dirty = true
+}
+
+// flush writes the checksum list, replacing the file as a whole, if it
+// changed. Files no longer in the target, e.g. after they were deleted,
+// are dropped first.
+func (l *checksumList) flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.sums {
+		dstPath := filepath.Join(l.root, filepath.FromSlash(key))
+		if _, err := os.Lstat(dstPath); os.IsNotExist(err) {
+			if _, err := os.Lstat(l.staging.path(dstPath)); os.IsNotExist(err) {
+				delete(l.sums, key)
+				l.dirty = true
+			}
+		}
+	}
+	if !l.dirty {
+		return nil
+	}
+
+	keys := make([]string, 0, len(l.sums))
+	for key := range l.sums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), "."+filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write checksum list: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, key := range keys {
+		w.WriteString(formatChecksumLine(l.sums[key], key))
+	}
+	err = w.Flush()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write checksum list: %w", err)
+	}
+	l.dirty = false
+	return nil
+}
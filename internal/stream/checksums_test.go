@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"testing"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	list := filepath.Join(tempDir, "SHA256SUMS")
+
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "copied.txt"), "copied")
+	createTestFile(t, filepath.Join(srcDir, "sub", "same.txt"), "same")
+	createTestFile(t, filepath.Join(dstDir, "sub", "same.txt"), "same")
+
+	cfg := &config.Config{
+		Source:         srcDir,
+		Target:         dstDir,
+		UpdateMethod:   "size",
+		WriteChecksums: list,
+	}
+	if _, err := Sync(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	sumOf := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	want := sumOf("copied") + "  copied.txt\n" + sumOf("same") + "  sub/same.txt\n"
+	if data, _ := os.ReadFile(list); string(data) != want {
+		t.Errorf("Expected checksum list\n%s\ngot\n%s", want, data)
+	}
+
+	// Removed files are dropped, files up to date keep their checksum
+	os.Remove(filepath.Join(srcDir, "copied.txt"))
+	os.Remove(filepath.Join(dstDir, "copied.txt"))
+	if _, err := Sync(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	want = sumOf("same") + "  sub/same.txt\n"
+	if data, _ := os.ReadFile(list); string(data) != want {
+		t.Errorf("Expected checksum list\n%s\ngot\n%s", want, data)
+	}
+}
+
+func TestChecksumLineEscaping(t *testing.T) {
+	sum := hex.EncodeToString(make([]byte, sha256.Size))
+	for _, rel := range []string{"plain.txt", "dir/with space.txt", `back\slash`, "new\nline"} {
+		line := formatChecksumLine(sum, rel)
+		gotSum, gotRel, ok := parseChecksumLine(line[:len(line)-1])
+		if !ok || gotSum != sum || gotRel != rel {
+			t.Errorf("Line %q parsed as %q, %q, %v", line, gotSum, gotRel, ok)
+		}
+	}
+	if _, _, ok := parseChecksumLine("not a checksum  file.txt"); ok {
+		t.Error("Expected a malformed line to be rejected")
+	}
+}
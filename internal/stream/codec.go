@@ -0,0 +1,348 @@
+package stream
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/crypt"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// targetCodec describes how source files are represented in the target.
+// The zero value stores files unchanged; with a cipher, contents (and
+// optionally names) are encrypted so the target can live on untrusted
+// storage. With a manifest, files are stored zstd-compressed under their
+// name with compressedExt appended.
+type targetCodec struct {
+	cipher   *crypt.Cipher
+	names    bool
+	manifest *manifest
+}
+
+// compressedExt is appended to the names of compressed target files
+const compressedExt = ".zst"
+
+// plainTarget stores files in the target as they are in the source
+var plainTarget = &targetCodec{}
+
+// newTargetCodec creates the codec selected by cfg
+func newTargetCodec(cfg *config.Config) (*targetCodec, error) {
+	if cfg.StoreCompressed == config.CompressZstd {
+		m, err := loadManifest(cfg.Target)
+		if err != nil {
+			return nil, err
+		}
+		return &targetCodec{manifest: m}, nil
+	}
+	if cfg.EncryptKey == "" {
+		return plainTarget, nil
+	}
+
+	key, err := crypt.LoadKey(cfg.EncryptKey)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := crypt.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &targetCodec{cipher: cipher, names: cfg.EncryptNames}, nil
+}
+
+// String describes the codec for log messages
+func (c *targetCodec) String() string {
+	switch {
+	case c.manifest != nil:
+		return "zstd-compressed"
+	case c.cipher == nil:
+		return "plain"
+	case c.names:
+		return "encrypted contents and names"
+	default:
+		return "encrypted contents"
+	}
+}
+
+// encodeFile maps the path of a source file relative to the source root
+// to the path of its copy relative to the target root
+func (c *targetCodec) encodeFile(rel string) string {
+	if c.manifest != nil {
+		return rel + compressedExt
+	}
+	return c.encodePath(rel)
+}
+
+// decodeFile reverses encodeFile
+func (c *targetCodec) decodeFile(rel string) (string, error) {
+	if c.manifest != nil {
+		name, ok := strings.CutSuffix(rel, compressedExt)
+		if !ok {
+			return "", fmt.Errorf("%s is not a compressed file", rel)
+		}
+		return name, nil
+	}
+	return c.decodePath(rel)
+}
+
+// encodePath maps a path relative to the source root to its path relative
+// to the target root
+func (c *targetCodec) encodePath(rel string) string {
+	if c.cipher == nil || !c.names {
+		return rel
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		parts[i] = c.cipher.EncryptName(part)
+	}
+	return filepath.Join(parts...)
+}
+
+// decodePath reverses encodePath
+func (c *targetCodec) decodePath(rel string) (string, error) {
+	if c.cipher == nil || !c.names {
+		return rel, nil
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		name, err := c.cipher.DecryptName(part)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = name
+	}
+	return filepath.Join(parts...), nil
+}
+
+// newWriter wraps w so that everything written to it is stored in the
+// target format. Close must be called before w is closed.
+func (c *targetCodec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	if c.manifest != nil {
+		return zstd.NewWriter(w)
+	}
+	if c.cipher == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return c.cipher.NewWriter(w)
+}
+
+// storedSize returns the size of the target copy of a source file of size
+// bytes. Compressed sizes are not known in advance; the source size is
+// returned as an upper estimate.
+func (c *targetCodec) storedSize(size int64) int64 {
+	if c.cipher == nil {
+		return size
+	}
+	return crypt.EncryptedSize(size)
+}
+
+// wrap adapts strategy to compare source files with their encoded target
+// copies
+func (c *targetCodec) wrap(strategy UpdateStrategy) UpdateStrategy {
+	if c.manifest != nil {
+		return &compressedStrategy{inner: strategy, manifest: c.manifest}
+	}
+	if c.cipher == nil {
+		return strategy
+	}
+	return &encryptedStrategy{inner: strategy, cipher: c.cipher}
+}
+
+// written records that the target file dstPath now holds a copy of size
+// source bytes
+func (c *targetCodec) written(dstPath string, size int64) {
+	if c.manifest != nil {
+		c.manifest.record(dstPath, size)
+	}
+}
+
+// removed records that the target file dstPath was deleted
+func (c *targetCodec) removed(dstPath string) {
+	if c.manifest != nil {
+		c.manifest.forget(dstPath)
+	}
+}
+
+// flush writes the manifest of a compressed target if it changed
+func (c *targetCodec) flush() error {
+	if c.manifest == nil {
+		return nil
+	}
+	return c.manifest.save()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// encryptedStrategy applies an UpdateStrategy to an encrypted target. Sizes
+// are compared against the expected ciphertext size and checksums against
+// the decrypted target contents; modification times are preserved on the
+// encrypted copy and compared as usual, as are the permissions, ownership
+// and extended attributes compared by a meta strategy.
+type encryptedStrategy struct {
+	inner  UpdateStrategy
+	cipher *crypt.Cipher
+}
+
+func (e *encryptedStrategy) Name() string {
+	return e.inner.Name()
+}
+
+func (e *encryptedStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
+	}
+
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
+	}
+
+	if crypt.EncryptedSize(srcInfo.Size()) != dstInfo.Size() {
+		return true, nil
+	}
+
+	switch inner := e.inner.(type) {
+	case *SizeStrategy:
+		return false, nil
+	case checksumStrategy:
+		return compareChecksums(inner.Name(), srcPath, dstPath,
+			func() (string, error) { return sourceChecksum(inner, srcPath) },
+			func() (string, error) { return e.decryptedChecksum(dstPath, inner.newHash()) })
+	case *MetaStrategy:
+		if inner.modTimeDiffers(srcInfo, dstInfo) {
+			return true, nil
+		}
+		return metadataDiffers(srcPath, dstPath)
+	case *ModTimeStrategy:
+		return inner.modTimeDiffers(srcInfo, dstInfo), nil
+	default:
+		return !srcInfo.ModTime().Equal(dstInfo.ModTime()), nil
+	}
+}
+
+// ContentNeedsUpdate reports whether the encrypted copy needs writing
+// again, rather than only the metadata compared by a meta strategy
+func (e *encryptedStrategy) ContentNeedsUpdate(srcPath, dstPath string) (bool, error) {
+	meta, ok := e.inner.(*MetaStrategy)
+	if !ok {
+		return true, nil
+	}
+	return (&encryptedStrategy{inner: &meta.ModTimeStrategy, cipher: e.cipher}).NeedsUpdate(srcPath, dstPath)
+}
+
+// decryptedChecksum calculates the checksum of the plaintext of an
+// encrypted file with h
+func (e *encryptedStrategy) decryptedChecksum(filePath string, h hash.Hash) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	plain, err := e.cipher.NewReader(file)
+	if err != nil {
+		return "", err
+	}
+	return hashReader(plain, h)
+}
+
+// compressedStrategy applies an UpdateStrategy to a compressed target.
+// Sizes are compared against the source sizes in the manifest and
+// checksums and samples against the decompressed target contents;
+// modification times
+// are preserved on the compressed copy and compared as usual, as are the
+// permissions, ownership and extended attributes compared by a meta
+// strategy.
+type compressedStrategy struct {
+	inner    UpdateStrategy
+	manifest *manifest
+}
+
+func (c *compressedStrategy) Name() string {
+	return c.inner.Name()
+}
+
+func (c *compressedStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
+	}
+
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
+	}
+
+	// A copy missing from the manifest was not written by snc
+	if size, ok := c.manifest.size(dstPath); !ok || size != srcInfo.Size() {
+		return true, nil
+	}
+
+	switch inner := c.inner.(type) {
+	case *SizeStrategy:
+		return false, nil
+	case checksumStrategy:
+		return compareChecksums(inner.Name(), srcPath, dstPath,
+			func() (string, error) { return sourceChecksum(inner, srcPath) },
+			func() (string, error) { return decompressedChecksum(dstPath, inner.newHash()) })
+	case *SampleStrategy:
+		size := srcInfo.Size()
+		return compareChecksums("sample checksum", srcPath, dstPath,
+			func() (string, error) { return inner.sampleChecksum(srcPath, size) },
+			func() (string, error) {
+				return readDecompressed(dstPath, func(r io.Reader) (string, error) { return inner.sampleStream(r, size) })
+			})
+	case *MetaStrategy:
+		if inner.modTimeDiffers(srcInfo, dstInfo) {
+			return true, nil
+		}
+		return metadataDiffers(srcPath, dstPath)
+	case *ModTimeStrategy:
+		return inner.modTimeDiffers(srcInfo, dstInfo), nil
+	default:
+		return !srcInfo.ModTime().Equal(dstInfo.ModTime()), nil
+	}
+}
+
+// ContentNeedsUpdate reports whether the compressed copy needs writing
+// again, rather than only the metadata compared by a meta strategy
+func (c *compressedStrategy) ContentNeedsUpdate(srcPath, dstPath string) (bool, error) {
+	meta, ok := c.inner.(*MetaStrategy)
+	if !ok {
+		return true, nil
+	}
+	return (&compressedStrategy{inner: &meta.ModTimeStrategy, manifest: c.manifest}).NeedsUpdate(srcPath, dstPath)
+}
+
+// decompressedChecksum calculates the checksum of the contents of a
+// compressed file with h
+func decompressedChecksum(filePath string, h hash.Hash) (string, error) {
+	return readDecompressed(filePath, func(r io.Reader) (string, error) { return hashReader(r, h) })
+}
+
+// readDecompressed calls read with the contents of a compressed file
+func readDecompressed(filePath string, read func(io.Reader) (string, error)) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	dec, err := zstd.NewReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer dec.Close()
+	return read(dec)
+}
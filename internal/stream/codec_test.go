@@ -0,0 +1,308 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestEncryptedSyncAndDecrypt(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "encrypted")
+	restoreDir := filepath.Join(tempDir, "restored")
+	keyFile := filepath.Join(tempDir, "key")
+
+	os.MkdirAll(filepath.Join(srcDir, "subdir"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "secret.txt"), "top secret")
+	createTestFile(t, filepath.Join(srcDir, "subdir", "notes.txt"), "more secrets")
+	createTestFile(t, keyFile, strings.Repeat("0123456789abcdef", 4))
+
+	for _, method := range []string{"modtime", "sha256", "size", "crc32c"} {
+		t.Run(method, func(t *testing.T) {
+			os.RemoveAll(dstDir)
+			os.RemoveAll(restoreDir)
+
+			cfg := &config.Config{
+				Source:       srcDir,
+				Target:       dstDir,
+				UpdateMethod: method,
+				EncryptKey:   keyFile,
+				EncryptNames: true,
+			}
+			if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+
+			// Neither names nor contents may appear in plain text
+			filepath.WalkDir(dstDir, func(path string, d os.DirEntry, err error) error {
+				if err != nil || path == dstDir {
+					return err
+				}
+				if strings.Contains(d.Name(), "secret") || strings.Contains(d.Name(), "subdir") {
+					t.Errorf("Target name %s is not encrypted", path)
+				}
+				if !d.IsDir() {
+					data, _ := os.ReadFile(path)
+					if strings.Contains(string(data), "secret") {
+						t.Errorf("Target file %s is not encrypted", path)
+					}
+				}
+				return nil
+			})
+
+			// A second run finds nothing to do
+			rec := &recordingSink{}
+			if _, err := Sync(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("Second sync failed: %v", err)
+			}
+			if len(rec.copied) != 0 || len(rec.skipped) != 2 {
+				t.Errorf("Expected 2 skipped files on second sync, got %d copied, %d skipped", len(rec.copied), len(rec.skipped))
+			}
+
+			diffs, err := Check(context.Background(), cfg, events.Nop{})
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if len(diffs) != 0 {
+				t.Errorf("Expected no differences, got %v", diffs)
+			}
+
+			restoreCfg := &config.Config{
+				Source:       dstDir,
+				Target:       restoreDir,
+				EncryptKey:   keyFile,
+				EncryptNames: true,
+			}
+			if err := Decrypt(context.Background(), restoreCfg, events.Nop{}); err != nil {
+				t.Fatalf("Decrypt failed: %v", err)
+			}
+			for rel, want := range map[string]string{"secret.txt": "top secret", "subdir/notes.txt": "more secrets"} {
+				got, err := os.ReadFile(filepath.Join(restoreDir, rel))
+				if err != nil {
+					t.Fatalf("Restored file %s missing: %v", rel, err)
+				}
+				if string(got) != want {
+					t.Errorf("Restored %s: expected %q, got %q", rel, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedDeleteMissing(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "encrypted")
+	keyFile := filepath.Join(tempDir, "key")
+
+	os.MkdirAll(srcDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "keep.txt"), "keep")
+	createTestFile(t, filepath.Join(srcDir, "remove.txt"), "remove")
+	createTestFile(t, keyFile, strings.Repeat("0123456789abcdef", 4))
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		UpdateMethod: "modtime",
+		EncryptKey:   keyFile,
+		EncryptNames: true,
+	}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	os.Remove(filepath.Join(srcDir, "remove.txt"))
+	createTestFile(t, filepath.Join(dstDir, "foreign.txt"), "not ours")
+
+	rec := &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+
+	if len(rec.deleted) != 1 || rec.deleted[0].Path != "remove.txt" {
+		t.Errorf("Expected remove.txt to be deleted, got %v", rec.deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "foreign.txt")); err != nil {
+		t.Errorf("File with unknown name should be kept: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dstDir)
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 files left in target, got %d", len(entries))
+	}
+}
+
+func TestCompressedSyncAndDeleteMissing(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "compressed")
+
+	os.MkdirAll(filepath.Join(srcDir, "subdir"), 0755)
+	content := strings.Repeat("compress me ", 1000)
+	createTestFile(t, filepath.Join(srcDir, "file.txt"), content)
+	createTestFile(t, filepath.Join(srcDir, "subdir", "notes.txt"), "notes")
+
+	for _, method := range []string{"modtime", "sha256", "size"} {
+		t.Run(method, func(t *testing.T) {
+			os.RemoveAll(dstDir)
+
+			cfg := &config.Config{
+				Source:          srcDir,
+				Target:          dstDir,
+				UpdateMethod:    method,
+				StoreCompressed: config.CompressZstd,
+			}
+			if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dstDir, "file.txt.zst"))
+			if err != nil {
+				t.Fatalf("Compressed copy missing: %v", err)
+			}
+			if len(data) >= len(content) {
+				t.Errorf("Expected compressed copy smaller than %d bytes, got %d", len(content), len(data))
+			}
+			dec, err := zstd.NewReader(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Failed to open compressed copy: %v", err)
+			}
+			got, err := io.ReadAll(dec)
+			dec.Close()
+			if err != nil || string(got) != content {
+				t.Errorf("Compressed copy does not decompress to the source: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, manifestName)); err != nil {
+				t.Errorf("Manifest missing: %v", err)
+			}
+
+			// A second run finds nothing to do
+			rec := &recordingSink{}
+			if _, err := Sync(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("Second sync failed: %v", err)
+			}
+			if len(rec.copied) != 0 || len(rec.skipped) != 2 {
+				t.Errorf("Expected 2 skipped files on second sync, got %d copied, %d skipped", len(rec.copied), len(rec.skipped))
+			}
+
+			diffs, err := Check(context.Background(), cfg, events.Nop{})
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if len(diffs) != 0 {
+				t.Errorf("Expected no differences, got %v", diffs)
+			}
+
+			os.Remove(filepath.Join(srcDir, "subdir", "notes.txt"))
+			defer createTestFile(t, filepath.Join(srcDir, "subdir", "notes.txt"), "notes")
+			rec = &recordingSink{}
+			if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("DeleteMissing failed: %v", err)
+			}
+			if len(rec.deleted) != 1 || rec.deleted[0].Path != "subdir/notes.txt" {
+				t.Errorf("Expected subdir/notes.txt to be deleted, got %v", rec.deleted)
+			}
+			m, err := loadManifest(dstDir)
+			if err != nil {
+				t.Fatalf("Failed to load manifest: %v", err)
+			}
+			if _, ok := m.size(filepath.Join(dstDir, "subdir", "notes.txt.zst")); ok {
+				t.Error("Deleted file should be dropped from the manifest")
+			}
+			if size, ok := m.size(filepath.Join(dstDir, "file.txt.zst")); !ok || size != int64(len(content)) {
+				t.Errorf("Expected manifest size %d for file.txt, got %d", len(content), size)
+			}
+		})
+	}
+}
+
+func TestCodecMetaStrategy(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	keyFile := filepath.Join(tempDir, "key")
+	os.MkdirAll(srcDir, 0755)
+	srcFile := filepath.Join(srcDir, "file.txt")
+	createTestFile(t, srcFile, "content")
+	createTestFile(t, keyFile, strings.Repeat("0123456789abcdef", 4))
+
+	configs := map[string]*config.Config{
+		"encrypted":  {EncryptKey: keyFile},
+		"compressed": {StoreCompressed: config.CompressZstd},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			os.Chmod(srcFile, 0644)
+			cfg.Source, cfg.Target, cfg.UpdateMethod = srcDir, filepath.Join(tempDir, name), "meta"
+			if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+
+			// Only the permissions change, so the copy only gets them
+			os.Chmod(srcFile, 0600)
+			rec := &recordingSink{}
+			if _, err := Sync(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("Second sync failed: %v", err)
+			}
+			if len(rec.copied) != 1 || rec.copied[0].Bytes != 0 {
+				t.Fatalf("Expected the metadata of 1 file to be updated, got %v", rec.copied)
+			}
+			dstFile := rec.copied[0].DstPath
+			info, err := os.Stat(dstFile)
+			if err != nil {
+				t.Fatalf("Copy missing: %v", err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("Expected the permissions of the source on %s, got %v", dstFile, info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestCompressedSampleStrategy(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	os.MkdirAll(srcDir, 0755)
+	srcFile := filepath.Join(srcDir, "file.txt")
+	createTestFile(t, srcFile, "original content")
+
+	cfg := &config.Config{
+		Source:          srcDir,
+		Target:          filepath.Join(tempDir, "compressed"),
+		UpdateMethod:    "sample",
+		StoreCompressed: config.CompressZstd,
+	}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Same size and modification time, different contents
+	info, _ := os.Stat(srcFile)
+	createTestFile(t, srcFile, "modified content")
+	os.Chtimes(srcFile, info.ModTime(), info.ModTime())
+	rec := &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if len(rec.copied) != 1 {
+		t.Errorf("Expected the changed file to be copied, got %d copied, %d skipped", len(rec.copied), len(rec.skipped))
+	}
+}
+
+// recordingSink collects the file events it receives
+type recordingSink struct {
+	events.Nop
+	copied, skipped, deleted []events.FileEvent
+}
+
+func (r *recordingSink) FileCopied(ev events.FileEvent)  { r.copied = append(r.copied, ev) }
+func (r *recordingSink) FileSkipped(ev events.FileEvent) { r.skipped = append(r.skipped, ev) }
+func (r *recordingSink) FileDeleted(ev events.FileEvent) { r.deleted = append(r.deleted, ev) }
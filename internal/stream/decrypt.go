@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"time"
+)
+
+// Decrypt restores an encrypted target: every file below cfg.Source is
+// decrypted with cfg.EncryptKey and written in plain text below cfg.Target,
+// decrypting names as well when cfg.EncryptNames is set. Existing files in
+// cfg.Target are overwritten.
+func Decrypt(ctx context.Context, cfg *config.Config, sink events.EventSink) error {
+	events.Infof(sink, "DECRYPT", "Decrypting %s into %s", cfg.Source, cfg.Target)
+
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return errors.NewSyncError(errors.ErrSyncFailed, "decryption setup", err)
+	}
+	if codec.cipher == nil {
+		return errors.NewSyncError(errors.ErrSyncFailed, "decryption setup", fmt.Errorf("no encryption key configured"))
+	}
+
+	var fileCount, restoredCount, errorCount int
+
+	err = filepath.WalkDir(cfg.Source, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if err != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "DECRYPT", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		fileCount++
+
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "DECRYPT", Message: "Cannot compute relative path for", Op: events.OpName, Path: path, Err: relErr})
+			return nil
+		}
+
+		plainRel, decodeErr := codec.decodePath(rel)
+		if decodeErr != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "DECRYPT", Message: "Skipping file with unknown encrypted name", Op: events.OpName, Path: path, Err: decodeErr})
+			return nil
+		}
+
+		dstPath := filepath.Join(cfg.Target, plainRel)
+		bytesWritten, err := decryptFile(codec, path, dstPath, sink)
+		if err != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "DECRYPT", Message: "Failed to decrypt file", Op: events.OpDecrypt, Path: path, Err: err})
+			return nil
+		}
+
+		restoredCount++
+		sink.FileCopied(events.FileEvent{
+			Path: plainRel, SrcPath: path, DstPath: dstPath,
+			Bytes: bytesWritten, Itemize: itemizeNewFile,
+		})
+		return nil
+	})
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		events.Warnf(sink, "DECRYPT", "Decryption interrupted: %v", ctxErr)
+		return ctxErr
+	}
+	if err != nil {
+		return errors.NewSyncError(errors.ErrSyncFailed, "decrypt operation", err)
+	}
+
+	events.Infof(sink, "DECRYPT", "Decryption completed: %d files processed, %d restored, %d errors",
+		fileCount, restoredCount, errorCount)
+
+	if errorCount > 0 {
+		return errors.NewSyncError(errors.ErrSyncFailed, "decrypt operation",
+			fmt.Errorf("%d files could not be decrypted", errorCount))
+	}
+	return nil
+}
+
+// decryptFile writes the plaintext of the encrypted file src to dst and
+// returns the number of plaintext bytes written. A partially written dst is
+// removed if the file fails authentication.
+func decryptFile(codec *targetCodec, src, dst string, sink events.EventSink) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return 0, errors.NewSyncError(errors.ErrCannotCreateParentDir, dst, err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, errors.NewFileError(errors.ErrCannotOpenFile, src, err)
+	}
+	defer in.Close()
+
+	plain, err := codec.cipher.NewReader(in)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, errors.NewFileError(errors.ErrCannotCreateFile, dst, err)
+	}
+
+	n, err := io.Copy(out, plain)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, err
+	}
+
+	if srcInfo, statErr := in.Stat(); statErr == nil {
+		if chtimesErr := os.Chtimes(dst, time.Now(), srcInfo.ModTime()); chtimesErr != nil {
+			events.Warnf(sink, "DECRYPT", "Failed to preserve modtime for %s: %v", dst, chtimesErr)
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// objectsDir is the directory in the root of a deduplicated target that
+// holds one hardlink per distinct file
+const objectsDir = ".snc-objects"
+
+// objectPool stores identical target files once by hardlinking them to an
+// object named after their contents. Hardlinks share their modification
+// time, so the name also holds the modification time, and only files
+// that agree in both are linked.
+type objectPool struct {
+	dir string
+	// disabled is set once the target turned out not to support hardlinks
+	disabled atomic.Bool
+}
+
+// newObjectPool returns the pool of the target at root
+func newObjectPool(root string) *objectPool {
+	return &objectPool{dir: filepath.Join(root, objectsDir)}
+}
+
+// objectPath returns the object of a file with the given SHA-256 sum and
+// modification time, spread over subdirectories by the first byte of sum
+func (p *objectPool) objectPath(sum []byte, modTime time.Time) string {
+	name := hex.EncodeToString(sum) + "-" + strconv.FormatInt(modTime.UnixNano(), 10)
+	return filepath.Join(p.dir, name[:2], name)
+}
+
+// add links the target file dst, with contents sum, to the pool. If an
+// identical file is in the pool already, dst is replaced by a link to it.
+// Failures are logged and leave dst as a separate copy.
+func (p *objectPool) add(dst string, sum []byte, modTime time.Time, sink events.EventSink) {
+	if p.disabled.Load() {
+		return
+	}
+
+	obj := p.objectPath(sum, modTime)
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		events.Warnf(sink, "STREAM", "Cannot create object directory, %s is not deduplicated: %v", dst, err)
+		return
+	}
+
+	err := os.Link(dst, obj)
+	if err == nil {
+		events.Tracef(sink, "STREAM", "Stored %s as object %s", dst, filepath.Base(obj))
+		return
+	}
+	if !os.IsExist(err) {
+		if p.disabled.CompareAndSwap(false, true) {
+			events.Warnf(sink, "STREAM", "Cannot link files in the target, deduplication disabled: %v", err)
+		}
+		return
+	}
+
+	if err := linkReplace(obj, dst); err != nil {
+		events.Warnf(sink, "STREAM", "Cannot link %s to existing object %s: %v", dst, filepath.Base(obj), err)
+		return
+	}
+	events.Debugf(sink, "STREAM", "Deduplicated %s with object %s", dst, filepath.Base(obj))
+}
+
+// linkReplace atomically replaces dst with a hardlink to obj, by linking
+// obj to a temporary name next to dst and renaming that over dst
+func linkReplace(obj, dst string) error {
+	for i := 0; i < 100; i++ {
+		tmp := filepath.Join(filepath.Dir(dst), fmt.Sprintf("%s%08x%s", targetTempPrefix, rand.Uint32(), tempSuffix))
+		err := os.Link(obj, tmp)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dst); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot create temporary link in %s", filepath.Dir(dst))
+}
+
+// prune removes objects no target file links to anymore, left behind when
+// files were updated or deleted, and returns their number. Where link
+// counts are not available, nothing is removed.
+func (p *objectPool) prune() (int, error) {
+	removed := 0
+	err := filepath.WalkDir(p.dir, func(path string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == p.dir {
+			return filepath.SkipAll
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if n, ok := linkCount(info); ok && n == 1 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to prune %s: %w", p.dir, err)
+	}
+	return removed, nil
+}
+
+// pruneObjects prunes pool, if any, and logs the outcome
+func pruneObjects(pool *objectPool, sink events.EventSink) {
+	if pool == nil {
+		return
+	}
+	removed, err := pool.prune()
+	if err != nil {
+		events.Warnf(sink, "STREAM", "%v", err)
+	}
+	if removed > 0 {
+		events.Infof(sink, "STREAM", "Removed %d unused objects from %s", removed, pool.dir)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package stream
+
+import "os"
+
+// linkCount is not known on platforms other than Unix
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
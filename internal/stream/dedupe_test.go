@@ -0,0 +1,107 @@
+//go:build unix
+
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+func TestDedupeSync(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+
+	os.MkdirAll(filepath.Join(srcDir, "moved"), 0755)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, rel := range []string{"a.txt", "moved/a.txt", "other.txt"} {
+		content := "same contents"
+		if rel == "other.txt" {
+			content = "different contents"
+		}
+		path := filepath.Join(srcDir, rel)
+		createTestFile(t, path, content)
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		UpdateMethod:  "modtime",
+		Dedupe:        true,
+		DeleteMissing: true,
+	}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	sameFile := func(a, b string) bool {
+		ai, errA := os.Stat(filepath.Join(dstDir, a))
+		bi, errB := os.Stat(filepath.Join(dstDir, b))
+		return errA == nil && errB == nil && os.SameFile(ai, bi)
+	}
+	if !sameFile("a.txt", "moved/a.txt") {
+		t.Error("Identical files should be linked to the same object")
+	}
+	if sameFile("a.txt", "other.txt") {
+		t.Error("Different files should not be linked")
+	}
+	if n := countObjects(t, dstDir); n != 2 {
+		t.Errorf("Expected 2 objects, got %d", n)
+	}
+
+	// A second run finds nothing to do and the pool is not a difference
+	rec := &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if len(rec.copied) != 0 || len(rec.skipped) != 3 {
+		t.Errorf("Expected 3 skipped files on second sync, got %d copied, %d skipped", len(rec.copied), len(rec.skipped))
+	}
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Expected no differences, got %v", diffs)
+	}
+
+	// Updating a linked file leaves the other link intact
+	createTestFile(t, filepath.Join(srcDir, "a.txt"), "new contents")
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync after update failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dstDir, "moved", "a.txt")); string(data) != "same contents" {
+		t.Errorf("Linked file changed with its update: %q", data)
+	}
+
+	// Objects of deleted files are removed
+	os.Remove(filepath.Join(srcDir, "moved", "a.txt"))
+	if _, err := DeleteMissing(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, objectsDir)); err != nil {
+		t.Errorf("Object pool should be kept: %v", err)
+	}
+	if n := countObjects(t, dstDir); n != 2 {
+		t.Errorf("Expected 2 objects after deletion, got %d", n)
+	}
+}
+
+// countObjects returns the number of objects in the pool of root
+func countObjects(t *testing.T, root string) int {
+	t.Helper()
+	n := 0
+	filepath.WalkDir(filepath.Join(root, objectsDir), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	return n
+}
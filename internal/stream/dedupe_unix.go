@@ -0,0 +1,17 @@
+//go:build unix
+
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkCount returns the number of hardlinks to the file of info
+func linkCount(info os.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Nlink), true
+}
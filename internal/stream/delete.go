@@ -1,70 +1,516 @@
 package stream
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
-	"snc/internal/logger"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"time"
 )
 
-// DeleteMissing removes files from dst that do not exist in src
-func DeleteMissing(srcRoot, dstRoot string) error {
-	logger.Info("DELETE", "Starting cleanup of missing files from %s", dstRoot)
+// DeleteMissing removes files from the target that do not exist in the
+// source. The walk stops early when ctx is cancelled; every deletion is
+// reported to sink.
+//
+// Files matching an exclude filter were intentionally left out of the sync
+// (e.g. target-local caches) and are kept, unless cfg.DeleteExcluded is
+// set, in which case they are deleted even if they exist in the source.
+//
+// With encrypted names, target files whose names cannot be decrypted were
+// not written by snc with this key and are reported and kept.
+//
+// Nothing is deleted if the source is missing, unreadable or empty (unless
+// cfg.ForceDelete is set), or if the cleanup would exceed cfg.MaxDelete or
+// cfg.MaxDeletePercent.
+//
+// The returned Stats are never nil and count the files checked, deleted and
+// failed so far.
+func DeleteMissing(ctx context.Context, cfg *config.Config, sink events.EventSink) (*Stats, error) {
+	return DeleteMissingIndexed(ctx, cfg, nil, sink)
+}
+
+// DeleteMissingIndexed is DeleteMissing, keeping the target files of the
+// source files in index, if set, without checking the source again
+func DeleteMissingIndexed(ctx context.Context, cfg *config.Config, index *SourceIndex, sink events.EventSink) (*Stats, error) {
+	events.Infof(sink, "DELETE", "Starting cleanup of missing files from %s", cfg.Target)
+
+	del, err := newDeleter(cfg, sink)
+	if err != nil {
+		return &Stats{}, err
+	}
+	del.index = index
+
+	start := time.Now()
+	defer func() {
+		del.stats.DeleteDuration += time.Since(start)
+		if err := del.codec.flush(); err != nil {
+			events.Warnf(sink, "DELETE", "%v", err)
+		}
+		if cfg.Dedupe {
+			pruneObjects(newObjectPool(cfg.Target), sink)
+		}
+	}()
+
+	if err := checkDeleteAllowed(ctx, cfg, index); err != nil {
+		events.Warnf(sink, "DELETE", "Cleanup aborted, no files deleted: %v", err)
+		return &del.stats, err
+	}
+
+	err = del.walkTarget(ctx)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		events.Warnf(sink, "DELETE", "Cleanup interrupted: %v", ctxErr)
+		return &del.stats, ctxErr
+	}
+	if err != nil {
+		return &del.stats, err
+	}
+
+	del.report()
+	return &del.stats, nil
+}
+
+// deleter removes target files missing from the source, either for a whole
+// tree or one directory at a time when interleaved with the sync walk
+type deleter struct {
+	cfg    *config.Config
+	codec  *targetCodec
+	filter *Filter
+	sink   events.EventSink
+	// protect finds the target directories protected by a marker
+	protect *protectedDirs
+	// index, if set, holds source files known to exist
+	index *SourceIndex
+
+	// dryRun only records the files that would be deleted in planned
+	dryRun  bool
+	planned []string
+
+	// stats counts the files checked, deleted and failed
+	stats Stats
+}
 
-	var fileCount, deletedCount, errorCount int
+// checkDeleteAllowed runs the safety checks that must pass before any file
+// is deleted from the target
+func checkDeleteAllowed(ctx context.Context, cfg *config.Config, index *SourceIndex) error {
+	if err := checkSourceForDelete(ctx, cfg); err != nil {
+		return err
+	}
+	return checkDeleteLimit(ctx, cfg, index)
+}
+
+// checkSourceForDelete refuses deletion when the source is missing or
+// unreadable, or holds no files while the target does. Both usually mean
+// the source is not what the user thinks it is, such as an unmounted
+// network share, and deleting would wipe the target.
+func checkSourceForDelete(ctx context.Context, cfg *config.Config) error {
+	if cfg.ForceDelete {
+		return nil
+	}
+
+	info, err := os.Stat(cfg.Source)
+	if err != nil {
+		return errors.NewSyncError(errors.ErrUnsafeDelete, "source is not accessible (use --force-delete to override)", err)
+	}
+	if !info.IsDir() {
+		return errors.NewSyncError(errors.ErrUnsafeDelete, "source is not a directory (use --force-delete to override)", errors.ErrNotADirectory)
+	}
+	if _, err := os.ReadDir(cfg.Source); err != nil {
+		return errors.NewSyncError(errors.ErrUnsafeDelete, "source is not readable (use --force-delete to override)", err)
+	}
+	if err := checkSubpaths(cfg.Source, cfg.Subpaths); err != nil {
+		return errors.NewSyncError(errors.ErrUnsafeDelete, "source subpath is not accessible (use --force-delete to override)", err)
+	}
 
-	err := filepath.WalkDir(dstRoot, func(dstPath string, d os.DirEntry, err error) error {
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return err
+	}
+	srcHasFiles, err := containsFile(ctx, cfg.Source, cfg.Subpaths, filter)
+	if err != nil || srcHasFiles {
+		return err
+	}
+	dstSubpaths := cfg.Subpaths
+	if len(dstSubpaths) > 0 {
+		codec, err := newTargetCodec(cfg)
 		if err != nil {
-			logger.Error("DELETE", "Error accessing %s: %v", dstPath, err)
-			errorCount++
-			return nil
+			return err
 		}
+		dstSubpaths = codec.encodeSubpaths(dstSubpaths)
+	}
+	if dstHasFiles, err := containsFile(ctx, cfg.Target, dstSubpaths, nil); err != nil || !dstHasFiles {
+		return err
+	}
+	return errors.NewSyncError(errors.ErrUnsafeDelete, "source contains no files (use --force-delete to override)",
+		fmt.Errorf("%s is empty", cfg.Source))
+}
 
-		if d.IsDir() {
-			logger.Debug("DELETE", "Skipping directory: %s", dstPath)
+// errFound stops a walk once its answer is known
+var errFound = fmt.Errorf("found")
+
+// containsFile reports whether root, or its subtrees named by subpaths,
+// holds at least one file not excluded by filter. Unreadable subdirectories
+// are ignored.
+func containsFile(ctx context.Context, root string, subpaths []string, filter *Filter) (bool, error) {
+	err := walkRoots(root, subpaths, 1, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
 			return nil
 		}
+		if rel, relErr := filepath.Rel(root, path); relErr == nil && filter.Excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return true, nil
+	}
+	return false, err
+}
+
+// DeletePlan describes what a cleanup would do
+type DeletePlan struct {
+	// Target is the target the files would be deleted from
+	Target string
+	// Files lists the target files that would be deleted, relative to the
+	// source root and in walk order
+	Files []string
+	// Checked is the number of target files checked
+	Checked int
+}
 
-		fileCount++
-		logger.Debug("DELETE", "Checking file: %s", dstPath)
+// PlanDelete returns the files DeleteMissing would delete with cfg, without
+// touching the target. The safety checks of DeleteMissing are not run.
+func PlanDelete(ctx context.Context, cfg *config.Config) (*DeletePlan, error) {
+	return planDelete(ctx, cfg, nil)
+}
 
-		// compute relative path to dst root
-		rel, relErr := filepath.Rel(dstRoot, dstPath)
-		if relErr != nil {
-			logger.Error("DELETE", "Cannot compute relative path for %s: %v", dstPath, relErr)
-			errorCount++
-			return nil
+// planDelete is PlanDelete, looking up source files in index first
+func planDelete(ctx context.Context, cfg *config.Config, index *SourceIndex) (*DeletePlan, error) {
+	plan, err := newDeleter(cfg, events.Nop{})
+	if err != nil {
+		return nil, err
+	}
+	plan.dryRun = true
+	plan.index = index
+	if err := plan.walkTarget(ctx); err != nil {
+		return nil, err
+	}
+	return &DeletePlan{Target: cfg.Target, Files: plan.planned, Checked: plan.stats.Checked}, nil
+}
+
+// checkDeleteLimit counts the files a cleanup would delete, without
+// touching the target, and returns an error if that exceeds the configured
+// limits. The limits protect against wiping the target when the source
+// unexpectedly appears empty, e.g. because a mount failed.
+func checkDeleteLimit(ctx context.Context, cfg *config.Config, index *SourceIndex) error {
+	if cfg.MaxDelete <= 0 && cfg.MaxDeletePercent <= 0 {
+		return nil
+	}
+
+	plan, err := planDelete(ctx, cfg, index)
+	if err != nil {
+		return err
+	}
+
+	n, total := len(plan.Files), plan.Checked
+	if cfg.MaxDelete > 0 && n > cfg.MaxDelete {
+		return errors.NewSyncError(errors.ErrDeleteLimitExceeded, "delete missing",
+			fmt.Errorf("would delete %d files, more than --max-delete %d", n, cfg.MaxDelete))
+	}
+	if cfg.MaxDeletePercent > 0 && total > 0 {
+		if percent := float64(n) * 100 / float64(total); percent > cfg.MaxDeletePercent {
+			return errors.NewSyncError(errors.ErrDeleteLimitExceeded, "delete missing",
+				fmt.Errorf("would delete %d of %d target files (%.1f%%), more than --max-delete-percent %g",
+					n, total, percent, cfg.MaxDeletePercent))
+		}
+	}
+	return nil
+}
+
+func newDeleter(cfg *config.Config, sink events.EventSink) (*deleter, error) {
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	protect, err := newProtectedDirs(cfg.Target)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrTargetProtected, "target protection", err)
+	}
+	return &deleter{cfg: cfg, codec: codec, filter: filter, protect: protect, sink: sink}, nil
+}
+
+// walkTarget checks every file in the target, or in its subtrees named by
+// cfg.Subpaths
+func (del *deleter) walkTarget(ctx context.Context) error {
+	if len(del.cfg.Subpaths) == 0 {
+		return del.walk(ctx, del.cfg.Target)
+	}
+	for _, sub := range del.codec.encodeSubpaths(del.cfg.Subpaths) {
+		dstDir := filepath.Join(del.cfg.Target, sub)
+		if _, err := os.Lstat(dstDir); os.IsNotExist(err) {
+			continue
+		}
+		if err := del.walk(ctx, dstDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walk checks every file below dstDir, which must be inside the target
+func (del *deleter) walk(ctx context.Context, dstDir string) error {
+	return walkDir(dstDir, del.cfg.WalkWorkers, func(dstPath string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
 		}
 
-		srcPath := filepath.Join(srcRoot, rel)
+		if err != nil {
+			del.stats.Errors++
+			del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Error accessing", Op: events.OpWalk, Path: dstPath, Err: err})
+			return nil
+		}
 
-		// check if file exists in source
-		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
-			// File doesn't exist in source, delete it
-			if err := os.Remove(dstPath); err != nil {
-				logger.Error("DELETE", "Failed to delete missing file %s: %v", dstPath, err)
-				errorCount++
-			} else {
-				logger.Progress("DELETE", "REMOVE", "Deleted missing file: %s", rel)
-				deletedCount++
+		if d.IsDir() {
+			if del.isObjectsDir(dstPath) || isStagingDir(del.cfg.Target, dstPath) || del.protect.protectedBy(filepath.Join(dstPath, protectMarker), del.sink) != "" {
+				return filepath.SkipDir
 			}
-		} else if err != nil {
-			// Log error accessing source file but continue
-			logger.Error("DELETE", "Error accessing source file %s: %v", srcPath, err)
-			errorCount++
-		} else {
-			logger.Debug("DELETE", "File exists in source, keeping: %s", rel)
+			events.Debugf(del.sink, "DELETE", "Skipping directory: %s", dstPath)
+			return nil
 		}
 
+		del.checkFile(dstPath)
 		return nil
 	})
+}
 
-	if err != nil {
-		logger.Error("DELETE", "Directory walk failed: %v", err)
-		return err
+// checkDir checks the entries of a single target directory, given the
+// path of the corresponding source directory relative to the source root.
+// Both directories are listed once and merged, so the source is not looked
+// up for every target entry. Subdirectories that still exist in the source
+// are left to later calls; the contents of those that do not are checked
+// right away.
+func (del *deleter) checkDir(ctx context.Context, srcRel string) error {
+	start := time.Now()
+	defer func() {
+		del.stats.DeleteDuration += time.Since(start)
+	}()
+
+	dstDir := del.cfg.Target
+	if srcRel != "." {
+		dstDir = filepath.Join(dstDir, del.codec.encodePath(srcRel))
+	}
+
+	dstEntries, err := os.ReadDir(dstDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		del.stats.Errors++
+		del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Error accessing", Op: events.OpWalk, Path: dstDir, Err: err})
+		return nil
 	}
+	// Without a source listing, every entry is looked up on its own
+	srcDir := filepath.Join(del.cfg.Source, srcRel)
+	srcEntries, srcErr := os.ReadDir(srcDir)
+	listed := srcErr == nil
+
+	merged, undecodable := mergeListings(srcEntries, dstEntries, del.decodeEntry)
+	for _, entry := range undecodable {
+		// Reported as unknown names by checkFile
+		if err := del.walk(ctx, filepath.Join(dstDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range merged {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if m.dst == nil {
+			continue
+		}
 
-	logger.Info("DELETE", "Cleanup completed: %d files checked, %d deleted, %d errors",
-		fileCount, deletedCount, errorCount)
+		dstPath := filepath.Join(dstDir, m.dst.Name())
+		if !m.dst.IsDir() {
+			if listed {
+				del.checkListedFile(dstPath, m.src != nil)
+			} else {
+				del.checkFile(dstPath)
+			}
+			continue
+		}
+		if del.isObjectsDir(dstPath) || isStagingDir(del.cfg.Target, dstPath) {
+			continue
+		}
 
+		if !del.filter.Excluded(filepath.Join(srcRel, m.name), true) && del.isSourceDir(srcDir, m, listed) {
+			continue
+		}
+		if err := del.walk(ctx, dstPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// decodeEntry returns the source name of the target directory entry d
+func (del *deleter) decodeEntry(d os.DirEntry) (string, error) {
+	if d.IsDir() {
+		return del.codec.decodePath(d.Name())
+	}
+	return del.codec.decodeFile(d.Name())
+}
+
+// isSourceDir reports whether the target directory of m has a directory
+// as counterpart in srcDir. Symbolic links in the source count as the
+// directories they point to.
+func (del *deleter) isSourceDir(srcDir string, m mergedEntry, listed bool) bool {
+	if listed {
+		if m.src == nil {
+			return false
+		}
+		if m.src.IsDir() {
+			return true
+		}
+		if m.src.Type()&os.ModeSymlink == 0 {
+			return false
+		}
+	}
+	info, err := os.Stat(filepath.Join(srcDir, m.name))
+	return err == nil && info.IsDir()
+}
+
+// isObjectsDir reports whether dstPath is the object pool of a
+// deduplicated target, which has no counterpart in the source
+func (del *deleter) isObjectsDir(dstPath string) bool {
+	return del.cfg.Dedupe && dstPath == filepath.Join(del.cfg.Target, objectsDir)
+}
+
+// checkFile deletes the target file at dstPath if it has no counterpart
+// in the source
+func (del *deleter) checkFile(dstPath string) {
+	del.checkFileIn(dstPath, false, false)
+}
+
+// checkListedFile is checkFile for a target file whose source directory was
+// listed, inSource telling whether it has a counterpart there
+func (del *deleter) checkListedFile(dstPath string, inSource bool) {
+	del.checkFileIn(dstPath, true, inSource)
+}
+
+// checkFileIn is checkFile, looking up the source file only if it was not
+// listed
+func (del *deleter) checkFileIn(dstPath string, listed, inSource bool) {
+	del.stats.Checked++
+	events.Debugf(del.sink, "DELETE", "Checking file: %s", dstPath)
+
+	// compute relative path to dst root
+	rel, relErr := filepath.Rel(del.cfg.Target, dstPath)
+	if relErr != nil {
+		del.stats.Errors++
+		del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Cannot compute relative path for", Op: events.OpName, Path: dstPath, Err: relErr})
+		return
+	}
+	if rel == manifestName && del.codec.manifest != nil {
+		return
+	}
+	if marker := del.protect.protectedBy(dstPath, del.sink); marker != "" {
+		events.Debugf(del.sink, "DELETE", "Protected by %s, keeping: %s", marker, rel)
+		return
+	}
+
+	srcRel, decodeErr := del.codec.decodeFile(rel)
+	if decodeErr != nil {
+		del.stats.Errors++
+		del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Keeping file with unknown encrypted name", Op: events.OpName, Path: dstPath, Err: decodeErr})
+		return
+	}
+	srcPath := filepath.Join(del.cfg.Source, srcRel)
+
+	if excluded, remove := excludedTarget(del.cfg, del.filter, rel, srcRel); excluded {
+		if !remove {
+			events.Debugf(del.sink, "DELETE", "File is excluded, keeping: %s", srcRel)
+			return
+		}
+		del.delete(dstPath, srcRel)
+		return
+	}
+
+	if del.index.has(srcRel) || (listed && inSource) {
+		events.Debugf(del.sink, "DELETE", "File exists in source, keeping: %s", srcRel)
+		return
+	}
+	if listed {
+		del.delete(dstPath, srcRel)
+		return
+	}
+
+	// check if file exists in source; a dangling symlink counts as existing
+	if _, err := os.Lstat(srcPath); os.IsNotExist(err) {
+		// File doesn't exist in source, delete it
+		del.delete(dstPath, srcRel)
+	} else if err != nil {
+		// Report error accessing source file but continue
+		del.stats.Errors++
+		del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Error accessing source file", Op: events.OpStat, Path: srcPath, Err: err})
+	} else {
+		events.Debugf(del.sink, "DELETE", "File exists in source, keeping: %s", srcRel)
+	}
+}
+
+// excludedTarget reports whether the target file rel, named srcRel in the
+// source, is excluded from the sync, and if so whether delete-missing
+// removes it anyway: with DeleteExcluded, or DeleteJunk for junk files.
+// Hidden target files count as excluded with SkipHidden, even if the
+// source has no counterpart whose attributes could tell.
+func excludedTarget(cfg *config.Config, filter *Filter, rel, srcRel string) (excluded, remove bool) {
+	if !filter.Excluded(srcRel, false) && !(cfg.SkipHidden && isHidden(cfg.Target, rel)) {
+		return false, false
+	}
+	return true, cfg.DeleteExcluded || (cfg.DeleteJunk && filter.Junk(srcRel))
+}
+
+// delete removes a target file and reports the outcome to the sink
+func (del *deleter) delete(dstPath, rel string) {
+	if del.dryRun {
+		del.planned = append(del.planned, rel)
+		del.stats.Deleted++
+		return
+	}
+	if del.cfg.StageDeletes {
+		// Removed from the manifest when the deletion is confirmed
+		if err := stageDelete(del.cfg.Target, dstPath); err != nil {
+			del.stats.Errors++
+			del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Failed to stage missing file for deletion", Op: events.OpDelete, Path: dstPath, Err: err})
+			return
+		}
+	} else {
+		if err := os.Remove(dstPath); err != nil {
+			del.stats.Errors++
+			del.sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Failed to delete missing file", Op: events.OpDelete, Path: dstPath, Err: err})
+			return
+		}
+		del.codec.removed(dstPath)
+	}
+	del.sink.FileDeleted(events.FileEvent{Path: rel, DstPath: dstPath, Itemize: itemizeDeleting})
+	del.stats.Deleted++
+}
+
+func (del *deleter) report() {
+	events.Infof(del.sink, "DELETE", "Cleanup completed: %d files checked, %d deleted, %d errors",
+		del.stats.Checked, del.stats.Deleted, del.stats.Errors)
+}
@@ -0,0 +1,245 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"sort"
+	"testing"
+)
+
+func TestSyncDeleteDuring(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "top.txt"), "top")
+	createTestFile(t, filepath.Join(srcDir, "sub", "kept.txt"), "kept")
+
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "gone", "deeper"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "cache"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "stale.txt"), "stale")
+	createTestFile(t, filepath.Join(dstDir, "sub", "stale.txt"), "stale")
+	createTestFile(t, filepath.Join(dstDir, "gone", "deeper", "old.txt"), "old")
+	createTestFile(t, filepath.Join(dstDir, "cache", "local.bin"), "local")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		UpdateMethod:  "modtime",
+		DeleteMissing: true,
+		DeleteMode:    config.DeleteDuring,
+		Exclude:       []string{"cache"},
+	}
+
+	rec := &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	var deleted []string
+	for _, ev := range rec.deleted {
+		deleted = append(deleted, filepath.ToSlash(ev.Path))
+	}
+	sort.Strings(deleted)
+	expected := []string{"gone/deeper/old.txt", "stale.txt", "sub/stale.txt"}
+	if len(deleted) != len(expected) {
+		t.Fatalf("Expected deletions %v, got %v", expected, deleted)
+	}
+	for i := range expected {
+		if deleted[i] != expected[i] {
+			t.Errorf("Expected deletions %v, got %v", expected, deleted)
+			break
+		}
+	}
+
+	if len(rec.copied) != 2 {
+		t.Errorf("Expected 2 copied files, got %d", len(rec.copied))
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "cache", "local.bin")); err != nil {
+		t.Errorf("Excluded file should be kept: %v", err)
+	}
+}
+
+func TestDeleteMissingLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxDelete     int
+		maxPercent    float64
+		mode          string
+		expectDeleted bool
+	}{
+		{name: "no limit", expectDeleted: true},
+		{name: "within max-delete", maxDelete: 3, expectDeleted: true},
+		{name: "exceeds max-delete", maxDelete: 2},
+		{name: "within percent", maxPercent: 75, expectDeleted: true},
+		{name: "exceeds percent", maxPercent: 50},
+		{name: "exceeds max-delete during sync", maxDelete: 2, mode: config.DeleteDuring},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			srcDir := filepath.Join(tempDir, "source")
+			dstDir := filepath.Join(tempDir, "destination")
+			os.MkdirAll(srcDir, 0755)
+			os.MkdirAll(dstDir, 0755)
+
+			// 3 of 4 target files are missing from the source
+			createTestFile(t, filepath.Join(srcDir, "keep.txt"), "keep")
+			for _, name := range []string{"keep.txt", "a.txt", "b.txt", "c.txt"} {
+				createTestFile(t, filepath.Join(dstDir, name), name)
+			}
+
+			cfg := &config.Config{
+				Source:           srcDir,
+				Target:           dstDir,
+				UpdateMethod:     "modtime",
+				DeleteMissing:    true,
+				DeleteMode:       tt.mode,
+				MaxDelete:        tt.maxDelete,
+				MaxDeletePercent: tt.maxPercent,
+			}
+
+			rec := &recordingSink{}
+			var err error
+			if tt.mode == config.DeleteDuring {
+				_, err = Sync(context.Background(), cfg, rec)
+			} else {
+				_, err = DeleteMissing(context.Background(), cfg, rec)
+			}
+
+			if tt.expectDeleted {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(rec.deleted) != 3 {
+					t.Errorf("Expected 3 deletions, got %d", len(rec.deleted))
+				}
+				return
+			}
+
+			if err == nil {
+				t.Error("Expected the delete limit to abort the cleanup")
+			}
+			if len(rec.deleted) != 0 {
+				t.Errorf("Expected no deletions, got %d", len(rec.deleted))
+			}
+			entries, _ := os.ReadDir(dstDir)
+			if len(entries) != 4 {
+				t.Errorf("Expected all 4 target files to remain, got %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestDeleteMissingRefusesEmptySource(t *testing.T) {
+	tests := []struct {
+		name          string
+		setupSource   func(srcDir string)
+		exclude       []string
+		force         bool
+		expectDeleted bool
+	}{
+		{
+			name:        "missing source",
+			setupSource: func(srcDir string) {},
+		},
+		{
+			name:        "empty source",
+			setupSource: func(srcDir string) { os.MkdirAll(filepath.Join(srcDir, "empty"), 0755) },
+		},
+		{
+			name: "only excluded files in source",
+			setupSource: func(srcDir string) {
+				os.MkdirAll(srcDir, 0755)
+				os.WriteFile(filepath.Join(srcDir, "file.tmp"), []byte("tmp"), 0644)
+			},
+			exclude: []string{"*.tmp"},
+		},
+		{
+			name:          "empty source with --force-delete",
+			setupSource:   func(srcDir string) { os.MkdirAll(srcDir, 0755) },
+			force:         true,
+			expectDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			srcDir := filepath.Join(tempDir, "source")
+			dstDir := filepath.Join(tempDir, "destination")
+			os.MkdirAll(dstDir, 0755)
+			createTestFile(t, filepath.Join(dstDir, "precious.txt"), "precious")
+			tt.setupSource(srcDir)
+
+			cfg := &config.Config{
+				Source:        srcDir,
+				Target:        dstDir,
+				DeleteMissing: true,
+				Exclude:       tt.exclude,
+				ForceDelete:   tt.force,
+			}
+
+			_, err := DeleteMissing(context.Background(), cfg, &recordingSink{})
+			_, statErr := os.Stat(filepath.Join(dstDir, "precious.txt"))
+
+			if tt.expectDeleted {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if !os.IsNotExist(statErr) {
+					t.Error("Expected the target file to be deleted")
+				}
+				return
+			}
+
+			if err == nil {
+				t.Error("Expected the cleanup to be refused")
+			}
+			if statErr != nil {
+				t.Errorf("Target file must not be deleted: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestDeleteMissingIndexed(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "top.txt"), "top")
+	createTestFile(t, filepath.Join(srcDir, "sub", "kept.txt"), "kept")
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "stale.txt"), "stale")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", DeleteMissing: true}
+	found := NewSourceIndex()
+	if _, err := SyncIndexed(context.Background(), cfg, found, &recordingSink{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if found.Len() != 2 || !found.has("top.txt") || !found.has(filepath.Join("sub", "kept.txt")) {
+		t.Fatalf("Expected both source files in the index, got %d", found.Len())
+	}
+
+	// Indexed files are kept without looking at the source, the others
+	// are still checked there
+	os.Remove(filepath.Join(srcDir, "top.txt"))
+	rec := &recordingSink{}
+	stats, err := DeleteMissingIndexed(context.Background(), cfg, found, rec)
+	if err != nil {
+		t.Fatalf("DeleteMissingIndexed failed: %v", err)
+	}
+	if stats.Checked != 3 || stats.Deleted != 1 || len(rec.deleted) != 1 || rec.deleted[0].Path != "stale.txt" {
+		t.Errorf("Expected only stale.txt deleted, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "top.txt")); err != nil {
+		t.Errorf("Indexed file should be kept: %v", err)
+	}
+}
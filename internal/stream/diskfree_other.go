@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package stream
+
+// freeSpace is not implemented on this platform
+func freeSpace(path string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,411 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"time"
+)
+
+// DoctorStatus is the outcome of a single check run by Doctor
+type DoctorStatus string
+
+const (
+	DoctorOK      DoctorStatus = "ok"
+	DoctorWarning DoctorStatus = "warning"
+	// DoctorSkipped marks checks the platform offers no means for
+	DoctorSkipped DoctorStatus = "skipped"
+)
+
+// Finding is the result of a single check run by Doctor. The message of a
+// warning says what to do about it.
+type Finding struct {
+	Check   string       `json:"check"`
+	Status  DoctorStatus `json:"status"`
+	Message string       `json:"message"`
+}
+
+// clockSkewTolerance is how far the clock of the target may be off from
+// the local one before Doctor warns about it
+const clockSkewTolerance = 2 * time.Second
+
+// mtimeResolutions are the modification time resolutions Doctor tells
+// apart, finest first: NTFS stores 100ns, some network filesystems
+// microseconds or milliseconds, HFS+ seconds and FAT two seconds
+var mtimeResolutions = []time.Duration{time.Nanosecond, 100 * time.Nanosecond, time.Microsecond, time.Millisecond, time.Second, 2 * time.Second}
+
+// Doctor checks whether the target of cfg can faithfully mirror its source
+// before a sync: clock skew, filesystem type, modification time
+// resolution, case sensitivity, symlink and extended attribute support,
+// path length and free space. It writes probe files to the target, or to its nearest existing
+// parent if the target does not exist yet, and never to the source.
+func Doctor(ctx context.Context, cfg *config.Config, sink events.EventSink) ([]Finding, error) {
+	dir := existingParent(cfg.Target)
+	if dir != cfg.Target {
+		events.Infof(sink, "DOCTOR", "Target %s does not exist yet, probing %s", cfg.Target, dir)
+	}
+	probe, err := os.MkdirTemp(dir, ".snc-probe-*")
+	if err != nil {
+		return nil, fmt.Errorf("cannot write to target: %w", err)
+	}
+	defer os.RemoveAll(probe)
+
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	resolution, resolutionErr := probeMtimeResolution(probe)
+	survey, err := surveySource(ctx, cfg, filter, codec, probe, resolution, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	nfs := newNFSTarget(dir)
+	findings := []Finding{
+		checkClock(probe, cfg),
+		checkFilesystem(dir),
+		checkMtimeResolution(resolution, resolutionErr, nfs.mtimeTolerance(), survey, cfg),
+		checkCase(probe, survey),
+		checkSymlinks(probe, cfg),
+		checkXattrs(probe, survey),
+		checkPathLength(probe, cfg.Target, survey),
+	}
+
+	index, err := Scan(ctx, cfg, sink)
+	if err != nil {
+		return findings, err
+	}
+	findings = append(findings, checkDoctorFreeSpace(dir, index))
+	return findings, nil
+}
+
+// existingParent returns path, or its nearest parent that exists if it
+// does not
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// sourceSurvey holds what Doctor learned about the source tree, with
+// paths as they are named in the target
+type sourceSurvey struct {
+	files int
+	// coarse counts the files whose modification time is finer than the
+	// target resolution
+	coarse int
+	// caseClash holds a pair of paths that only differ in case
+	caseClash   [2]string
+	caseClashes int
+	xattrs      int
+	longestName string
+	longestPath string
+}
+
+// surveySource walks the source like Scan and collects the names, times
+// and attributes the checks compare with the target
+func surveySource(ctx context.Context, cfg *config.Config, filter *Filter, codec *targetCodec, probe string, resolution time.Duration, sink events.EventSink) (*sourceSurvey, error) {
+	survey := &sourceSurvey{}
+	folded := make(map[string]string)
+
+	err := walkRoots(cfg.Source, cfg.Subpaths, cfg.WalkWorkers, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			sink.Error(events.ErrorEvent{Component: "DOCTOR", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
+			return nil
+		}
+		if path == probe {
+			// The target is inside the source
+			return filepath.SkipDir
+		}
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil || rel == "." {
+			return nil
+		}
+		if filter.Excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		stored := codec.encodePath(rel)
+		if !d.IsDir() {
+			stored = codec.encodeFile(rel)
+		}
+		if len(filepath.Base(stored)) > len(filepath.Base(survey.longestName)) {
+			survey.longestName = stored
+		}
+		if len(stored) > len(survey.longestPath) {
+			survey.longestPath = stored
+		}
+		key := strings.ToLower(stored)
+		if other, ok := folded[key]; ok {
+			if survey.caseClashes == 0 {
+				survey.caseClash = [2]string{other, stored}
+			}
+			survey.caseClashes++
+		} else {
+			folded[key] = stored
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		survey.files++
+		if info, err := d.Info(); err == nil && info.ModTime().UnixNano()%int64(resolution) != 0 {
+			survey.coarse++
+		}
+		if hasXattrs(path) {
+			survey.xattrs++
+		}
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return survey, nil
+}
+
+// checkClock compares the modification time the target gives a new file
+// with the local clock, and the time it stores with the time set
+func checkClock(probe string, cfg *config.Config) Finding {
+	finding := Finding{Check: "clock"}
+
+	before := time.Now()
+	path := filepath.Join(probe, "clock")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return doctorWarning(finding, "Cannot create a probe file: %v", err)
+	}
+	after := time.Now()
+	info, err := os.Stat(path)
+	if err != nil {
+		return doctorWarning(finding, "Cannot stat a probe file: %v", err)
+	}
+	var skew time.Duration
+	switch mtime := info.ModTime(); {
+	case mtime.Before(before):
+		skew = mtime.Sub(before)
+	case mtime.After(after):
+		skew = mtime.Sub(after)
+	}
+	if skew > clockSkewTolerance || skew < -clockSkewTolerance {
+		return doctorWarning(finding, "The target clock is %s off from the local one; synchronize both clocks, e.g. with NTP, as files changed during a sync may be missed by the next one", skew.Round(time.Second))
+	}
+
+	offset, err := detectTimeOffset(probe)
+	switch {
+	case err != nil:
+		return doctorWarning(finding, "Cannot set modification times in the target: %v", err)
+	case offset != 0 && !cfg.DetectTimeOffset && offset != cfg.TimeOffset:
+		return doctorWarning(finding, "The target stores modification times off by %s; pass --detect-time-offset or --time-offset %s, or every file is copied on each sync", offset, offset)
+	case offset != 0:
+		return doctorOK(finding, "The target stores modification times off by %s, which is compensated for", offset)
+	}
+	return doctorOK(finding, "The target clock agrees with the local one")
+}
+
+// probeMtimeResolution sets a modification time with nanoseconds on a probe
+// file and returns the resolution the time read back suggests, apart from
+// any time offset
+func probeMtimeResolution(probe string) (time.Duration, error) {
+	offset, err := detectTimeOffset(probe)
+	if err != nil {
+		return time.Nanosecond, err
+	}
+	path := filepath.Join(probe, "mtime")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return time.Nanosecond, err
+	}
+	set := probeTime.Add(123456789 * time.Nanosecond)
+	if err := os.Chtimes(path, set, set); err != nil {
+		return time.Nanosecond, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Nanosecond, err
+	}
+
+	diff := set.Add(offset).Sub(info.ModTime())
+	if diff < 0 {
+		diff = -diff
+	}
+	for _, r := range mtimeResolutions {
+		if diff < r {
+			return r, nil
+		}
+	}
+	return mtimeResolutions[len(mtimeResolutions)-1], nil
+}
+
+// checkMtimeResolution warns if source files have modification times the
+// target cannot store, which the modtime method then always finds changed
+// unless they are within tolerance
+func checkMtimeResolution(resolution time.Duration, err error, tolerance time.Duration, survey *sourceSurvey, cfg *config.Config) Finding {
+	finding := Finding{Check: "mtime resolution"}
+	switch {
+	case err != nil:
+		return doctorWarning(finding, "Cannot set modification times in the target: %v", err)
+	case survey.coarse > 0 && resolution > tolerance && cfg.UpdateMethod == "modtime":
+		return doctorWarning(finding, "The target stores modification times to %s, more coarsely than %d of %d source files have them; they are copied again on each sync unless you pass --update-method size or sha256", resolution, survey.coarse, survey.files)
+	}
+	return doctorOK(finding, "The target stores modification times to %s", resolution)
+}
+
+// checkFilesystem reports whether the target is on NFS, for which snc
+// tolerates coarser modification times and retries stale file handles
+func checkFilesystem(dir string) Finding {
+	finding := Finding{Check: "filesystem"}
+	nfs, known := isNFS(dir)
+	switch {
+	case !known:
+		finding.Status, finding.Message = DoctorSkipped, "The filesystem type cannot be checked on this platform"
+		return finding
+	case nfs:
+		return doctorOK(finding, "The target is on NFS; modification times within %s are taken as equal, stale file handles are retried and failures to set modification times are warned about once", nfsMtimeTolerance)
+	}
+	return doctorOK(finding, "The target is not on NFS")
+}
+
+// checkCase warns if the target ignores the case of names and the source
+// has names that only differ in case, which would overwrite each other
+func checkCase(probe string, survey *sourceSurvey) Finding {
+	finding := Finding{Check: "case sensitivity"}
+
+	path := filepath.Join(probe, "case")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return doctorWarning(finding, "Cannot create a probe file: %v", err)
+	}
+	lower, err := os.Stat(path)
+	if err != nil {
+		return doctorWarning(finding, "Cannot stat a probe file: %v", err)
+	}
+	upper, err := os.Stat(filepath.Join(probe, "CASE"))
+	if err != nil || !os.SameFile(lower, upper) {
+		return doctorOK(finding, "The target is case-sensitive")
+	}
+
+	if survey.caseClashes > 0 {
+		return doctorWarning(finding, "The target is case-insensitive, but %d source paths only differ in case from another, e.g. %s and %s; rename them or they overwrite each other", survey.caseClashes, survey.caseClash[0], survey.caseClash[1])
+	}
+	return doctorOK(finding, "The target is case-insensitive, no source paths only differ in case")
+}
+
+// checkSymlinks reports whether the target can hold the symbolic links
+// --dangling-symlinks copy creates
+func checkSymlinks(probe string, cfg *config.Config) Finding {
+	finding := Finding{Check: "symlinks"}
+	err := os.Symlink("missing", filepath.Join(probe, "symlink"))
+	switch {
+	case err == nil:
+		return doctorOK(finding, "The target supports symbolic links")
+	case cfg.DanglingSymlinks == config.DanglingSymlinksCopy:
+		return doctorWarning(finding, "The target does not support symbolic links, so dangling ones cannot be copied; pass --dangling-symlinks skip: %v", err)
+	}
+	return doctorOK(finding, "The target does not support symbolic links, which is fine unless you pass --dangling-symlinks copy: %v", err)
+}
+
+// checkXattrs reports whether the target can hold extended attributes and
+// how many source files have some, since snc does not copy them
+func checkXattrs(probe string, survey *sourceSurvey) Finding {
+	finding := Finding{Check: "xattrs"}
+
+	path := filepath.Join(probe, "xattr")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		return doctorWarning(finding, "Cannot create a probe file: %v", err)
+	}
+	supported, known := xattrSupported(path)
+	switch {
+	case !known:
+		finding.Status, finding.Message = DoctorSkipped, "Extended attributes cannot be checked on this platform"
+		return finding
+	case survey.xattrs > 0 && !supported:
+		return doctorWarning(finding, "%d source files have extended attributes, which snc does not copy and the target does not support; use another tool if you need them", survey.xattrs)
+	case survey.xattrs > 0:
+		return doctorWarning(finding, "%d source files have extended attributes, which snc does not copy; use another tool if you need them", survey.xattrs)
+	case !supported:
+		return doctorOK(finding, "The target does not support extended attributes, no source files have any")
+	}
+	return doctorOK(finding, "The target supports extended attributes, no source files have any")
+}
+
+// checkPathLength creates the longest file name and a path as long as the
+// longest one of the source would be in target, below the probe directory
+func checkPathLength(probe, target string, survey *sourceSurvey) Finding {
+	finding := Finding{Check: "path length"}
+	if survey.longestPath == "" {
+		return doctorOK(finding, "The source is empty")
+	}
+
+	name := filepath.Base(survey.longestName)
+	if err := os.WriteFile(filepath.Join(probe, name), nil, 0644); err != nil {
+		return doctorWarning(finding, "The target cannot hold names of %d bytes like %s; shorten them or they fail to sync: %v", len(name), survey.longestName, err)
+	}
+
+	// Nest directories of at most the longest name length until the path
+	// is as long as the longest one would be in the target
+	want := len(filepath.Join(target, survey.longestPath))
+	segment := max(len(name), 16)
+	path := probe
+	for len(path)+1+segment < want {
+		path = filepath.Join(path, strings.Repeat("d", segment))
+	}
+	err := os.MkdirAll(path, 0755)
+	if rest := want - len(path) - 1; err == nil && rest > 0 {
+		err = os.WriteFile(filepath.Join(path, strings.Repeat("f", rest)), nil, 0644)
+	}
+	if err != nil {
+		return doctorWarning(finding, "The target cannot hold paths of %d bytes like %s; shorten them or they fail to sync: %v", want, survey.longestPath, err)
+	}
+	return doctorOK(finding, "The target holds the longest source name (%d bytes) and path (%d bytes with the target)", len(name), want)
+}
+
+// checkDoctorFreeSpace compares the free space of the target with what
+// the scanned source needs
+func checkDoctorFreeSpace(dir string, index *ScanIndex) Finding {
+	finding := Finding{Check: "free space"}
+	free, known := freeSpace(dir)
+	switch {
+	case !known:
+		finding.Status, finding.Message = DoctorSkipped, "Free space cannot be checked on this platform"
+		return finding
+	case index.NeededBytes > int64(free):
+		return doctorWarning(finding, "The target has %s free, but up to %s are needed; free up space or exclude large files", formatBytes(int64(free)), formatBytes(index.NeededBytes))
+	}
+	return doctorOK(finding, "The target has %s free, up to %s are needed", formatBytes(int64(free)), formatBytes(index.NeededBytes))
+}
+
+// doctorOK returns f as passed with the formatted message
+func doctorOK(f Finding, format string, args ...any) Finding {
+	f.Status = DoctorOK
+	f.Message = fmt.Sprintf(format, args...)
+	return f
+}
+
+// doctorWarning returns f as a warning with the formatted message
+func doctorWarning(f Finding, format string, args ...any) Finding {
+	f.Status = DoctorWarning
+	f.Message = fmt.Sprintf(format, args...)
+	return f
+}
@@ -0,0 +1,84 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoctor(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "missing", "target")
+	os.MkdirAll(filepath.Join(srcDir, "dir"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "dir", "file.txt"), "contents")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime"}
+	findings, err := Doctor(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Doctor failed: %v", err)
+	}
+
+	checks := []string{"clock", "filesystem", "mtime resolution", "case sensitivity", "symlinks", "xattrs", "path length", "free space"}
+	if len(findings) != len(checks) {
+		t.Fatalf("Expected %d findings, got %v", len(checks), findings)
+	}
+	for i, f := range findings {
+		if f.Check != checks[i] {
+			t.Errorf("Finding %d: expected check %q, got %q", i, checks[i], f.Check)
+		}
+		if f.Status == DoctorWarning && f.Check != "mtime resolution" && f.Check != "xattrs" {
+			t.Errorf("Unexpected warning: %s: %s", f.Check, f.Message)
+		}
+	}
+
+	// Probes are removed and the missing target is not created
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the source left, got %v", entries)
+	}
+}
+
+func TestDoctorMtimeResolution(t *testing.T) {
+	cfg := &config.Config{UpdateMethod: "modtime"}
+	survey := &sourceSurvey{files: 10, coarse: 3}
+
+	f := checkMtimeResolution(time.Second, nil, 0, survey, cfg)
+	if f.Status != DoctorWarning || !strings.Contains(f.Message, "--update-method") {
+		t.Errorf("Expected a warning suggesting another update method, got %+v", f)
+	}
+
+	// Modification times within the NFS tolerance are taken as equal
+	if f := checkMtimeResolution(time.Second, nil, nfsMtimeTolerance, survey, cfg); f.Status != DoctorOK {
+		t.Errorf("Expected no warning within the tolerance, got %+v", f)
+	}
+
+	cfg.UpdateMethod = "sha256"
+	if f := checkMtimeResolution(time.Second, nil, 0, survey, cfg); f.Status != DoctorOK {
+		t.Errorf("Expected no warning with sha256, got %+v", f)
+	}
+}
+
+func TestDoctorPathLength(t *testing.T) {
+	probe := t.TempDir()
+
+	long := strings.Repeat("n", 300)
+	f := checkPathLength(probe, probe, &sourceSurvey{longestName: long, longestPath: long})
+	if f.Status != DoctorWarning {
+		t.Errorf("Expected a warning for a %d byte name, got %+v", len(long), f)
+	}
+
+	path := filepath.Join(strings.Repeat("d", 100), strings.Repeat("d", 100), "file.txt")
+	f = checkPathLength(probe, probe, &sourceSurvey{longestName: path, longestPath: path})
+	if f.Status != DoctorOK {
+		t.Errorf("Expected a nested path to fit, got %+v", f)
+	}
+}
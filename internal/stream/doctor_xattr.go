@@ -0,0 +1,21 @@
+//go:build linux || darwin
+
+package stream
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrSupported reports whether an extended attribute can be set on path
+func xattrSupported(path string) (supported, known bool) {
+	err := unix.Setxattr(path, "user.snc-probe", []byte("1"), 0)
+	return err == nil, err == nil || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM)
+}
+
+// hasXattrs reports whether the file at path has extended attributes
+func hasXattrs(path string) bool {
+	n, err := unix.Listxattr(path, nil)
+	return err == nil && n > 0
+}
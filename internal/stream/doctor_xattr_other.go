@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package stream
+
+// xattrSupported is not known without an extended attribute API
+func xattrSupported(path string) (supported, known bool) {
+	return false, false
+}
+
+// hasXattrs is always false without an extended attribute API
+func hasXattrs(path string) bool {
+	return false
+}
@@ -0,0 +1,267 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"sync"
+	"time"
+)
+
+// TargetResult is the outcome of syncing one of several targets
+type TargetResult struct {
+	Target string
+	// Stats are never nil
+	Stats *Stats
+	// Err is set if the target could not be synced completely
+	Err error
+}
+
+// SyncTargets mirrors cfg.Source to every target in cfg.Targets. The source
+// is walked once and every file found is handed to all targets, which copy
+// in parallel, one goroutine per target. A target that fails to set up or
+// runs out of space does not affect the others. Files are compared and
+// copied like in Sync; missing files are not deleted, see DeleteMissing.
+//
+// Events of all targets are passed to sink one at a time. The results are
+// in the order of cfg.Targets; the returned error is only set if the source
+// could not be walked or ctx was cancelled. The source files found are
+// recorded in found, if set, for DeleteMissingIndexed.
+func SyncTargets(ctx context.Context, cfg *config.Config, found *SourceIndex, sink events.EventSink) ([]TargetResult, error) {
+	sink = &serialSink{sink: sink}
+	events.Infof(sink, "STREAM", "Starting file synchronization from %s to %d targets", cfg.Source, len(cfg.Targets))
+
+	results := make([]TargetResult, len(cfg.Targets))
+	for i, target := range cfg.Targets {
+		results[i] = TargetResult{Target: target, Stats: &Stats{}}
+	}
+
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return results, errors.NewSyncError(errors.ErrSyncFailed, "exclude filter", err)
+	}
+	if err := checkSubpaths(cfg.Source, cfg.Subpaths); err != nil {
+		return results, errors.NewSyncError(errors.ErrSyncFailed, "subpath", err)
+	}
+	if cfg.FilesFrom != "" {
+		if cfg, err = withFilesFrom(cfg); err != nil {
+			return results, errors.NewSyncError(errors.ErrSyncFailed, "file list", err)
+		}
+		events.Infof(sink, "STREAM", "Syncing %d listed files instead of walking the source", len(cfg.Subpaths))
+		if len(cfg.Subpaths) == 0 {
+			return results, nil
+		}
+	}
+
+	var index *ScanIndex
+	if cfg.Prescan {
+		scanStart := time.Now()
+		if index, err = Scan(ctx, cfg, sink); err != nil {
+			return results, errors.NewSyncError(errors.ErrSyncFailed, "pre-scan", err)
+		}
+		// The scan is shared, its duration is counted once
+		if len(results) > 0 {
+			results[0].Stats.ScanDuration = time.Since(scanStart)
+		}
+	}
+
+	// Every target gets its own copier and a queue of the files found; the
+	// bandwidth limit is shared by all of them
+	limit := newThrottle(cfg, sink)
+	var wg sync.WaitGroup
+	queues := make([]chan pendingFile, len(results))
+	for i := range results {
+		copier, err := newTargetCopier(cfg, results[i], index, limit, sink)
+		if err != nil {
+			results[i].Err = err
+			events.Warnf(sink, "STREAM", "Not syncing to %s: %v", results[i].Target, err)
+			continue
+		}
+
+		queues[i] = make(chan pendingFile, 64)
+		wg.Add(1)
+		go func(r *TargetResult, queue <-chan pendingFile) {
+			defer wg.Done()
+			copyStart := time.Now()
+			for f := range queue {
+				// Drain the queue, the walk stops soon
+				if ctx.Err() != nil {
+					continue
+				}
+				r.Stats.Files++
+				events.Debugf(sink, "STREAM", "Processing file: %s -> %s", f.path, r.Target)
+				copier.process(f)
+			}
+			if err := copier.finishLocked(ctx); err != nil {
+				r.Err = err
+			}
+			if err := copier.opts.codec.flush(); err != nil {
+				events.Warnf(sink, "STREAM", "%v", err)
+			}
+			pruneObjects(copier.opts.pool, sink)
+			r.Stats.CopyDuration = time.Since(copyStart)
+		}(&results[i], queues[i])
+	}
+
+	send := func(f pendingFile) {
+		for _, queue := range queues {
+			if queue != nil {
+				queue <- f
+			}
+		}
+	}
+
+	// Files are handed out as the walk finds them, in lexical order,
+	// unless another order was requested
+	deferred := cfg.Order != "" && cfg.Order != config.OrderAlpha
+	var pending []pendingFile
+	var walkErrors int
+
+	err = walkRoots(cfg.Source, cfg.Subpaths, cfg.WalkWorkers, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if err != nil {
+			walkErrors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
+			return nil // continue walking
+		}
+
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil {
+			walkErrors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Cannot compute relative path for", Op: events.OpName, Path: path, Err: relErr})
+			return nil
+		}
+
+		if filter.Excluded(rel, d.IsDir()) {
+			events.Debugf(sink, "STREAM", "Excluding: %s", path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			events.Debugf(sink, "STREAM", "Skipping directory: %s", path)
+			return nil
+		}
+
+		found.add(rel)
+		f := pendingFile{path: path, rel: rel, entry: d}
+		if deferred {
+			pending = append(pending, f)
+			return nil
+		}
+		send(f)
+		return nil
+	})
+
+	if err == nil && deferred {
+		events.Infof(sink, "STREAM", "Processing %d files in %s order", len(pending), cfg.Order)
+		orderFiles(pending, cfg.Order)
+		for _, f := range pending {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			send(f)
+		}
+	}
+
+	for _, queue := range queues {
+		if queue != nil {
+			close(queue)
+		}
+	}
+	wg.Wait()
+
+	// Source errors affect every target
+	for _, r := range results {
+		r.Stats.Errors += walkErrors
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		events.Warnf(sink, "STREAM", "Synchronization interrupted: %v", ctxErr)
+		return results, ctxErr
+	}
+	if err != nil {
+		return results, errors.NewSyncError(errors.ErrSyncFailed, "sync operation", err)
+	}
+
+	for _, r := range results {
+		if r.Err == nil {
+			events.Infof(sink, "STREAM", "Synchronization to %s completed: %d files processed, %d copied, %d updated, %d unchanged, %d locked, %d errors",
+				r.Target, r.Stats.Files, r.Stats.Copied, r.Stats.Updated, r.Stats.Skipped, r.Stats.Locked, r.Stats.Errors)
+			reportDeferred(r.Target, cfg.MaxTransfer, r.Stats, sink)
+		}
+	}
+	return results, nil
+}
+
+// newTargetCopier sets up copying to the target of r. With a pre-scan
+// index, the target must have room for the source.
+func newTargetCopier(cfg *config.Config, r TargetResult, index *ScanIndex, limit *throttle, sink events.EventSink) (*fileCopier, error) {
+	targetCfg := *cfg
+	targetCfg.Target = r.Target
+	targetCfg.Targets = []string{r.Target}
+
+	selector, err := NewStrategySelector(cfg.StrategyMap, cfg.UpdateMethod)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "update strategy creation", err)
+	}
+	opts, err := newCopyOptions(&targetCfg)
+	if err != nil {
+		return nil, err
+	}
+	opts.throttle = limit
+	if index != nil {
+		if err := checkFreeSpace(r.Target, index); err != nil {
+			return nil, errors.NewSyncError(errors.ErrInsufficientSpace, "pre-scan", err)
+		}
+	}
+	selector.SetTimeOffset(targetTimeOffset(&targetCfg, sink))
+	selector.SetTimeTolerance(opts.nfs.mtimeTolerance())
+	selector.trustSidecars(cfg.SourceChecksums, cfg.Source)
+	return &fileCopier{cfg: &targetCfg, selector: selector, opts: opts, sink: sink, stats: r.Stats}, nil
+}
+
+// serialSink passes events to sink one at a time, for sinks shared by
+// several goroutines
+type serialSink struct {
+	mu   sync.Mutex
+	sink events.EventSink
+}
+
+func (s *serialSink) FileCopied(ev events.FileEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sink.FileCopied(ev)
+}
+
+func (s *serialSink) FileSkipped(ev events.FileEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sink.FileSkipped(ev)
+}
+
+func (s *serialSink) FileDeleted(ev events.FileEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sink.FileDeleted(ev)
+}
+
+func (s *serialSink) Error(ev events.ErrorEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sink.Error(ev)
+}
+
+func (s *serialSink) Progress(ev events.ProgressEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sink.Progress(ev)
+}
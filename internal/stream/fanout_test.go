@@ -0,0 +1,82 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+// countingSink counts file events; it is not safe for concurrent use
+type countingSink struct {
+	events.Nop
+	copied, errors int
+}
+
+func (c *countingSink) FileCopied(events.FileEvent) { c.copied++ }
+func (c *countingSink) Error(events.ErrorEvent)     { c.errors++ }
+
+func TestSyncTargets(t *testing.T) {
+	srcDir := t.TempDir()
+	files := []string{"a.txt", "b.txt", "sub/c.txt"}
+	for _, file := range files {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content of "+file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	good, broken := t.TempDir(), t.TempDir()
+	// A directory in place of a file makes copying it to broken fail
+	if err := os.MkdirAll(filepath.Join(broken, "b.txt", "blocker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []string{config.OrderAlpha, config.OrderLargestFirst} {
+		t.Run(order, func(t *testing.T) {
+			for _, dir := range []string{good, broken} {
+				os.Remove(filepath.Join(dir, "a.txt"))
+			}
+
+			cfg := &config.Config{
+				Source:       srcDir,
+				Target:       good,
+				Targets:      []string{good, broken},
+				UpdateMethod: "modtime",
+				Order:        order,
+			}
+			sink := &countingSink{}
+			results, err := SyncTargets(context.Background(), cfg, nil, sink)
+			if err != nil {
+				t.Fatalf("SyncTargets failed: %v", err)
+			}
+			if len(results) != 2 || results[0].Target != good || results[1].Target != broken {
+				t.Fatalf("Unexpected results: %+v", results)
+			}
+
+			for _, file := range files {
+				if _, err := os.Stat(filepath.Join(good, file)); err != nil {
+					t.Errorf("Expected %s in the first target: %v", file, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(broken, "a.txt")); err != nil {
+				t.Errorf("Expected other files to be copied to the broken target: %v", err)
+			}
+
+			if results[0].Stats.Files != 3 || results[0].Stats.Errors != 0 {
+				t.Errorf("Unexpected stats for the first target: %+v", results[0].Stats)
+			}
+			if results[1].Stats.Files != 3 || results[1].Stats.Errors != 1 {
+				t.Errorf("Expected one error for the broken target, got %+v", results[1].Stats)
+			}
+			if sink.errors != 1 {
+				t.Errorf("Expected 1 error event, got %d", sink.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"strings"
+)
+
+// withFilesFrom returns a copy of cfg that syncs only the files listed in
+// cfg.FilesFrom, as subpaths. Like with rsync's --files-from, listed
+// directories are not synced recursively; they are skipped along with
+// paths missing from the source, e.g. deleted files in the output of
+// git diff --name-only. The subpaths are empty if nothing is to be synced.
+func withFilesFrom(cfg *config.Config) (*config.Config, error) {
+	var data []byte
+	var err error
+	if cfg.FilesFrom == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(cfg.FilesFrom)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file list: %w", err)
+	}
+
+	list, err := parseFileList(data, cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	listed := *cfg
+	listed.Subpaths = []string{}
+	for _, rel := range list {
+		info, err := os.Lstat(filepath.Join(cfg.Source, rel))
+		if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+			continue
+		}
+		listed.Subpaths = append(listed.Subpaths, rel)
+	}
+	return &listed, nil
+}
+
+// parseFileList splits a file list into paths relative to source. Paths
+// are separated by NUL bytes if there are any, like the output of
+// find -print0, otherwise by newlines. Absolute paths must be inside
+// source; duplicates are dropped.
+func parseFileList(data []byte, source string) ([]string, error) {
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+
+	var list []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), sep) {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+
+		path := filepath.FromSlash(line)
+		if filepath.IsAbs(path) {
+			if !isBelow(source, path) {
+				return nil, fmt.Errorf("listed path %s is not inside %s", line, source)
+			}
+			path, _ = filepath.Rel(source, path)
+		}
+		path = filepath.Clean(path)
+		if path == "." {
+			// The source root, as listed by find
+			continue
+		}
+		if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("listed path %s is not inside %s", line, source)
+		}
+
+		if !seen[path] {
+			seen[path] = true
+			list = append(list, path)
+		}
+	}
+	return list, nil
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestParseFileList(t *testing.T) {
+	source := filepath.FromSlash("/data/source")
+	tests := []struct {
+		name        string
+		data        string
+		expected    []string
+		expectError bool
+	}{
+		{name: "lines", data: "a.txt\nsub/b.txt\n", expected: []string{"a.txt", filepath.FromSlash("sub/b.txt")}},
+		{name: "crlf and blank lines", data: "a.txt\r\n\r\nb.txt", expected: []string{"a.txt", "b.txt"}},
+		{name: "nul separated", data: "with\nnewline\x00b.txt\x00", expected: []string{"with\nnewline", "b.txt"}},
+		{name: "find output", data: ".\n./a.txt\n./sub/../b.txt\n", expected: []string{"a.txt", "b.txt"}},
+		{name: "duplicates", data: "a.txt\na.txt\n", expected: []string{"a.txt"}},
+		{name: "absolute inside source", data: filepath.Join(source, "a.txt") + "\n", expected: []string{"a.txt"}},
+		{name: "absolute outside source", data: filepath.FromSlash("/data/other/a.txt"), expectError: true},
+		{name: "escaping source", data: "../a.txt", expectError: true},
+		{name: "empty", data: "", expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := parseFileList([]byte(tt.data), source)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected an error, got %v", list)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(list, tt.expected) {
+				t.Errorf("Expected %q, got %q", tt.expected, list)
+			}
+		})
+	}
+}
+
+func TestSyncFilesFrom(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		tempDir := t.TempDir()
+		srcDir := filepath.Join(tempDir, "source")
+		dstDir := filepath.Join(tempDir, "target")
+		listFile := filepath.Join(tempDir, "list")
+
+		os.MkdirAll(filepath.Join(srcDir, "dir"), 0755)
+		createTestFile(t, filepath.Join(srcDir, "listed.txt"), "listed")
+		createTestFile(t, filepath.Join(srcDir, "unlisted.txt"), "unlisted")
+		createTestFile(t, filepath.Join(srcDir, "dir", "listed.txt"), "listed")
+		createTestFile(t, filepath.Join(srcDir, "dir", "unlisted.txt"), "unlisted")
+		createTestFile(t, listFile, "listed.txt\ndeleted.txt\ndir\ndir/listed.txt\n")
+
+		cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", FilesFrom: listFile, WalkWorkers: workers}
+		stats, err := Sync(context.Background(), cfg, events.Nop{})
+		if err != nil {
+			t.Fatalf("Sync with %d workers failed: %v", workers, err)
+		}
+		if stats.Files != 2 || stats.Copied != 2 || stats.Errors != 0 {
+			t.Errorf("Expected 2 files copied with %d workers, got %d files, %d copied, %d errors", workers, stats.Files, stats.Copied, stats.Errors)
+		}
+		for rel, want := range map[string]bool{"listed.txt": true, "dir/listed.txt": true, "unlisted.txt": false, "dir/unlisted.txt": false} {
+			_, err := os.Stat(filepath.Join(dstDir, rel))
+			if exists := err == nil; exists != want {
+				t.Errorf("%s: expected exists=%v, got %v", rel, want, exists)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"fmt"
+	"path/filepath"
+	"snc/internal/config"
+	"strings"
+)
+
+// Filter excludes files from the sync by glob pattern. Patterns follow the
+// rules of the strategy map: a pattern without a path separator matches any
+// single path component (so "cache" excludes every directory or file named
+// cache), a pattern containing '/' matches the path relative to the source
+// root. A matching directory excludes everything below it.
+//
+// Rules read from an rsync filter file are applied after the exclude
+// patterns, in rsync's manner: the first rule matching a path decides
+// whether it is included or excluded.
+type Filter struct {
+	excludes []string
+	rules    []filterRule
+	// hiddenIn is the source root if hidden files are excluded
+	hiddenIn string
+	// junk is set if junkPatterns are excluded
+	junk bool
+}
+
+// junkPatterns match files that operating systems and office suites leave
+// behind next to user documents: Finder metadata, AppleDouble files and
+// volume indexes on macOS, thumbnail caches, folder settings and the
+// recycle bin on Windows, and the lock files of open Office and
+// LibreOffice documents. They are matched against every path component,
+// ignoring case.
+var junkPatterns = []string{
+	".ds_store", "._*", ".spotlight-v100", ".trashes", ".fseventsd",
+	"thumbs.db", "ehthumbs.db", "desktop.ini", "$recycle.bin",
+	"~$*", ".~lock.*#",
+}
+
+// loadFilter returns the Filter of cfg's exclude patterns, filter file,
+// hidden files and junk settings
+func loadFilter(cfg *config.Config) (*Filter, error) {
+	f, err := NewFilter(cfg.Exclude)
+	if err != nil || (cfg.FilterFile == "" && !cfg.SkipHidden && cfg.IncludeJunk) {
+		return f, err
+	}
+	if f == nil {
+		f = &Filter{}
+	}
+	f.junk = !cfg.IncludeJunk
+
+	if cfg.FilterFile != "" {
+		if f.rules, err = readFilterFile(cfg.FilterFile, 0); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SkipHidden {
+		f.hiddenIn = cfg.Source
+	}
+	return f, nil
+}
+
+// NewFilter validates the exclude patterns and returns a Filter. A nil
+// Filter excludes nothing.
+func NewFilter(excludes []string) (*Filter, error) {
+	if len(excludes) == 0 {
+		return nil, nil
+	}
+
+	f := &Filter{}
+	for _, pattern := range excludes {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		f.excludes = append(f.excludes, filepath.FromSlash(strings.TrimSuffix(pattern, "/")))
+	}
+	return f, nil
+}
+
+// Excluded reports whether the file or directory at rel, relative to the
+// source root, or any of its parent directories is excluded. isDir tells
+// whether rel is a directory, for rules that only match directories.
+func (f *Filter) Excluded(rel string, isDir bool) bool {
+	if f == nil || rel == "." {
+		return false
+	}
+	if f.hiddenIn != "" && isHidden(f.hiddenIn, rel) {
+		return true
+	}
+	if f.Junk(rel) {
+		return true
+	}
+
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, pattern := range f.excludes {
+		if !strings.ContainsRune(pattern, filepath.Separator) {
+			for _, part := range parts {
+				if matched, _ := filepath.Match(pattern, part); matched {
+					return true
+				}
+			}
+			continue
+		}
+
+		for i := range parts {
+			prefix := filepath.Join(parts[:i+1]...)
+			if matched, _ := filepath.Match(pattern, prefix); matched {
+				return true
+			}
+		}
+	}
+
+	if len(f.rules) > 0 {
+		for i := range parts {
+			prefix := strings.Join(parts[:i+1], "/")
+			if f.excludedByRules(prefix, isDir || i < len(parts)-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Junk reports whether the file or directory at rel, or any of its parent
+// directories, is excluded as junk by the default excludes
+func (f *Filter) Junk(rel string) bool {
+	if f == nil || !f.junk || rel == "." {
+		return false
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		part = strings.ToLower(part)
+		for _, pattern := range junkPatterns {
+			if matched, _ := filepath.Match(pattern, part); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestFilterExcluded(t *testing.T) {
+	filter, err := NewFilter([]string{"*.tmp", "cache/", "build/out"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		rel      string
+		excluded bool
+	}{
+		{"file.txt", false},
+		{"file.tmp", true},
+		{"sub/file.tmp", true},
+		{"cache", true},
+		{"sub/cache/data.bin", true},
+		{"build/out", true},
+		{"build/out/app", true},
+		{"src/build/out/app", false},
+		{"build/other", false},
+		{".", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			if got := filter.Excluded(filepath.FromSlash(tt.rel), false); got != tt.excluded {
+				t.Errorf("Excluded(%q) = %v, expected %v", tt.rel, got, tt.excluded)
+			}
+		})
+	}
+
+	var none *Filter
+	if none.Excluded("file.tmp", false) {
+		t.Error("A nil filter must not exclude anything")
+	}
+
+	if _, err := NewFilter([]string{"[invalid"}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+}
+
+func TestDeleteMissingKeepsExcluded(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(filepath.Join(dstDir, "cache"), 0755)
+
+	createTestFile(t, filepath.Join(srcDir, "keep.txt"), "keep")
+	createTestFile(t, filepath.Join(dstDir, "keep.txt"), "keep")
+	createTestFile(t, filepath.Join(dstDir, "stale.txt"), "stale")
+	createTestFile(t, filepath.Join(dstDir, "cache", "local.bin"), "target-local")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		UpdateMethod:  "modtime",
+		Exclude:       []string{"cache"},
+	}
+
+	rec := &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 1 || rec.deleted[0].Path != "stale.txt" {
+		t.Errorf("Expected only stale.txt to be deleted, got %v", rec.deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "cache", "local.bin")); err != nil {
+		t.Errorf("Excluded file should be kept: %v", err)
+	}
+
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Excluded target files should not be reported, got %v", diffs)
+	}
+
+	cfg.DeleteExcluded = true
+	rec = &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 1 || rec.deleted[0].Path != filepath.Join("cache", "local.bin") {
+		t.Errorf("Expected the excluded file to be deleted, got %v", rec.deleted)
+	}
+}
+
+func TestSkipHidden(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(filepath.Join(srcDir, ".git"), 0755)
+	os.MkdirAll(filepath.Join(srcDir, "docs"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, ".cache"), 0755)
+
+	createTestFile(t, filepath.Join(srcDir, ".env"), "secret")
+	createTestFile(t, filepath.Join(srcDir, ".git", "config"), "config")
+	createTestFile(t, filepath.Join(srcDir, "docs", "report.txt"), "report")
+	createTestFile(t, filepath.Join(dstDir, ".cache", "thumbs.db"), "target-local")
+	createTestFile(t, filepath.Join(dstDir, "stale.txt"), "stale")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		UpdateMethod:  "modtime",
+		SkipHidden:    true,
+	}
+
+	rec := &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(rec.copied) != 1 || rec.copied[0].Path != filepath.Join("docs", "report.txt") {
+		t.Errorf("Expected only docs/report.txt to be copied, got %v", rec.copied)
+	}
+
+	rec = &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 1 || rec.deleted[0].Path != "stale.txt" {
+		t.Errorf("Expected only stale.txt to be deleted, got %v", rec.deleted)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ".cache", "thumbs.db")); err != nil {
+		t.Errorf("Hidden target file should be kept: %v", err)
+	}
+
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Hidden files should not be reported, got %v", diffs)
+	}
+}
+
+func TestDefaultExcludes(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(filepath.Join(srcDir, "photos"), 0755)
+	os.MkdirAll(dstDir, 0755)
+
+	createTestFile(t, filepath.Join(srcDir, "report.docx"), "report")
+	createTestFile(t, filepath.Join(srcDir, "~$report.docx"), "owner file")
+	createTestFile(t, filepath.Join(srcDir, ".DS_Store"), "finder")
+	createTestFile(t, filepath.Join(srcDir, "photos", "Thumbs.db"), "thumbnails")
+	createTestFile(t, filepath.Join(dstDir, "Desktop.ini"), "folder settings")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		UpdateMethod:  "modtime",
+	}
+
+	rec := &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(rec.copied) != 1 || rec.copied[0].Path != "report.docx" {
+		t.Errorf("Expected only report.docx to be copied, got %v", rec.copied)
+	}
+
+	rec = &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 0 {
+		t.Errorf("Junk in the target should be kept, got %v", rec.deleted)
+	}
+
+	cfg.DeleteJunk = true
+	diffs, err := Check(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(diffs) != 1 || diffs[0].Path != "Desktop.ini" {
+		t.Errorf("Expected junk to delete to be reported, got %v", diffs)
+	}
+	rec = &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 1 || rec.deleted[0].Path != "Desktop.ini" {
+		t.Errorf("Expected Desktop.ini to be deleted, got %v", rec.deleted)
+	}
+
+	cfg.DeleteJunk = false
+	cfg.IncludeJunk = true
+	rec = &recordingSink{}
+	if _, err := Sync(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if len(rec.copied) != 3 {
+		t.Errorf("Expected the junk files to be copied with IncludeJunk, got %v", rec.copied)
+	}
+}
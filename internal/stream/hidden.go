@@ -0,0 +1,30 @@
+package stream
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// isHidden reports whether rel, relative to root, or one of its parent
+// directories is hidden: named with a leading dot, or on Windows marked
+// with the hidden attribute
+func isHidden(root, rel string) bool {
+	if rel == "." {
+		return false
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts {
+		if strings.HasPrefix(part, ".") && part != ".." {
+			return true
+		}
+	}
+	if !hiddenAttrSupported {
+		return false
+	}
+	for i := range parts {
+		if hiddenAttr(filepath.Join(root, filepath.Join(parts[:i+1]...))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package stream
+
+// hiddenAttrSupported is false where files are only hidden by their name
+const hiddenAttrSupported = false
+
+// hiddenAttr is always false without a hidden attribute
+func hiddenAttr(path string) bool {
+	return false
+}
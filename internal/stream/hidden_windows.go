@@ -0,0 +1,16 @@
+package stream
+
+import "syscall"
+
+// hiddenAttrSupported tells isHidden to check the hidden attribute
+const hiddenAttrSupported = true
+
+// hiddenAttr reports whether the file at path has the hidden attribute
+func hiddenAttr(path string) bool {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	return err == nil && attrs&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+}
@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"os"
+)
+
+// Itemized change codes follow rsync's --itemize-changes layout (YXcstpoguax):
+//
+//	Y  update type: '>' file transferred, 'c' created without a transfer,
+//	   '.' attributes updated only, '*' message (e.g. deleting)
+//	X  file type: 'f' regular file, 'L' symbolic link, 'D' device, FIFO or
+//	   socket
+//	c  checksum differs        s  size differs
+//	t  modtime differs         p  permissions differ
+//
+// The remaining attribute columns (owner, group, ACL, xattr) are not
+// tracked and are always '.'. A new file is shown as all '+'.
+const (
+	itemizeNewFile       = ">f+++++++++"
+	itemizeNewSpecial    = "cD+++++++++"
+	itemizeSpecialUpdate = "cD........."
+	itemizeNewSymlink    = "cL+++++++++"
+	itemizeSymlinkUpdate = "cL........."
+	itemizeDeleting      = "*deleting  "
+)
+
+// itemizeUpdate builds the change code for an existing file that is
+// being overwritten, describing why it was considered out of date
+func itemizeUpdate(srcInfo, dstInfo os.FileInfo, checksumDiffers bool) string {
+	code := []byte(">f.........")
+
+	if checksumDiffers {
+		code[2] = 'c'
+	}
+	if srcInfo.Size() != dstInfo.Size() {
+		code[3] = 's'
+	}
+	if !srcInfo.ModTime().Equal(dstInfo.ModTime()) {
+		code[4] = 't'
+	}
+	if srcInfo.Mode().Perm() != dstInfo.Mode().Perm() {
+		code[5] = 'p'
+	}
+	return string(code)
+}
+
+// itemizeMetadata builds the change code for an existing file whose
+// metadata is updated without a transfer
+func itemizeMetadata(srcInfo, dstInfo os.FileInfo) string {
+	code := []byte(".f.........")
+	if srcInfo.Mode()&modeBits != dstInfo.Mode()&modeBits {
+		code[5] = 'p'
+	}
+	return string(code)
+}
+
+// comparesChecksum reports whether the strategy decides updates by file content
+func comparesChecksum(strategy UpdateStrategy) bool {
+	switch s := strategy.(type) {
+	case checksumStrategy, *SampleStrategy:
+		return true
+	case *encryptedStrategy:
+		// Samples are not taken from encrypted targets
+		_, ok := s.inner.(checksumStrategy)
+		return ok
+	case *compressedStrategy:
+		return comparesChecksum(s.inner)
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestItemizeUpdate(t *testing.T) {
+	// Create temporary test directory
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+	modTime := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name            string
+		dstContent      string
+		dstModTime      time.Time
+		checksumDiffers bool
+		expected        string
+	}{
+		{
+			name:       "size and time differ",
+			dstContent: "old",
+			dstModTime: modTime.Add(-time.Hour),
+			expected:   ">f.st......",
+		},
+		{
+			name:       "only time differs",
+			dstContent: "test content",
+			dstModTime: modTime.Add(-time.Hour),
+			expected:   ">f..t......",
+		},
+		{
+			name:            "only checksum differs",
+			dstContent:      "TEST CONTENT",
+			dstModTime:      modTime,
+			checksumDiffers: true,
+			expected:        ">fc........",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			createTestFile(t, srcFile, "test content")
+			createTestFile(t, dstFile, tt.dstContent)
+			os.Chtimes(srcFile, modTime, modTime)
+			os.Chtimes(dstFile, tt.dstModTime, tt.dstModTime)
+
+			srcInfo, _ := os.Stat(srcFile)
+			dstInfo, _ := os.Stat(dstFile)
+
+			code := itemizeUpdate(srcInfo, dstInfo, tt.checksumDiffers)
+			if code != tt.expected {
+				t.Errorf("Expected code '%s', got '%s'", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestComparesChecksum(t *testing.T) {
+	if comparesChecksum(&ModTimeStrategy{}) {
+		t.Error("Expected modtime strategy not to compare checksums")
+	}
+	if !comparesChecksum(&SHA256Strategy{}) {
+		t.Error("Expected sha256 strategy to compare checksums")
+	}
+	if !comparesChecksum(&compressedStrategy{inner: &SHA256Strategy{}}) || !comparesChecksum(&compressedStrategy{inner: &SampleStrategy{}}) {
+		t.Error("Expected checksum strategies to compare checksums on compressed targets")
+	}
+	if comparesChecksum(&encryptedStrategy{inner: &SampleStrategy{}}) {
+		t.Error("Expected sample strategy not to compare checksums on encrypted targets")
+	}
+}
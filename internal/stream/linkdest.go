@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"sync/atomic"
+)
+
+// linkDest hardlinks files missing from the target to their copies in a
+// previous snapshot of the same source if those are up to date, so
+// unchanged files take no space in a new snapshot
+type linkDest struct {
+	dir string
+	// disabled is set once linking failed, e.g. because the previous
+	// snapshot is on another filesystem
+	disabled atomic.Bool
+}
+
+// newLinkDest returns the linkDest for the previous snapshot dir of the
+// target at root. A relative dir is taken relative to root, like rsync's
+// --link-dest.
+func newLinkDest(root, dir string) *linkDest {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return &linkDest{dir: filepath.Clean(dir)}
+}
+
+// link hardlinks dstPath to the copy of rel in the previous snapshot if
+// strategy finds it up to date with srcPath. It returns false if the file
+// needs to be copied instead.
+func (l *linkDest) link(rel, srcPath, dstPath string, strategy UpdateStrategy, opts *copyOptions, sink events.EventSink) (bool, error) {
+	if l.disabled.Load() {
+		return false, nil
+	}
+
+	prevPath := filepath.Join(l.dir, opts.codec.encodeFile(rel))
+	if _, err := os.Stat(prevPath); err != nil {
+		return false, nil
+	}
+	needsUpdate, err := strategy.NeedsUpdate(srcPath, prevPath)
+	if err != nil {
+		events.Debugf(sink, "STREAM", "Cannot compare with previous snapshot, copying %s: %v", rel, err)
+		return false, nil
+	}
+	if needsUpdate {
+		return false, nil
+	}
+
+	if err := opts.attrs.mkdirAll(filepath.Dir(dstPath)); err != nil {
+		return false, errors.NewSyncError(errors.ErrCannotCreateParentDir, dstPath, err)
+	}
+	if err := os.Link(prevPath, dstPath); err != nil {
+		if l.disabled.CompareAndSwap(false, true) {
+			events.Warnf(sink, "STREAM", "Cannot link to previous snapshot %s, copying all files: %v", l.dir, err)
+		}
+		return false, nil
+	}
+	events.Debugf(sink, "STREAM", "Linked unchanged file: %s -> %s", dstPath, prevPath)
+	return true, nil
+}
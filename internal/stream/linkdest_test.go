@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestLinkDest(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	firstDir := filepath.Join(tempDir, "backups", "day1")
+	secondDir := filepath.Join(tempDir, "backups", "day2")
+
+	os.MkdirAll(filepath.Join(srcDir, "subdir"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "unchanged.txt"), "unchanged")
+	createTestFile(t, filepath.Join(srcDir, "subdir", "changed.txt"), "old")
+
+	if _, err := Sync(context.Background(), &config.Config{Source: srcDir, Target: firstDir, UpdateMethod: "modtime"}, events.Nop{}); err != nil {
+		t.Fatalf("First sync failed: %v", err)
+	}
+
+	createTestFile(t, filepath.Join(srcDir, "subdir", "changed.txt"), "new contents")
+	createTestFile(t, filepath.Join(srcDir, "added.txt"), "added")
+
+	cfg := &config.Config{Source: srcDir, Target: secondDir, UpdateMethod: "modtime", LinkDest: "../day1"}
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if stats.Linked != 1 || stats.Copied != 2 {
+		t.Errorf("Expected 1 linked and 2 copied files, got %d linked, %d copied", stats.Linked, stats.Copied)
+	}
+
+	sameFile := func(rel string) bool {
+		a, errA := os.Stat(filepath.Join(firstDir, rel))
+		b, errB := os.Stat(filepath.Join(secondDir, rel))
+		return errA == nil && errB == nil && os.SameFile(a, b)
+	}
+	if !sameFile("unchanged.txt") {
+		t.Error("Unchanged file should be linked to the previous snapshot")
+	}
+	if sameFile("subdir/changed.txt") {
+		t.Error("Changed file should be copied")
+	}
+	for rel, want := range map[string]string{"subdir/changed.txt": "new contents", "added.txt": "added"} {
+		if data, _ := os.ReadFile(filepath.Join(secondDir, rel)); string(data) != want {
+			t.Errorf("%s: expected %q, got %q", rel, want, data)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(firstDir, "subdir", "changed.txt")); string(data) != "old" {
+		t.Errorf("Previous snapshot was modified: %q", data)
+	}
+
+	// A missing previous snapshot copies everything
+	cfg.Target = filepath.Join(tempDir, "backups", "day3")
+	cfg.LinkDest = filepath.Join(tempDir, "missing")
+	if stats, err = Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync without previous snapshot failed: %v", err)
+	}
+	if stats.Linked != 0 || stats.Copied != 3 {
+		t.Errorf("Expected 3 copied files, got %d linked, %d copied", stats.Linked, stats.Copied)
+	}
+}
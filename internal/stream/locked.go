@@ -0,0 +1,58 @@
+package stream
+
+import (
+	stderrors "errors"
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// Windows error codes for files opened exclusively or with locked regions
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// lockedFileError reports a file that is held open or locked by another
+// process. Such files can usually be copied later, so they are handled
+// apart from other failures.
+type lockedFileError struct {
+	Path string
+	Err  error
+}
+
+func (e *lockedFileError) Error() string {
+	return fmt.Sprintf("%s is locked by another process: %v", e.Path, e.Err)
+}
+
+func (e *lockedFileError) Unwrap() error {
+	return e.Err
+}
+
+// isLockedError reports whether err was caused by a sharing violation or a
+// lock held by another process
+func isLockedError(err error) bool {
+	var locked *lockedFileError
+	if stderrors.As(err, &locked) {
+		return true
+	}
+
+	var errno syscall.Errno
+	if !stderrors.As(err, &errno) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errno == errorSharingViolation || errno == errorLockViolation
+	}
+	return errno == syscall.ETXTBSY || errno == syscall.EAGAIN
+}
+
+// lockedOr returns a lockedFileError for path if err is a locking error and
+// fallback otherwise, so that locked files can be told apart from other
+// failures
+func lockedOr(path string, err, fallback error) error {
+	if isLockedError(err) {
+		return &lockedFileError{Path: path, Err: err}
+	}
+	return fallback
+}
@@ -0,0 +1,59 @@
+package stream
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"runtime"
+	"snc/internal/errors"
+	"syscall"
+	"testing"
+)
+
+func TestIsLockedError(t *testing.T) {
+	lockErrno := syscall.ETXTBSY
+	if runtime.GOOS == "windows" {
+		lockErrno = errorSharingViolation
+	}
+	pathErr := &os.PathError{Op: "open", Path: "file.txt", Err: lockErrno}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"path error", pathErr, true},
+		{"wrapped path error", fmt.Errorf("cannot stat: %w", pathErr), true},
+		{"locked file error", &lockedFileError{Path: "file.txt", Err: stderrors.New("busy")}, true},
+		{"not found", &os.PathError{Op: "open", Path: "file.txt", Err: syscall.ENOENT}, false},
+		{"plain error", stderrors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLockedError(tt.err); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLockedOr(t *testing.T) {
+	lockErrno := syscall.ETXTBSY
+	if runtime.GOOS == "windows" {
+		lockErrno = errorSharingViolation
+	}
+
+	// The file error flattens its cause, so the lock must be detected first
+	lockErr := &os.PathError{Op: "open", Path: "file.txt", Err: lockErrno}
+	err := lockedOr("file.txt", lockErr, errors.NewFileError(errors.ErrCannotOpenFile, "file.txt", lockErr))
+	if !isLockedError(err) {
+		t.Errorf("Expected a locked file error, got %v", err)
+	}
+
+	otherErr := &os.PathError{Op: "open", Path: "file.txt", Err: syscall.EACCES}
+	fallback := errors.NewFileError(errors.ErrCannotOpenFile, "file.txt", otherErr)
+	if err := lockedOr("file.txt", otherErr, fallback); err != fallback {
+		t.Errorf("Expected the fallback error, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// manifestName is the file in the root of a compressed target that maps
+// every compressed copy to the size of its source file
+const manifestName = ".snc-manifest.json"
+
+// manifest records the original sizes of the files in a compressed
+// target, which cannot be told from the compressed copies, keyed by the
+// path of the copy relative to the target root
+type manifest struct {
+	mu    sync.Mutex
+	root  string
+	files map[string]int64
+	dirty bool
+}
+
+// loadManifest reads the manifest of the target at root; a missing one
+// yields an empty manifest
+func loadManifest(root string) (*manifest, error) {
+	m := &manifest{root: root, files: map[string]int64{}}
+	data, err := os.ReadFile(filepath.Join(root, manifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var stored struct {
+		Files map[string]int64 `json:"files"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("damaged manifest %s: %w", filepath.Join(root, manifestName), err)
+	}
+	if stored.Files != nil {
+		m.files = stored.Files
+	}
+	return m, nil
+}
+
+// key returns the manifest key of the target file dstPath
+func (m *manifest) key(dstPath string) string {
+	rel, err := filepath.Rel(m.root, dstPath)
+	if err != nil {
+		return dstPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// size returns the source size recorded for the target file dstPath
+func (m *manifest) size(dstPath string) (int64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	size, ok := m.files[m.key(dstPath)]
+	return size, ok
+}
+
+// record remembers that the target file dstPath is a copy of size bytes
+func (m *manifest) record(dstPath string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[m.key(dstPath)] = size
+	m.dirty = true
+}
+
+// forget drops the target file dstPath, e.g. after it was deleted
+func (m *manifest) forget(dstPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[m.key(dstPath)]; ok {
+		delete(m.files, m.key(dstPath))
+		m.dirty = true
+	}
+}
+
+// save writes the manifest to the target root, replacing the file as a
+// whole, if it changed since it was loaded
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(m.root, "."+manifestName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = json.NewEncoder(tmp).Encode(struct {
+		Files map[string]int64 `json:"files"`
+	}{m.files})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(m.root, manifestName))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	m.dirty = false
+	return nil
+}
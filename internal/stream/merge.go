@@ -0,0 +1,58 @@
+package stream
+
+import (
+	"os"
+	"sort"
+)
+
+// mergedEntry is a name found in a source directory, the corresponding
+// target directory, or both. Name is the source name; src and dst are nil
+// on the side the name is missing from.
+type mergedEntry struct {
+	name string
+	src  os.DirEntry
+	dst  os.DirEntry
+}
+
+// mergeListings compares the listings of a source directory and its target
+// directory in a single sorted pass. Target names are decoded with decode;
+// entries whose names cannot be decoded are returned separately. The
+// merged entries are in the lexical order of the source names, so the
+// decisions made from them are always made in the same order.
+func mergeListings(src, dst []os.DirEntry, decode func(os.DirEntry) (string, error)) (merged []mergedEntry, undecodable []os.DirEntry) {
+	type named struct {
+		name  string
+		entry os.DirEntry
+	}
+	targets := make([]named, 0, len(dst))
+	for _, d := range dst {
+		name, err := decode(d)
+		if err != nil {
+			undecodable = append(undecodable, d)
+			continue
+		}
+		targets = append(targets, named{name, d})
+	}
+	// Encoded names sort differently from the source names
+	sort.Slice(targets, func(i, j int) bool { return targets[i].name < targets[j].name })
+	sources := make([]os.DirEntry, len(src))
+	copy(sources, src)
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name() < sources[j].Name() })
+
+	i, j := 0, 0
+	for i < len(sources) || j < len(targets) {
+		switch {
+		case j == len(targets) || (i < len(sources) && sources[i].Name() < targets[j].name):
+			merged = append(merged, mergedEntry{name: sources[i].Name(), src: sources[i]})
+			i++
+		case i == len(sources) || targets[j].name < sources[i].Name():
+			merged = append(merged, mergedEntry{name: targets[j].name, dst: targets[j].entry})
+			j++
+		default:
+			merged = append(merged, mergedEntry{name: sources[i].Name(), src: sources[i], dst: targets[j].entry})
+			i++
+			j++
+		}
+	}
+	return merged, undecodable
+}
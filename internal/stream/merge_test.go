@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeListings(t *testing.T) {
+	srcDir := t.TempDir()
+	dstDir := t.TempDir()
+	for _, name := range []string{"a.txt", "c.txt", "d"} {
+		createTestFile(t, filepath.Join(srcDir, name), name)
+	}
+	// Target names are upper case, so they sort differently
+	for _, name := range []string{"B.TXT", "C.TXT", "D", "bad"} {
+		createTestFile(t, filepath.Join(dstDir, name), name)
+	}
+	src, _ := os.ReadDir(srcDir)
+	dst, _ := os.ReadDir(dstDir)
+
+	decode := func(d os.DirEntry) (string, error) {
+		if strings.ToUpper(d.Name()) != d.Name() {
+			return "", fmt.Errorf("not encoded")
+		}
+		return strings.ToLower(d.Name()), nil
+	}
+	merged, undecodable := mergeListings(src, dst, decode)
+
+	var got []string
+	for _, m := range merged {
+		side := "both"
+		if m.src == nil {
+			side = "target"
+		} else if m.dst == nil {
+			side = "source"
+		}
+		got = append(got, m.name+":"+side)
+	}
+	expected := "a.txt:source b.txt:target c.txt:both d:both"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(got, " "))
+	}
+	if len(undecodable) != 1 || undecodable[0].Name() != "bad" {
+		t.Errorf("Expected bad to be undecodable, got %v", undecodable)
+	}
+}
@@ -0,0 +1,40 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+)
+
+// modeBits are the mode bits compared and carried over besides the type
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// metadataDiffers reports whether the permissions, ownership or extended
+// attributes of the target file dstPath differ from those of srcPath
+func metadataDiffers(srcPath, dstPath string) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
+	}
+
+	if srcInfo.Mode()&modeBits != dstInfo.Mode()&modeBits || ownerDiffers(srcInfo, dstInfo) {
+		return true, nil
+	}
+	return xattrsDiffer(srcPath, dstPath)
+}
+
+// copyMetadata gives the target file dstPath the permissions, ownership
+// and extended attributes of the source file srcPath with srcInfo.
+// Ownership is set first, since changing it clears set-id bits.
+func copyMetadata(srcPath, dstPath string, srcInfo os.FileInfo) error {
+	if err := copyOwner(dstPath, srcInfo); err != nil {
+		return err
+	}
+	if err := os.Chmod(dstPath, srcInfo.Mode()&modeBits); err != nil {
+		return err
+	}
+	return copyXattrs(srcPath, dstPath)
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package stream
+
+import "os"
+
+// ownerDiffers is always false, ownership is not compared here
+func ownerDiffers(srcInfo, dstInfo os.FileInfo) bool {
+	return false
+}
+
+// copyOwner does nothing, ownership is not carried over here
+func copyOwner(dstPath string, srcInfo os.FileInfo) error {
+	return nil
+}
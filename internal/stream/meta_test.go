@@ -0,0 +1,87 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+func TestMetaStrategy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Permissions are not compared on Windows")
+	}
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "test content")
+	os.Chmod(srcFile, 0640)
+	os.Chmod(dstFile, 0640)
+
+	strategy := &MetaStrategy{}
+	needsUpdate, err := strategy.NeedsUpdate(srcFile, dstFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if needsUpdate {
+		t.Error("Expected no update needed for identical files")
+	}
+
+	// Drifted permissions, same contents
+	os.Chmod(dstFile, 0644)
+	if needsUpdate, _ = strategy.NeedsUpdate(srcFile, dstFile); !needsUpdate {
+		t.Error("Expected update needed for different permissions")
+	}
+
+	info, _ := os.Stat(srcFile)
+	if err := copyMetadata(srcFile, dstFile, info); err != nil {
+		t.Fatalf("copyMetadata failed: %v", err)
+	}
+	if needsUpdate, _ = strategy.NeedsUpdate(srcFile, dstFile); needsUpdate {
+		t.Error("Expected no update needed once the metadata was copied")
+	}
+}
+
+func TestMetadataOnlyUpdate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Permissions are not compared on Windows")
+	}
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	srcFile := filepath.Join(srcDir, "test.txt")
+	dstFile := filepath.Join(dstDir, "test.txt")
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "test content")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(srcFile, modTime, modTime)
+	os.Chtimes(dstFile, modTime, modTime)
+	os.Chmod(srcFile, 0600)
+	os.Chmod(dstFile, 0644)
+
+	before, _ := os.Stat(dstFile)
+	srcInfo, _ := os.Stat(srcFile)
+	opts := &copyOptions{codec: plainTarget, attrs: defaultAttrs, preserveMeta: true}
+	result, bytes, err := processFileWithStrategy(srcDir, dstDir, srcFile, &mockDirEntry{fileInfo: srcInfo}, &MetaStrategy{}, opts, events.Nop{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result != fileMetadataUpdated || bytes != 0 {
+		t.Errorf("Expected a metadata update without bytes written, got result %d with %d bytes", result, bytes)
+	}
+
+	after, err := os.Stat(dstFile)
+	if err != nil {
+		t.Fatalf("Failed to stat target: %v", err)
+	}
+	if after.Mode().Perm() != 0600 {
+		t.Errorf("Expected permissions 0600, got %o", after.Mode().Perm())
+	}
+	if !os.SameFile(before, after) {
+		t.Error("Expected the target file to be kept rather than copied again")
+	}
+	if !after.ModTime().Equal(modTime) {
+		t.Errorf("Expected modtime %v to be kept, got %v", modTime, after.ModTime())
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownerDiffers reports whether the files have different owners or groups.
+// Only root can give files away, so ownership is not compared otherwise.
+func ownerDiffers(srcInfo, dstInfo os.FileInfo) bool {
+	src, ok1 := srcInfo.Sys().(*syscall.Stat_t)
+	dst, ok2 := dstInfo.Sys().(*syscall.Stat_t)
+	if !ok1 || !ok2 || os.Geteuid() != 0 {
+		return false
+	}
+	return src.Uid != dst.Uid || src.Gid != dst.Gid
+}
+
+// copyOwner gives dstPath the owner and group of srcInfo, when running as
+// root
+func copyOwner(dstPath string, srcInfo os.FileInfo) error {
+	st, ok := srcInfo.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(dstPath, int(st.Uid), int(st.Gid))
+}
@@ -0,0 +1,101 @@
+//go:build linux || darwin
+
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// xattrsDiffer reports whether the extended attributes of the files
+// differ. A target that cannot store them never differs.
+func xattrsDiffer(srcPath, dstPath string) (bool, error) {
+	src, _, err := readXattrs(srcPath)
+	if err != nil {
+		return false, err
+	}
+	dst, supported, err := readXattrs(dstPath)
+	if err != nil || !supported {
+		return false, err
+	}
+
+	if len(src) != len(dst) {
+		return true, nil
+	}
+	for name, value := range src {
+		if other, ok := dst[name]; !ok || !bytes.Equal(value, other) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// copyXattrs gives dstPath the extended attributes of srcPath, removing
+// those srcPath does not have
+func copyXattrs(srcPath, dstPath string) error {
+	src, _, err := readXattrs(srcPath)
+	if err != nil {
+		return err
+	}
+	dst, supported, err := readXattrs(dstPath)
+	if err != nil || !supported {
+		return err
+	}
+
+	for name := range dst {
+		if _, ok := src[name]; !ok {
+			if err := unix.Removexattr(dstPath, name); err != nil {
+				return err
+			}
+		}
+	}
+	for name, value := range src {
+		if other, ok := dst[name]; ok && bytes.Equal(value, other) {
+			continue
+		}
+		if err := unix.Setxattr(dstPath, name, value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readXattrs returns the extended attributes of path that are carried
+// over, and whether its filesystem supports them at all. Attributes of the
+// security, system and trusted namespaces belong to the system the file
+// is on, such as SELinux labels, and are left alone.
+func readXattrs(path string) (attrs map[string][]byte, supported bool, err error) {
+	size, err := unix.Listxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil, false, nil
+	}
+	if err != nil || size == 0 {
+		return nil, true, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.Listxattr(path, buf)
+	if err != nil {
+		return nil, true, err
+	}
+
+	attrs = map[string][]byte{}
+	for _, name := range strings.Split(string(buf[:n]), "\x00") {
+		if name == "" || strings.HasPrefix(name, "security.") || strings.HasPrefix(name, "system.") || strings.HasPrefix(name, "trusted.") {
+			continue
+		}
+		size, err := unix.Getxattr(path, name, nil)
+		if err != nil {
+			return nil, true, err
+		}
+		value := make([]byte, size)
+		n, err := unix.Getxattr(path, name, value)
+		if err != nil {
+			return nil, true, err
+		}
+		attrs[name] = value[:n]
+	}
+	return attrs, true, nil
+}
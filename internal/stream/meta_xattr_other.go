@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package stream
+
+// xattrsDiffer is always false without an extended attribute API
+func xattrsDiffer(srcPath, dstPath string) (bool, error) {
+	return false, nil
+}
+
+// copyXattrs does nothing without an extended attribute API
+func copyXattrs(srcPath, dstPath string) error {
+	return nil
+}
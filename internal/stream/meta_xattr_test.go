@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package stream
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMetaStrategyXattrs(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "test content")
+	if err := unix.Setxattr(srcFile, "user.snc-test", []byte("tag"), 0); err != nil {
+		t.Skipf("Extended attributes not supported: %v", err)
+	}
+
+	strategy := &MetaStrategy{}
+	if needsUpdate, err := strategy.NeedsUpdate(srcFile, dstFile); err != nil || !needsUpdate {
+		t.Errorf("Expected update needed for a missing extended attribute, got %v, %v", needsUpdate, err)
+	}
+	if err := copyXattrs(srcFile, dstFile); err != nil {
+		t.Fatalf("copyXattrs failed: %v", err)
+	}
+	if needsUpdate, err := strategy.NeedsUpdate(srcFile, dstFile); err != nil || needsUpdate {
+		t.Errorf("Expected no update needed once the extended attributes were copied, got %v, %v", needsUpdate, err)
+	}
+
+	// Attributes the source no longer has are removed
+	unix.Removexattr(srcFile, "user.snc-test")
+	if err := copyXattrs(srcFile, dstFile); err != nil {
+		t.Fatalf("copyXattrs failed: %v", err)
+	}
+	if attrs, _, _ := readXattrs(dstFile); len(attrs) != 0 {
+		t.Errorf("Expected the extended attribute to be removed, got %v", attrs)
+	}
+}
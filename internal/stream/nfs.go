@@ -0,0 +1,67 @@
+package stream
+
+import (
+	stderrors "errors"
+	"snc/internal/events"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// nfsMtimeTolerance is how far modification times in NFS targets may
+	// be off from the source and still be taken as equal: servers store
+	// them with the precision of their own filesystem
+	nfsMtimeTolerance = time.Second
+	// nfsStaleRetries is how often a file that failed with a stale NFS
+	// file handle is processed again
+	nfsStaleRetries = 3
+)
+
+// nfsTarget adjusts the handling of a target on NFS
+type nfsTarget struct {
+	// chtimesWarned is set once a failure to set a modification time was
+	// reported as a warning; further ones are only logged at debug level
+	chtimesWarned atomic.Bool
+}
+
+// newNFSTarget returns the NFS adjustments for target, or nil if it is not
+// on NFS. A target that does not exist yet is looked up by its nearest
+// existing parent.
+func newNFSTarget(target string) *nfsTarget {
+	if nfs, _ := isNFS(existingParent(target)); !nfs {
+		return nil
+	}
+	return &nfsTarget{}
+}
+
+// mtimeTolerance returns how far modification times in the target may be
+// off from the source
+func (n *nfsTarget) mtimeTolerance() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return nfsMtimeTolerance
+}
+
+// retryable reports whether processing a file that failed with err is worth
+// another attempt: on NFS, a file handle goes stale when another client
+// replaces the file
+func (n *nfsTarget) retryable(err error) bool {
+	return n != nil && stderrors.Is(err, syscall.ESTALE)
+}
+
+// chtimesFailed reports that the modification time of dst could not be
+// set. On NFS, this usually fails for every file alike, e.g. when the
+// server squashes root to another user, so it is warned about once.
+func (n *nfsTarget) chtimesFailed(dst string, err error, sink events.EventSink) {
+	if n == nil {
+		events.Warnf(sink, "STREAM", "Failed to preserve modtime for %s: %v", dst, err)
+		return
+	}
+	if n.chtimesWarned.CompareAndSwap(false, true) {
+		events.Warnf(sink, "STREAM", "Failed to preserve modtime for %s on NFS, further failures are only logged at debug level; check that the server does not squash the user running snc: %v", dst, err)
+		return
+	}
+	events.Debugf(sink, "STREAM", "Failed to preserve modtime for %s: %v", dst, err)
+}
@@ -0,0 +1,12 @@
+package stream
+
+import "golang.org/x/sys/unix"
+
+// isNFS reports whether path is on an NFS mount
+func isNFS(path string) (nfs, known bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, false
+	}
+	return unix.ByteSliceToString(st.Fstypename[:]) == "nfs", true
+}
@@ -0,0 +1,15 @@
+package stream
+
+import "syscall"
+
+// nfsSuperMagic is the filesystem type statfs reports for NFS mounts
+const nfsSuperMagic = 0x6969
+
+// isNFS reports whether path is on an NFS mount
+func isNFS(path string) (nfs, known bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, false
+	}
+	return st.Type == nfsSuperMagic, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package stream
+
+// isNFS reports whether path is on an NFS mount, which is unknown on this
+// platform
+func isNFS(path string) (nfs, known bool) {
+	return false, false
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/crypt"
+	"snc/internal/events"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// levelSink counts the progress messages reported per level
+type levelSink struct {
+	events.Nop
+	levels map[events.Level]int
+}
+
+func (s *levelSink) Progress(ev events.ProgressEvent) { s.levels[ev.Level]++ }
+
+func TestModTimeStrategyTolerance(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "test content")
+
+	modTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(srcFile, modTime, modTime.Add(400*time.Millisecond))
+	os.Chtimes(dstFile, modTime, modTime)
+
+	strategy := &ModTimeStrategy{}
+	if needsUpdate, _ := strategy.NeedsUpdate(srcFile, dstFile); !needsUpdate {
+		t.Error("Expected update needed without a tolerance")
+	}
+	strategy.Tolerance = nfsMtimeTolerance
+	if needsUpdate, _ := strategy.NeedsUpdate(srcFile, dstFile); needsUpdate {
+		t.Error("Expected no update needed within the tolerance")
+	}
+	os.Chtimes(srcFile, modTime, modTime.Add(2*time.Second))
+	if needsUpdate, _ := strategy.NeedsUpdate(srcFile, dstFile); !needsUpdate {
+		t.Error("Expected update needed beyond the tolerance")
+	}
+}
+
+func TestCodecStrategiesTolerance(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	createTestFile(t, srcFile, "test content")
+	cipher, err := crypt.New(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+
+	codecs := map[string]struct {
+		codec   *targetCodec
+		content string
+	}{
+		"encrypted":  {&targetCodec{cipher: cipher}, strings.Repeat("x", int(crypt.EncryptedSize(12)))},
+		"compressed": {&targetCodec{manifest: &manifest{root: tempDir, files: map[string]int64{}}}, "compressed"},
+	}
+	for name, tt := range codecs {
+		t.Run(name, func(t *testing.T) {
+			dstFile := filepath.Join(tempDir, name)
+			createTestFile(t, dstFile, tt.content)
+			tt.codec.written(dstFile, 12)
+
+			modTime := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+			os.Chtimes(srcFile, modTime, modTime.Add(400*time.Millisecond))
+			os.Chtimes(dstFile, modTime, modTime)
+			srcInfo, _ := os.Stat(srcFile)
+			dstInfo, _ := os.Stat(dstFile)
+
+			times := &ModTimeStrategy{Tolerance: nfsMtimeTolerance}
+			if needsUpdate, err := tt.codec.wrap(times).NeedsUpdate(srcFile, dstFile); err != nil || needsUpdate {
+				t.Errorf("Expected no update needed within the tolerance, got %v (%v)", needsUpdate, err)
+			}
+			kind, err := classify(tt.codec.wrap(&SizeStrategy{}), srcFile, dstFile, srcInfo, dstInfo, defaultAttrs, times)
+			if err != nil || kind != "" {
+				t.Errorf("Expected no difference within the tolerance, got %q (%v)", kind, err)
+			}
+		})
+	}
+}
+
+func TestNFSTarget(t *testing.T) {
+	stale := fmt.Errorf("copy failed: %w", &os.PathError{Op: "open", Path: "file", Err: syscall.ESTALE})
+
+	var local *nfsTarget
+	if local.retryable(stale) || local.mtimeTolerance() != 0 {
+		t.Error("Expected no adjustments for a target not on NFS")
+	}
+
+	nfs := &nfsTarget{}
+	if !nfs.retryable(stale) {
+		t.Error("Expected a stale file handle to be retried on NFS")
+	}
+	if nfs.retryable(os.ErrNotExist) {
+		t.Error("Expected other errors not to be retried")
+	}
+
+	sink := &levelSink{levels: map[events.Level]int{}}
+	for i := 0; i < 3; i++ {
+		nfs.chtimesFailed("file", os.ErrPermission, sink)
+	}
+	if sink.levels[events.LevelWarn] != 1 {
+		t.Errorf("Expected a single warning for failures to set modification times, got %d", sink.levels[events.LevelWarn])
+	}
+}
@@ -0,0 +1,54 @@
+package stream
+
+import (
+	"os"
+)
+
+// noCacheWindow is how much data is read or written before it is dropped
+// from the page cache
+const noCacheWindow = 32 << 20
+
+// noCacheReader reads a file and drops the data read from the page cache
+// in noCacheWindow steps, so copying large trees does not evict the cache
+// of other workloads
+type noCacheReader struct {
+	f              *os.File
+	read, released int64
+}
+
+func (r *noCacheReader) Read(p []byte) (int, error) {
+	n, err := r.f.Read(p)
+	r.read += int64(n)
+	if r.read-r.released >= noCacheWindow {
+		r.release()
+	}
+	return n, err
+}
+
+// release drops everything read so far from the page cache
+func (r *noCacheReader) release() {
+	dropCache(r.f, r.released, r.read-r.released, false)
+	r.released = r.read
+}
+
+// noCacheWriter is the writing counterpart of noCacheReader. Dirty pages
+// cannot be dropped, so every window is flushed to disk first.
+type noCacheWriter struct {
+	f                 *os.File
+	written, released int64
+}
+
+func (w *noCacheWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.written += int64(n)
+	if w.written-w.released >= noCacheWindow {
+		w.release()
+	}
+	return n, err
+}
+
+// release flushes and drops everything written so far from the page cache
+func (w *noCacheWriter) release() {
+	dropCache(w.f, w.released, w.written-w.released, true)
+	w.released = w.written
+}
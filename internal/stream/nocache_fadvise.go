@@ -0,0 +1,24 @@
+//go:build linux && (amd64 || arm64 || riscv64 || ppc64le || loong64)
+
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+const fadvDontNeed = 4 // POSIX_FADV_DONTNEED
+
+// dropCache advises the kernel that the given range of f is no longer
+// needed, flushing it to disk first if flush is set. It is best effort:
+// errors only mean the data stays cached.
+func dropCache(f *os.File, offset, length int64, flush bool) {
+	if length <= 0 {
+		return
+	}
+	fd := int(f.Fd())
+	if flush {
+		syscall.Fdatasync(fd)
+	}
+	syscall.Syscall6(syscall.SYS_FADVISE64, uintptr(fd), uintptr(offset), uintptr(length), fadvDontNeed, 0, 0)
+}
@@ -0,0 +1,10 @@
+//go:build !linux || !(amd64 || arm64 || riscv64 || ppc64le || loong64)
+
+package stream
+
+import (
+	"os"
+)
+
+// dropCache is a no-op on platforms without posix_fadvise support
+func dropCache(f *os.File, offset, length int64, flush bool) {}
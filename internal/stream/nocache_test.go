@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestCopyFileNoCache(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.bin")
+	dst := filepath.Join(tempDir, "out", "dst.bin")
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+
+	opts := &copyOptions{codec: plainTarget, attrs: defaultAttrs, buffers: newBufferPool(64 << 10), noCache: true}
+	n, err := copyFile(src, dst, opts, events.Nop{})
+	if err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("Expected %d bytes copied, got %d", len(data), n)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Failed to read copy: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Copy differs from source")
+	}
+}
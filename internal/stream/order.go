@@ -0,0 +1,66 @@
+package stream
+
+import (
+	"math/rand"
+	"os"
+	"snc/internal/config"
+	"sort"
+	"strings"
+)
+
+// orderFiles sorts files in place for the given processing order. Files
+// whose size cannot be determined are treated as empty; files of the same
+// size keep the path order.
+func orderFiles(files []pendingFile, order string) {
+	switch order {
+	case config.OrderLargestFirst, config.OrderSmallestFirst:
+		sizes := make(map[string]int64, len(files))
+		for _, f := range files {
+			if info, err := f.entry.Info(); err == nil {
+				sizes[f.path] = info.Size()
+			}
+		}
+		sort.SliceStable(files, func(i, j int) bool {
+			si, sj := sizes[files[i].path], sizes[files[j].path]
+			if si == sj {
+				return comparePaths(files[i].rel, files[j].rel) < 0
+			}
+			if order == config.OrderLargestFirst {
+				return si > sj
+			}
+			return si < sj
+		})
+	case config.OrderRandom:
+		rand.Shuffle(len(files), func(i, j int) {
+			files[i], files[j] = files[j], files[i]
+		})
+	default:
+		sort.SliceStable(files, func(i, j int) bool {
+			return comparePaths(files[i].rel, files[j].rel) < 0
+		})
+	}
+}
+
+// comparePaths compares the relative paths a and b in the order the walk
+// visits them: component by component, byte-wise, so a directory comes
+// right before its contents. Unlike comparing the paths as strings, the
+// order does not depend on the path separator of the platform, e.g.
+// "a/b" sorts before "a-c" everywhere.
+func comparePaths(a, b string) int {
+	for {
+		ca, restA, moreA := strings.Cut(a, string(os.PathSeparator))
+		cb, restB, moreB := strings.Cut(b, string(os.PathSeparator))
+		if c := strings.Compare(ca, cb); c != 0 {
+			return c
+		}
+		switch {
+		case !moreA && !moreB:
+			return 0
+		case !moreA:
+			return -1
+		case !moreB:
+			return 1
+		}
+		a, b = restA, restB
+	}
+}
@@ -0,0 +1,85 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"snc/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestSyncOrder(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	os.MkdirAll(srcDir, 0755)
+
+	createTestFile(t, filepath.Join(srcDir, "a-medium.txt"), strings.Repeat("m", 50))
+	createTestFile(t, filepath.Join(srcDir, "b-large.txt"), strings.Repeat("l", 500))
+	createTestFile(t, filepath.Join(srcDir, "c-small.txt"), "s")
+
+	tests := []struct {
+		order    string
+		expected []string
+	}{
+		{config.OrderAlpha, []string{"a-medium.txt", "b-large.txt", "c-small.txt"}},
+		{config.OrderLargestFirst, []string{"b-large.txt", "a-medium.txt", "c-small.txt"}},
+		{config.OrderSmallestFirst, []string{"c-small.txt", "a-medium.txt", "b-large.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			cfg := &config.Config{
+				Source:       srcDir,
+				Target:       filepath.Join(tempDir, tt.order),
+				UpdateMethod: "modtime",
+				Order:        tt.order,
+			}
+
+			rec := &recordingSink{}
+			if _, err := Sync(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+
+			var got []string
+			for _, ev := range rec.copied {
+				got = append(got, ev.Path)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected order %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run(config.OrderRandom, func(t *testing.T) {
+		cfg := &config.Config{
+			Source:       srcDir,
+			Target:       filepath.Join(tempDir, "random"),
+			UpdateMethod: "modtime",
+			Order:        config.OrderRandom,
+		}
+		rec := &recordingSink{}
+		if _, err := Sync(context.Background(), cfg, rec); err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+		if len(rec.copied) != 3 {
+			t.Errorf("Expected all 3 files to be copied, got %d", len(rec.copied))
+		}
+	})
+}
+
+func TestComparePaths(t *testing.T) {
+	sep := string(filepath.Separator)
+	paths := []string{"b", "a-c", "a" + sep + "b" + sep + "c", "a", "a" + sep + "b", "a" + sep + "a-z"}
+	slices.SortFunc(paths, comparePaths)
+
+	// The order of the walk, with the same result on every platform
+	expected := []string{"a", "a/a-z", "a/b", "a/b/c", "a-c", "b"}
+	for i := range paths {
+		paths[i] = filepath.ToSlash(paths[i])
+	}
+	if !slices.Equal(paths, expected) {
+		t.Errorf("Expected %v, got %v", expected, paths)
+	}
+}
@@ -0,0 +1,262 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"time"
+)
+
+// planVersion is the format version of plan files
+const planVersion = 1
+
+// Plan actions
+const (
+	ActionCopy   = "copy"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Plan lists the changes a sync would make, for review before ApplyPlan
+// makes exactly these changes
+type Plan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Source  string    `json:"source"`
+	Target  string    `json:"target"`
+	// Storage describes how the target stores files, see targetCodec
+	Storage string      `json:"storage"`
+	Entries []PlanEntry `json:"entries"`
+}
+
+// PlanEntry is a single change of a plan. The hashes are the SHA-256 of
+// the files as they were when the plan was made: SourceSHA256 for copies
+// and updates, TargetSHA256 of the file as stored for updates and
+// deletions.
+type PlanEntry struct {
+	Action       string `json:"action"`
+	Path         string `json:"path"`
+	Size         int64  `json:"size,omitempty"`
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	TargetSHA256 string `json:"target_sha256,omitempty"`
+}
+
+// MakePlan compares source and target like Check and returns the copies,
+// updates and, with cfg.DeleteMissing, deletions a sync would make.
+// Files that only differ in their metadata are left out.
+func MakePlan(ctx context.Context, cfg *config.Config, sink events.EventSink) (*Plan, error) {
+	diffs, err := Check(ctx, cfg, sink)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target encryption setup", err)
+	}
+
+	plan := &Plan{Version: planVersion, Created: time.Now().UTC(), Source: cfg.Source, Target: cfg.Target, Storage: codec.String()}
+	for _, d := range diffs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entry := PlanEntry{Path: filepath.ToSlash(d.Path)}
+		switch d.Kind {
+		case DiffOnlyInSource:
+			entry.Action = ActionCopy
+		case DiffContent:
+			entry.Action = ActionUpdate
+		case DiffOnlyInTarget:
+			if !cfg.DeleteMissing {
+				continue
+			}
+			entry.Action = ActionDelete
+		case DiffTypeConflict:
+			events.Warnf(sink, "PLAN", "Leaving %s out of the plan, it is a file on one side and a directory on the other", d.Path)
+			continue
+		default:
+			continue
+		}
+
+		srcPath, dstPath := planPaths(cfg, codec, d.Path)
+		if entry.Action != ActionDelete {
+			info, err := os.Stat(srcPath)
+			if err != nil {
+				return nil, errors.NewFileStatError(srcPath, err)
+			}
+			entry.Size = info.Size()
+			if entry.SourceSHA256, err = calculateSHA256(srcPath); err != nil {
+				return nil, err
+			}
+		}
+		if entry.Action != ActionCopy {
+			if entry.TargetSHA256, err = calculateSHA256(dstPath); err != nil {
+				return nil, err
+			}
+		}
+		plan.Entries = append(plan.Entries, entry)
+	}
+	return plan, nil
+}
+
+// WritePlan writes plan to path as JSON
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// ReadPlan reads a plan written by WritePlan
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has unsupported version %d", path, plan.Version)
+	}
+	return &plan, nil
+}
+
+// ApplyPlan makes the changes of plan, from cfg.Source to cfg.Target. All
+// files of the plan are checked first: if a source or target file changed
+// since the plan was made, or a file to be copied appeared in the target,
+// nothing is changed and the changed files are reported to sink.
+//
+// The returned Stats are never nil and cover the work done so far.
+func ApplyPlan(ctx context.Context, cfg *config.Config, plan *Plan, sink events.EventSink) (*Stats, error) {
+	stats := &Stats{}
+	events.Infof(sink, "PLAN", "Applying %d planned changes from %s to %s", len(plan.Entries), cfg.Source, cfg.Target)
+
+	opts, err := newCopyOptions(cfg)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		if err := opts.codec.flush(); err != nil {
+			events.Warnf(sink, "PLAN", "%v", err)
+		}
+		if err := opts.checksums.flush(); err != nil {
+			events.Warnf(sink, "PLAN", "%v", err)
+		}
+	}()
+	if storage := opts.codec.String(); storage != plan.Storage {
+		return stats, errors.NewSyncError(errors.ErrPlanOutdated, "plan",
+			fmt.Errorf("plan was made for target storage %q, not %q", plan.Storage, storage))
+	}
+
+	changed := 0
+	for _, e := range plan.Entries {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if err := checkPlanEntry(cfg, opts.codec, e); err != nil {
+			changed++
+			sink.Error(events.ErrorEvent{Component: "PLAN", Message: "Changed since the plan was made", Op: events.OpCompare, Path: e.Path, Err: err})
+		}
+	}
+	if changed > 0 {
+		return stats, errors.NewSyncError(errors.ErrPlanOutdated, "plan",
+			fmt.Errorf("%d of %d planned files changed, nothing was applied", changed, len(plan.Entries)))
+	}
+
+	for _, e := range plan.Entries {
+		if err := ctx.Err(); err != nil {
+			events.Warnf(sink, "PLAN", "Applying the plan interrupted: %v", err)
+			return stats, err
+		}
+		stats.Files++
+		rel := filepath.FromSlash(e.Path)
+		srcPath, dstPath := planPaths(cfg, opts.codec, rel)
+
+		if e.Action == ActionDelete {
+			if err := os.Remove(dstPath); err != nil {
+				stats.Errors++
+				sink.Error(events.ErrorEvent{Component: "PLAN", Message: "Failed to delete file", Op: events.OpDelete, Path: dstPath, Err: err})
+				continue
+			}
+			opts.codec.removed(dstPath)
+			sink.FileDeleted(events.FileEvent{Path: rel, DstPath: dstPath, Itemize: itemizeDeleting})
+			stats.Deleted++
+			continue
+		}
+
+		ev := events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Itemize: itemizeNewFile}
+		result := fileCopied
+		if e.Action == ActionUpdate {
+			srcInfo, srcErr := os.Stat(srcPath)
+			dstInfo, dstErr := os.Stat(dstPath)
+			if srcErr == nil && dstErr == nil {
+				ev.Itemize = itemizeUpdate(srcInfo, dstInfo, true)
+			}
+			ev.Update, result = true, fileUpdated
+		}
+		n, err := tracedCopy(rel, srcPath, dstPath, opts, sink)
+		if err != nil {
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "PLAN", Message: "Failed to copy file", Op: failedOp(err, events.OpCopy), Path: srcPath, Err: err})
+			continue
+		}
+		ev.Bytes = n
+		sink.FileCopied(ev)
+		stats.record(result, n)
+	}
+
+	events.Infof(sink, "PLAN", "Plan applied: %d copied, %d updated, %d deleted, %d errors",
+		stats.Copied, stats.Updated, stats.Deleted, stats.Errors)
+	return stats, nil
+}
+
+// checkPlanEntry returns an error if the files of e are no longer as they
+// were when the plan was made
+func checkPlanEntry(cfg *config.Config, codec *targetCodec, e PlanEntry) error {
+	switch e.Action {
+	case ActionCopy, ActionUpdate, ActionDelete:
+	default:
+		return fmt.Errorf("unknown action %q", e.Action)
+	}
+	srcPath, dstPath := planPaths(cfg, codec, filepath.FromSlash(e.Path))
+
+	if e.Action != ActionDelete {
+		sum, err := calculateSHA256(srcPath)
+		if err != nil {
+			return err
+		}
+		if sum != e.SourceSHA256 {
+			return fmt.Errorf("source file %s changed", srcPath)
+		}
+	}
+	if e.Action == ActionCopy {
+		if _, err := os.Lstat(dstPath); !os.IsNotExist(err) {
+			return fmt.Errorf("target file %s appeared", dstPath)
+		}
+		return nil
+	}
+	sum, err := calculateSHA256(dstPath)
+	if err != nil {
+		return err
+	}
+	if sum != e.TargetSHA256 {
+		return fmt.Errorf("target file %s changed", dstPath)
+	}
+	return nil
+}
+
+// planPaths returns the source and target paths of the file rel
+func planPaths(cfg *config.Config, codec *targetCodec, rel string) (srcPath, dstPath string) {
+	return filepath.Join(cfg.Source, rel), filepath.Join(cfg.Target, codec.encodeFile(rel))
+}
@@ -0,0 +1,86 @@
+package stream
+
+import (
+	"context"
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"testing"
+)
+
+func TestPlanApply(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.MkdirAll(dstDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "new.txt"), "new")
+	createTestFile(t, filepath.Join(srcDir, "sub", "changed.txt"), "new contents")
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "sub", "changed.txt"), "old")
+	createTestFile(t, filepath.Join(dstDir, "stale.txt"), "stale")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "sha256", DeleteMissing: true}
+	plan, err := MakePlan(context.Background(), cfg, &recordingSink{})
+	if err != nil {
+		t.Fatalf("MakePlan failed: %v", err)
+	}
+	actions := map[string]string{}
+	for _, e := range plan.Entries {
+		actions[e.Path] = e.Action
+	}
+	expected := map[string]string{"new.txt": ActionCopy, "sub/changed.txt": ActionUpdate, "stale.txt": ActionDelete}
+	if len(actions) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, actions)
+	}
+	for path, action := range expected {
+		if actions[path] != action {
+			t.Errorf("Expected %s for %s, got %q", action, path, actions[path])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "new.txt")); !os.IsNotExist(err) {
+		t.Error("Planning should not change the target")
+	}
+
+	path := filepath.Join(tempDir, "plan.json")
+	if err := WritePlan(path, plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err = ReadPlan(path); err != nil {
+		t.Fatalf("ReadPlan failed: %v", err)
+	}
+
+	// A source file changed since the plan: nothing is applied
+	createTestFile(t, filepath.Join(srcDir, "new.txt"), "newer")
+	stats, err := ApplyPlan(context.Background(), cfg, plan, &recordingSink{})
+	if !stderrors.Is(err, errors.ErrPlanOutdated) {
+		t.Fatalf("Expected an outdated plan, got %v", err)
+	}
+	if stats.Copied+stats.Updated+stats.Deleted != 0 {
+		t.Errorf("Expected nothing applied, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "stale.txt")); err != nil {
+		t.Errorf("Stale file should be kept: %v", err)
+	}
+
+	createTestFile(t, filepath.Join(srcDir, "new.txt"), "new")
+	rec := &recordingSink{}
+	stats, err = ApplyPlan(context.Background(), cfg, plan, rec)
+	if err != nil {
+		t.Fatalf("ApplyPlan failed: %v", err)
+	}
+	if stats.Copied != 1 || stats.Updated != 1 || stats.Deleted != 1 || stats.Errors != 0 {
+		t.Errorf("Expected 1 copy, 1 update and 1 deletion, got %+v", stats)
+	}
+	for name, content := range map[string]string{"new.txt": "new", "sub/changed.txt": "new contents"} {
+		data, err := os.ReadFile(filepath.Join(dstDir, filepath.FromSlash(name)))
+		if err != nil || string(data) != content {
+			t.Errorf("Target %s: got %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "stale.txt")); !os.IsNotExist(err) {
+		t.Error("Stale file should be deleted")
+	}
+}
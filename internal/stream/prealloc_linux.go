@@ -0,0 +1,22 @@
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+const fallocKeepSize = 0x1 // FALLOC_FL_KEEP_SIZE
+
+// preallocate reserves size bytes of disk space for f. On Linux the file
+// size is left unchanged, so extended is always false. Filesystems without
+// fallocate support are ignored.
+func preallocate(f *os.File, size int64) (extended bool, err error) {
+	if size <= 0 {
+		return false, nil
+	}
+	err = syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return false, nil
+	}
+	return false, err
+}
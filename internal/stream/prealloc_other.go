@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package stream
+
+import (
+	"os"
+)
+
+// preallocate is a no-op on platforms without a preallocation API
+func preallocate(f *os.File, size int64) (extended bool, err error) {
+	return false, nil
+}
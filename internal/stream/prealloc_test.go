@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestCopyFilePreallocate(t *testing.T) {
+	tempDir := t.TempDir()
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "empty file", size: 0},
+		{name: "small file", size: 100},
+		{name: "large file", size: 3 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(tempDir, tt.name+".src")
+			dst := filepath.Join(tempDir, tt.name+".dst")
+			data := bytes.Repeat([]byte("x"), tt.size)
+			if err := os.WriteFile(src, data, 0644); err != nil {
+				t.Fatalf("Failed to create source file: %v", err)
+			}
+
+			opts := &copyOptions{codec: plainTarget, attrs: defaultAttrs, preallocate: true}
+			if _, err := copyFile(src, dst, opts, events.Nop{}); err != nil {
+				t.Fatalf("copyFile failed: %v", err)
+			}
+
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatalf("Failed to read copy: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Copy differs from source: expected %d bytes, got %d", len(data), len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package stream
+
+import (
+	"os"
+)
+
+// preallocate reserves size bytes of disk space for f by setting the end of
+// the file, which extends it; the caller truncates the file to the bytes
+// actually written afterwards
+func preallocate(f *os.File, size int64) (extended bool, err error) {
+	if size <= 0 {
+		return false, nil
+	}
+	if err := f.Truncate(size); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"io"
+	"snc/internal/events"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress reports
+const progressInterval = 10 * time.Second
+
+// progressTracker reports overall progress and an ETA against the totals
+// of a pre-scan
+type progressTracker struct {
+	sink  events.EventSink
+	index *ScanIndex
+	start time.Time
+	last  time.Time
+
+	files int
+	bytes int64
+}
+
+func newProgressTracker(sink events.EventSink, index *ScanIndex) *progressTracker {
+	now := time.Now()
+	return &progressTracker{sink: sink, index: index, start: now, last: now}
+}
+
+// fileDone records a processed file of the given size and reports progress
+// if the last report is old enough. A nil tracker does nothing.
+func (p *progressTracker) fileDone(size int64) {
+	if p == nil {
+		return
+	}
+	p.files++
+	p.bytes += size
+
+	now := time.Now()
+	if now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.last = now
+
+	events.Infof(p.sink, "STREAM", "Progress: %d/%d files, %s/%s (%.0f%%), ETA %s",
+		p.files, p.index.Files, formatBytes(p.bytes), formatBytes(p.index.Bytes),
+		p.percent(), p.eta(now).Round(time.Second))
+}
+
+func (p *progressTracker) percent() float64 {
+	if p.index.Bytes == 0 {
+		return 100
+	}
+	return float64(p.bytes) * 100 / float64(p.index.Bytes)
+}
+
+// eta extrapolates the remaining time from the throughput so far
+func (p *progressTracker) eta(now time.Time) time.Duration {
+	if p.bytes == 0 || p.bytes >= p.index.Bytes {
+		return 0
+	}
+	elapsed := now.Sub(p.start)
+	return time.Duration(float64(elapsed) * float64(p.index.Bytes-p.bytes) / float64(p.bytes))
+}
+
+// fileProgressReader reports the progress of copying a single large file,
+// which can take long enough to look like a hang otherwise. It is read by
+// the goroutine of a copy with a watchdog, which keeps running after the
+// copy is abandoned, so it is muted then.
+type fileProgressReader struct {
+	r        io.Reader
+	sink     events.EventSink
+	path     string
+	size     int64
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+
+	read int64
+
+	// mu is held while a report is made, so none is in progress once
+	// muted is set
+	mu    sync.Mutex
+	muted bool
+}
+
+func newFileProgressReader(r io.Reader, path string, size int64, sink events.EventSink) *fileProgressReader {
+	now := time.Now()
+	return &fileProgressReader{r: r, sink: sink, path: path, size: size, interval: progressInterval, start: now, last: now}
+}
+
+// mute stops all further reports and waits for one in progress, if any;
+// p may be nil
+func (p *fileProgressReader) mute() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.muted = true
+}
+
+func (p *fileProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+
+	now := time.Now()
+	if now.Sub(p.last) < p.interval || p.read >= p.size {
+		return n, err
+	}
+	p.last = now
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.muted {
+		return n, err
+	}
+	elapsed := now.Sub(p.start)
+	rate := float64(p.read) / max(elapsed.Seconds(), 1e-9)
+	eta := time.Duration(float64(p.size-p.read) / max(rate, 1e-9) * float64(time.Second))
+	events.Infof(p.sink, "STREAM", "Copying %s: %s/%s (%.0f%%) at %s/s, ETA %s",
+		p.path, formatBytes(p.read), formatBytes(p.size), float64(p.read)*100/float64(p.size),
+		formatBytes(int64(rate)), eta.Round(time.Second))
+	return n, err
+}
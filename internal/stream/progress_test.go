@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"snc/internal/events"
+	"strings"
+	"testing"
+)
+
+// messageSink records the progress messages reported
+type messageSink struct {
+	events.Nop
+	messages []string
+}
+
+func (m *messageSink) Progress(ev events.ProgressEvent) { m.messages = append(m.messages, ev.Message) }
+
+func TestFileProgressReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4<<10)
+	sink := &messageSink{}
+	r := newFileProgressReader(bytes.NewReader(data), "/src/big.iso", int64(len(data)), sink)
+	r.interval = 0
+
+	buf := make([]byte, 1<<10)
+	for {
+		if _, err := r.Read(buf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Every read but the last one is reported
+	if len(sink.messages) != 3 {
+		t.Fatalf("Expected 3 progress reports, got %q", sink.messages)
+	}
+	if !strings.Contains(sink.messages[0], "/src/big.iso: 1.0 KiB/4.0 KiB (25%)") {
+		t.Errorf("Unexpected progress report %q", sink.messages[0])
+	}
+}
+
+func TestFileProgressReaderMuted(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 4<<10)
+	sink := &messageSink{}
+	r := newFileProgressReader(bytes.NewReader(data), "/src/big.iso", int64(len(data)), sink)
+	r.interval = 0
+
+	buf := make([]byte, 1<<10)
+	r.Read(buf)
+	r.mute()
+	r.Read(buf)
+	if len(sink.messages) != 1 {
+		t.Errorf("Expected no progress reports once muted, got %q", sink.messages)
+	}
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"sync"
+)
+
+// protectMarker is the name of the file that protects the target
+// directory holding it, and everything below, from being written to
+const protectMarker = ".snc-protect"
+
+// protectedDirs finds the protection markers above target files. Lookups
+// are cached per directory, since many files share them.
+type protectedDirs struct {
+	root string
+
+	mu sync.Mutex
+	// markers holds the marker protecting each directory looked up, or ""
+	markers map[string]string
+	// reported holds the markers already logged
+	reported map[string]bool
+}
+
+// newProtectedDirs returns the protectedDirs of the target at root, or an
+// error if root or one of its parents holds a marker, so nothing at all
+// may be written
+func newProtectedDirs(root string) (*protectedDirs, error) {
+	root = filepath.Clean(root)
+	for dir := root; ; {
+		marker := filepath.Join(dir, protectMarker)
+		if _, err := os.Lstat(marker); err == nil {
+			return nil, fmt.Errorf("target %s is write-protected by %s", root, marker)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return &protectedDirs{root: root, markers: make(map[string]string), reported: make(map[string]bool)}, nil
+}
+
+// protectedBy returns the marker protecting the target file dstPath, or ""
+// if it may be written. The first file found below each marker is logged.
+func (p *protectedDirs) protectedBy(dstPath string, sink events.EventSink) string {
+	if p == nil {
+		return ""
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	marker := p.lookup(filepath.Dir(dstPath))
+	if marker != "" && !p.reported[marker] {
+		p.reported[marker] = true
+		events.Infof(sink, "STREAM", "Leaving %s unchanged, protected by %s", filepath.Dir(marker), marker)
+	}
+	return marker
+}
+
+// lookup returns the marker in dir or the closest of its parents below
+// the root, or ""
+func (p *protectedDirs) lookup(dir string) string {
+	if marker, ok := p.markers[dir]; ok {
+		return marker
+	}
+
+	marker := ""
+	if dir != p.root && isBelow(p.root, dir) {
+		if _, err := os.Lstat(filepath.Join(dir, protectMarker)); err == nil {
+			marker = filepath.Join(dir, protectMarker)
+		} else {
+			marker = p.lookup(filepath.Dir(dir))
+		}
+	}
+	p.markers[dir] = marker
+	return marker
+}
@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestProtectMarker(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	os.MkdirAll(filepath.Join(srcDir, "shared", "sub"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "shared", "sub"), 0755)
+
+	createTestFile(t, filepath.Join(srcDir, "new.txt"), "new")
+	createTestFile(t, filepath.Join(srcDir, "shared", "sub", "doc.txt"), "from source")
+	createTestFile(t, filepath.Join(dstDir, "shared", protectMarker), "")
+	createTestFile(t, filepath.Join(dstDir, "shared", "sub", "doc.txt"), "edited in target")
+	createTestFile(t, filepath.Join(dstDir, "shared", "local.txt"), "target only")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		UpdateMethod:  "sha256",
+	}
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats.Copied != 1 || stats.Protected != 1 {
+		t.Errorf("Expected 1 copied and 1 protected file, got %+v", stats)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dstDir, "shared", "sub", "doc.txt")); string(data) != "edited in target" {
+		t.Errorf("Protected file was overwritten: %q", data)
+	}
+
+	rec := &recordingSink{}
+	if _, err := DeleteMissing(context.Background(), cfg, rec); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if len(rec.deleted) != 0 {
+		t.Errorf("Protected files were deleted: %v", rec.deleted)
+	}
+	for _, rel := range []string{protectMarker, "local.txt"} {
+		if _, err := os.Stat(filepath.Join(dstDir, "shared", rel)); err != nil {
+			t.Errorf("Protected file %s is gone: %v", rel, err)
+		}
+	}
+}
+
+func TestProtectMarkerAboveTarget(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "mirrors", "target")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "file.txt"), "contents")
+	createTestFile(t, filepath.Join(tempDir, "mirrors", protectMarker), "")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, DeleteMissing: true, UpdateMethod: "modtime"}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err == nil {
+		t.Error("Expected sync into a protected target to fail")
+	}
+	if _, err := DeleteMissing(context.Background(), cfg, events.Nop{}); err == nil {
+		t.Error("Expected deleting from a protected target to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "file.txt")); !os.IsNotExist(err) {
+		t.Errorf("Nothing should be written to a protected target: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"strings"
+	"time"
+)
+
+// Retry re-attempts the operations of an earlier run that failed on paths,
+// without walking the rest of either tree. Paths inside cfg.Source are
+// synced again, with everything below them if they are directories. Paths
+// inside cfg.Target are removed if they are still missing from the source,
+// which requires cfg.DeleteMissing and passes the same source checks as
+// DeleteMissing. Other paths are reported as errors.
+//
+// The returned Stats are never nil and cover the work done so far when an
+// error is returned.
+func Retry(ctx context.Context, cfg *config.Config, paths []string, sink events.EventSink) (*Stats, error) {
+	events.Infof(sink, "STREAM", "Retrying %d failed paths from %s to %s", len(paths), cfg.Source, cfg.Target)
+	return syncPaths(ctx, cfg, paths, "Retry", sink)
+}
+
+// SyncChanges syncs the paths named by rels, relative to the source root,
+// which changed since the last sync, without walking the rest of either
+// tree. Paths no longer in the source are removed from the target with
+// cfg.DeleteMissing.
+func SyncChanges(ctx context.Context, cfg *config.Config, rels []string, sink events.EventSink) (*Stats, error) {
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return &Stats{}, errors.NewSyncError(errors.ErrSyncFailed, "target encryption setup", err)
+	}
+
+	var paths []string
+	for _, rel := range rels {
+		srcPath := filepath.Join(cfg.Source, rel)
+		if _, err := os.Lstat(srcPath); !os.IsNotExist(err) {
+			paths = append(paths, srcPath)
+			continue
+		}
+		if !cfg.DeleteMissing {
+			continue
+		}
+		// Whether rel was a file or a directory is unknown now; the path
+		// that does not exist in the target is skipped
+		paths = append(paths, filepath.Join(cfg.Target, codec.encodeFile(rel)))
+		if dir := codec.encodePath(rel); dir != codec.encodeFile(rel) {
+			paths = append(paths, filepath.Join(cfg.Target, dir))
+		}
+	}
+
+	events.Infof(sink, "STREAM", "Syncing %d changed paths from %s to %s", len(rels), cfg.Source, cfg.Target)
+	return syncPaths(ctx, cfg, paths, "Sync of changes", sink)
+}
+
+// syncPaths syncs paths inside cfg.Source and removes paths inside
+// cfg.Target, as described by Retry; what names the operation in messages
+func syncPaths(ctx context.Context, cfg *config.Config, paths []string, what string, sink events.EventSink) (*Stats, error) {
+	stats := &Stats{}
+
+	selector, err := NewStrategySelector(cfg.StrategyMap, cfg.UpdateMethod)
+	if err != nil {
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "update strategy creation", err)
+	}
+	opts, err := newCopyOptions(cfg)
+	if err != nil {
+		return stats, err
+	}
+	opts.throttle = newThrottle(cfg, sink)
+	defer func() {
+		if err := opts.codec.flush(); err != nil {
+			events.Warnf(sink, "STREAM", "%v", err)
+		}
+		if err := opts.checksums.flush(); err != nil {
+			events.Warnf(sink, "STREAM", "%v", err)
+		}
+	}()
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "exclude filter", err)
+	}
+
+	var srcPaths, dstPaths []string
+	for _, path := range paths {
+		switch {
+		case isBelow(cfg.Source, path):
+			srcPaths = append(srcPaths, path)
+		case isBelow(cfg.Target, path):
+			dstPaths = append(dstPaths, path)
+		default:
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Cannot retry", Op: events.OpName, Path: path,
+				Err: fmt.Errorf("not inside %s or %s", cfg.Source, cfg.Target)})
+		}
+	}
+
+	selector.SetTimeOffset(targetTimeOffset(cfg, sink))
+	selector.SetTimeTolerance(opts.nfs.mtimeTolerance())
+	selector.trustSidecars(cfg.SourceChecksums, cfg.Source)
+	copyStart := time.Now()
+	copier := &fileCopier{cfg: cfg, selector: selector, opts: opts, sink: sink, stats: stats}
+	process := func(path string, d os.DirEntry) {
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil {
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Cannot compute relative path for", Op: events.OpName, Path: path, Err: relErr})
+			return
+		}
+		if filter.Excluded(rel, d.IsDir()) {
+			events.Debugf(sink, "STREAM", "Excluding: %s", path)
+			return
+		}
+
+		stats.Files++
+		copier.process(pendingFile{path: path, rel: rel, entry: d})
+	}
+
+	for _, path := range srcPaths {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			events.Warnf(sink, "STREAM", "No longer in the source, not retried: %s", path)
+			continue
+		} else if err != nil {
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Error accessing", Op: events.OpStat, Path: path, Err: err})
+			continue
+		}
+
+		if !info.IsDir() {
+			process(path, fs.FileInfoToDirEntry(info))
+			continue
+		}
+
+		err = walkDir(path, cfg.WalkWorkers, func(path string, d os.DirEntry, err error) error {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if err != nil {
+				stats.Errors++
+				sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
+				return nil
+			}
+			if d.IsDir() {
+				if rel, relErr := filepath.Rel(cfg.Source, path); relErr == nil && rel != "." && filter.Excluded(rel, true) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			process(path, d)
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, strings.ToLower(what), err)
+		}
+	}
+	copier.finishLocked(ctx) // cancellation is reported below
+	stats.CopyDuration = time.Since(copyStart)
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		events.Warnf(sink, "STREAM", "%s interrupted: %v", what, ctxErr)
+		return stats, ctxErr
+	}
+
+	events.Infof(sink, "STREAM", "%s completed: %d files processed, %d copied, %d updated, %d unchanged, %d locked, %d errors",
+		what, stats.Files, stats.Copied, stats.Updated, stats.Skipped, stats.Locked, stats.Errors)
+	reportDeferred(cfg.Target, cfg.MaxTransfer, stats, sink)
+
+	if len(dstPaths) == 0 {
+		return stats, nil
+	}
+	deleteStats, err := retryDeletes(ctx, cfg, dstPaths, sink)
+	stats.Add(deleteStats)
+	return stats, err
+}
+
+// retryDeletes checks the target paths again and removes those still
+// missing from the source
+func retryDeletes(ctx context.Context, cfg *config.Config, dstPaths []string, sink events.EventSink) (*Stats, error) {
+	if !cfg.DeleteMissing {
+		events.Warnf(sink, "DELETE", "Not retrying %d failed deletions without --delete-missing", len(dstPaths))
+		return &Stats{}, nil
+	}
+
+	del, err := newDeleter(cfg, sink)
+	if err != nil {
+		return &Stats{}, err
+	}
+
+	start := time.Now()
+	defer func() {
+		del.stats.DeleteDuration += time.Since(start)
+		if err := del.codec.flush(); err != nil {
+			events.Warnf(sink, "DELETE", "%v", err)
+		}
+	}()
+
+	// The limits of --max-delete do not apply to a handful of files, but a
+	// missing source must not look like a reason to delete them
+	if err := checkSourceForDelete(ctx, cfg); err != nil {
+		events.Warnf(sink, "DELETE", "Cleanup aborted, no files deleted: %v", err)
+		return &del.stats, err
+	}
+
+	for _, dstPath := range dstPaths {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			events.Warnf(sink, "DELETE", "Cleanup interrupted: %v", ctxErr)
+			return &del.stats, ctxErr
+		}
+
+		info, err := os.Lstat(dstPath)
+		switch {
+		case os.IsNotExist(err):
+			events.Debugf(sink, "DELETE", "Already removed: %s", dstPath)
+		case err != nil:
+			del.stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "DELETE", Message: "Error accessing", Op: events.OpStat, Path: dstPath, Err: err})
+		case info.IsDir():
+			if err := del.walk(ctx, dstPath); err != nil {
+				return &del.stats, err
+			}
+		default:
+			del.checkFile(dstPath)
+		}
+	}
+
+	del.report()
+	return &del.stats, nil
+}
+
+// isBelow reports whether path is root or inside it
+func isBelow(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
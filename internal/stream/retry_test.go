@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	root := t.TempDir()
+	srcDir := filepath.Join(root, "source")
+	dstDir := filepath.Join(root, "target")
+	for _, file := range []string{"a.txt", "b.txt", "dir/c.txt"} {
+		path := filepath.Join(srcDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content of "+file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dstDir, "stale.txt"), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", DeleteMissing: true}
+	paths := []string{
+		filepath.Join(srcDir, "a.txt"),
+		filepath.Join(srcDir, "dir"),
+		filepath.Join(srcDir, "gone.txt"),
+		filepath.Join(dstDir, "stale.txt"),
+		filepath.Join(root, "elsewhere.txt"),
+	}
+
+	stats, err := Retry(context.Background(), cfg, paths, events.Nop{})
+	if err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+
+	for _, file := range []string{"a.txt", "dir/c.txt"} {
+		if _, err := os.Stat(filepath.Join(dstDir, file)); err != nil {
+			t.Errorf("Expected %s to be copied: %v", file, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "b.txt")); !os.IsNotExist(err) {
+		t.Error("Expected b.txt, which did not fail, not to be copied")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "stale.txt")); !os.IsNotExist(err) {
+		t.Error("Expected stale.txt to be deleted")
+	}
+	if stats.Copied != 2 || stats.Deleted != 1 || stats.Errors != 1 {
+		t.Errorf("Expected 2 copied, 1 deleted and 1 error, got %+v", stats)
+	}
+}
+
+func TestRetryKeepsTargetWithoutDeleteMissing(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	stale := filepath.Join(dstDir, "stale.txt")
+	if err := os.WriteFile(stale, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime"}
+	if _, err := Retry(context.Background(), cfg, []string{stale}, events.Nop{}); err != nil {
+		t.Fatalf("Retry failed: %v", err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("Expected stale.txt to be kept: %v", err)
+	}
+}
+
+func TestSyncChanges(t *testing.T) {
+	root := t.TempDir()
+	srcDir := filepath.Join(root, "source")
+	dstDir := filepath.Join(root, "target")
+	os.MkdirAll(filepath.Join(srcDir, "new"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "old"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "a.txt"), "changed")
+	createTestFile(t, filepath.Join(srcDir, "new", "b.txt"), "b")
+	createTestFile(t, filepath.Join(srcDir, "untouched.txt"), "untouched")
+	createTestFile(t, filepath.Join(dstDir, "a.txt"), "a")
+	createTestFile(t, filepath.Join(dstDir, "gone.txt"), "gone")
+	createTestFile(t, filepath.Join(dstDir, "old", "c.txt"), "c")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "sha256", DeleteMissing: true}
+	stats, err := SyncChanges(context.Background(), cfg, []string{"a.txt", "gone.txt", "new", "old"}, events.Nop{})
+	if err != nil {
+		t.Fatalf("SyncChanges failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dstDir, "a.txt")); err != nil || string(data) != "changed" {
+		t.Errorf("Expected a.txt to be updated, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "new", "b.txt")); err != nil {
+		t.Errorf("Expected the new directory to be copied: %v", err)
+	}
+	for _, path := range []string{"gone.txt", filepath.Join("old", "c.txt"), "untouched.txt"} {
+		if _, err := os.Stat(filepath.Join(dstDir, path)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be missing from the target: %v", path, err)
+		}
+	}
+	if stats.Files != 2 || stats.Deleted != 2 {
+		t.Errorf("Expected 2 files synced and 2 deleted, got %d and %d", stats.Files, stats.Deleted)
+	}
+}
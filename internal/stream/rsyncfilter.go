@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// maxFilterMerges limits how deeply filter files may merge each other, to
+// stop merge loops
+const maxFilterMerges = 16
+
+// filterRule is a single include or exclude rule of an rsync filter file
+type filterRule struct {
+	include bool
+	// negate inverts the match, from the ! modifier
+	negate bool
+	// dirOnly is set for patterns with a trailing slash
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// matches reports whether the rule matches the slash-separated path rel
+func (r *filterRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.re.MatchString(rel) != r.negate
+}
+
+// excludedByRules reports whether the first rule matching the
+// slash-separated path rel, if any, excludes it
+func (f *Filter) excludedByRules(rel string, isDir bool) bool {
+	for i := range f.rules {
+		if f.rules[i].matches(rel, isDir) {
+			return !f.rules[i].include
+		}
+	}
+	return false
+}
+
+// readFilterFile reads the rsync filter rules in the file at path; depth
+// counts the merges that led to it
+func readFilterFile(path string, depth int) ([]filterRule, error) {
+	if depth > maxFilterMerges {
+		return nil, fmt.Errorf("filter file %s: merged more than %d levels deep", path, maxFilterMerges)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filter file: %w", err)
+	}
+	return parseFilterRules(string(data), path, depth)
+}
+
+// parseFilterRules parses rules in the syntax of rsync's --filter option,
+// one per line: "+ PATTERN" or "include PATTERN", "- PATTERN" or
+// "exclude PATTERN", "merge FILE" or ". FILE" to read the rules of another
+// file at that point, and "!" or "clear" to drop the rules so far. The !
+// modifier, as in "-! PATTERN", inverts the match. Blank lines and lines
+// starting with # or ; are ignored. Rules and modifiers that tell sender
+// and receiver apart, or merge per-directory files, are rejected rather
+// than silently applied differently from rsync.
+func parseFilterRules(data, name string, depth int) ([]filterRule, error) {
+	var rules []filterRule
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		kind, modifiers, arg := splitFilterRule(line)
+		fail := func(format string, args ...any) error {
+			return fmt.Errorf("filter file %s, line %d: %s", name, i+1, fmt.Sprintf(format, args...))
+		}
+
+		var include bool
+		switch kind {
+		case "+", "include":
+			include = true
+		case "-", "exclude":
+		case "!", "clear":
+			rules = nil
+			continue
+		case ".", "merge":
+			if arg == "" {
+				return nil, fail("merge without a file")
+			}
+			merged, err := readFilterFile(arg, depth+1)
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			rules = append(rules, merged...)
+			continue
+		case ":", "dir-merge", "H", "hide", "S", "show", "P", "protect", "R", "risk":
+			return nil, fail("rule %q is not supported", kind)
+		default:
+			return nil, fail("unknown rule %q", kind)
+		}
+
+		rule := filterRule{include: include}
+		for _, m := range modifiers {
+			switch m {
+			case '!':
+				rule.negate = true
+			default:
+				return nil, fail("modifier %q is not supported", m)
+			}
+		}
+		if arg == "" {
+			return nil, fail("rule without a pattern")
+		}
+		re, dirOnly, err := compileRsyncPattern(arg)
+		if err != nil {
+			return nil, fail("invalid pattern %q: %v", arg, err)
+		}
+		rule.re, rule.dirOnly = re, dirOnly
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// splitFilterRule splits a filter rule line into the rule name, its
+// modifiers and its pattern or file. Names are a word, optionally followed
+// by a comma and modifiers, or a single character directly followed by
+// modifiers; a space or an underscore separates them from the argument.
+func splitFilterRule(line string) (kind, modifiers, arg string) {
+	end := strings.IndexAny(line, " _")
+	if end < 0 {
+		end = len(line)
+	} else {
+		arg = line[end+1:]
+	}
+	head := line[:end]
+
+	if name, mods, ok := strings.Cut(head, ","); ok {
+		return name, mods, arg
+	}
+	if strings.ContainsRune("+-HSPR.:!", rune(head[0])) {
+		return head[:1], head[1:], arg
+	}
+	return head, "", arg
+}
+
+// compileRsyncPattern translates an rsync filter pattern into a regular
+// expression matching slash-separated paths relative to the source root.
+// As in rsync, a leading slash anchors the pattern at the root, otherwise
+// it matches the end of the path; a trailing slash only matches
+// directories; "*" and "?" match within a path component, "**" across
+// them, and "dir/***" matches dir as well as everything below it.
+func compileRsyncPattern(pattern string) (re *regexp.Regexp, dirOnly bool, err error) {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	tail := ""
+	if base, ok := strings.CutSuffix(pattern, "/***"); ok {
+		pattern, tail = base, "(?:/.*)?"
+	}
+
+	var expr strings.Builder
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("^(?:.*/)?")
+	}
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				for i+1 < len(runes) && runes[i+1] == '*' {
+					i++
+				}
+				expr.WriteString(".*")
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			// A ] right after the opening bracket is part of the class
+			end := i + 2
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, false, fmt.Errorf("unterminated character class")
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString(tail + "$")
+
+	re, err = regexp.Compile(expr.String())
+	return re, dirOnly, err
+}
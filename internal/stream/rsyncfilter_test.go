@@ -0,0 +1,99 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"testing"
+)
+
+func TestFilterFileRules(t *testing.T) {
+	dir := t.TempDir()
+	merged := filepath.Join(dir, "common.rules")
+	os.WriteFile(merged, []byte("- *.log\n"), 0644)
+
+	rules := filepath.Join(dir, "filter.rules")
+	os.WriteFile(rules, []byte(`# Only docs and sources, without build output
+; and without logs
+merge `+merged+`
+- /src/build/
++ /docs/***
++ /src/
++ /src/**.go
+- /*
++ */
+-! *.txt
+`), 0644)
+
+	filter, err := loadFilter(&config.Config{Exclude: []string{"*.bak"}, FilterFile: rules})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		rel      string
+		isDir    bool
+		excluded bool
+	}{
+		{"docs", true, false},
+		{"docs/guide/intro.md", false, false},
+		{"docs/debug.log", false, true},
+		{"docs/old.bak", false, true},
+		{"src", true, false},
+		{"src/main.go", false, false},
+		{"src/pkg/util/util.go", false, false},
+		{"src/build", true, true},
+		{"src/build/app.go", false, true},
+		{"src/notes.txt", false, false},
+		{"src/README.md", false, true},
+		{"other", true, true},
+		{"other/file.go", false, true},
+		{"top.txt", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rel, func(t *testing.T) {
+			if got := filter.Excluded(filepath.FromSlash(tt.rel), tt.isDir); got != tt.excluded {
+				t.Errorf("Excluded(%q) = %v, expected %v", tt.rel, got, tt.excluded)
+			}
+		})
+	}
+}
+
+func TestParseFilterRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		rules   int
+	}{
+		{"long names and underscore", "exclude *.tmp\ninclude_*.c\r\n", false, 2},
+		{"clear", "- a\n- b\n!\n+ c\n", false, 1},
+		{"modifiers after comma", "-,! *.txt\n", false, 1},
+		{"dir merge", ": .rsync-filter\n", true, 0},
+		{"protect", "P /keep\n", true, 0},
+		{"unknown modifier", "-C\n", true, 0},
+		{"unknown rule", "*.tmp\n", true, 0},
+		{"missing pattern", "-\n", true, 0},
+		{"unterminated class", "- [abc\n", true, 0},
+		{"missing merge file", "merge /nonexistent/rules\n", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseFilterRules(tt.data, "rules", 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilterRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(rules) != tt.rules {
+				t.Errorf("Expected %d rules, got %d", tt.rules, len(rules))
+			}
+		})
+	}
+}
+
+func TestFilterFileMergeLoop(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "loop.rules")
+	os.WriteFile(rules, []byte("merge "+rules+"\n"), 0644)
+	if _, err := loadFilter(&config.Config{FilterFile: rules}); err == nil {
+		t.Error("Expected error for a filter file merging itself")
+	}
+}
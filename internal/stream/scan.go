@@ -0,0 +1,154 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"sort"
+)
+
+// scanLargestCount is the number of largest files kept in a ScanIndex
+const scanLargestCount = 10
+
+// ScanIndex summarizes the source tree before anything is copied
+type ScanIndex struct {
+	Files int
+	Bytes int64
+	// Largest holds the largest files, biggest first
+	Largest []ScannedFile
+	// NeededBytes estimates the additional space the target needs to hold
+	// the new and grown files
+	NeededBytes int64
+}
+
+// ScannedFile is a single file found by Scan
+type ScannedFile struct {
+	Path string
+	Size int64
+}
+
+// Scan walks the source without copying anything and returns an index of
+// the files a sync would process. Files excluded by cfg.Exclude are left
+// out, like in Sync.
+func Scan(ctx context.Context, cfg *config.Config, sink events.EventSink) (*ScanIndex, error) {
+	events.Infof(sink, "SCAN", "Scanning %s", cfg.Source)
+
+	filter, err := loadFilter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &ScanIndex{}
+	var errorCount int
+
+	err = walkRoots(cfg.Source, cfg.Subpaths, cfg.WalkWorkers, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "SCAN", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "SCAN", Message: "Cannot compute relative path for", Op: events.OpName, Path: path, Err: relErr})
+			return nil
+		}
+		if filter.Excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		info, infoErr := d.Info()
+		if infoErr != nil {
+			errorCount++
+			sink.Error(events.ErrorEvent{Component: "SCAN", Message: "Cannot stat", Op: events.OpStat, Path: path, Err: infoErr})
+			return nil
+		}
+
+		size := info.Size()
+		index.Files++
+		index.Bytes += size
+		index.addLargest(ScannedFile{Path: rel, Size: size})
+
+		stored := codec.storedSize(size)
+		if dstInfo, statErr := os.Stat(filepath.Join(cfg.Target, codec.encodeFile(rel))); statErr == nil {
+			if grown := stored - dstInfo.Size(); grown > 0 {
+				index.NeededBytes += grown
+			}
+		} else {
+			index.NeededBytes += stored
+		}
+		return nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events.Infof(sink, "SCAN", "Scan completed: %d files, %s, up to %s to write, %d errors",
+		index.Files, formatBytes(index.Bytes), formatBytes(index.NeededBytes), errorCount)
+	for _, f := range index.Largest {
+		events.Debugf(sink, "SCAN", "Large file: %s (%s)", f.Path, formatBytes(f.Size))
+	}
+
+	return index, nil
+}
+
+// addLargest keeps f if it is among the largest files seen so far
+func (idx *ScanIndex) addLargest(f ScannedFile) {
+	if len(idx.Largest) == scanLargestCount && f.Size <= idx.Largest[len(idx.Largest)-1].Size {
+		return
+	}
+
+	idx.Largest = append(idx.Largest, f)
+	sort.SliceStable(idx.Largest, func(i, j int) bool {
+		return idx.Largest[i].Size > idx.Largest[j].Size
+	})
+	if len(idx.Largest) > scanLargestCount {
+		idx.Largest = idx.Largest[:scanLargestCount]
+	}
+}
+
+// checkFreeSpace returns an error if the filesystem holding target has less
+// free space than the index needs. Platforms without free space
+// information always pass.
+func checkFreeSpace(target string, index *ScanIndex) error {
+	free, ok := freeSpace(target)
+	if !ok || index.NeededBytes <= int64(free) {
+		return nil
+	}
+	return fmt.Errorf("target has %s free, but up to %s are needed",
+		formatBytes(int64(free)), formatBytes(index.NeededBytes))
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,108 @@
+package stream
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.MkdirAll(dstDir, 0755)
+
+	createTestFile(t, filepath.Join(srcDir, "small.txt"), "abc")
+	createTestFile(t, filepath.Join(srcDir, "sub", "big.bin"), strings.Repeat("x", 1000))
+	createTestFile(t, filepath.Join(srcDir, "grown.txt"), strings.Repeat("y", 100))
+	createTestFile(t, filepath.Join(srcDir, "skip.tmp"), strings.Repeat("z", 5000))
+
+	// grown.txt already exists with 40 bytes, so it needs 60 more
+	createTestFile(t, filepath.Join(dstDir, "grown.txt"), strings.Repeat("y", 40))
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, Exclude: []string{"*.tmp"}}
+	index, err := Scan(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if index.Files != 3 {
+		t.Errorf("Expected 3 files, got %d", index.Files)
+	}
+	if index.Bytes != 1103 {
+		t.Errorf("Expected 1103 bytes, got %d", index.Bytes)
+	}
+	if index.NeededBytes != 3+1000+60 {
+		t.Errorf("Expected 1063 needed bytes, got %d", index.NeededBytes)
+	}
+	if len(index.Largest) != 3 || index.Largest[0].Path != filepath.Join("sub", "big.bin") {
+		t.Errorf("Expected big.bin to be the largest file, got %v", index.Largest)
+	}
+}
+
+func TestScanIndexLargest(t *testing.T) {
+	index := &ScanIndex{}
+	for i := 1; i <= scanLargestCount+5; i++ {
+		index.addLargest(ScannedFile{Path: "f", Size: int64(i)})
+	}
+
+	if len(index.Largest) != scanLargestCount {
+		t.Fatalf("Expected %d largest files, got %d", scanLargestCount, len(index.Largest))
+	}
+	if index.Largest[0].Size != scanLargestCount+5 || index.Largest[scanLargestCount-1].Size != 6 {
+		t.Errorf("Unexpected largest files: %v", index.Largest)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := checkFreeSpace(dir, &ScanIndex{NeededBytes: 1}); err != nil {
+		t.Errorf("Expected 1 byte to fit, got %v", err)
+	}
+
+	err := checkFreeSpace(dir, &ScanIndex{NeededBytes: math.MaxInt64})
+	if _, ok := freeSpace(dir); ok && err == nil {
+		t.Error("Expected an error when the target cannot hold the files")
+	} else if !ok && runtime.GOOS == "linux" {
+		t.Error("Expected free space information on Linux")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1024:            "1.0 KiB",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 40:         "3.0 TiB",
+	}
+	for n, expected := range tests {
+		if got := formatBytes(n); got != expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", n, got, expected)
+		}
+	}
+}
+
+func TestProgressTrackerETA(t *testing.T) {
+	start := time.Now()
+	p := &progressTracker{index: &ScanIndex{Files: 4, Bytes: 400}, start: start}
+	p.bytes = 100
+
+	if got := p.percent(); got != 25 {
+		t.Errorf("Expected 25%%, got %v", got)
+	}
+	// A quarter took 10 seconds, so three quarters take 30 more
+	if got := p.eta(start.Add(10 * time.Second)); got != 30*time.Second {
+		t.Errorf("Expected ETA 30s, got %v", got)
+	}
+}
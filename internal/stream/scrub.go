@@ -0,0 +1,198 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"sort"
+	"strings"
+	"time"
+)
+
+// scrubEntry is what a scrub manifest records of a target file
+type scrubEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// ScrubResult is what a scrub found
+type ScrubResult struct {
+	// Checked is the number of files rehashed against the manifest
+	Checked int
+	// Added and Changed are the files new to the manifest or modified
+	// since it was written, whose checksums were recorded; Removed are
+	// those no longer in the target
+	Added   int
+	Changed int
+	Removed int
+	// Corrupted are the files whose contents differ from the manifest
+	// while their size and modification time do not, relative to the
+	// target
+	Corrupted []string
+	// Errors is the number of files that could not be hashed
+	Errors int
+}
+
+func (r *ScrubResult) String() string {
+	return fmt.Sprintf("%d checked, %d corrupted, %d added, %d changed, %d removed, %d errors",
+		r.Checked, len(r.Corrupted), r.Added, r.Changed, r.Removed, r.Errors)
+}
+
+// Scrub rehashes every file in cfg.Target and compares it with the
+// checksum stored in the manifest cfg.Manifest, to find files damaged by
+// the storage since. Like the manifest of a compressed target, it is a
+// JSON object whose files are keyed by their slash-separated path relative
+// to the target.
+//
+// A file whose size or modification time changed was modified on purpose
+// and its new checksum is recorded, as are new files; files no longer in
+// the target are dropped. Corrupted files keep their recorded checksum, so
+// they are reported again until they are restored. The manifest is written
+// when it does not exist yet and after every scrub.
+func Scrub(ctx context.Context, cfg *config.Config, sink events.EventSink) (*ScrubResult, error) {
+	files, err := loadScrubManifest(cfg.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	events.Infof(sink, "SCRUB", "Rehashing %s against %d files in %s", cfg.Target, len(files), cfg.Manifest)
+
+	found, err := listFiles(ctx, cfg.Target, nil, cfg.WalkWorkers, nil)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target listing", err)
+	}
+	// The manifest may be kept in the target itself
+	manifestPath, _ := filepath.Abs(cfg.Manifest)
+	target, _ := filepath.Abs(cfg.Target)
+	for rel := range found {
+		if skipScrub(rel) || filepath.Join(target, rel) == manifestPath {
+			delete(found, rel)
+		}
+	}
+
+	rels := make([]string, 0, len(found))
+	for rel := range found {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	result := &ScrubResult{}
+	// The files hashed so far are recorded even if the scrub is stopped
+	var stopErr error
+	for _, rel := range rels {
+		if stopErr = ctx.Err(); stopErr != nil {
+			break
+		}
+
+		path := filepath.Join(cfg.Target, rel)
+		info := found[rel]
+		sum, err := calculateSHA256(path)
+		if err != nil {
+			result.Errors++
+			sink.Error(events.ErrorEvent{Component: "SCRUB", Message: "Failed to hash", Op: events.OpCompare, Path: path, Err: errors.NewFileError(errors.ErrCannotOpenFile, path, err)})
+			continue
+		}
+
+		key := filepath.ToSlash(rel)
+		entry := scrubEntry{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+		prev, ok := files[key]
+		switch {
+		case !ok:
+			result.Added++
+		case prev.Size != entry.Size || !prev.ModTime.Equal(entry.ModTime):
+			result.Changed++
+			events.Debugf(sink, "SCRUB", "Modified since the last scrub: %s", path)
+		case prev.SHA256 != entry.SHA256:
+			result.Checked++
+			result.Corrupted = append(result.Corrupted, rel)
+			err := fmt.Errorf("sha256 is %s, the manifest records %s", entry.SHA256, prev.SHA256)
+			sink.Error(events.ErrorEvent{Component: "SCRUB", Message: "Corrupted file", Op: events.OpCompare, Path: path, Err: errors.NewFileError(errors.ErrBitRot, path, err)})
+			continue
+		default:
+			result.Checked++
+		}
+		files[key] = entry
+	}
+
+	if stopErr == nil {
+		for key := range files {
+			if _, ok := found[filepath.FromSlash(key)]; !ok {
+				delete(files, key)
+				result.Removed++
+			}
+		}
+	}
+
+	if err := saveScrubManifest(cfg.Manifest, files); err != nil {
+		return result, err
+	}
+	return result, stopErr
+}
+
+// skipScrub reports whether the target file rel belongs to snc rather
+// than the mirror
+func skipScrub(rel string) bool {
+	for _, dir := range []string{stagingDir, writeStagingDir, objectsDir} {
+		if strings.HasPrefix(rel, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadScrubManifest reads the scrub manifest at path; a missing one yields
+// no files
+func loadScrubManifest(path string) (map[string]scrubEntry, error) {
+	files := map[string]scrubEntry{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return files, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var stored struct {
+		Files map[string]scrubEntry `json:"files"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("damaged manifest %s: %w", path, err)
+	}
+	if stored.Files != nil {
+		files = stored.Files
+	}
+	return files, nil
+}
+
+// saveScrubManifest writes files to the scrub manifest at path. The file
+// is replaced as a whole, so it never holds a partial manifest.
+func saveScrubManifest(path string, files map[string]scrubEntry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(struct {
+		Files map[string]scrubEntry `json:"files"`
+	}{files})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	dstDir := t.TempDir()
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "rot.txt"), "original")
+	createTestFile(t, filepath.Join(dstDir, "edited.txt"), "original")
+	createTestFile(t, filepath.Join(dstDir, "sub", "gone.txt"), "gone")
+	cfg := &config.Config{Target: dstDir, Manifest: filepath.Join(dstDir, "manifest.json")}
+
+	result, err := Scrub(context.Background(), cfg, &codeSink{})
+	if err != nil {
+		t.Fatalf("Scrub failed: %v", err)
+	}
+	if result.Added != 3 || result.Checked != 0 {
+		t.Errorf("Expected the first scrub to record 3 files, got %s", result)
+	}
+
+	// Damaged in place, with the same size and modification time
+	info, _ := os.Stat(filepath.Join(dstDir, "rot.txt"))
+	os.WriteFile(filepath.Join(dstDir, "rot.txt"), []byte("0riginal"), 0644)
+	os.Chtimes(filepath.Join(dstDir, "rot.txt"), info.ModTime(), info.ModTime())
+	os.WriteFile(filepath.Join(dstDir, "edited.txt"), []byte("edited on purpose"), 0644)
+	os.Remove(filepath.Join(dstDir, "sub", "gone.txt"))
+
+	for i := 0; i < 2; i++ {
+		sink := &codeSink{}
+		result, err = Scrub(context.Background(), cfg, sink)
+		if err != nil {
+			t.Fatalf("Scrub failed: %v", err)
+		}
+		if len(result.Corrupted) != 1 || result.Corrupted[0] != "rot.txt" {
+			t.Errorf("Scrub %d: expected rot.txt to be corrupted, got %v", i+2, result.Corrupted)
+		}
+		if len(sink.codes) != 1 || sink.codes[0] != errors.ErrBitRot.Code() {
+			t.Errorf("Scrub %d: expected a bit rot error, got %v", i+2, sink.codes)
+		}
+	}
+	// Counted by the second scrub only
+	if result.Changed != 0 || result.Removed != 0 || result.Checked != 2 {
+		t.Errorf("Expected the modified and removed files to be recorded once, got %s", result)
+	}
+}
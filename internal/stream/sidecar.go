@@ -0,0 +1,147 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sidecarExt is the extension of a file holding the SHA-256 of the
+	// file it is named after, as written by `sha256sum FILE > FILE.sha256`
+	sidecarExt = ".sha256"
+	// sumsName is the sha256sum manifest of a directory, listing files
+	// relative to it
+	sumsName = "SHA256SUMS"
+)
+
+// sidecarChecksums provides the checksums the source tree ships for its
+// files, so the sha256 strategy can trust them instead of hashing the
+// source. A sidecar or manifest older than the file it describes is
+// ignored, since the file may have changed since.
+type sidecarChecksums struct {
+	root string
+
+	mu sync.Mutex
+	// manifests caches the parsed SHA256SUMS of every directory looked at,
+	// nil for directories without one
+	manifests map[string]*sumsManifest
+}
+
+// sumsManifest is a parsed SHA256SUMS file
+type sumsManifest struct {
+	modTime time.Time
+	sums    map[string]string
+}
+
+func newSidecarChecksums(root string) *sidecarChecksums {
+	return &sidecarChecksums{root: filepath.Clean(root), manifests: map[string]*sumsManifest{}}
+}
+
+// lookup returns the SHA-256 the source tree records for srcPath, from
+// srcPath.sha256 or the SHA256SUMS of its directory or a directory above
+// it, up to the root
+func (c *sidecarChecksums) lookup(srcPath string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return "", false
+	}
+
+	if sum, ok := readSidecar(srcPath+sidecarExt, info.ModTime()); ok {
+		return sum, true
+	}
+
+	for dir := filepath.Dir(srcPath); ; dir = filepath.Dir(dir) {
+		rel, err := filepath.Rel(dir, srcPath)
+		if err != nil || !filepath.IsLocal(rel) {
+			return "", false
+		}
+		if m := c.manifest(dir); m != nil && !m.modTime.Before(info.ModTime()) {
+			if sum, ok := m.sums[filepath.ToSlash(rel)]; ok {
+				return sum, true
+			}
+		}
+		if dir == c.root || dir == filepath.Dir(dir) {
+			return "", false
+		}
+	}
+}
+
+// readSidecar reads the checksum in the sidecar file name, unless it is
+// older than modTime
+func readSidecar(name string, modTime time.Time) (string, bool) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.ModTime().Before(modTime) {
+		return "", false
+	}
+
+	// Either a bare checksum or a line of sha256sum output
+	line, err := bufio.NewReader(io.LimitReader(f, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, `\`))
+	if len(fields) == 0 {
+		return "", false
+	}
+	sum := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return "", false
+	}
+	return sum, true
+}
+
+// manifest returns the parsed SHA256SUMS of dir, or nil if it has none or
+// it cannot be read
+func (c *sidecarChecksums) manifest(dir string) *sumsManifest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m, ok := c.manifests[dir]; ok {
+		return m
+	}
+	m := readSumsManifest(filepath.Join(dir, sumsName))
+	c.manifests[dir] = m
+	return m
+}
+
+// readSumsManifest parses the sha256sum manifest name; lines that are not
+// sha256sum output are skipped
+func readSumsManifest(name string) *sumsManifest {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+
+	m := &sumsManifest{modTime: info.ModTime(), sums: map[string]string{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		sum, rel, ok := parseChecksumLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		m.sums[path.Clean(rel)] = strings.ToLower(sum)
+	}
+	if scanner.Err() != nil {
+		return nil
+	}
+	return m
+}
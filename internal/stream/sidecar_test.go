@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSHA256StrategySidecars(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	for _, rel := range []string{"sidecar.txt", "stale.txt", filepath.Join("sub", "listed.txt"), "plain.txt"} {
+		createTestFile(t, filepath.Join(srcDir, rel), "content")
+		createTestFile(t, filepath.Join(dstDir, rel), "content")
+	}
+
+	// The recorded checksums are not those of the contents, so a file
+	// compared with them needs an update
+	other := sha256.Sum256([]byte("other"))
+	otherSum := hex.EncodeToString(other[:])
+	createTestFile(t, filepath.Join(srcDir, "sidecar.txt.sha256"), otherSum+"  sidecar.txt\n")
+	createTestFile(t, filepath.Join(srcDir, "stale.txt.sha256"), otherSum+"\n")
+	createTestFile(t, filepath.Join(srcDir, "SHA256SUMS"), otherSum+"  ./sub/listed.txt\n")
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(srcDir, "stale.txt.sha256"), past, past)
+
+	strategy := &SHA256Strategy{}
+	selector := &StrategySelector{fallback: strategy}
+	selector.trustSidecars(true, srcDir)
+
+	tests := []struct {
+		rel         string
+		needsUpdate bool
+	}{
+		{"sidecar.txt", true},
+		{filepath.Join("sub", "listed.txt"), true},
+		// Older than the file it describes
+		{"stale.txt", false},
+		{"plain.txt", false},
+	}
+	for _, tt := range tests {
+		needsUpdate, err := strategy.NeedsUpdate(filepath.Join(srcDir, tt.rel), filepath.Join(dstDir, tt.rel))
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", tt.rel, err)
+		}
+		if needsUpdate != tt.needsUpdate {
+			t.Errorf("Expected update needed %v for %s, got %v", tt.needsUpdate, tt.rel, needsUpdate)
+		}
+	}
+}
+
+func TestSidecarsOnEncodedTargets(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	keyFile := filepath.Join(tempDir, "key")
+	os.MkdirAll(srcDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "file.txt"), "content")
+	createTestFile(t, keyFile, strings.Repeat("0123456789abcdef", 4))
+
+	configs := map[string]*config.Config{
+		"encrypted":  {EncryptKey: keyFile},
+		"compressed": {StoreCompressed: config.CompressZstd},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			os.Remove(filepath.Join(srcDir, "file.txt.sha256"))
+			cfg.Source, cfg.Target, cfg.UpdateMethod = srcDir, filepath.Join(tempDir, name), "sha256"
+			cfg.SourceChecksums = true
+			if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+
+			// The sidecar is trusted instead of hashing the source, so its
+			// checksum of other contents makes the copy out of date
+			other := sha256.Sum256([]byte("other"))
+			createTestFile(t, filepath.Join(srcDir, "file.txt.sha256"), hex.EncodeToString(other[:])+"  file.txt\n")
+			rec := &recordingSink{}
+			if _, err := Sync(context.Background(), cfg, rec); err != nil {
+				t.Fatalf("Second sync failed: %v", err)
+			}
+			copied := 0
+			for _, ev := range rec.copied {
+				if ev.Path == "file.txt" {
+					copied++
+				}
+			}
+			if copied != 1 {
+				t.Errorf("Expected file.txt to be compared with its sidecar and copied, got %v", rec.copied)
+			}
+		})
+	}
+}
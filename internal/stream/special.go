@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+)
+
+// isSpecial reports whether mode is that of a device, FIFO or socket,
+// which cannot be copied by reading them
+func isSpecial(mode os.FileMode) bool {
+	return mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe|os.ModeSocket) != 0
+}
+
+// processSpecialFile handles the device, FIFO or socket srcPath according
+// to opts.specialFiles: it is left out, reported as an error, or created
+// again in the target with the same type and device number
+func processSpecialFile(rel, srcPath, dstPath string, opts *copyOptions, sink events.EventSink) (fileResult, error) {
+	switch opts.specialFiles {
+	case config.SpecialFilesError:
+		return fileFailed, &opError{op: events.OpCopy, err: errors.NewFileError(errors.ErrSpecialFile, srcPath, nil)}
+	case config.SpecialFilesRecreate:
+	default:
+		events.Infof(sink, "STREAM", "Skipping special file: %s", srcPath)
+		return fileSpecial, nil
+	}
+
+	srcInfo, err := os.Lstat(srcPath)
+	if err != nil {
+		return fileFailed, &opError{op: events.OpStat, err: errors.NewFileStatError(srcPath, err)}
+	}
+
+	dstInfo, err := os.Lstat(dstPath)
+	update := err == nil
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fileFailed, &opError{op: events.OpStat, err: errors.NewFileStatError(dstPath, err)}
+	case dstInfo.Mode().Type() == srcInfo.Mode().Type() && deviceNumber(dstInfo) == deviceNumber(srcInfo):
+		sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+		return fileUnchanged, nil
+	}
+
+	if err := opts.attrs.mkdirAll(filepath.Dir(dstPath)); err != nil {
+		return fileFailed, errors.NewSyncError(errors.ErrCannotCreateParentDir, dstPath, err)
+	}
+	if update {
+		if err := os.Remove(dstPath); err != nil {
+			return fileFailed, errors.NewFileError(errors.ErrCannotCreateFile, dstPath, err)
+		}
+	}
+	if err := mknod(dstPath, srcInfo); err != nil {
+		return fileFailed, errors.NewFileError(errors.ErrCannotCreateFile, dstPath, err)
+	}
+	if err := opts.attrs.applyFile(dstPath); err != nil {
+		return fileFailed, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(srcPath).WithTargetPath(dstPath), "ownership and permission overrides", err)
+	}
+
+	events.Debugf(sink, "STREAM", "Recreated special file: %s", dstPath)
+	if update {
+		sink.FileCopied(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Update: true, Itemize: itemizeSpecialUpdate})
+		return fileUpdated, nil
+	}
+	sink.FileCopied(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Itemize: itemizeNewSpecial})
+	return fileCopied, nil
+}
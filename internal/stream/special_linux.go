@@ -0,0 +1,37 @@
+//go:build linux
+
+package stream
+
+import (
+	"os"
+	"syscall"
+)
+
+// mknod creates a device, FIFO or socket at path like the one described
+// by info. Devices can only be created with root privileges.
+func mknod(path string, info os.FileInfo) error {
+	mode := uint32(info.Mode().Perm())
+	switch t := info.Mode().Type(); {
+	case t&os.ModeNamedPipe != 0:
+		mode |= syscall.S_IFIFO
+	case t&os.ModeSocket != 0:
+		mode |= syscall.S_IFSOCK
+	case t&os.ModeCharDevice != 0:
+		mode |= syscall.S_IFCHR
+	default:
+		mode |= syscall.S_IFBLK
+	}
+	if err := syscall.Mknod(path, mode, int(deviceNumber(info))); err != nil {
+		return &os.PathError{Op: "mknod", Path: path, Err: err}
+	}
+	// The umask applies to mknod
+	return os.Chmod(path, info.Mode().Perm())
+}
+
+// deviceNumber returns the device a device file refers to, or 0
+func deviceNumber(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Rdev)
+	}
+	return 0
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package stream
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// mknod is not supported on platforms other than Linux
+func mknod(path string, info os.FileInfo) error {
+	return fmt.Errorf("recreating special files is not supported on %s", runtime.GOOS)
+}
+
+// deviceNumber is not known on platforms other than Linux
+func deviceNumber(info os.FileInfo) uint64 {
+	return 0
+}
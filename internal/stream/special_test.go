@@ -0,0 +1,69 @@
+//go:build linux
+
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"syscall"
+	"testing"
+)
+
+func TestSyncSpecialFiles(t *testing.T) {
+	tests := []struct {
+		policy        string
+		expectSpecial int
+		expectCopied  int
+		expectCode    errors.Code
+	}{
+		{policy: config.SpecialFilesSkip, expectSpecial: 1, expectCopied: 1},
+		{policy: config.SpecialFilesError, expectCopied: 1, expectCode: "special_file"},
+		{policy: config.SpecialFilesRecreate, expectCopied: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srcDir, dstDir := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Opening the FIFO for reading would block the sync
+			if err := syscall.Mkfifo(filepath.Join(srcDir, "pipe"), 0640); err != nil {
+				t.Skipf("Cannot create a FIFO: %v", err)
+			}
+
+			cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", SpecialFiles: tt.policy}
+			sink := &codeSink{}
+			stats, err := Sync(context.Background(), cfg, sink)
+			if err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+			if stats.Special != tt.expectSpecial || stats.Copied != tt.expectCopied {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+			if tt.expectCode != "" && (len(sink.codes) != 1 || sink.codes[0] != tt.expectCode) {
+				t.Errorf("Expected a %s error, got %v", tt.expectCode, sink.codes)
+			}
+
+			info, err := os.Lstat(filepath.Join(dstDir, "pipe"))
+			if tt.policy != config.SpecialFilesRecreate {
+				if !os.IsNotExist(err) {
+					t.Errorf("Expected no FIFO in the target, got %v", err)
+				}
+				return
+			}
+			if err != nil || info.Mode().Type() != os.ModeNamedPipe || info.Mode().Perm() != 0640 {
+				t.Fatalf("Expected a FIFO with mode 0640 in the target, got %v, %v", info, err)
+			}
+
+			// A recreated FIFO is up to date
+			stats, err = Sync(context.Background(), cfg, sink)
+			if err != nil || stats.Skipped != 2 || stats.Copied != 0 {
+				t.Errorf("Expected both files unchanged, got %+v, %v", stats, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package stream
+
+import "sync"
+
+// SourceIndex holds the source files found by the walk of a sync, so the
+// delete phase that follows can look them up instead of checking the
+// source again. It only answers for files that were found: a file missing
+// from the index, e.g. below an excluded or unreadable directory, is still
+// looked up in the source before its target copy is deleted. A file
+// removed from the source after the walk keeps its target copy until the
+// next run.
+type SourceIndex struct {
+	mu    sync.RWMutex
+	files map[string]struct{}
+}
+
+// NewSourceIndex returns an empty index to be filled by SyncIndexed or
+// SyncTargets
+func NewSourceIndex() *SourceIndex {
+	return &SourceIndex{files: make(map[string]struct{})}
+}
+
+// add records the source file rel, relative to the source root
+func (x *SourceIndex) add(rel string) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	x.files[rel] = struct{}{}
+	x.mu.Unlock()
+}
+
+// has reports whether the source file rel was found; always false for a
+// nil index
+func (x *SourceIndex) has(rel string) bool {
+	if x == nil {
+		return false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	_, ok := x.files[rel]
+	return ok
+}
+
+// Len returns the number of files in the index
+func (x *SourceIndex) Len() int {
+	if x == nil {
+		return 0
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.files)
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+)
+
+// stagingDir is the directory in the target root that holds the files
+// deleted by a run with StageDeletes, under their target paths,
+// until the run confirms or restores them
+const stagingDir = ".snc-deleted"
+
+// isStagingDir reports whether dstPath is one of the staging directories
+// of target, for deletes or writes
+func isStagingDir(target, dstPath string) bool {
+	return dstPath == filepath.Join(target, stagingDir) || dstPath == filepath.Join(target, writeStagingDir)
+}
+
+// stageDelete moves the target file dstPath into the staging directory
+// instead of deleting it
+func stageDelete(target, dstPath string) error {
+	rel, err := filepath.Rel(target, dstPath)
+	if err != nil {
+		return err
+	}
+	staged := filepath.Join(target, stagingDir, rel)
+	if err := os.MkdirAll(filepath.Dir(staged), 0700); err != nil {
+		return err
+	}
+	return os.Rename(dstPath, staged)
+}
+
+// CommitDeletes deletes the files staged in cfg.Target for good, once the
+// run that staged them completed without errors
+func CommitDeletes(cfg *config.Config, sink events.EventSink) error {
+	root := filepath.Join(cfg.Target, stagingDir)
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return nil
+	}
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		codec.removed(filepath.Join(cfg.Target, rel))
+		n++
+		return nil
+	})
+	if err == nil {
+		err = os.RemoveAll(root)
+	}
+	if flushErr := codec.flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return err
+	}
+	events.Infof(sink, "DELETE", "Confirmed the deletion of %d files from %s", n, cfg.Target)
+	return nil
+}
+
+// RestoreDeletes moves the files staged in cfg.Target back into place, for
+// a run that did not complete without errors or never finished. A file
+// whose path was written again in the meantime is kept and the staged
+// copy dropped. It returns the number of files restored.
+func RestoreDeletes(cfg *config.Config, sink events.EventSink) (int, error) {
+	root := filepath.Join(cfg.Target, stagingDir)
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	n := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		dstPath := filepath.Join(cfg.Target, rel)
+		if _, err := os.Lstat(dstPath); err == nil {
+			events.Debugf(sink, "DELETE", "Keeping the new %s instead of the staged copy", dstPath)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(path, dstPath); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err == nil {
+		err = os.RemoveAll(root)
+	}
+	if err != nil {
+		return n, err
+	}
+	if n > 0 {
+		events.Warnf(sink, "DELETE", "Restored %d deleted files in %s, the deletions were not confirmed", n, cfg.Target)
+	}
+	return n, nil
+}
@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"testing"
+)
+
+func TestStageDeletes(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(srcDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "kept.txt"), "kept")
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "kept.txt"), "kept")
+	createTestFile(t, filepath.Join(dstDir, "sub", "stale.txt"), "stale")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		UpdateMethod:  "modtime",
+		DeleteMissing: true,
+		StageDeletes:  true,
+	}
+	stale := filepath.Join(dstDir, "sub", "stale.txt")
+	staged := filepath.Join(dstDir, stagingDir, "sub", "stale.txt")
+
+	if _, err := DeleteMissing(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be moved away: %v", stale, err)
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Fatalf("Expected the file to be staged: %v", err)
+	}
+
+	// The staging directory itself is never deleted as missing
+	if _, err := DeleteMissing(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if _, err := os.Stat(staged); err != nil {
+		t.Fatalf("Expected the staged file to be left alone: %v", err)
+	}
+
+	n, err := RestoreDeletes(cfg, &recordingSink{})
+	if err != nil {
+		t.Fatalf("RestoreDeletes failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 restored file, got %d", n)
+	}
+	if data, err := os.ReadFile(stale); err != nil || string(data) != "stale" {
+		t.Errorf("Expected the file to be restored, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, stagingDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging directory to be removed: %v", err)
+	}
+
+	if _, err := DeleteMissing(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if err := CommitDeletes(cfg, &recordingSink{}); err != nil {
+		t.Fatalf("CommitDeletes failed: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to stay deleted: %v", stale, err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, stagingDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging directory to be removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "kept.txt")); err != nil {
+		t.Errorf("Expected kept.txt to be left alone: %v", err)
+	}
+}
+
+func TestStageWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(filepath.Join(srcDir, "new"), 0755)
+	os.MkdirAll(dstDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "updated.txt"), "new contents")
+	createTestFile(t, filepath.Join(srcDir, "new", "file.txt"), "new")
+	createTestFile(t, filepath.Join(dstDir, "updated.txt"), "old")
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		UpdateMethod: "size",
+		StageWrites:  true,
+	}
+	if _, err := Sync(context.Background(), cfg, &recordingSink{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Nothing changes in the target until the swap
+	if data, _ := os.ReadFile(filepath.Join(dstDir, "updated.txt")); string(data) != "old" {
+		t.Errorf("Expected the target file to be unchanged before the swap, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "new")); !os.IsNotExist(err) {
+		t.Errorf("Expected the new directory to be staged only: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, writeStagingDir, "new", "file.txt")); err != nil {
+		t.Errorf("Expected the new file to be staged: %v", err)
+	}
+
+	if err := SwapStagedWrites(cfg, &recordingSink{}); err != nil {
+		t.Fatalf("SwapStagedWrites failed: %v", err)
+	}
+	for path, expected := range map[string]string{"updated.txt": "new contents", filepath.Join("new", "file.txt"): "new"} {
+		if data, err := os.ReadFile(filepath.Join(dstDir, path)); err != nil || string(data) != expected {
+			t.Errorf("Expected %s to be swapped into place, got %q, %v", path, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, writeStagingDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging directory to be removed: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package stream
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// slowestFiles is the number of slowest files kept in Stats
+const slowestFiles = 5
+
+// FileTiming is how long syncing a single file took
+type FileTiming struct {
+	// Path is relative to the source root
+	Path     string
+	Duration time.Duration
+	// Bytes written to the target
+	Bytes int64
+}
+
+// Stats summarizes what a sync or cleanup did
+type Stats struct {
+	// Files is the number of source files found by the walk
+	Files int
+	// Copied counts new files written to the target
+	Copied int
+	// Updated counts existing target files that were overwritten, or whose
+	// metadata alone was updated
+	Updated int
+	// Skipped counts unchanged files that were already up to date
+	Skipped int
+	// Locked counts files left out because another process held them
+	Locked int
+	// Special counts devices, FIFOs and sockets left out of the copy
+	Special int
+	// Dangling counts symbolic links to missing files left out of the copy
+	Dangling int
+	// Linked counts files hardlinked to a previous snapshot instead of
+	// copied
+	Linked int
+	// Protected counts files left unchanged because a .snc-protect marker
+	// protects their target directory
+	Protected int
+	// Deferred counts files left for the next run once the transfer limit
+	// was reached, and DeferredBytes their size
+	Deferred      int
+	DeferredBytes int64
+	// Checked is the number of target files checked for deletion
+	Checked int
+	Deleted int
+	Errors  int
+	// Bytes written to the target
+	Bytes int64
+
+	ValidateDuration time.Duration
+	ScanDuration     time.Duration
+	CopyDuration     time.Duration
+	DeleteDuration   time.Duration
+	// FileDuration is the total time spent on single files, from
+	// comparing to copying
+	FileDuration time.Duration
+	// Slowest holds the files that took longest, slowest first
+	Slowest []FileTiming
+}
+
+// Add accumulates the counters and durations of other into s
+func (s *Stats) Add(other *Stats) {
+	s.Files += other.Files
+	s.Copied += other.Copied
+	s.Updated += other.Updated
+	s.Skipped += other.Skipped
+	s.Locked += other.Locked
+	s.Special += other.Special
+	s.Dangling += other.Dangling
+	s.Linked += other.Linked
+	s.Protected += other.Protected
+	s.Deferred += other.Deferred
+	s.DeferredBytes += other.DeferredBytes
+	s.Checked += other.Checked
+	s.Deleted += other.Deleted
+	s.Errors += other.Errors
+	s.Bytes += other.Bytes
+	s.ValidateDuration += other.ValidateDuration
+	s.ScanDuration += other.ScanDuration
+	s.CopyDuration += other.CopyDuration
+	s.DeleteDuration += other.DeleteDuration
+	s.FileDuration += other.FileDuration
+	for _, t := range other.Slowest {
+		s.recordTiming(t)
+	}
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf("%d files, %d copied, %d updated, %d unchanged, %d locked, %d deleted, %d errors, %s written",
+		s.Files, s.Copied, s.Updated, s.Skipped, s.Locked, s.Deleted, s.Errors, formatBytes(s.Bytes))
+}
+
+// Throughput returns the bytes written per second of copying
+func (s *Stats) Throughput() float64 {
+	if s.CopyDuration <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.CopyDuration.Seconds()
+}
+
+// AverageFileDuration returns the average time spent on a single file
+func (s *Stats) AverageFileDuration() time.Duration {
+	if s.Files == 0 {
+		return 0
+	}
+	return s.FileDuration / time.Duration(s.Files)
+}
+
+// Timing describes how long each phase took, the copy throughput and the
+// average time per file
+func (s *Stats) Timing() string {
+	return fmt.Sprintf("validate %s, scan %s, copy %s, delete %s, %s/s, %s per file",
+		roundDuration(s.ValidateDuration), roundDuration(s.ScanDuration),
+		roundDuration(s.CopyDuration), roundDuration(s.DeleteDuration),
+		formatBytes(int64(s.Throughput())), roundDuration(s.AverageFileDuration()))
+}
+
+// SlowestString lists the slowest files with their durations and sizes
+func (s *Stats) SlowestString() string {
+	parts := make([]string, len(s.Slowest))
+	for i, t := range s.Slowest {
+		parts[i] = fmt.Sprintf("%s (%s, %s)", t.Path, roundDuration(t.Duration), formatBytes(t.Bytes))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// roundDuration rounds d for display to milliseconds, or to microseconds
+// under a second
+func roundDuration(d time.Duration) time.Duration {
+	if d >= time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// recordTiming adds the time spent on a single file and keeps it if it is
+// among the slowest
+func (s *Stats) recordTiming(t FileTiming) {
+	i := sort.Search(len(s.Slowest), func(i int) bool { return s.Slowest[i].Duration < t.Duration })
+	if i >= slowestFiles {
+		return
+	}
+	s.Slowest = append(s.Slowest, FileTiming{})
+	copy(s.Slowest[i+1:], s.Slowest[i:])
+	s.Slowest[i] = t
+	if len(s.Slowest) > slowestFiles {
+		s.Slowest = s.Slowest[:slowestFiles]
+	}
+}
+
+// record counts the result of processing a single file
+func (s *Stats) record(result fileResult, bytes int64) {
+	switch result {
+	case fileCopied:
+		s.Copied++
+	case fileUpdated, fileMetadataUpdated:
+		s.Updated++
+	case fileUnchanged:
+		s.Skipped++
+	case fileSpecial:
+		s.Special++
+	case fileDangling:
+		s.Dangling++
+	case fileLinked:
+		s.Linked++
+	case fileProtected:
+		s.Protected++
+	case fileDeferred:
+		s.Deferred++
+		s.DeferredBytes += bytes
+		return
+	}
+	s.Bytes += bytes
+}
@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncStats(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(srcDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "a.txt"), "aaaa")
+	createTestFile(t, filepath.Join(srcDir, "b.txt"), "bb")
+	createTestFile(t, filepath.Join(srcDir, "c.txt"), "c")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime"}
+
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats.Files != 3 || stats.Copied != 3 || stats.Updated != 0 || stats.Skipped != 0 || stats.Bytes != 7 {
+		t.Errorf("First sync: unexpected stats %+v", *stats)
+	}
+
+	// Update one file and remove another
+	later := time.Now().Add(time.Hour)
+	createTestFile(t, filepath.Join(srcDir, "a.txt"), "AAAAA")
+	os.Chtimes(filepath.Join(srcDir, "a.txt"), later, later)
+	os.Remove(filepath.Join(srcDir, "c.txt"))
+
+	stats, err = Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if stats.Files != 2 || stats.Copied != 0 || stats.Updated != 1 || stats.Skipped != 1 || stats.Bytes != 5 {
+		t.Errorf("Second sync: unexpected stats %+v", *stats)
+	}
+	if stats.CopyDuration <= 0 {
+		t.Error("Expected copy duration to be recorded")
+	}
+	if len(stats.Slowest) != 2 || stats.FileDuration <= 0 {
+		t.Errorf("Expected the time spent on 2 files to be recorded, got %v", stats.Slowest)
+	}
+
+	stats, err = DeleteMissing(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("DeleteMissing failed: %v", err)
+	}
+	if stats.Checked != 3 || stats.Deleted != 1 || stats.Errors != 0 {
+		t.Errorf("DeleteMissing: unexpected stats %+v", *stats)
+	}
+}
+
+func TestStatsAdd(t *testing.T) {
+	total := &Stats{Files: 2, Copied: 1, Bytes: 10, CopyDuration: time.Second}
+	total.Add(&Stats{Checked: 4, Deleted: 1, Errors: 1, DeleteDuration: time.Second})
+
+	want := Stats{Files: 2, Copied: 1, Checked: 4, Deleted: 1, Errors: 1, Bytes: 10,
+		CopyDuration: time.Second, DeleteDuration: time.Second}
+	if !reflect.DeepEqual(*total, want) {
+		t.Errorf("Expected %+v, got %+v", want, *total)
+	}
+}
+
+func TestStatsSlowest(t *testing.T) {
+	a, b := &Stats{}, &Stats{}
+	for i := 1; i <= 4; i++ {
+		a.recordTiming(FileTiming{Path: fmt.Sprintf("a%d", i), Duration: time.Duration(i) * time.Second})
+		b.recordTiming(FileTiming{Path: fmt.Sprintf("b%d", i), Duration: time.Duration(i)*time.Second + time.Millisecond})
+	}
+	a.Add(b)
+
+	var got []string
+	for _, timing := range a.Slowest {
+		got = append(got, timing.Path)
+	}
+	want := []string{"b4", "a4", "b3", "a3", "b2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected slowest %v, got %v", want, got)
+	}
+}
+
+func TestStatsTiming(t *testing.T) {
+	stats := &Stats{Files: 4, Bytes: 4 << 20, CopyDuration: 2 * time.Second, FileDuration: 100 * time.Millisecond}
+	if got := stats.Throughput(); got != 2<<20 {
+		t.Errorf("Expected throughput of 2 MiB/s, got %f", got)
+	}
+	if got := stats.AverageFileDuration(); got != 25*time.Millisecond {
+		t.Errorf("Expected 25ms per file, got %s", got)
+	}
+	if got := (&Stats{}).Timing(); !strings.Contains(got, "0 B/s") {
+		t.Errorf("Expected no throughput without copying, got %q", got)
+	}
+}
@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StrategySelector chooses the UpdateStrategy for each file from an ordered
+// list of glob patterns, so cheap checks can be used for huge media files
+// while critical data still gets checksums.
+//
+// The map is written as comma-separated pattern=method pairs, e.g.
+//
+//	*.iso=size,*.db=sha256,default=modtime
+//
+// Patterns are matched in order and the first match wins. A pattern without
+// a path separator matches the file's base name; a pattern containing '/'
+// matches the whole path relative to the source root. The "default" entry
+// overrides the fallback method used for files matching no pattern.
+type StrategySelector struct {
+	rules    []strategyRule
+	fallback UpdateStrategy
+}
+
+type strategyRule struct {
+	pattern  string
+	strategy UpdateStrategy
+}
+
+// NewStrategySelector parses spec and returns a selector falling back to
+// defaultMethod for unmatched files. An empty spec selects defaultMethod
+// for every file.
+func NewStrategySelector(spec, defaultMethod string) (*StrategySelector, error) {
+	fallbackMethod := defaultMethod
+	var rules []strategyRule
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pattern, method, ok := strings.Cut(entry, "=")
+		pattern, method = strings.TrimSpace(pattern), strings.TrimSpace(method)
+		if !ok || pattern == "" || method == "" {
+			return nil, fmt.Errorf("invalid strategy map entry %q (expected pattern=method)", entry)
+		}
+
+		if pattern == "default" {
+			fallbackMethod = method
+			continue
+		}
+
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in strategy map: %w", pattern, err)
+		}
+		strategy, err := NewUpdateStrategy(method)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strategy map entry %q: %w", entry, err)
+		}
+		rules = append(rules, strategyRule{pattern: filepath.FromSlash(pattern), strategy: strategy})
+	}
+
+	fallback, err := NewUpdateStrategy(fallbackMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StrategySelector{rules: rules, fallback: fallback}, nil
+}
+
+// Select returns the strategy for a file, given its path relative to the
+// source root
+func (s *StrategySelector) Select(rel string) UpdateStrategy {
+	base := filepath.Base(rel)
+	for _, rule := range s.rules {
+		name := base
+		if strings.ContainsRune(rule.pattern, filepath.Separator) {
+			name = rel
+		}
+		if matched, _ := filepath.Match(rule.pattern, name); matched {
+			return rule.strategy
+		}
+	}
+	return s.fallback
+}
+
+// SetTimeOffset makes the modtime strategies of s expect target
+// modification times to be off by offset
+func (s *StrategySelector) SetTimeOffset(offset time.Duration) {
+	for _, strategy := range s.strategies() {
+		switch m := strategy.(type) {
+		case *ModTimeStrategy:
+			m.Offset = offset
+		case *MetaStrategy:
+			m.Offset = offset
+		}
+	}
+}
+
+// SetTimeTolerance makes the modtime strategies of s take modification
+// times within tolerance of each other as equal
+func (s *StrategySelector) SetTimeTolerance(tolerance time.Duration) {
+	for _, strategy := range s.strategies() {
+		switch m := strategy.(type) {
+		case *ModTimeStrategy:
+			m.Tolerance = tolerance
+		case *MetaStrategy:
+			m.Tolerance = tolerance
+		}
+	}
+}
+
+// trustSidecars makes the sha256 strategies of s take the source checksums
+// from the sidecar files and SHA256SUMS manifests below root, when
+// enabled
+func (s *StrategySelector) trustSidecars(enabled bool, root string) {
+	if !enabled {
+		return
+	}
+	sidecars := newSidecarChecksums(root)
+	for _, strategy := range s.strategies() {
+		if m, ok := strategy.(*SHA256Strategy); ok {
+			m.sidecars = sidecars
+		}
+	}
+}
+
+// comparesMetadata reports whether one of the strategies of s compares
+// metadata, which copies then carry over from the source
+func (s *StrategySelector) comparesMetadata() bool {
+	if _, ok := s.fallback.(*MetaStrategy); ok {
+		return true
+	}
+	for _, rule := range s.rules {
+		if _, ok := rule.strategy.(*MetaStrategy); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// strategies returns every strategy of s
+func (s *StrategySelector) strategies() []UpdateStrategy {
+	strategies := []UpdateStrategy{s.fallback}
+	for _, rule := range s.rules {
+		strategies = append(strategies, rule.strategy)
+	}
+	return strategies
+}
+
+// String describes the selector for log messages
+func (s *StrategySelector) String() string {
+	if len(s.rules) == 0 {
+		return s.fallback.Name()
+	}
+
+	parts := make([]string, 0, len(s.rules)+1)
+	for _, rule := range s.rules {
+		parts = append(parts, filepath.ToSlash(rule.pattern)+"="+rule.strategy.Name())
+	}
+	parts = append(parts, "default="+s.fallback.Name())
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewStrategySelector(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          string
+		defaultMethod string
+		expectError   bool
+		expected      map[string]string // relative path -> strategy name
+	}{
+		{
+			name:          "empty spec uses default method",
+			spec:          "",
+			defaultMethod: "sha256",
+			expected:      map[string]string{"a.txt": "sha256", "dir/b.iso": "sha256"},
+		},
+		{
+			name:          "patterns and default override",
+			spec:          "*.iso=size, *.db=sha256, default=modtime",
+			defaultMethod: "sha256",
+			expected: map[string]string{
+				"movie.iso":        "size",
+				"nested/disk.iso":  "size",
+				"data/app.db":      "sha256",
+				"notes.txt":        "modtime",
+				"nested/README.md": "modtime",
+			},
+		},
+		{
+			name:          "first match wins",
+			spec:          "important/*=sha256,*.txt=size",
+			defaultMethod: "modtime",
+			expected: map[string]string{
+				"important/a.txt": "sha256",
+				"other/a.txt":     "size",
+				"a.bin":           "modtime",
+			},
+		},
+		{
+			name:          "missing method",
+			spec:          "*.iso=",
+			defaultMethod: "modtime",
+			expectError:   true,
+		},
+		{
+			name:          "unknown method",
+			spec:          "*.iso=fast",
+			defaultMethod: "modtime",
+			expectError:   true,
+		},
+		{
+			name:          "invalid pattern",
+			spec:          "[=size",
+			defaultMethod: "modtime",
+			expectError:   true,
+		},
+		{
+			name:          "invalid default",
+			spec:          "default=invalid",
+			defaultMethod: "modtime",
+			expectError:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewStrategySelector(tt.spec, tt.defaultMethod)
+
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			for rel, want := range tt.expected {
+				got := selector.Select(filepath.FromSlash(rel)).Name()
+				if got != want {
+					t.Errorf("Select(%s): expected '%s', got '%s'", rel, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestStrategySelectorString(t *testing.T) {
+	selector, err := NewStrategySelector("*.iso=size", "modtime")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := selector.String(); s != "*.iso=size,default=modtime" {
+		t.Errorf("Unexpected description '%s'", s)
+	}
+
+	selector, _ = NewStrategySelector("", "sha256")
+	if s := selector.String(); s != "sha256" {
+		t.Errorf("Unexpected description '%s'", s)
+	}
+}
+
+func TestStrategySelectorSetTimeOffset(t *testing.T) {
+	selector, err := NewStrategySelector("*.db=modtime,*.iso=size", "modtime")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	selector.SetTimeOffset(time.Hour)
+
+	for _, rel := range []string{"a.db", "b.txt"} {
+		if m, ok := selector.Select(rel).(*ModTimeStrategy); !ok || m.Offset != time.Hour {
+			t.Errorf("Expected the modtime strategy of %s to have the offset, got %+v", rel, selector.Select(rel))
+		}
+	}
+	if _, ok := selector.Select("c.iso").(*SizeStrategy); !ok {
+		t.Errorf("Expected the size strategy for c.iso, got %+v", selector.Select("c.iso"))
+	}
+}
@@ -0,0 +1,54 @@
+package stream
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// walkRoots walks the subtrees of root named by subpaths one after the
+// other with walkDir, in path order whatever order they were given in, or
+// all of root without subpaths. Subtrees missing from root are skipped.
+func walkRoots(root string, subpaths []string, workers int, fn fs.WalkDirFunc) error {
+	if len(subpaths) == 0 {
+		return walkDir(root, workers, fn)
+	}
+	sorted := slices.Clone(subpaths)
+	slices.SortFunc(sorted, comparePaths)
+	for _, sub := range sorted {
+		path := filepath.Join(root, sub)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := walkDir(path, workers, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSubpaths returns an error if one of subpaths is missing from the
+// source. Its target subtree would be left as it is, or emptied with
+// delete-missing, which is more likely a typo than intended.
+func checkSubpaths(source string, subpaths []string) error {
+	for _, sub := range subpaths {
+		if _, err := os.Lstat(filepath.Join(source, sub)); err != nil {
+			return fmt.Errorf("subpath %s: %w", sub, err)
+		}
+	}
+	return nil
+}
+
+// encodeSubpaths returns the subpaths as named in the target
+func (c *targetCodec) encodeSubpaths(subpaths []string) []string {
+	if len(subpaths) == 0 {
+		return nil
+	}
+	encoded := make([]string, len(subpaths))
+	for i, sub := range subpaths {
+		encoded[i] = c.encodePath(sub)
+	}
+	return encoded
+}
@@ -0,0 +1,95 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestSyncSubpaths(t *testing.T) {
+	for _, mode := range []string{config.DeleteAfter, config.DeleteDuring} {
+		t.Run(mode, func(t *testing.T) {
+			tempDir := t.TempDir()
+			srcDir := filepath.Join(tempDir, "source")
+			dstDir := filepath.Join(tempDir, "target")
+
+			for _, dir := range []string{"a/deep", "b"} {
+				os.MkdirAll(filepath.Join(srcDir, dir), 0755)
+				os.MkdirAll(filepath.Join(dstDir, dir), 0755)
+			}
+			createTestFile(t, filepath.Join(srcDir, "root.txt"), "root")
+			createTestFile(t, filepath.Join(srcDir, "a", "deep", "file.txt"), "a")
+			createTestFile(t, filepath.Join(srcDir, "b", "file.txt"), "b")
+			createTestFile(t, filepath.Join(dstDir, "a", "extra.txt"), "extra")
+			createTestFile(t, filepath.Join(dstDir, "b", "extra.txt"), "extra")
+			createTestFile(t, filepath.Join(dstDir, "extra.txt"), "extra")
+
+			cfg := &config.Config{
+				Source:        srcDir,
+				Target:        dstDir,
+				UpdateMethod:  "modtime",
+				Subpaths:      []string{"a"},
+				DeleteMissing: true,
+				DeleteMode:    mode,
+			}
+			stats, err := Sync(context.Background(), cfg, events.Nop{})
+			if err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+			if mode == config.DeleteAfter {
+				if _, err := DeleteMissing(context.Background(), cfg, events.Nop{}); err != nil {
+					t.Fatalf("DeleteMissing failed: %v", err)
+				}
+			}
+			if stats.Files != 1 {
+				t.Errorf("Expected 1 file processed, got %d", stats.Files)
+			}
+
+			for rel, want := range map[string]bool{
+				"a/deep/file.txt": true,
+				"a/extra.txt":     false,
+				"b/file.txt":      false,
+				"b/extra.txt":     true,
+				"root.txt":        false,
+				"extra.txt":       true,
+			} {
+				_, err := os.Stat(filepath.Join(dstDir, rel))
+				if exists := err == nil; exists != want {
+					t.Errorf("%s: expected exists=%v, got %v", rel, want, exists)
+				}
+			}
+
+			diffs, err := Check(context.Background(), cfg, events.Nop{})
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if len(diffs) != 0 {
+				t.Errorf("Expected no differences within the subpath, got %v", diffs)
+			}
+		})
+	}
+}
+
+func TestSyncMissingSubpath(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(filepath.Join(dstDir, "gone"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "file.txt"), "file")
+	createTestFile(t, filepath.Join(dstDir, "gone", "file.txt"), "keep")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", Subpaths: []string{"gone"}, DeleteMissing: true}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err == nil {
+		t.Error("Expected an error for a subpath missing from the source")
+	}
+	if _, err := DeleteMissing(context.Background(), cfg, events.Nop{}); err == nil {
+		t.Error("Expected deletion to be refused for a subpath missing from the source")
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "gone", "file.txt")); err != nil {
+		t.Errorf("Target subtree should be kept: %v", err)
+	}
+}
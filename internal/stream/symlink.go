@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+)
+
+// processDanglingSymlink handles the symbolic link srcPath, whose target
+// does not exist, according to opts.danglingSymlinks: it is reported as an
+// error, left out, or copied to the target as a link with the same target
+func processDanglingSymlink(rel, srcPath, dstPath string, opts *copyOptions, sink events.EventSink) (fileResult, error) {
+	link, err := os.Readlink(srcPath)
+	if err != nil {
+		return fileFailed, &opError{op: events.OpStat, err: errors.NewFileStatError(srcPath, err)}
+	}
+
+	switch opts.danglingSymlinks {
+	case config.DanglingSymlinksSkip:
+		events.Infof(sink, "STREAM", "Skipping dangling symlink: %s -> %s", srcPath, link)
+		return fileDangling, nil
+	case config.DanglingSymlinksCopy:
+	default:
+		return fileFailed, &opError{op: events.OpStat, err: errors.NewFileError(errors.ErrDanglingSymlink, srcPath, fmt.Errorf("%s does not exist", link))}
+	}
+
+	dstLink, err := os.Readlink(dstPath)
+	if err == nil && dstLink == link {
+		sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+		return fileUnchanged, nil
+	}
+
+	if err := opts.attrs.mkdirAll(filepath.Dir(dstPath)); err != nil {
+		return fileFailed, errors.NewSyncError(errors.ErrCannotCreateParentDir, dstPath, err)
+	}
+	_, err = os.Lstat(dstPath)
+	update := err == nil
+	if update {
+		if err := os.Remove(dstPath); err != nil {
+			return fileFailed, errors.NewFileError(errors.ErrCannotCreateFile, dstPath, err)
+		}
+	}
+	if err := os.Symlink(link, dstPath); err != nil {
+		return fileFailed, errors.NewFileError(errors.ErrCannotCreateFile, dstPath, err)
+	}
+
+	events.Debugf(sink, "STREAM", "Copied dangling symlink: %s -> %s", dstPath, link)
+	if update {
+		sink.FileCopied(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Update: true, Itemize: itemizeSymlinkUpdate})
+		return fileUpdated, nil
+	}
+	sink.FileCopied(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Itemize: itemizeNewSymlink})
+	return fileCopied, nil
+}
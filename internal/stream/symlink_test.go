@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"testing"
+)
+
+// codeSink records the error codes reported
+type codeSink struct {
+	events.Nop
+	codes []errors.Code
+}
+
+func (c *codeSink) Error(ev events.ErrorEvent) { c.codes = append(c.codes, errors.CodeOf(ev.Err)) }
+
+func TestSyncDanglingSymlinks(t *testing.T) {
+	tests := []struct {
+		policy         string
+		expectDangling int
+		expectCopied   int
+		expectCode     errors.Code
+	}{
+		{policy: config.DanglingSymlinksSkip, expectDangling: 1, expectCopied: 1},
+		{policy: config.DanglingSymlinksError, expectCopied: 1, expectCode: "dangling_symlink"},
+		{policy: config.DanglingSymlinksCopy, expectCopied: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srcDir, dstDir := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("data"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("missing.txt", filepath.Join(srcDir, "link")); err != nil {
+				t.Skipf("Cannot create a symlink: %v", err)
+			}
+
+			cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", DanglingSymlinks: tt.policy, DeleteMissing: true}
+			sink := &codeSink{}
+			stats, err := Sync(context.Background(), cfg, sink)
+			if err != nil {
+				t.Fatalf("Sync failed: %v", err)
+			}
+			if stats.Dangling != tt.expectDangling || stats.Copied != tt.expectCopied {
+				t.Errorf("Unexpected stats: %+v", stats)
+			}
+			if tt.expectCode != "" && (len(sink.codes) != 1 || sink.codes[0] != tt.expectCode) {
+				t.Errorf("Expected a %s error, got %v", tt.expectCode, sink.codes)
+			}
+
+			link, err := os.Readlink(filepath.Join(dstDir, "link"))
+			if tt.policy != config.DanglingSymlinksCopy {
+				if err == nil {
+					t.Errorf("Expected no link in the target, got one to %s", link)
+				}
+				return
+			}
+			if link != "missing.txt" {
+				t.Fatalf("Expected a link to missing.txt in the target, got %q, %v", link, err)
+			}
+
+			// The copied link is up to date and not deleted as missing
+			stats, err = Sync(context.Background(), cfg, sink)
+			if err != nil || stats.Skipped != 2 || stats.Copied != 0 {
+				t.Errorf("Expected both files unchanged, got %+v, %v", stats, err)
+			}
+			if _, err := DeleteMissing(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("Cleanup failed: %v", err)
+			}
+			if _, err := os.Lstat(filepath.Join(dstDir, "link")); err != nil {
+				t.Errorf("Expected the link to be kept: %v", err)
+			}
+		})
+	}
+}
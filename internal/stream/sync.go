@@ -1,154 +1,989 @@
 package stream
 
 import (
+	"context"
+	"crypto/sha256"
+	stderrors "errors"
+	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"snc/internal/config"
 	"snc/internal/errors"
-	"snc/internal/logger"
+	"snc/internal/events"
+	"snc/internal/state"
 	"time"
 )
 
-// Sync performs file synchronization using the specified configuration
-func Sync(cfg *config.Config) error {
-	logger.Info("STREAM", "Starting file synchronization from %s to %s", cfg.Source, cfg.Target)
-	logger.Info("STREAM", "Using update method: %s", cfg.UpdateMethod)
+// Sync performs file synchronization using the specified configuration.
+// The walk stops early when ctx is cancelled; every file operation is
+// reported to sink. The returned Stats are never nil and cover the work
+// done so far when an error is returned.
+func Sync(ctx context.Context, cfg *config.Config, sink events.EventSink) (*Stats, error) {
+	return SyncIndexed(ctx, cfg, nil, sink)
+}
+
+// SyncIndexed is Sync, recording the source files it finds in found, if set,
+// for DeleteMissingIndexed
+func SyncIndexed(ctx context.Context, cfg *config.Config, found *SourceIndex, sink events.EventSink) (*Stats, error) {
+	stats := &Stats{}
+	events.Infof(sink, "STREAM", "Starting file synchronization from %s to %s", cfg.Source, cfg.Target)
+
+	// Create update strategy selector
+	selector, err := NewStrategySelector(cfg.StrategyMap, cfg.UpdateMethod)
+	if err != nil {
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "update strategy creation", err)
+	}
+	events.Infof(sink, "STREAM", "Using update method: %s", selector)
+
+	opts, err := newCopyOptions(cfg)
+	if err != nil {
+		return stats, err
+	}
+	opts.throttle = newThrottle(cfg, sink)
+	codec := opts.codec
+	if codec != plainTarget {
+		events.Infof(sink, "STREAM", "Target storage: %s", codec)
+	}
+	defer func() {
+		if err := codec.flush(); err != nil {
+			events.Warnf(sink, "STREAM", "%v", err)
+		}
+		if err := opts.checksums.flush(); err != nil {
+			events.Warnf(sink, "STREAM", "%v", err)
+		}
+		// Updated and deleted files leave their objects unused
+		pruneObjects(opts.pool, sink)
+	}()
+	if opts.linkDest != nil {
+		events.Infof(sink, "STREAM", "Hardlinking unchanged files to previous snapshot %s", opts.linkDest.dir)
+	}
+	if cfg.SpecialFiles == config.SpecialFilesRecreate && os.Geteuid() != 0 {
+		events.Warnf(sink, "STREAM", "Not running as root, devices cannot be recreated and are reported as errors")
+	}
 
-	// Create update strategy
-	updateStrategy, err := NewUpdateStrategy(cfg.UpdateMethod)
+	filter, err := loadFilter(cfg)
 	if err != nil {
-		logger.Error("STREAM", "Failed to create update strategy: %v", err)
-		return errors.NewSyncError(errors.ErrSyncFailed, "update strategy creation", err)
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "exclude filter", err)
+	}
+	if err := checkSubpaths(cfg.Source, cfg.Subpaths); err != nil {
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "subpath", err)
+	}
+	if cfg.FilesFrom != "" {
+		if cfg, err = withFilesFrom(cfg); err != nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, "file list", err)
+		}
+		events.Infof(sink, "STREAM", "Syncing %d listed files instead of walking the source", len(cfg.Subpaths))
+		if len(cfg.Subpaths) == 0 {
+			return stats, nil
+		}
+	}
+
+	// Files not seen by a complete walk are dropped from the state
+	var walked bool
+	if cfg.StateFile != "" {
+		db, reset, err := state.Load(cfg.StateFile, cfg.Source, cfg.Target, codec.String())
+		if err != nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, "state file", err)
+		}
+		if reset {
+			events.Warnf(sink, "STREAM", "State file %s is damaged or was written for another source, target or storage, starting over", cfg.StateFile)
+		}
+		events.Infof(sink, "STREAM", "Loaded the state of %d files from %s", len(db.Files), cfg.StateFile)
+		opts.state = db
+		defer func() {
+			if err := db.Save(cfg.StateFile, walked); err != nil {
+				events.Warnf(sink, "STREAM", "%v", err)
+			}
+		}()
+	}
+
+	if cfg.TempDir != "" {
+		removed, err := cleanTempDir(cfg.TempDir, time.Now())
+		if err != nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, "temporary directory", err)
+		}
+		if removed > 0 {
+			events.Infof(sink, "STREAM", "Removed %d stale temporary files from %s", removed, cfg.TempDir)
+		}
+	}
+	if _, swept := sweptTargets.LoadOrStore(filepath.Clean(cfg.Target), true); !swept {
+		if removed := cleanTargetTemps(cfg.Target, time.Now()); removed > 0 {
+			events.Infof(sink, "STREAM", "Removed %d stale temporary files from %s", removed, cfg.Target)
+		}
+	}
+
+	selector.SetTimeOffset(targetTimeOffset(cfg, sink))
+	selector.SetTimeTolerance(opts.nfs.mtimeTolerance())
+	selector.trustSidecars(cfg.SourceChecksums, cfg.Source)
+	if opts.nfs != nil {
+		events.Infof(sink, "STREAM", "Target %s is on NFS, taking modification times within %s as equal and retrying stale file handles", cfg.Target, nfsMtimeTolerance)
+	}
+	copier := &fileCopier{cfg: cfg, selector: selector, opts: opts, sink: sink, stats: stats}
+	if cfg.Prescan {
+		scanStart := time.Now()
+		index, err := Scan(ctx, cfg, sink)
+		if err != nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, "pre-scan", err)
+		}
+		if err := checkFreeSpace(cfg.Target, index); err != nil {
+			// Deleting first may free enough space
+			if !cfg.DeleteMissing || (cfg.DeleteMode != config.DeleteBefore && cfg.DeleteMode != config.DeleteDuring) {
+				return stats, errors.NewSyncError(errors.ErrInsufficientSpace, "pre-scan", err)
+			}
+			events.Warnf(sink, "STREAM", "Free space check: %v", err)
+		}
+		copier.progress = newProgressTracker(sink, index)
+		stats.ScanDuration = time.Since(scanStart)
+	}
+
+	copyStart := time.Now()
+
+	// In delete mode "during", every target directory is cleaned up when
+	// the walk reaches the corresponding source directory
+	var del *deleter
+	var deleteErr error
+	if cfg.DeleteMissing && cfg.DeleteMode == config.DeleteDuring {
+		if del, err = newDeleter(cfg, sink); err != nil {
+			return stats, errors.NewSyncError(errors.ErrSyncFailed, "delete setup", err)
+		}
+		// Deletions are recorded in the manifest saved above
+		del.codec = codec
+		// Copying goes ahead when a delete safety check fails, deleting
+		// does not
+		if deleteErr = checkDeleteAllowed(ctx, cfg, nil); deleteErr != nil {
+			events.Warnf(sink, "DELETE", "Cleanup aborted, no files deleted: %v", deleteErr)
+			del = nil
+		}
 	}
 
-	var fileCount, copiedCount, skippedCount, errorCount int
+	// Files are processed as the walk finds them, in lexical order, unless
+	// another order was requested
+	deferred := cfg.Order != "" && cfg.Order != config.OrderAlpha
+	var pending []pendingFile
+	// Small files are batched per directory, unless they are reordered
+	if !deferred {
+		opts.batch = newDirBatch(cfg.SmallFiles)
+	}
+
+	err = walkRoots(cfg.Source, cfg.Subpaths, cfg.WalkWorkers, func(path string, d os.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 
-	err = filepath.WalkDir(cfg.Source, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			logger.Error("STREAM", "Error accessing %s: %v", path, err)
-			errorCount++
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Error accessing", Op: events.OpWalk, Path: path, Err: err})
 			return nil // continue walking
 		}
 
-		if d.IsDir() {
-			logger.Debug("STREAM", "Skipping directory: %s", path)
+		rel, relErr := filepath.Rel(cfg.Source, path)
+		if relErr != nil {
+			stats.Errors++
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Cannot compute relative path for", Op: events.OpName, Path: path, Err: relErr})
+			return nil
+		}
+
+		if filter.Excluded(rel, d.IsDir()) {
+			events.Debugf(sink, "STREAM", "Excluding: %s", path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		fileCount++
-		logger.Debug("STREAM", "Processing file: %s", path)
+		if d.IsDir() {
+			if del != nil {
+				return del.checkDir(ctx, rel)
+			}
+			events.Debugf(sink, "STREAM", "Skipping directory: %s", path)
+			return nil
+		}
 
-		// Process the file
-		if err := processFileWithStrategy(cfg.Source, cfg.Target, path, d, updateStrategy); err != nil {
-			logger.Error("STREAM", "Failed to process file %s: %v", path, err)
-			errorCount++
-		} else {
-			copiedCount++
+		stats.Files++
+		found.add(rel)
+		f := pendingFile{path: path, rel: rel, entry: d}
+		if deferred {
+			pending = append(pending, f)
+			return nil
+		}
+		if ready, batched := opts.batch.add(f); batched {
+			return copier.processBatch(ctx, ready)
 		}
 
+		events.Debugf(sink, "STREAM", "Processing file: %s", path)
+		copier.process(f)
 		return nil
 	})
+	if err == nil {
+		err = copier.processBatch(ctx, opts.batch.take())
+	}
 
+	// Files outside the subpaths are not seen, but still in sync
+	walked = err == nil && len(cfg.Subpaths) == 0
+	if err == nil && deferred {
+		events.Infof(sink, "STREAM", "Processing %d files in %s order", len(pending), cfg.Order)
+		orderFiles(pending, cfg.Order)
+		for _, f := range pending {
+			if err = ctx.Err(); err != nil {
+				break
+			}
+			events.Debugf(sink, "STREAM", "Processing file: %s", f.path)
+			copier.process(f)
+		}
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		events.Warnf(sink, "STREAM", "Synchronization interrupted: %v", ctxErr)
+		return stats, ctxErr
+	}
+	if err != nil {
+		return stats, errors.NewSyncError(errors.ErrSyncFailed, "sync operation", err)
+	}
+
+	if err := copier.finishLocked(ctx); err != nil {
+		events.Warnf(sink, "STREAM", "Synchronization interrupted: %v", err)
+		return stats, err
+	}
+	stats.CopyDuration = time.Since(copyStart)
+
+	events.Infof(sink, "STREAM", "Synchronization completed: %d files processed, %d copied, %d updated, %d unchanged, %d locked, %d errors",
+		stats.Files, stats.Copied, stats.Updated, stats.Skipped, stats.Locked, stats.Errors)
+
+	reportDeferred(cfg.Target, cfg.MaxTransfer, stats, sink)
+
+	if del != nil {
+		del.report()
+		stats.Add(&del.stats)
+	}
+
+	return stats, deleteErr
+}
+
+// fileCopier syncs the source files found by a walk to one target and
+// counts the results
+type fileCopier struct {
+	cfg      *config.Config
+	selector *StrategySelector
+	opts     *copyOptions
+	sink     events.EventSink
+	stats    *Stats
+	// progress, if set, is told about every file done
+	progress *progressTracker
+	// locked holds the files skipped because another process locked them
+	locked []pendingFile
+}
+
+// process syncs a single file. Locked files are set aside with
+// cfg.SkipLocked, all other failures are reported to the sink.
+func (c *fileCopier) process(f pendingFile) {
+	start := time.Now()
+	result, bytes, err := processFileWithStrategy(c.cfg.Source, c.cfg.Target, f.path, f.entry, c.opts.codec.wrap(c.selector.Select(f.rel)), c.opts, c.sink)
+	for attempt := 0; err != nil && attempt < nfsStaleRetries && c.opts.nfs.retryable(err); attempt++ {
+		events.Debugf(c.sink, "STREAM", "Stale NFS file handle, processing again: %s", f.path)
+		result, bytes, err = processFileWithStrategy(c.cfg.Source, c.cfg.Target, f.path, f.entry, c.opts.codec.wrap(c.selector.Select(f.rel)), c.opts, c.sink)
+	}
+	elapsed := time.Since(start)
+	c.stats.record(result, bytes)
+	c.stats.FileDuration += elapsed
+	c.stats.recordTiming(FileTiming{Path: f.rel, Duration: elapsed, Bytes: bytes})
 	if err != nil {
-		logger.Error("STREAM", "Directory walk failed: %v", err)
-		return errors.NewSyncError(errors.ErrSyncFailed, "sync operation", err)
+		if c.cfg.SkipLocked && isLockedError(err) {
+			events.Warnf(c.sink, "STREAM", "Skipping locked file: %s", f.path)
+			c.locked = append(c.locked, f)
+			return
+		}
+		c.stats.Errors++
+		c.sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to process file", Op: failedOp(err, events.OpCopy), Path: f.path, Err: err})
 	}
 
-	logger.Info("STREAM", "Synchronization completed: %d files processed, %d copied, %d skipped, %d errors",
-		fileCount, copiedCount, skippedCount, errorCount)
+	if c.progress != nil {
+		if info, infoErr := f.entry.Info(); infoErr == nil {
+			c.progress.fileDone(info.Size())
+		}
+	}
+}
 
+// processBatch syncs files of a single source directory, batched by
+// c.opts.batch. It returns ctx's error if it was cancelled.
+func (c *fileCopier) processBatch(ctx context.Context, files []pendingFile) error {
+	if len(files) == 0 {
+		return nil
+	}
+	events.Debugf(c.sink, "STREAM", "Processing %d small files in %s", len(files), filepath.Dir(files[0].path))
+	c.opts.batch.begin(files)
+	defer c.opts.batch.end()
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		events.Debugf(c.sink, "STREAM", "Processing file: %s", f.path)
+		c.process(f)
+	}
 	return nil
 }
 
-// processFileWithStrategy handles a single file during synchronization using the specified update strategy
-func processFileWithStrategy(srcRoot, dstRoot, srcPath string, d os.DirEntry, strategy UpdateStrategy) error {
+// finishLocked retries the locked files once with cfg.RetryLocked and
+// reports those still locked. It returns ctx's error if it was cancelled.
+func (c *fileCopier) finishLocked(ctx context.Context) error {
+	if c.cfg.RetryLocked && len(c.locked) > 0 {
+		events.Infof(c.sink, "STREAM", "Retrying %d locked files", len(c.locked))
+
+		var stillLocked []pendingFile
+		for _, f := range c.locked {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+
+			result, bytes, err := processFileWithStrategy(c.cfg.Source, c.cfg.Target, f.path, f.entry, c.opts.codec.wrap(c.selector.Select(f.rel)), c.opts, c.sink)
+			c.stats.record(result, bytes)
+			switch {
+			case err == nil:
+			case isLockedError(err):
+				stillLocked = append(stillLocked, f)
+			default:
+				c.stats.Errors++
+				c.sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to process file", Op: failedOp(err, events.OpCopy), Path: f.path, Err: err})
+			}
+		}
+		c.locked = stillLocked
+	}
+
+	for _, f := range c.locked {
+		events.Warnf(c.sink, "STREAM", "Locked file not copied: %s", f.path)
+	}
+	c.stats.Locked = len(c.locked)
+	return nil
+}
+
+// newCopyOptions sets up how files are written to the target for cfg
+func newCopyOptions(cfg *config.Config) (*copyOptions, error) {
+	codec, err := newTargetCodec(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target encryption setup", err)
+	}
+
+	attrs, err := newTargetAttrs(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "target attribute overrides", err)
+	}
+
+	var pool *objectPool
+	if cfg.Dedupe {
+		pool = newObjectPool(cfg.Target)
+	}
+	var prev *linkDest
+	if cfg.LinkDest != "" {
+		prev = newLinkDest(cfg.Target, cfg.LinkDest)
+	}
+
+	protect, err := newProtectedDirs(cfg.Target)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrTargetProtected, "target protection", err)
+	}
+
+	checksums, err := loadChecksumList(cfg)
+	if err != nil {
+		return nil, errors.NewSyncError(errors.ErrSyncFailed, "checksum list", err)
+	}
+
+	return &copyOptions{
+		codec:            codec,
+		attrs:            attrs,
+		buffers:          newBufferPool(cfg.BufferSize),
+		noCache:          cfg.NoCache,
+		preallocate:      cfg.Preallocate,
+		tempDir:          cfg.TempDir,
+		fileTimeout:      cfg.FileTimeout,
+		stallTimeout:     cfg.StallTimeout,
+		targetChanges:    cfg.TargetChanges,
+		specialFiles:     cfg.SpecialFiles,
+		danglingSymlinks: cfg.DanglingSymlinks,
+		changeRetries:    cfg.ChangeRetries,
+		fileProgress:     cfg.FileProgress,
+		pool:             pool,
+		linkDest:         prev,
+		protect:          protect,
+		types:            newTargetTypes(cfg.Target, cfg.ForceTypeReplace),
+		budget:           newTransferBudget(cfg.MaxTransfer),
+		staging:          newWriteStaging(cfg),
+		preserveMeta:     preservesMetadata(cfg),
+		checksums:        checksums,
+		nfs:              newNFSTarget(cfg.Target),
+	}, nil
+}
+
+// preservesMetadata reports whether cfg selects the meta strategy for some
+// files, whose copies then carry over the metadata of the source
+func preservesMetadata(cfg *config.Config) bool {
+	selector, err := NewStrategySelector(cfg.StrategyMap, cfg.UpdateMethod)
+	return err == nil && selector.comparesMetadata()
+}
+
+// pendingFile is a source file found by the walk, kept when it is not
+// processed right away: for ordered processing, or for the retry pass and
+// the final report after it was skipped because it was locked
+type pendingFile struct {
+	path  string
+	rel   string
+	entry os.DirEntry
+}
+
+// fileResult is what processFileWithStrategy did with a file
+type fileResult int
+
+const (
+	// fileFailed means the file was not processed, see the returned error
+	fileFailed fileResult = iota
+	fileCopied
+	fileUpdated
+	// fileMetadataUpdated means only the metadata of an existing target
+	// file was updated, its contents were up to date
+	fileMetadataUpdated
+	fileUnchanged
+	// fileSpecial means a device, FIFO or socket was left out
+	fileSpecial
+	// fileDangling means a symbolic link to a missing file was left out
+	fileDangling
+	// fileLinked means the file was hardlinked to a previous snapshot
+	fileLinked
+	// fileProtected means a protection marker kept the target unchanged
+	fileProtected
+	// fileDeferred means the file needs copying, but the transfer limit
+	// was reached; the returned bytes are its size
+	fileDeferred
+)
+
+// processFileWithStrategy handles a single file during synchronization
+// using the specified update strategy. It returns what was done and the
+// number of bytes written to the target.
+func processFileWithStrategy(srcRoot, dstRoot, srcPath string, d os.DirEntry, strategy UpdateStrategy, opts *copyOptions, sink events.EventSink) (fileResult, int64, error) {
 	// Calculate relative path
 	rel, relErr := filepath.Rel(srcRoot, srcPath)
 	if relErr != nil {
-		logger.Error("STREAM", "Cannot compute relative path for %s: %v", srcPath, relErr)
-		return errors.NewRelativePathError(srcPath, relErr)
+		return fileFailed, 0, &opError{op: events.OpName, err: errors.NewRelativePathError(srcPath, relErr)}
+	}
+
+	dstPath := filepath.Join(dstRoot, opts.codec.encodeFile(rel))
+	events.Debugf(sink, "STREAM", "Processing: %s -> %s", srcPath, dstPath)
+
+	if marker := opts.protect.protectedBy(dstPath, sink); marker != "" {
+		events.Debugf(sink, "STREAM", "Protected by %s, not writing: %s", marker, dstPath)
+		return fileProtected, 0, nil
+	}
+	if err := opts.types.checkParents(dstPath, sink); err != nil {
+		return fileFailed, 0, &opError{op: events.OpStat, err: err}
 	}
 
-	dstPath := filepath.Join(dstRoot, rel)
-	logger.Debug("STREAM", "Processing: %s -> %s", srcPath, dstPath)
+	// Reading a FIFO blocks until something writes to it
+	if isSpecial(d.Type()) {
+		result, err := processSpecialFile(rel, srcPath, dstPath, opts, sink)
+		return result, 0, err
+	}
+	if d.Type()&os.ModeSymlink != 0 {
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			result, err := processDanglingSymlink(rel, srcPath, dstPath, opts, sink)
+			return result, 0, err
+		}
+	}
+
+	// The source metadata is taken before copying, so a file changed while
+	// it is copied is not recorded as in sync
+	var srcInfo os.FileInfo
+	if opts.state != nil {
+		var err error
+		if srcInfo, err = d.Info(); err != nil {
+			return fileFailed, 0, &opError{op: events.OpStat, err: errors.NewFileStatError(srcPath, err)}
+		}
+		if opts.targetChanges != "" {
+			if result, bytes, handled, err := checkTargetChanges(rel, srcPath, dstPath, srcInfo, opts, sink); handled {
+				return result, bytes, err
+			}
+		}
+		if opts.state.Unchanged(rel, srcInfo) {
+			events.Tracef(sink, "STREAM", "Unchanged since last sync, target not checked: %s", rel)
+			opts.checksums.verified(dstPath, sink)
+			sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+			return fileUnchanged, 0, nil
+		}
+	}
 
 	// Check if destination file exists
-	if _, err := os.Stat(dstPath); os.IsNotExist(err) {
+	start := time.Now()
+	dstInfo, err := os.Stat(dstPath)
+	events.Tracef(sink, "STREAM", "Stat %s took %s", dstPath, time.Since(start))
+	if err == nil && dstInfo.IsDir() {
+		if err := opts.types.replaceDir(dstPath, sink); err != nil {
+			return fileFailed, 0, &opError{op: events.OpStat, err: err}
+		}
+		dstInfo, err = nil, os.ErrNotExist
+	}
+	if os.IsNotExist(err) {
+		if opts.linkDest != nil {
+			linked, err := opts.linkDest.link(rel, srcPath, dstPath, strategy, opts, sink)
+			if err != nil {
+				return fileFailed, 0, err
+			}
+			if linked {
+				opts.remember(rel, srcInfo, dstPath, nil)
+				opts.checksums.verified(dstPath, sink)
+				sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+				return fileLinked, 0, nil
+			}
+		}
+
 		// File doesn't exist, copy it
-		logger.Progress("STREAM", "COPY", "New file: %s", rel)
-		return copyFile(srcPath, dstPath)
+		if opts.budget.exhausted() {
+			return deferFile(rel, d, srcInfo, sink)
+		}
+		bytesCopied, err := tracedCopy(rel, srcPath, dstPath, opts, sink)
+		if err != nil {
+			return fileFailed, 0, err
+		}
+		opts.remember(rel, srcInfo, dstPath, nil)
+		sink.FileCopied(events.FileEvent{
+			Path: rel, SrcPath: srcPath, DstPath: dstPath,
+			Bytes: bytesCopied, Itemize: itemizeNewFile,
+		})
+		return fileCopied, bytesCopied, nil
 	} else if err != nil {
 		// Error accessing destination file
-		logger.Error("STREAM", "Cannot access destination file %s: %v", dstPath, err)
-		return errors.NewFileStatError(dstPath, err)
+		return fileFailed, 0, &opError{op: events.OpStat, err: errors.NewFileStatError(dstPath, err)}
 	}
 
 	// File exists, check if update is needed using the strategy
+	start = time.Now()
 	needsUpdate, err := strategy.NeedsUpdate(srcPath, dstPath)
 	if err != nil {
-		logger.Error("STREAM", "Failed to check if file needs update %s: %v", srcPath, err)
-		return err
+		return fileFailed, 0, &opError{op: events.OpCompare, err: err}
+	}
+	decision := "unchanged"
+	if needsUpdate {
+		decision = "update"
 	}
+	events.Tracef(sink, "STREAM", "Strategy %s decided %s for %s in %s", strategy.Name(), decision, rel, time.Since(start))
 
 	if needsUpdate {
-		logger.Progress("STREAM", "UPDATE", "Modified file: %s", rel)
-		return copyFile(srcPath, dstPath)
+		if srcInfo == nil {
+			if srcInfo, err = d.Info(); err != nil {
+				return fileFailed, 0, &opError{op: events.OpStat, err: errors.NewFileStatError(srcPath, err)}
+			}
+		}
+
+		if ms, ok := strategy.(metadataStrategy); ok {
+			contentChanged, err := ms.ContentNeedsUpdate(srcPath, dstPath)
+			if err != nil {
+				return fileFailed, 0, &opError{op: events.OpCompare, err: err}
+			}
+			if !contentChanged {
+				return updateMetadata(rel, srcPath, dstPath, srcInfo, dstInfo, opts, sink)
+			}
+		}
+
+		if opts.budget.exhausted() {
+			return deferFile(rel, d, srcInfo, sink)
+		}
+		bytesCopied, err := tracedCopy(rel, srcPath, dstPath, opts, sink)
+		if err != nil {
+			return fileFailed, 0, err
+		}
+		opts.remember(rel, srcInfo, dstPath, nil)
+		sink.FileCopied(events.FileEvent{
+			Path: rel, SrcPath: srcPath, DstPath: dstPath,
+			Bytes: bytesCopied, Update: true,
+			Itemize: itemizeUpdate(srcInfo, dstInfo, comparesChecksum(strategy)),
+		})
+		return fileUpdated, bytesCopied, nil
 	} else {
-		logger.Debug("STREAM", "Skipping unchanged file: %s", rel)
-		return nil
+		opts.remember(rel, srcInfo, dstPath, dstInfo)
+		opts.checksums.verified(dstPath, sink)
+		sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+		return fileUnchanged, 0, nil
+	}
+}
+
+// updateMetadata gives the target file dstPath, whose contents are up to
+// date, the permissions, ownership and extended attributes of srcPath
+// without copying it again. The modification time is restored in case
+// setting the attributes changed it.
+func updateMetadata(rel, srcPath, dstPath string, srcInfo, dstInfo os.FileInfo, opts *copyOptions, sink events.EventSink) (fileResult, int64, error) {
+	events.Debugf(sink, "STREAM", "Only metadata differs, not copying: %s", dstPath)
+	if err := copyMetadata(srcPath, dstPath, srcInfo); err != nil {
+		return fileFailed, 0, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(srcPath).WithTargetPath(dstPath), "metadata", err)
+	}
+	if err := os.Chtimes(dstPath, time.Now(), dstInfo.ModTime()); err != nil {
+		opts.nfs.chtimesFailed(dstPath, err, sink)
+	}
+	opts.remember(rel, srcInfo, dstPath, nil)
+	opts.checksums.verified(dstPath, sink)
+	sink.FileCopied(events.FileEvent{
+		Path: rel, SrcPath: srcPath, DstPath: dstPath, Update: true,
+		Itemize: itemizeMetadata(srcInfo, dstInfo),
+	})
+	return fileMetadataUpdated, 0, nil
+}
+
+// checkTargetChanges compares the target file of rel with its state after
+// the last sync and applies opts.targetChanges if it was modified or
+// removed since. handled is false if the file needs no special treatment.
+func checkTargetChanges(rel, srcPath, dstPath string, srcInfo os.FileInfo, opts *copyOptions, sink events.EventSink) (result fileResult, bytes int64, handled bool, err error) {
+	prev, ok := opts.state.Lookup(rel)
+	if !ok {
+		return fileFailed, 0, false, nil
+	}
+
+	var change string
+	dstInfo, err := os.Stat(dstPath)
+	switch {
+	case os.IsNotExist(err):
+		change = "removed"
+	case err != nil:
+		return fileFailed, 0, true, &opError{op: events.OpStat, err: errors.NewFileStatError(dstPath, err)}
+	case dstInfo.Size() != prev.TargetSize:
+		change = fmt.Sprintf("size changed from %d to %d bytes", prev.TargetSize, dstInfo.Size())
+	case !dstInfo.ModTime().Equal(prev.TargetModTime):
+		change = fmt.Sprintf("modified at %s", dstInfo.ModTime().Format(time.RFC3339))
+	default:
+		return fileFailed, 0, false, nil
+	}
+
+	switch opts.targetChanges {
+	case config.TargetChangesSkip:
+		events.Warnf(sink, "STREAM", "Keeping target file changed since the last sync (%s): %s", change, dstPath)
+		opts.state.Keep(rel)
+		sink.FileSkipped(events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath})
+		return fileUnchanged, 0, true, nil
+	case config.TargetChangesError:
+		opts.state.Keep(rel)
+		return fileFailed, 0, true, &opError{op: events.OpCompare, err: errors.NewFileError(errors.ErrTargetModified, dstPath, stderrors.New(change))}
+	}
+
+	events.Warnf(sink, "STREAM", "Overwriting target file changed since the last sync (%s): %s", change, dstPath)
+	bytesCopied, err := tracedCopy(rel, srcPath, dstPath, opts, sink)
+	if err != nil {
+		return fileFailed, 0, true, err
+	}
+	opts.remember(rel, srcInfo, dstPath, nil)
+	ev := events.FileEvent{Path: rel, SrcPath: srcPath, DstPath: dstPath, Bytes: bytesCopied, Itemize: itemizeNewFile}
+	if dstInfo != nil {
+		ev.Update = true
+		ev.Itemize = itemizeUpdate(srcInfo, dstInfo, false)
+	}
+	sink.FileCopied(ev)
+	if ev.Update {
+		return fileUpdated, bytesCopied, true, nil
+	}
+	return fileCopied, bytesCopied, true, nil
+}
+
+// remember records in opts.state that the source file rel with metadata
+// srcInfo is in sync with dstPath. dstInfo is looked up if nil, in the
+// staging directory first for a copy not moved into place yet; a file
+// whose target cannot be checked is not recorded.
+func (o *copyOptions) remember(rel string, srcInfo os.FileInfo, dstPath string, dstInfo os.FileInfo) {
+	if o.state == nil {
+		return
+	}
+	if dstInfo == nil {
+		var err error
+		dstInfo, err = os.Stat(o.staging.path(dstPath))
+		if os.IsNotExist(err) && o.staging != nil {
+			dstInfo, err = os.Stat(dstPath)
+		}
+		if err != nil {
+			return
+		}
+	}
+	o.state.Record(rel, srcInfo, dstInfo)
+}
+
+// tracedCopy copies a file with copyFile and traces how long it took
+func tracedCopy(rel, srcPath, dstPath string, opts *copyOptions, sink events.EventSink) (int64, error) {
+	start := time.Now()
+	n, err := copyFile(srcPath, dstPath, opts, sink)
+	opts.budget.spend(n)
+	if err == nil {
+		elapsed := time.Since(start)
+		events.Tracef(sink, "STREAM", "Copy of %s took %s for %s (%s/s)",
+			rel, elapsed, formatBytes(n), formatBytes(int64(float64(n)/max(elapsed.Seconds(), 1e-9))))
+	}
+	return n, err
+}
+
+// deferFile leaves the file rel, which needs copying, for the next run
+// since the transfer limit was reached, and returns its size
+func deferFile(rel string, d os.DirEntry, srcInfo os.FileInfo, sink events.EventSink) (fileResult, int64, error) {
+	if srcInfo == nil {
+		var err error
+		if srcInfo, err = d.Info(); err != nil {
+			return fileFailed, 0, &opError{op: events.OpStat, err: errors.NewFileStatError(rel, err)}
+		}
+	}
+	events.Debugf(sink, "STREAM", "Transfer limit reached, deferring: %s", rel)
+	return fileDeferred, srcInfo.Size(), nil
+}
+
+// opError tags an error with the operation that failed
+type opError struct {
+	op  string
+	err error
+}
+
+func (e *opError) Error() string {
+	return e.err.Error()
+}
+
+func (e *opError) Unwrap() error {
+	return e.err
+}
+
+// failedOp returns the operation err was tagged with, or fallback
+func failedOp(err error, fallback string) string {
+	var op *opError
+	if stderrors.As(err, &op) {
+		return op.op
+	}
+	return fallback
+}
+
+// copyOptions describes how files are written to the target
+type copyOptions struct {
+	codec *targetCodec
+	attrs *targetAttrs
+	// buffers provides the copy buffers; nil uses io.CopyBuffer's default
+	buffers *bufferPool
+	// preallocate reserves the space for each copy before writing it
+	preallocate bool
+	// tempDir holds the temporary files copies are written to; empty
+	// writes them next to the target file
+	tempDir string
+	// noCache keeps copied data out of the page cache
+	noCache bool
+	// fileTimeout and stallTimeout abort a copy that takes too long or
+	// stops making progress; zero disables them
+	fileTimeout  time.Duration
+	stallTimeout time.Duration
+	// state, if set, records the files found in sync and skips those whose
+	// source did not change since the last sync without checking the
+	// target
+	state *state.DB
+	// targetChanges is the policy for target files changed since they were
+	// recorded in state; empty trusts state without checking the target
+	targetChanges string
+	// specialFiles is the policy for devices, FIFOs and sockets; empty
+	// skips them
+	specialFiles string
+	// danglingSymlinks is the policy for symbolic links to missing files;
+	// empty reports them as errors
+	danglingSymlinks string
+	// changeRetries is how often a file that changed while it was copied
+	// is copied again
+	changeRetries int
+	// fileProgress is the size from which the progress of a copy is
+	// reported; 0 disables it
+	fileProgress int64
+	// pool, if set, hardlinks identical copies to a single object
+	pool *objectPool
+	// linkDest, if set, hardlinks new files to their unchanged copies in
+	// a previous snapshot
+	linkDest *linkDest
+	// protect finds the target directories protected by a marker
+	protect *protectedDirs
+	// types finds and, if forced, replaces target paths whose type differs
+	// from the source
+	types *targetTypes
+	// budget, if set, limits the bytes copied in this run
+	budget *transferBudget
+	// throttle, if set, limits the rate sources are read at
+	throttle *throttle
+	// batch, if set, copies small files per source directory
+	batch *dirBatch
+	// staging, if set, redirects copies to the staging directory of the
+	// target
+	staging *writeStaging
+	// preserveMeta carries the permissions, ownership and extended
+	// attributes of the source over to copies, for the meta strategy
+	preserveMeta bool
+	// checksums, if set, lists the checksums of the files copied or found
+	// up to date
+	checksums *checksumList
+	// nfs, if set, adjusts the handling of a target on NFS
+	nfs *nfsTarget
+}
+
+// defaultCopyOptions writes plain copies without overrides or timeouts
+var defaultCopyOptions = &copyOptions{codec: plainTarget, attrs: defaultAttrs}
+
+// copyFile copies src to dst as described by opts and returns the number of
+// source bytes read. A source file that changes while it is copied is
+// copied again, up to opts.changeRetries times. If it keeps changing, the
+// last copy is kept with the modification time from before it, so the next
+// sync copies it again, and an ErrSourceChanged error is returned.
+func copyFile(src, dst string, opts *copyOptions, sink events.EventSink) (int64, error) {
+	for attempt := 0; ; attempt++ {
+		last := attempt >= opts.changeRetries
+		n, changed, err := copyFileOnce(src, dst, opts, sink, last)
+		if err != nil || !changed {
+			return n, err
+		}
+		if last {
+			return n, errors.NewFileError(errors.ErrSourceChanged, src, fmt.Errorf("still changing after %d attempts", attempt+1))
+		}
+		events.Debugf(sink, "STREAM", "Source changed while it was copied, copying again: %s", src)
 	}
 }
 
-func copyFile(src, dst string) error {
-	logger.Debug("STREAM", "Starting copy: %s -> %s", src, dst)
+// copyFileOnce makes one attempt of copyFile. It reports whether src
+// changed during the copy, in which case dst is only written if
+// commitChanged is set.
+func copyFileOnce(src, dst string, opts *copyOptions, sink events.EventSink, commitChanged bool) (int64, bool, error) {
+	events.Debugf(sink, "STREAM", "Starting copy: %s -> %s", src, dst)
+
+	// The copy is written to the staging directory with staged writes,
+	// and only moved to dst at the end of the run
+	written := opts.staging.path(dst)
 
 	// ensure parent directory exists
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		logger.Error("STREAM", "Cannot create parent directory for %s: %v", dst, err)
-		return errors.NewSyncError(errors.ErrCannotCreateParentDir, dst, err)
+	if err := opts.batch.mkdirAll(opts.attrs, filepath.Dir(written)); err != nil {
+		return 0, false, errors.NewSyncError(errors.ErrCannotCreateParentDir, dst, err)
 	}
 
 	// Open source file
-	in, err := os.Open(src)
+	in, err := opts.batch.open(src)
 	if err != nil {
-		logger.Error("STREAM", "Cannot open source file %s: %v", src, err)
-		return errors.NewFileError(errors.ErrCannotOpenFile, src, err)
+		return 0, false, lockedOr(src, err, errors.NewFileError(errors.ErrCannotOpenFile, src, err))
 	}
 	defer func() {
-		if closeErr := in.Close(); closeErr != nil {
-			logger.Warn("STREAM", "Failed to close source file %s: %v", src, closeErr)
+		if closeErr := in.Close(); closeErr != nil && !stderrors.Is(closeErr, os.ErrClosed) {
+			events.Warnf(sink, "STREAM", "Failed to close source file %s: %v", src, closeErr)
 		}
 	}()
 
-	// Create destination file
-	out, err := os.Create(dst)
+	// Compared with the metadata after the copy to detect changes
+	before, err := in.Stat()
 	if err != nil {
-		logger.Error("STREAM", "Cannot create destination file %s: %v", dst, err)
-		return errors.NewFileError(errors.ErrCannotCreateFile, dst, err)
+		return 0, false, &opError{op: events.OpStat, err: errors.NewFileStatError(src, err)}
 	}
+
+	// Write to a temporary file that replaces dst once complete, so an
+	// interrupted copy never leaves a partial file under the final name
+	out, err := createTemp(opts.tempDir, written)
+	if err != nil {
+		return 0, false, errors.NewFileError(errors.ErrCannotCreateFile, dst, err)
+	}
+	tmp := out.Name()
+	committed := false
 	defer func() {
-		if closeErr := out.Close(); closeErr != nil {
-			logger.Warn("STREAM", "Failed to close destination file %s: %v", dst, closeErr)
+		if closeErr := out.Close(); closeErr != nil && !stderrors.Is(closeErr, os.ErrClosed) {
+			events.Warnf(sink, "STREAM", "Failed to close temporary file %s: %v", tmp, closeErr)
+		}
+		if !committed {
+			os.Remove(tmp)
 		}
 	}()
 
+	// Reserve space for the whole copy up front
+	extended := false
+	if opts.preallocate {
+		if extended, err = preallocate(out, opts.codec.storedSize(before.Size())); err != nil {
+			return 0, false, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "preallocation", err)
+		}
+	}
+
 	// Copy file contents
-	bytesCopied, err := io.Copy(out, in)
+	var r io.Reader = in
+	var target io.Writer = out
+	var ncr *noCacheReader
+	var ncw *noCacheWriter
+	if opts.noCache {
+		ncr, ncw = &noCacheReader{f: in}, &noCacheWriter{f: out}
+		r, target = ncr, ncw
+	}
+	var progress *fileProgressReader
+	if opts.fileProgress > 0 && before.Size() >= opts.fileProgress {
+		progress = newFileProgressReader(r, src, before.Size(), sink)
+		r = progress
+	}
+	r = opts.throttle.reader(r)
+	// Objects are named after the contents as stored, which checksum
+	// lists record as well
+	var sum hash.Hash
+	if opts.pool != nil || opts.checksums != nil {
+		sum = sha256.New()
+		target = io.MultiWriter(target, sum)
+	}
+	w, err := opts.codec.newWriter(target)
 	if err != nil {
-		logger.Error("STREAM", "File copy failed from %s to %s: %v", src, dst, err)
-		return errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "copy operation", err)
+		return 0, false, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "copy operation", err)
+	}
+	buf := opts.buffers.get()
+	bytesCopied, err := copyWithWatchdog(w, r, buf, opts.fileTimeout, opts.stallTimeout, func() {
+		// The abandoned copy must not report to sink after it returns
+		progress.mute()
+		in.Close()
+		out.Close()
+	})
+	if stalled, ok := err.(*stalledCopyError); ok {
+		// The abandoned copy may still use buf, so it is not returned to
+		// the pool
+		return 0, false, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "copy operation", stalled)
+	}
+	opts.buffers.put(buf)
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil && extended {
+		// Drop the unused end of the preallocated file, e.g. when the
+		// source shrank while it was copied
+		var written int64
+		if written, err = out.Seek(0, io.SeekCurrent); err == nil {
+			err = out.Truncate(written)
+		}
+	}
+	if ncr != nil {
+		ncr.release()
+		ncw.release()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		return 0, false, lockedOr(src, err, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "copy operation", err))
 	}
 
-	// Preserve file modtime
-	if srcInfo, statErr := in.Stat(); statErr == nil {
-		if chtimesErr := os.Chtimes(dst, time.Now(), srcInfo.ModTime()); chtimesErr != nil {
-			logger.Warn("STREAM", "Failed to preserve modtime for %s: %v", dst, chtimesErr)
+	after, err := in.Stat()
+	changed := err != nil || bytesCopied != before.Size() || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime())
+	if changed && !commitChanged {
+		return bytesCopied, true, nil
+	}
+
+	if err := commitTemp(tmp, written); err != nil {
+		return 0, false, lockedOr(dst, err, errors.NewFileError(errors.ErrCannotCreateFile, dst, err))
+	}
+	committed = true
+	opts.codec.written(dst, bytesCopied)
+	if opts.checksums != nil {
+		opts.checksums.record(dst, sum.Sum(nil))
+	}
+
+	if opts.preserveMeta {
+		if err := copyMetadata(src, written, before); err != nil {
+			return 0, false, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "metadata", err)
 		}
-	} else {
-		logger.Warn("STREAM", "Failed to stat source file %s for modtime: %v", src, statErr)
+	}
+	if err := opts.attrs.applyFile(written); err != nil {
+		return 0, false, errors.NewSyncError(errors.ErrFileCopyFailed.WithSourcePath(src).WithTargetPath(dst), "ownership and permission overrides", err)
 	}
 
-	logger.Success("STREAM", "Copied %s -> %s (%d bytes)", src, dst, bytesCopied)
-	return nil
+	// Preserve file modtime as of before the copy, so a copy of a file that
+	// changed meanwhile is not taken for up to date
+	preserveBirthTime(written, before, sink)
+	if chtimesErr := os.Chtimes(written, time.Now(), before.ModTime()); chtimesErr != nil {
+		opts.nfs.chtimesFailed(dst, chtimesErr, sink)
+	} else if opts.pool != nil {
+		opts.pool.add(written, sum.Sum(nil), before.ModTime(), sink)
+	}
+
+	return bytesCopied, changed, nil
 }
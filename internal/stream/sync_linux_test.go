@@ -0,0 +1,33 @@
+//go:build linux
+
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestCopyFileSourceChanged(t *testing.T) {
+	// procfs reports a size of 0 for files with content, so the copy never
+	// matches the metadata taken before it
+	src := "/proc/self/status"
+	if _, err := os.Stat(src); err != nil {
+		t.Skipf("No procfs: %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "status")
+
+	opts := &copyOptions{codec: plainTarget, attrs: defaultAttrs, buffers: newBufferPool(64 << 10), changeRetries: 2}
+	n, err := copyFile(src, dst, opts, events.Nop{})
+	if errors.CodeOf(err) != "source_changed" {
+		t.Fatalf("Expected a source_changed error, got %v", err)
+	}
+
+	// The last copy is kept with the modification time from before it
+	data, readErr := os.ReadFile(dst)
+	if readErr != nil || int64(len(data)) != n || n == 0 {
+		t.Errorf("Expected the last copy of %d bytes in the target, got %d, %v", n, len(data), readErr)
+	}
+}
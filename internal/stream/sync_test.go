@@ -1,10 +1,13 @@
 package stream
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"snc/internal/config"
+	"snc/internal/events"
 	"testing"
+	"time"
 )
 
 func TestSync(t *testing.T) {
@@ -89,7 +92,7 @@ func TestSync(t *testing.T) {
 			// Clean up destination directory
 			os.RemoveAll(dstDir)
 
-			err := Sync(tt.config)
+			_, err := Sync(context.Background(), tt.config, events.Nop{})
 
 			if tt.expectError {
 				if err == nil {
@@ -147,10 +150,11 @@ func TestProcessFileWithStrategy(t *testing.T) {
 	dirEntry := &mockDirEntry{fileInfo: fileInfo}
 
 	tests := []struct {
-		name        string
-		strategy    UpdateStrategy
-		setupDst    func() // function to set up destination
-		expectError bool
+		name         string
+		strategy     UpdateStrategy
+		setupDst     func() // function to set up destination
+		expectError  bool
+		expectResult fileResult
 	}{
 		{
 			name:     "new file with modtime strategy",
@@ -158,7 +162,8 @@ func TestProcessFileWithStrategy(t *testing.T) {
 			setupDst: func() {
 				// No destination file
 			},
-			expectError: false,
+			expectError:  false,
+			expectResult: fileCopied,
 		},
 		{
 			name:     "new file with sha256 strategy",
@@ -166,7 +171,8 @@ func TestProcessFileWithStrategy(t *testing.T) {
 			setupDst: func() {
 				// No destination file
 			},
-			expectError: false,
+			expectError:  false,
+			expectResult: fileCopied,
 		},
 		{
 			name:     "existing identical file with modtime strategy",
@@ -190,7 +196,20 @@ func TestProcessFileWithStrategy(t *testing.T) {
 					t.Fatalf("Failed to create destination file: %v", err)
 				}
 			},
-			expectError: false,
+			expectError:  false,
+			expectResult: fileUnchanged,
+		},
+		{
+			name:     "existing different file with sha256 strategy",
+			strategy: &SHA256Strategy{},
+			setupDst: func() {
+				err := os.WriteFile(filepath.Join(dstDir, "test.txt"), []byte("old content"), 0644)
+				if err != nil {
+					t.Fatalf("Failed to create destination file: %v", err)
+				}
+			},
+			expectError:  false,
+			expectResult: fileUpdated,
 		},
 	}
 
@@ -202,7 +221,7 @@ func TestProcessFileWithStrategy(t *testing.T) {
 
 			tt.setupDst()
 
-			err := processFileWithStrategy(srcDir, dstDir, srcFile, dirEntry, tt.strategy)
+			result, _, err := processFileWithStrategy(srcDir, dstDir, srcFile, dirEntry, tt.strategy, defaultCopyOptions, events.Nop{})
 
 			if tt.expectError {
 				if err == nil {
@@ -212,6 +231,9 @@ func TestProcessFileWithStrategy(t *testing.T) {
 				if err != nil {
 					t.Errorf("Unexpected error: %v", err)
 				}
+				if tt.expectResult != fileFailed && result != tt.expectResult {
+					t.Errorf("Expected result %d, got %d", tt.expectResult, result)
+				}
 			}
 		})
 	}
@@ -237,3 +259,101 @@ func (m *mockDirEntry) Type() os.FileMode {
 func (m *mockDirEntry) Info() (os.FileInfo, error) {
 	return m.fileInfo, nil
 }
+
+func TestSyncWithStateFile(t *testing.T) {
+	srcDir, dstDir := t.TempDir(), t.TempDir()
+	srcFile := filepath.Join(srcDir, "file.txt")
+	if err := os.WriteFile(srcFile, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		UpdateMethod: "modtime",
+		StateFile:    filepath.Join(t.TempDir(), "state.json"),
+	}
+
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("First sync failed: %v", err)
+	}
+
+	// The state says the file is in sync, so the target is not checked and
+	// the removed copy goes unnoticed
+	dstFile := filepath.Join(dstDir, "file.txt")
+	if err := os.Remove(dstFile); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Sync(context.Background(), cfg, events.Nop{})
+	if err != nil {
+		t.Fatalf("Second sync failed: %v", err)
+	}
+	if stats.Skipped != 1 || stats.Copied != 0 {
+		t.Errorf("Expected the file to be skipped, got %+v", stats)
+	}
+
+	// A changed source file is checked and copied again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(srcFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Third sync failed: %v", err)
+	}
+	if stats.Copied != 1 {
+		t.Errorf("Expected the file to be copied again, got %+v", stats)
+	}
+	if _, err := os.Stat(dstFile); err != nil {
+		t.Errorf("Expected target file to exist: %v", err)
+	}
+}
+
+func TestSyncTargetChanges(t *testing.T) {
+	tests := []struct {
+		policy        string
+		expectContent string
+		expectErrors  int
+	}{
+		{policy: config.TargetChangesOverwrite, expectContent: "source", expectErrors: 0},
+		{policy: config.TargetChangesSkip, expectContent: "edited in target", expectErrors: 0},
+		{policy: config.TargetChangesError, expectContent: "edited in target", expectErrors: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srcDir, dstDir := t.TempDir(), t.TempDir()
+			if err := os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("source"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config.Config{
+				Source:        srcDir,
+				Target:        dstDir,
+				UpdateMethod:  "modtime",
+				StateFile:     filepath.Join(t.TempDir(), "state.json"),
+				TargetChanges: tt.policy,
+			}
+			if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+				t.Fatalf("First sync failed: %v", err)
+			}
+
+			dstFile := filepath.Join(dstDir, "file.txt")
+			if err := os.WriteFile(dstFile, []byte("edited in target"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			// The change is handled the same way on every run
+			for run := 0; run < 2; run++ {
+				stats, err := Sync(context.Background(), cfg, events.Nop{})
+				if err != nil {
+					t.Fatalf("Sync failed: %v", err)
+				}
+				if run == 0 && stats.Errors != tt.expectErrors {
+					t.Errorf("Expected %d errors, got %+v", tt.expectErrors, stats)
+				}
+				content, _ := os.ReadFile(dstFile)
+				if string(content) != tt.expectContent {
+					t.Errorf("Run %d: expected target content %q, got %q", run, tt.expectContent, content)
+				}
+			}
+		})
+	}
+}
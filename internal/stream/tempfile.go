@@ -0,0 +1,138 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Temporary files are named <prefix><random>.tmp. Next to the target file
+// the name is hidden; in a dedicated temp directory it is not, since the
+// point of --temp-dir is often a target that rejects dot-prefixed names.
+const (
+	targetTempPrefix = ".snc-"
+	tempDirPrefix    = "snc-"
+	tempSuffix       = ".tmp"
+)
+
+// staleTempAge is how long a temporary file must have gone unwritten to be
+// taken for the leftover of a run that was killed or crashed. Copies in
+// progress, e.g. of another process sharing the temp directory, write to
+// theirs all the time.
+const staleTempAge = time.Hour
+
+// sweptTargets holds the targets whose stale temporary files this process
+// removed already; walking the whole target is left to the first run of a
+// daemon
+var sweptTargets sync.Map
+
+// createTemp creates the temporary file a copy of dst is written to before
+// it is moved into place: in tempDir if set, otherwise next to dst
+func createTemp(tempDir, dst string) (*os.File, error) {
+	dir, prefix := tempDir, tempDirPrefix
+	if dir == "" {
+		dir, prefix = filepath.Dir(dst), targetTempPrefix
+	}
+
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("%s%08x%s", prefix, rand.Uint32(), tempSuffix))
+		// Same permissions as os.Create, unlike os.CreateTemp
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("cannot create temporary file in %s", dir)
+}
+
+// commitTemp moves the finished temporary file tmp to dst, replacing any
+// existing file. When tmp is in a separate temp directory on another
+// filesystem, where renaming is not possible, its contents are copied to
+// dst instead, which is not atomic.
+func commitTemp(tmp, dst string) error {
+	err := os.Rename(tmp, dst)
+	if err == nil || filepath.Dir(tmp) == filepath.Dir(dst) {
+		return err
+	}
+
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// dst may be a hardlink shared with other files, which must not be
+	// overwritten
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(tmp)
+}
+
+// cleanTempDir removes temporary files left in tempDir by runs that were
+// killed or crashed and returns the number of files removed. Files written
+// to within staleTempAge before now are kept.
+func cleanTempDir(tempDir string, now time.Time) (int, error) {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !isStaleTemp(entry, tempDirPrefix, now) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(tempDir, entry.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// cleanTargetTemps removes the temporary files left next to the files in
+// target by runs that were killed or crashed and returns the number of
+// files removed. Like cleanTempDir, it keeps files written to recently.
+// Directories that cannot be read are skipped.
+func cleanTargetTemps(target string, now time.Time) int {
+	removed := 0
+	filepath.WalkDir(target, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isStaleTemp(d, targetTempPrefix, now) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed
+}
+
+// isStaleTemp reports whether entry is a temporary file named with prefix
+// that was last written staleTempAge or longer before now
+func isStaleTemp(entry os.DirEntry, prefix string, now time.Time) bool {
+	name := entry.Name()
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, tempSuffix) {
+		return false
+	}
+	info, err := entry.Info()
+	return err == nil && info.Mode().IsRegular() && now.Sub(info.ModTime()) >= staleTempAge
+}
@@ -0,0 +1,118 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/events"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncWithTempDir(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "target")
+	stagingDir := filepath.Join(tempDir, "staging")
+
+	os.MkdirAll(filepath.Join(srcDir, "sub"), 0755)
+	os.MkdirAll(stagingDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "a.txt"), "new a")
+	createTestFile(t, filepath.Join(srcDir, "sub", "b.txt"), "new b")
+	createTestFile(t, filepath.Join(dstDir, "a.txt"), "old")
+
+	// Leftovers of a crashed run are removed, other files are kept
+	createTestFile(t, filepath.Join(stagingDir, "snc-deadbeef.tmp"), "partial")
+	createTestFile(t, filepath.Join(stagingDir, "notes.txt"), "keep")
+	stale := time.Now().Add(-2 * staleTempAge)
+	os.Chtimes(filepath.Join(stagingDir, "snc-deadbeef.tmp"), stale, stale)
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "sha256", TempDir: stagingDir}
+	if _, err := Sync(context.Background(), cfg, events.Nop{}); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	for rel, want := range map[string]string{"a.txt": "new a", "sub/b.txt": "new b"} {
+		got, err := os.ReadFile(filepath.Join(dstDir, rel))
+		if err != nil || string(got) != want {
+			t.Errorf("Expected %s to contain %q, got %q (%v)", rel, want, got, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(stagingDir)
+	if len(entries) != 1 || entries[0].Name() != "notes.txt" {
+		t.Errorf("Expected only notes.txt in temp dir, got %v", entries)
+	}
+
+	missing := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "sha256", TempDir: filepath.Join(tempDir, "missing")}
+	if _, err := Sync(context.Background(), missing, events.Nop{}); err == nil {
+		t.Error("Expected error for missing temp dir")
+	}
+}
+
+func TestCopyFileLeavesNoTempFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	dstDir := filepath.Join(tempDir, "target")
+	createTestFile(t, src, "content")
+
+	if _, err := copyFile(src, filepath.Join(dstDir, "dst.txt"), defaultCopyOptions, events.Nop{}); err != nil {
+		t.Fatalf("copyFile failed: %v", err)
+	}
+	if _, err := copyFile(filepath.Join(tempDir, "missing.txt"), filepath.Join(dstDir, "other.txt"), defaultCopyOptions, events.Nop{}); err == nil {
+		t.Fatal("Expected error for missing source file")
+	}
+
+	entries, _ := os.ReadDir(dstDir)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), tempSuffix) {
+			t.Errorf("Temporary file left behind: %s", entry.Name())
+		}
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only dst.txt in target, got %v", entries)
+	}
+}
+
+func TestCleanTempDirKeepsCopiesInProgress(t *testing.T) {
+	tempDir := t.TempDir()
+	createTestFile(t, filepath.Join(tempDir, "snc-00000001.tmp"), "crashed")
+	createTestFile(t, filepath.Join(tempDir, "snc-00000002.tmp"), "in progress")
+	stale := time.Now().Add(-2 * staleTempAge)
+	os.Chtimes(filepath.Join(tempDir, "snc-00000001.tmp"), stale, stale)
+
+	removed, err := cleanTempDir(tempDir, time.Now())
+	if err != nil {
+		t.Fatalf("cleanTempDir failed: %v", err)
+	}
+	entries, _ := os.ReadDir(tempDir)
+	if removed != 1 || len(entries) != 1 || entries[0].Name() != "snc-00000002.tmp" {
+		t.Errorf("Expected only the stale file to be removed, removed %d and kept %v", removed, entries)
+	}
+}
+
+func TestCleanTargetTemps(t *testing.T) {
+	dstDir := t.TempDir()
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "sub", ".snc-00000001.tmp"), "crashed")
+	createTestFile(t, filepath.Join(dstDir, ".snc-00000002.tmp"), "in progress")
+	createTestFile(t, filepath.Join(dstDir, "sub", "file.tmp"), "keep")
+	stale := time.Now().Add(-2 * staleTempAge)
+	os.Chtimes(filepath.Join(dstDir, "sub", ".snc-00000001.tmp"), stale, stale)
+	os.Chtimes(filepath.Join(dstDir, "sub", "file.tmp"), stale, stale)
+
+	if removed := cleanTargetTemps(dstDir, time.Now()); removed != 1 {
+		t.Errorf("Expected 1 stale file removed, got %d", removed)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "sub", ".snc-00000001.tmp")); !os.IsNotExist(err) {
+		t.Error("Expected the stale temporary file to be removed")
+	}
+	for _, rel := range []string{".snc-00000002.tmp", filepath.Join("sub", "file.tmp")} {
+		if _, err := os.Stat(filepath.Join(dstDir, rel)); err != nil {
+			t.Errorf("Expected %s to be kept: %v", rel, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"io"
+	"snc/internal/config"
+	"snc/internal/events"
+	"sync"
+	"time"
+)
+
+// minThrottleChunk is the smallest read of a throttled copy, so very low
+// rates do not read byte by byte
+const minThrottleChunk = 512
+
+// throttle limits the rate copies read their sources at, together across
+// all copies sharing it. The rate follows the schedule, if any, and is
+// looked up again on every read, so long runs pick up changes.
+type throttle struct {
+	limit    int64
+	schedule []config.BandwidthWindow
+	sink     events.EventSink
+
+	mu sync.Mutex
+	// next is when the bytes read so far are paid for
+	next time.Time
+	// lastRate is the rate last logged, -1 before the first read
+	lastRate int64
+}
+
+// newThrottle returns the throttle for the rate limits of cfg, or nil if
+// copies are never limited
+func newThrottle(cfg *config.Config, sink events.EventSink) *throttle {
+	if cfg.BwLimit <= 0 && len(cfg.BwSchedule) == 0 {
+		return nil
+	}
+	return &throttle{limit: cfg.BwLimit, schedule: cfg.BwSchedule, sink: sink, lastRate: -1}
+}
+
+// rate returns the limit in bytes per second at now, 0 for no limit. The
+// first window containing now wins; --bwlimit applies outside them.
+func (t *throttle) rate(now time.Time) int64 {
+	for _, w := range t.schedule {
+		if w.Contains(now) {
+			return w.Rate
+		}
+	}
+	return t.limit
+}
+
+// wait blocks until reading n more bytes keeps to the current rate
+func (t *throttle) wait(n int) {
+	now := time.Now()
+	t.mu.Lock()
+	rate := t.rate(now)
+	if rate != t.lastRate {
+		if rate == 0 {
+			events.Infof(t.sink, "STREAM", "Bandwidth limit lifted")
+		} else {
+			events.Infof(t.sink, "STREAM", "Bandwidth limited to %s/s", formatBytes(rate))
+		}
+		t.lastRate = rate
+	}
+	if rate == 0 {
+		t.next = time.Time{}
+		t.mu.Unlock()
+		return
+	}
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	delay := t.next.Sub(now)
+	t.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// chunk returns how much a single read may take at the current rate, a
+// tenth of a second's worth, or 0 for no limit
+func (t *throttle) chunk() int {
+	t.mu.Lock()
+	rate := t.rate(time.Now())
+	t.mu.Unlock()
+	if rate == 0 {
+		return 0
+	}
+	return int(max(rate/10, minThrottleChunk))
+}
+
+// reader returns r reading at the rate of t, or r itself for a nil t
+func (t *throttle) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (tr *throttledReader) Read(b []byte) (int, error) {
+	if chunk := tr.t.chunk(); chunk > 0 && len(b) > chunk {
+		b = b[:chunk]
+	}
+	n, err := tr.r.Read(b)
+	if n > 0 {
+		tr.t.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"snc/internal/config"
+	"snc/internal/events"
+	"testing"
+	"time"
+)
+
+func TestThrottleRate(t *testing.T) {
+	cfg := &config.Config{BwLimit: 1 << 20, BwSchedule: []config.BandwidthWindow{
+		{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 5 << 20},
+		{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 0},
+	}}
+	th := newThrottle(cfg, events.Nop{})
+	day := func(hour int) time.Time { return time.Date(2024, 1, 1, hour, 0, 0, 0, time.Local) }
+
+	for hour, expected := range map[int]int64{10: 5 << 20, 20: 1 << 20, 23: 0, 2: 0, 7: 1 << 20} {
+		if got := th.rate(day(hour)); got != expected {
+			t.Errorf("Rate at %02d:00: expected %d, got %d", hour, expected, got)
+		}
+	}
+
+	if newThrottle(&config.Config{}, events.Nop{}) != nil {
+		t.Error("Expected no throttle without limits")
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	th := newThrottle(&config.Config{BwLimit: 100 << 10}, events.Nop{})
+	data := bytes.Repeat([]byte("x"), 30<<10)
+
+	start := time.Now()
+	got, err := io.ReadAll(th.reader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Throttled data differs")
+	}
+	// 30K at 100K/s take about 0.3s
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected the read to be throttled, took %v", elapsed)
+	}
+
+	var nilThrottle *throttle
+	r := bytes.NewReader(data)
+	if nilThrottle.reader(r) != io.Reader(r) {
+		t.Error("A nil throttle should not wrap the reader")
+	}
+}
@@ -0,0 +1,55 @@
+package stream
+
+import (
+	"os"
+	"snc/internal/config"
+	"snc/internal/events"
+	"time"
+)
+
+// probeTime is set on the probe file; a whole, even second survives
+// filesystems that store modification times with little precision
+var probeTime = time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC)
+
+// targetTimeOffset returns the offset modtime comparisons with cfg.Target
+// must allow for. With cfg.DetectTimeOffset it is measured on the target,
+// falling back to no offset if that fails.
+func targetTimeOffset(cfg *config.Config, sink events.EventSink) time.Duration {
+	if !cfg.DetectTimeOffset {
+		return cfg.TimeOffset
+	}
+
+	offset, err := detectTimeOffset(cfg.Target)
+	if err != nil {
+		events.Warnf(sink, "STREAM", "Cannot detect the modification time offset of %s, assuming none: %v", cfg.Target, err)
+		return 0
+	}
+	if offset != 0 {
+		events.Infof(sink, "STREAM", "Target %s stores modification times off by %s", cfg.Target, offset)
+	} else {
+		events.Debugf(sink, "STREAM", "Target %s stores modification times as set", cfg.Target)
+	}
+	return offset
+}
+
+// detectTimeOffset writes a probe file to dir, sets its modification time
+// and returns how far the time read back is off from the one set
+func detectTimeOffset(dir string) (time.Duration, error) {
+	f, err := os.CreateTemp(dir, ".snc-probe-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Chtimes(f.Name(), probeTime, probeTime); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return 0, err
+	}
+	return info.ModTime().Sub(probeTime), nil
+}
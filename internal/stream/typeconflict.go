@@ -0,0 +1,124 @@
+package stream
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/errors"
+	"snc/internal/events"
+	"sync"
+)
+
+// targetTypes finds target files standing where the source has a
+// directory. Lookups are cached per directory, since many files share
+// them.
+type targetTypes struct {
+	root  string
+	force bool
+
+	mu sync.Mutex
+	// conflicts holds the file found in place of each directory looked up,
+	// or ""
+	conflicts map[string]string
+}
+
+func newTargetTypes(root string, force bool) *targetTypes {
+	return &targetTypes{root: filepath.Clean(root), force: force, conflicts: make(map[string]string)}
+}
+
+// checkParents returns a type conflict error if a parent directory of the
+// target file dstPath is a file in the target. With force, that file is
+// removed instead, so the directory can be created.
+func (t *targetTypes) checkParents(dstPath string, sink events.EventSink) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conflict := t.lookup(filepath.Dir(dstPath))
+	if conflict == "" {
+		return nil
+	}
+	if !t.force {
+		return typeConflict(conflict, "a file in the target but a directory in the source")
+	}
+
+	events.Warnf(sink, "STREAM", "Replacing the file %s with a directory from the source", conflict)
+	if err := os.Remove(conflict); err != nil && !os.IsNotExist(err) {
+		return errors.NewFileError(errors.ErrCannotDeleteFile, conflict, err)
+	}
+	for dir, c := range t.conflicts {
+		if c == conflict {
+			t.conflicts[dir] = ""
+		}
+	}
+	return nil
+}
+
+// lookup returns the target file standing in place of dir or the closest
+// of its parents below the root, or ""
+func (t *targetTypes) lookup(dir string) string {
+	if conflict, ok := t.conflicts[dir]; ok {
+		return conflict
+	}
+
+	conflict := ""
+	if dir != t.root && isBelow(t.root, dir) {
+		info, err := os.Stat(dir)
+		switch {
+		case err == nil && !info.IsDir():
+			conflict = dir
+		case err != nil:
+			// Missing, or below a file
+			conflict = t.lookup(filepath.Dir(dir))
+		}
+	}
+	t.conflicts[dir] = conflict
+	return conflict
+}
+
+// replaceDir removes the target directory dstPath where the source has a
+// file, with force, or returns a type conflict error. Directories holding
+// a protection marker are never removed.
+func (t *targetTypes) replaceDir(dstPath string, sink events.EventSink) error {
+	if t == nil || !t.force {
+		return typeConflict(dstPath, "a directory in the target but a file in the source")
+	}
+	if marker := findProtectMarker(dstPath); marker != "" {
+		return typeConflict(dstPath, fmt.Sprintf("a directory in the target protected by %s, but a file in the source", marker))
+	}
+
+	events.Warnf(sink, "STREAM", "Replacing the directory %s with a file from the source", dstPath)
+	if err := os.RemoveAll(dstPath); err != nil {
+		return errors.NewFileError(errors.ErrCannotDeleteFile, dstPath, err)
+	}
+	return nil
+}
+
+// typeConflict returns the error reported for the target path dstPath,
+// which is described as what it is on either side
+func typeConflict(dstPath, desc string) error {
+	return errors.NewFileError(errors.ErrTypeConflict, dstPath, fmt.Errorf("%s is %s; use --force-type-replace to replace it", dstPath, desc))
+}
+
+// findProtectMarker returns the first protection marker found below dir,
+// or ""
+func findProtectMarker(dir string) string {
+	var marker string
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Name() == protectMarker {
+			marker = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return marker
+}
+
+// isDir reports whether path is a directory, following symbolic links
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
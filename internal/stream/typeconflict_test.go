@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"testing"
+)
+
+func TestSyncTypeConflicts(t *testing.T) {
+	for _, force := range []bool{false, true} {
+		tempDir := t.TempDir()
+		srcDir := filepath.Join(tempDir, "source")
+		dstDir := filepath.Join(tempDir, "destination")
+
+		// file.txt is a file in the source but a directory in the target,
+		// dir the reverse
+		os.MkdirAll(filepath.Join(srcDir, "dir", "sub"), 0755)
+		createTestFile(t, filepath.Join(srcDir, "file.txt"), "file")
+		createTestFile(t, filepath.Join(srcDir, "dir", "a.txt"), "a")
+		createTestFile(t, filepath.Join(srcDir, "dir", "sub", "b.txt"), "b")
+		os.MkdirAll(filepath.Join(dstDir, "file.txt"), 0755)
+		createTestFile(t, filepath.Join(dstDir, "file.txt", "inner.txt"), "inner")
+		createTestFile(t, filepath.Join(dstDir, "dir"), "dir")
+
+		cfg := &config.Config{
+			Source:           srcDir,
+			Target:           dstDir,
+			UpdateMethod:     "modtime",
+			ForceTypeReplace: force,
+		}
+		sink := &codeSink{}
+		stats, err := Sync(context.Background(), cfg, sink)
+		if err != nil {
+			t.Fatalf("Sync failed: %v", err)
+		}
+
+		if !force {
+			if stats.Errors != 3 {
+				t.Errorf("Expected 3 errors, got %d", stats.Errors)
+			}
+			for _, code := range sink.codes {
+				if code != errors.CodeOf(errors.ErrTypeConflict) {
+					t.Errorf("Expected type conflicts, got %v", sink.codes)
+					break
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, "file.txt", "inner.txt")); err != nil {
+				t.Errorf("Expected the target directory to be kept: %v", err)
+			}
+			if data, err := os.ReadFile(filepath.Join(dstDir, "dir")); err != nil || string(data) != "dir" {
+				t.Errorf("Expected the target file to be kept, got %q, %v", data, err)
+			}
+			continue
+		}
+
+		if stats.Errors != 0 {
+			t.Errorf("Expected no errors with --force-type-replace, got %d", stats.Errors)
+		}
+		for path, content := range map[string]string{"file.txt": "file", "dir/a.txt": "a", "dir/sub/b.txt": "b"} {
+			if data, err := os.ReadFile(filepath.Join(dstDir, filepath.FromSlash(path))); err != nil || string(data) != content {
+				t.Errorf("Expected %s to be replaced, got %q, %v", path, data, err)
+			}
+		}
+	}
+}
+
+func TestSyncTypeConflictProtected(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(srcDir, 0755)
+	createTestFile(t, filepath.Join(srcDir, "data"), "data")
+	os.MkdirAll(filepath.Join(dstDir, "data"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "data", protectMarker), "")
+
+	cfg := &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime", ForceTypeReplace: true}
+	stats, err := Sync(context.Background(), cfg, &recordingSink{})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats.Errors != 1 {
+		t.Errorf("Expected the protected directory to be reported, got %d errors", stats.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "data", protectMarker)); err != nil {
+		t.Errorf("Expected the protected directory to be kept: %v", err)
+	}
+}
+
+func TestCheckTypeConflicts(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+
+	os.MkdirAll(filepath.Join(srcDir, "dir"), 0755)
+	createTestFile(t, filepath.Join(srcDir, "file.txt"), "file")
+	createTestFile(t, filepath.Join(srcDir, "dir", "a.txt"), "a")
+	os.MkdirAll(filepath.Join(dstDir, "file.txt"), 0755)
+	createTestFile(t, filepath.Join(dstDir, "file.txt", "inner.txt"), "inner")
+	createTestFile(t, filepath.Join(dstDir, "dir"), "dir")
+
+	diffs, err := Check(context.Background(), &config.Config{Source: srcDir, Target: dstDir, UpdateMethod: "modtime"}, &recordingSink{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	expected := []Difference{
+		{Path: "dir", Kind: DiffTypeConflict},
+		{Path: filepath.Join("dir", "a.txt"), Kind: DiffOnlyInSource},
+		{Path: "file.txt", Kind: DiffTypeConflict},
+		{Path: filepath.Join("file.txt", "inner.txt"), Kind: DiffOnlyInTarget},
+	}
+	if len(diffs) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, diffs)
+	}
+	for i := range expected {
+		if diffs[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, diffs)
+			break
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package stream
 
 import (
+	"crypto/md5"
 	"crypto/sha256"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"os"
+	"snc/internal/config"
+	"strings"
+	"time"
 )
 
 // UpdateStrategy defines the interface for different file update detection methods
@@ -14,6 +20,14 @@ type UpdateStrategy interface {
 	Name() string
 }
 
+// metadataStrategy is implemented by strategies that compare metadata
+// besides the contents. A target file that needs an update but whose
+// contents are up to date only gets the metadata of the source.
+type metadataStrategy interface {
+	UpdateStrategy
+	ContentNeedsUpdate(srcPath, dstPath string) (bool, error)
+}
+
 // ModTimeStrategy uses file modification time and size for update detection
 //
 // Pros:
@@ -27,7 +41,14 @@ type UpdateStrategy interface {
 //   - Not suitable for files that are frequently modified with same timestamp
 //
 // This is the default strategy for backward compatibility and performance
-type ModTimeStrategy struct{}
+type ModTimeStrategy struct {
+	// Offset is how far the target stores modification times off from the
+	// times set, e.g. on NAS devices applying a timezone
+	Offset time.Duration
+	// Tolerance is how far modification times may differ and still be
+	// taken as equal, e.g. on NFS targets
+	Tolerance time.Duration
+}
 
 func (m *ModTimeStrategy) Name() string {
 	return "modtime"
@@ -48,10 +69,78 @@ func (m *ModTimeStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
 	if srcInfo.Size() != dstInfo.Size() {
 		return true, nil
 	}
-	if !srcInfo.ModTime().Equal(dstInfo.ModTime()) {
-		return true, nil
+	return m.modTimeDiffers(srcInfo, dstInfo), nil
+}
+
+// modTimeDiffers reports whether the modification time of the target file
+// differs from that of the source, allowing for the offset and tolerance
+func (m *ModTimeStrategy) modTimeDiffers(srcInfo, dstInfo os.FileInfo) bool {
+	diff := dstInfo.ModTime().Sub(srcInfo.ModTime().Add(m.Offset))
+	return diff > m.Tolerance || diff < -m.Tolerance
+}
+
+// MetaStrategy compares size and modification time like ModTimeStrategy,
+// and also the permissions, ownership and extended attributes of the
+// files
+//
+// Pros:
+//   - Finds target metadata that drifted from the source, e.g. after a
+//     chmod on the target, while the contents are unchanged
+//
+// Cons:
+//   - Slower than modtime on files with extended attributes
+//
+// Copies made with it carry over the metadata of the source. Ownership is
+// only compared and carried over when running as root.
+type MetaStrategy struct {
+	ModTimeStrategy
+}
+
+func (m *MetaStrategy) Name() string {
+	return "meta"
+}
+
+func (m *MetaStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	if changed, err := m.ModTimeStrategy.NeedsUpdate(srcPath, dstPath); err != nil || changed {
+		return changed, err
 	}
-	return false, nil
+	return metadataDiffers(srcPath, dstPath)
+}
+
+// ContentNeedsUpdate reports whether size or modification time differ,
+// that is whether the contents need copying rather than only the metadata
+func (m *MetaStrategy) ContentNeedsUpdate(srcPath, dstPath string) (bool, error) {
+	return m.ModTimeStrategy.NeedsUpdate(srcPath, dstPath)
+}
+
+// SizeStrategy uses only the file size for update detection
+//
+// Pros:
+//   - Fastest possible check (a single stat per file)
+//   - Unaffected by timestamps that are not preserved by the target
+//
+// Cons:
+//   - Misses any change that keeps the file size identical
+//
+// Intended for large, write-once files such as media or disk images
+type SizeStrategy struct{}
+
+func (s *SizeStrategy) Name() string {
+	return "size"
+}
+
+func (s *SizeStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
+	}
+
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
+	}
+
+	return srcInfo.Size() != dstInfo.Size(), nil
 }
 
 // SHA256Strategy uses SHA256 checksums for update detection
@@ -68,40 +157,247 @@ func (m *ModTimeStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
 //   - Higher I/O usage (must read both source and destination files)
 //
 // Recommended for critical data or when file timestamps cannot be trusted
-type SHA256Strategy struct{}
+type SHA256Strategy struct {
+	// sidecars, if set, provides checksums shipped with the source, which
+	// are trusted instead of hashing the source file
+	sidecars *sidecarChecksums
+}
 
 func (s *SHA256Strategy) Name() string {
 	return "sha256"
 }
 
 func (s *SHA256Strategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
-	srcHash, err := calculateSHA256(srcPath)
+	return checksumsDifferWith(s, srcPath, dstPath, func() (string, error) { return sourceChecksum(s, srcPath) })
+}
+
+func (s *SHA256Strategy) newHash() hash.Hash {
+	return sha256.New()
+}
+
+// MD5Strategy uses MD5 checksums for update detection
+//
+// MD5 is not collision resistant, but it is what md5sum manifests and the
+// ETags of single-part S3 uploads contain, so comparisons match that
+// tooling. Prefer sha256 when interoperability does not matter.
+type MD5Strategy struct{}
+
+func (s *MD5Strategy) Name() string {
+	return "md5"
+}
+
+func (s *MD5Strategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	return checksumsDiffer(s, srcPath, dstPath)
+}
+
+func (s *MD5Strategy) newHash() hash.Hash {
+	return md5.New()
+}
+
+// CRC32CStrategy uses CRC-32C (Castagnoli) checksums for update detection
+//
+// CRC-32C is much faster to compute than a cryptographic hash, but only
+// reliably detects accidental corruption. It matches the checksums used by
+// cloud storage such as Google Cloud Storage and S3.
+type CRC32CStrategy struct{}
+
+func (s *CRC32CStrategy) Name() string {
+	return "crc32c"
+}
+
+func (s *CRC32CStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	return checksumsDiffer(s, srcPath, dstPath)
+}
+
+func (s *CRC32CStrategy) newHash() hash.Hash {
+	return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+}
+
+// defaultSampleSize is the size of each sample read by SampleStrategy
+const defaultSampleSize = 1 << 20
+
+// SampleStrategy compares the size and checksums of samples taken from the
+// start, middle and end of a file
+//
+// Pros:
+//   - Reads at most three samples per file, regardless of its size
+//   - Detects most changes to large media files, including re-encodes and
+//     truncated or appended copies
+//
+// Cons:
+//   - Misses changes that keep the size and lie outside the samples
+//
+// A middle ground between modtime and sha256 for multi-gigabyte files
+type SampleStrategy struct {
+	// SampleSize is the number of bytes in each sample
+	SampleSize int64
+}
+
+func (s *SampleStrategy) Name() string {
+	if s.SampleSize == defaultSampleSize {
+		return "sample"
+	}
+	return fmt.Sprintf("sample:%d", s.SampleSize)
+}
+
+func (s *SampleStrategy) NeedsUpdate(srcPath, dstPath string) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
+	}
+
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
+	}
+
+	if srcInfo.Size() != dstInfo.Size() {
+		return true, nil
+	}
+
+	size := srcInfo.Size()
+	return compareChecksums("sample checksum", srcPath, dstPath,
+		func() (string, error) { return s.sampleChecksum(srcPath, size) },
+		func() (string, error) { return s.sampleChecksum(dstPath, size) })
+}
+
+// sampleChecksum calculates the SHA256 hash of the samples of a file of the
+// given size. Files no larger than three samples are hashed completely.
+func (s *SampleStrategy) sampleChecksum(filePath string, size int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	n := s.SampleSize
+	if size <= 3*n {
+		return hashReader(file, sha256.New())
+	}
+
+	samples := io.MultiReader(
+		io.NewSectionReader(file, 0, n),
+		io.NewSectionReader(file, (size-n)/2, n),
+		io.NewSectionReader(file, size-n, n),
+	)
+	return hashReader(samples, sha256.New())
+}
+
+// sampleStream calculates the same hash as sampleChecksum from a stream of
+// the given size, which cannot seek, by reading past the data between the
+// samples
+func (s *SampleStrategy) sampleStream(r io.Reader, size int64) (string, error) {
+	n := s.SampleSize
+	if size <= 3*n {
+		return hashReader(r, sha256.New())
+	}
+
+	h := sha256.New()
+	var pos int64
+	for _, off := range []int64{0, (size - n) / 2, size - n} {
+		if _, err := io.CopyN(io.Discard, r, off-pos); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(h, r, n); err != nil {
+			return "", err
+		}
+		pos = off + n
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// checksumStrategy is implemented by strategies that compare file contents
+// by checksum
+type checksumStrategy interface {
+	UpdateStrategy
+	newHash() hash.Hash
+}
+
+// sourceChecksum returns the checksum of the source file srcPath for s,
+// taken from the sidecars a sha256 strategy trusts when they record it
+func sourceChecksum(s checksumStrategy, srcPath string) (string, error) {
+	if sha, ok := s.(*SHA256Strategy); ok {
+		if sum, ok := sha.sidecars.lookup(srcPath); ok {
+			return sum, nil
+		}
+	}
+	return calculateChecksum(srcPath, s.newHash())
+}
+
+// checksumsDiffer reports whether srcPath and dstPath have different
+// checksums. Files of different sizes always differ, so they are not read.
+func checksumsDiffer(s checksumStrategy, srcPath, dstPath string) (bool, error) {
+	return checksumsDifferWith(s, srcPath, dstPath, func() (string, error) { return calculateChecksum(srcPath, s.newHash()) })
+}
+
+// checksumsDifferWith is checksumsDiffer taking the checksum of the source
+// from srcHash
+func checksumsDifferWith(s checksumStrategy, srcPath, dstPath string, srcHash func() (string, error)) (bool, error) {
+	srcInfo, err := os.Stat(srcPath)
 	if err != nil {
-		return false, fmt.Errorf("cannot calculate SHA256 for source file %s: %w", srcPath, err)
+		return false, fmt.Errorf("cannot stat source file %s: %w", srcPath, err)
 	}
 
-	dstHash, err := calculateSHA256(dstPath)
+	dstInfo, err := os.Stat(dstPath)
 	if err != nil {
-		return false, fmt.Errorf("cannot calculate SHA256 for destination file %s: %w", dstPath, err)
+		return false, fmt.Errorf("cannot stat destination file %s: %w", dstPath, err)
 	}
 
-	return srcHash != dstHash, nil
+	if srcInfo.Size() != dstInfo.Size() {
+		return true, nil
+	}
+
+	return compareChecksums(s.Name(), srcPath, dstPath, srcHash,
+		func() (string, error) { return calculateChecksum(dstPath, s.newHash()) })
+}
+
+// compareChecksums runs srcHash and dstHash concurrently, since source and
+// target usually live on different devices, and reports whether the
+// checksums differ. name describes the checksum in errors.
+func compareChecksums(name, srcPath, dstPath string, srcHash, dstHash func() (string, error)) (bool, error) {
+	var dstSum string
+	var dstErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		dstSum, dstErr = dstHash()
+	}()
+
+	srcSum, srcErr := srcHash()
+	<-done
+
+	if srcErr != nil {
+		return false, fmt.Errorf("cannot calculate %s for source file %s: %w", name, srcPath, srcErr)
+	}
+	if dstErr != nil {
+		return false, fmt.Errorf("cannot calculate %s for destination file %s: %w", name, dstPath, dstErr)
+	}
+	return srcSum != dstSum, nil
 }
 
 // calculateSHA256 calculates the SHA256 hash of a file
 func calculateSHA256(filePath string) (string, error) {
+	return calculateChecksum(filePath, sha256.New())
+}
+
+// calculateChecksum calculates the checksum of a file with h
+func calculateChecksum(filePath string, h hash.Hash) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
 	defer file.Close()
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	return hashReader(file, h)
+}
+
+// hashReader calculates the checksum of everything read from r with h
+func hashReader(r io.Reader, h hash.Hash) (string, error) {
+	if _, err := io.Copy(h, r); err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
 // NewUpdateStrategy creates an UpdateStrategy based on the method name
@@ -109,17 +405,42 @@ func calculateSHA256(filePath string) (string, error) {
 // Supported methods:
 //   - "modtime": Fast but less reliable (default)
 //   - "sha256":  Slower but highly reliable
+//   - "size":    Fastest, only detects size changes
+//   - "md5":     Checksum matching md5sum manifests and S3 ETags
+//   - "crc32c":  Fast checksum matching cloud storage checksums
+//   - "sample":  Size and checksums of 1 MiB samples; "sample:SIZE" sets
+//     the sample size, e.g. "sample:8M"
+//   - "meta":    Like modtime, also comparing permissions, ownership and
+//     extended attributes
 //
 // The modtime strategy is recommended for most use cases due to its speed,
 // while sha256 is recommended for critical data synchronization where
 // reliability is more important than performance.
 func NewUpdateStrategy(method string) (UpdateStrategy, error) {
+	if arg, ok := strings.CutPrefix(method, "sample:"); ok {
+		n, err := config.ParseSize(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid sample size in update method %s", method)
+		}
+		return &SampleStrategy{SampleSize: n}, nil
+	}
+
 	switch method {
 	case "modtime":
 		return &ModTimeStrategy{}, nil
 	case "sha256":
 		return &SHA256Strategy{}, nil
+	case "size":
+		return &SizeStrategy{}, nil
+	case "md5":
+		return &MD5Strategy{}, nil
+	case "crc32c":
+		return &CRC32CStrategy{}, nil
+	case "sample":
+		return &SampleStrategy{SampleSize: defaultSampleSize}, nil
+	case "meta":
+		return &MetaStrategy{}, nil
 	default:
-		return nil, fmt.Errorf("unsupported update method: %s (supported: modtime, sha256)", method)
+		return nil, fmt.Errorf("unsupported update method: %s (supported: modtime, sha256, size, md5, crc32c, sample, meta)", method)
 	}
 }
@@ -1,8 +1,11 @@
 package stream
 
 import (
+	"hash"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -73,6 +76,44 @@ func TestModTimeStrategy(t *testing.T) {
 	}
 }
 
+func TestModTimeStrategyOffset(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "test content")
+
+	// The target stores times an hour behind
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(srcFile, mtime, mtime)
+	os.Chtimes(dstFile, mtime.Add(-time.Hour), mtime.Add(-time.Hour))
+
+	if needsUpdate, err := (&ModTimeStrategy{}).NeedsUpdate(srcFile, dstFile); err != nil || !needsUpdate {
+		t.Errorf("Expected an update without offset, got %v, %v", needsUpdate, err)
+	}
+	if needsUpdate, err := (&ModTimeStrategy{Offset: -time.Hour}).NeedsUpdate(srcFile, dstFile); err != nil || needsUpdate {
+		t.Errorf("Expected no update with the offset, got %v, %v", needsUpdate, err)
+	}
+}
+
+func TestDetectTimeOffset(t *testing.T) {
+	dir := t.TempDir()
+	offset, err := detectTimeOffset(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if offset != 0 {
+		t.Errorf("Expected no offset on a local filesystem, got %v", offset)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected the probe file to be removed, found %d entries", len(entries))
+	}
+
+	if _, err := detectTimeOffset(filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing target")
+	}
+}
+
 func TestSHA256Strategy(t *testing.T) {
 	// Create temporary test directory
 	tempDir, err := os.MkdirTemp("", "sync_test_*")
@@ -138,6 +179,208 @@ func TestSHA256Strategy(t *testing.T) {
 	}
 }
 
+func TestSizeStrategy(t *testing.T) {
+	// Create temporary test directory
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "TEST CONTENT")
+	os.Chtimes(srcFile, time.Now(), time.Now().Add(time.Hour))
+
+	strategy := &SizeStrategy{}
+
+	// Same size, different content and modtime - not detected
+	needsUpdate, err := strategy.NeedsUpdate(srcFile, dstFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if needsUpdate {
+		t.Error("Expected no update needed for files of equal size")
+	}
+
+	// Different size - should need update
+	createTestFile(t, dstFile, "short")
+	needsUpdate, err = strategy.NeedsUpdate(srcFile, dstFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !needsUpdate {
+		t.Error("Expected update needed for different size")
+	}
+
+	// Non-existent files
+	if _, err := strategy.NeedsUpdate("nonexistent.txt", dstFile); err == nil {
+		t.Error("Expected error for non-existent source file")
+	}
+	if _, err := strategy.NeedsUpdate(srcFile, "nonexistent.txt"); err == nil {
+		t.Error("Expected error for non-existent destination file")
+	}
+
+	if strategy.Name() != "size" {
+		t.Errorf("Expected name 'size', got '%s'", strategy.Name())
+	}
+}
+
+func TestChecksumStrategies(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+
+	tests := []struct {
+		strategy checksumStrategy
+		// checksum of "hello world\n" as printed by md5sum or crc32c tools
+		want string
+	}{
+		{&SHA256Strategy{}, "a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"},
+		{&MD5Strategy{}, "6f5902ac237024bdd0c176cb93063dc4"},
+		{&CRC32CStrategy{}, "f0ff7292"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy.Name(), func(t *testing.T) {
+			createTestFile(t, srcFile, "hello world\n")
+			createTestFile(t, dstFile, "hello world\n")
+
+			got, err := calculateChecksum(srcFile, tt.strategy.newHash())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected checksum %s, got %s", tt.want, got)
+			}
+
+			needsUpdate, err := tt.strategy.NeedsUpdate(srcFile, dstFile)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if needsUpdate {
+				t.Error("Expected no update needed for identical files")
+			}
+
+			// Same size, different content
+			createTestFile(t, dstFile, "hello World\n")
+			needsUpdate, err = tt.strategy.NeedsUpdate(srcFile, dstFile)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !needsUpdate {
+				t.Error("Expected update needed for different content")
+			}
+
+			if _, err := tt.strategy.NeedsUpdate("nonexistent.txt", dstFile); err == nil || !strings.Contains(err.Error(), "source file") {
+				t.Errorf("Expected error for non-existent source file, got %v", err)
+			}
+			if _, err := tt.strategy.NeedsUpdate(srcFile, "nonexistent.txt"); err == nil || !strings.Contains(err.Error(), "destination file") {
+				t.Errorf("Expected error for non-existent destination file, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSampleStrategy(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.bin")
+	dstFile := filepath.Join(tempDir, "destination.bin")
+
+	// 40 bytes with 4 byte samples at offsets 0, 18 and 36
+	content := "0123456789abcdefghijklmnopqrstuvwxyzABCD"
+	modify := func(offset int) string {
+		b := []byte(content)
+		b[offset] = '#'
+		return string(b)
+	}
+
+	tests := []struct {
+		name string
+		dst  string
+		want bool
+	}{
+		{name: "identical", dst: content, want: false},
+		{name: "change in first sample", dst: modify(1), want: true},
+		{name: "change in middle sample", dst: modify(19), want: true},
+		{name: "change in last sample", dst: modify(39), want: true},
+		{name: "change outside samples", dst: modify(10), want: false},
+		{name: "different size", dst: content + "E", want: true},
+	}
+
+	strategy := &SampleStrategy{SampleSize: 4}
+	createTestFile(t, srcFile, content)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			createTestFile(t, dstFile, tt.dst)
+			needsUpdate, err := strategy.NeedsUpdate(srcFile, dstFile)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if needsUpdate != tt.want {
+				t.Errorf("Expected needsUpdate %v, got %v", tt.want, needsUpdate)
+			}
+		})
+	}
+
+	// Small files are compared completely
+	small := &SampleStrategy{SampleSize: 16}
+	createTestFile(t, dstFile, modify(10))
+	if needsUpdate, err := small.NeedsUpdate(srcFile, dstFile); err != nil || !needsUpdate {
+		t.Errorf("Expected update needed for small file, got %v, %v", needsUpdate, err)
+	}
+
+	// Streams, such as decompressed copies, yield the same samples
+	for _, s := range []*SampleStrategy{strategy, small} {
+		want, _ := s.sampleChecksum(srcFile, int64(len(content)))
+		if got, err := s.sampleStream(strings.NewReader(content), int64(len(content))); err != nil || got != want {
+			t.Errorf("Expected stream samples %s with sample size %d, got %s (%v)", want, s.SampleSize, got, err)
+		}
+	}
+}
+
+// countingStrategy counts the hashes created by checksumsDiffer
+type countingStrategy struct {
+	SHA256Strategy
+	hashes atomic.Int32
+}
+
+func (c *countingStrategy) newHash() hash.Hash {
+	c.hashes.Add(1)
+	return c.SHA256Strategy.newHash()
+}
+
+func TestChecksumsDifferSizeShortCircuit(t *testing.T) {
+	tempDir := t.TempDir()
+	srcFile := filepath.Join(tempDir, "source.txt")
+	dstFile := filepath.Join(tempDir, "destination.txt")
+
+	createTestFile(t, srcFile, "test content")
+	createTestFile(t, dstFile, "longer test content")
+
+	strategy := &countingStrategy{}
+	needsUpdate, err := checksumsDiffer(strategy, srcFile, dstFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !needsUpdate {
+		t.Error("Expected update needed for different sizes")
+	}
+	if n := strategy.hashes.Load(); n != 0 {
+		t.Errorf("Expected no checksums for different sizes, got %d", n)
+	}
+
+	createTestFile(t, dstFile, "test CONTENT")
+	if _, err := checksumsDiffer(strategy, srcFile, dstFile); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := strategy.hashes.Load(); n != 2 {
+		t.Errorf("Expected 2 checksums for equal sizes, got %d", n)
+	}
+}
+
 func TestNewUpdateStrategy(t *testing.T) {
 	tests := []struct {
 		method    string
@@ -146,6 +389,13 @@ func TestNewUpdateStrategy(t *testing.T) {
 	}{
 		{"modtime", "modtime", false},
 		{"sha256", "sha256", false},
+		{"size", "size", false},
+		{"md5", "md5", false},
+		{"crc32c", "crc32c", false},
+		{"sample", "sample", false},
+		{"sample:4K", "sample:4096", false},
+		{"sample:x", "", true},
+		{"sample:0", "", true},
 		{"invalid", "", true},
 		{"", "", true},
 	}
@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// walkDir walks the tree at root like filepath.WalkDir. With more than one
+// worker, directories are read ahead in parallel, up to workers at a time
+// and at most workers subdirectories ahead per directory level.
+//
+// fn is still called from the calling goroutine, for one entry at a time
+// and in the same lexical order as filepath.WalkDir, so errors are reported
+// in the same order regardless of the number of workers and fn needs no
+// locking.
+func walkDir(root string, workers int, fn fs.WalkDirFunc) error {
+	if workers <= 1 {
+		return filepath.WalkDir(root, fn)
+	}
+
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w := &parallelWalker{workers: workers, sem: make(chan struct{}, workers), fn: fn}
+		d := fs.FileInfoToDirEntry(info)
+		var listing <-chan dirListing
+		if d.IsDir() {
+			listing = w.read(root)
+		}
+		err = w.walk(root, d, listing)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// dirListing is the result of reading a directory
+type dirListing struct {
+	entries []fs.DirEntry
+	err     error
+}
+
+type parallelWalker struct {
+	workers int
+	// sem limits the number of directories read at the same time
+	sem chan struct{}
+	fn  fs.WalkDirFunc
+}
+
+// read starts reading dir in the background. Results that are never
+// received, e.g. for skipped directories, are dropped with the channel.
+func (w *parallelWalker) read(dir string) <-chan dirListing {
+	ch := make(chan dirListing, 1)
+	go func() {
+		w.sem <- struct{}{}
+		entries, err := os.ReadDir(dir)
+		<-w.sem
+		ch <- dirListing{entries: entries, err: err}
+	}()
+	return ch
+}
+
+// walk visits path and, for directories, everything below it. listing
+// delivers the contents of a directory.
+func (w *parallelWalker) walk(path string, d fs.DirEntry, listing <-chan dirListing) error {
+	if err := w.fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	res := <-listing
+	if res.err != nil {
+		if err := w.fn(path, d, res.err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	// Keep up to w.workers subdirectories of this directory read ahead
+	pending := make([]<-chan dirListing, len(res.entries))
+	next, inFlight := 0, 0
+	fill := func() {
+		for ; next < len(res.entries) && inFlight < w.workers; next++ {
+			if res.entries[next].IsDir() {
+				pending[next] = w.read(filepath.Join(path, res.entries[next].Name()))
+				inFlight++
+			}
+		}
+	}
+	fill()
+
+	for i, entry := range res.entries {
+		name := filepath.Join(path, entry.Name())
+		if !entry.IsDir() {
+			if err := w.fn(name, entry, nil); err != nil {
+				if err == filepath.SkipDir {
+					// Skip the remaining entries of this directory
+					return nil
+				}
+				return err
+			}
+			continue
+		}
+
+		sub := pending[i]
+		pending[i] = nil
+		inFlight--
+		fill()
+		if err := w.walk(name, entry, sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWalkDirParallelMatchesSequential(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 4; j++ {
+			dir := filepath.Join(root, fmt.Sprintf("d%d", i), fmt.Sprintf("s%d", j))
+			os.MkdirAll(dir, 0755)
+			createTestFile(t, filepath.Join(dir, "file.txt"), "x")
+		}
+		createTestFile(t, filepath.Join(root, fmt.Sprintf("d%d", i), "a.txt"), "x")
+		createTestFile(t, filepath.Join(root, fmt.Sprintf("d%d", i), "z.txt"), "x")
+	}
+	createTestFile(t, filepath.Join(root, "top.txt"), "x")
+
+	tests := []struct {
+		name string
+		fn   func(visited *[]string) func(path string, d os.DirEntry, err error) error
+	}{
+		{
+			name: "all entries",
+			fn: func(visited *[]string) func(string, os.DirEntry, error) error {
+				return func(path string, d os.DirEntry, err error) error {
+					*visited = append(*visited, path)
+					return err
+				}
+			},
+		},
+		{
+			name: "skip directory",
+			fn: func(visited *[]string) func(string, os.DirEntry, error) error {
+				return func(path string, d os.DirEntry, err error) error {
+					*visited = append(*visited, path)
+					if d.IsDir() && d.Name() == "d2" {
+						return filepath.SkipDir
+					}
+					return err
+				}
+			},
+		},
+		{
+			name: "skip rest of directory from file",
+			fn: func(visited *[]string) func(string, os.DirEntry, error) error {
+				return func(path string, d os.DirEntry, err error) error {
+					*visited = append(*visited, path)
+					if filepath.Base(filepath.Dir(path)) == "d1" && d.Name() == "a.txt" {
+						return filepath.SkipDir
+					}
+					return err
+				}
+			},
+		},
+		{
+			name: "skip all",
+			fn: func(visited *[]string) func(string, os.DirEntry, error) error {
+				return func(path string, d os.DirEntry, err error) error {
+					*visited = append(*visited, path)
+					if d.Name() == "d3" {
+						return filepath.SkipAll
+					}
+					return err
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var want []string
+			if err := filepath.WalkDir(root, tt.fn(&want)); err != nil {
+				t.Fatalf("WalkDir failed: %v", err)
+			}
+
+			for _, workers := range []int{1, 2, 8} {
+				var got []string
+				if err := walkDir(root, workers, tt.fn(&got)); err != nil {
+					t.Fatalf("walkDir with %d workers failed: %v", workers, err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("walkDir with %d workers visited\n%v\nexpected\n%v", workers, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkDirParallelMissingRoot(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "missing")
+
+	var calls int
+	err := walkDir(root, 4, func(path string, d os.DirEntry, err error) error {
+		calls++
+		if err == nil {
+			t.Errorf("Expected error for missing root")
+		}
+		return err
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected one call and an error, got %d calls and %v", calls, err)
+	}
+}
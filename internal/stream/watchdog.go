@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// watchdogMaxInterval bounds how often a running copy is checked
+const watchdogMaxInterval = time.Second
+
+// stalledCopyError reports a copy aborted by the watchdog
+type stalledCopyError struct {
+	reason string
+}
+
+func (e *stalledCopyError) Error() string {
+	return e.reason
+}
+
+// copyWithWatchdog copies src to dst through buf like io.CopyBuffer, but
+// gives up when the copy runs longer than fileTimeout or transfers nothing
+// for stallTimeout. Zero disables the respective limit.
+//
+// Reads and writes on files cannot be interrupted, so on timeout abort is
+// called to close the files, which unblocks most stuck operations, and the
+// copy is abandoned. If the operation stays blocked (e.g. on a hard NFS
+// mount), its goroutine only exits once the kernel gives up; buf must not
+// be reused after a timeout.
+func copyWithWatchdog(dst io.Writer, src io.Reader, buf []byte, fileTimeout, stallTimeout time.Duration, abort func()) (int64, error) {
+	if fileTimeout <= 0 && stallTimeout <= 0 {
+		return io.CopyBuffer(dst, readerOnly{src}, buf)
+	}
+
+	var transferred atomic.Int64
+	type result struct {
+		n   int64
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := io.CopyBuffer(&countingWriter{w: dst, n: &transferred}, readerOnly{src}, buf)
+		done <- result{n, err}
+	}()
+
+	ticker := time.NewTicker(watchdogInterval(fileTimeout, stallTimeout))
+	defer ticker.Stop()
+
+	start := time.Now()
+	lastProgress, lastBytes := start, int64(0)
+	for {
+		select {
+		case r := <-done:
+			return r.n, r.err
+		case now := <-ticker.C:
+			if n := transferred.Load(); n != lastBytes {
+				lastProgress, lastBytes = now, n
+			}
+
+			var err error
+			switch {
+			case fileTimeout > 0 && now.Sub(start) >= fileTimeout:
+				err = &stalledCopyError{reason: fmt.Sprintf("copy did not finish within %s", fileTimeout)}
+			case stallTimeout > 0 && now.Sub(lastProgress) >= stallTimeout:
+				err = &stalledCopyError{reason: fmt.Sprintf("copy stalled: no data transferred for %s", stallTimeout)}
+			}
+			if err != nil {
+				abort()
+				return lastBytes, err
+			}
+		}
+	}
+}
+
+// watchdogInterval checks several times per limit, but at most once a second
+func watchdogInterval(limits ...time.Duration) time.Duration {
+	interval := watchdogMaxInterval
+	for _, limit := range limits {
+		if limit > 0 && limit/4 < interval {
+			interval = limit / 4
+		}
+	}
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// countingWriter records the number of bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingReader returns its data, then blocks until closed
+type blockingReader struct {
+	data   *strings.Reader
+	closed chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	if r.data.Len() > 0 {
+		return r.data.Read(p)
+	}
+	<-r.closed
+	return 0, io.ErrClosedPipe
+}
+
+func TestCopyWithWatchdog(t *testing.T) {
+	tests := []struct {
+		name         string
+		fileTimeout  time.Duration
+		stallTimeout time.Duration
+		stalls       bool
+		wantErr      string
+	}{
+		{name: "no limits", stalls: false},
+		{name: "completes within limits", fileTimeout: time.Second, stallTimeout: time.Second},
+		{name: "stall detected", stallTimeout: 50 * time.Millisecond, stalls: true, wantErr: "copy stalled"},
+		{name: "file timeout", fileTimeout: 50 * time.Millisecond, stalls: true, wantErr: "did not finish within"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var src io.Reader = strings.NewReader("hello")
+			var abort func()
+			aborted := false
+			if tt.stalls {
+				br := &blockingReader{data: strings.NewReader("hello"), closed: make(chan struct{})}
+				src = br
+				abort = func() {
+					aborted = true
+					close(br.closed)
+				}
+			}
+
+			var dst bytes.Buffer
+			n, err := copyWithWatchdog(&dst, src, make([]byte, 2), tt.fileTimeout, tt.stallTimeout, abort)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if n != 5 || dst.String() != "hello" {
+					t.Errorf("Expected 5 bytes \"hello\", got %d bytes %q", n, dst.String())
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+			if _, ok := err.(*stalledCopyError); !ok {
+				t.Errorf("Expected *stalledCopyError, got %T", err)
+			}
+			if !aborted {
+				t.Error("Expected abort to be called")
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/errors"
+	"snc/internal/events"
+)
+
+// writeStagingDir is the directory in the target root that holds the files
+// written by a run with StageWrites, under their target paths, until they
+// are swapped into place at the end of the run
+const writeStagingDir = ".snc-staging"
+
+// writeStaging redirects the files written to a target into its staging
+// directory
+type writeStaging struct {
+	target string
+}
+
+// newWriteStaging returns the staging of cfg.Target, or nil if writes are
+// not staged
+func newWriteStaging(cfg *config.Config) *writeStaging {
+	if !cfg.StageWrites {
+		return nil
+	}
+	return &writeStaging{target: filepath.Clean(cfg.Target)}
+}
+
+// path returns where the target file dst is written: dst itself without
+// staging
+func (s *writeStaging) path(dst string) string {
+	if s == nil {
+		return dst
+	}
+	rel, err := filepath.Rel(s.target, dst)
+	if err != nil || !filepath.IsLocal(rel) {
+		return dst
+	}
+	return filepath.Join(s.target, writeStagingDir, rel)
+}
+
+// SwapStagedWrites moves the files staged in cfg.Target into place, one
+// directory after the other. A staged directory missing from the target is
+// moved as a whole, so a new subtree appears at once. Staged files are
+// complete copies, so files left by a run that never finished are swapped
+// as well.
+func SwapStagedWrites(cfg *config.Config, sink events.EventSink) error {
+	root := filepath.Join(cfg.Target, writeStagingDir)
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return nil
+	}
+
+	n, failed := swapDir(root, filepath.Clean(cfg.Target), sink)
+	if failed > 0 {
+		return fmt.Errorf("%d staged files could not be moved into place, they are kept in %s", failed, root)
+	}
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+	events.Infof(sink, "STREAM", "Moved %d staged files into place in %s", n, cfg.Target)
+	return nil
+}
+
+// swapDir moves the entries of the staged directory staged into dir and
+// returns the number of files moved and of entries that failed
+func swapDir(staged, dir string, sink events.EventSink) (n, failed int) {
+	entries, err := os.ReadDir(staged)
+	if err != nil {
+		sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to read staged files", Path: staged, Err: err})
+		return 0, 1
+	}
+
+	for _, entry := range entries {
+		from, to := filepath.Join(staged, entry.Name()), filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			info, err := os.Lstat(to)
+			if err == nil && info.IsDir() {
+				moved, f := swapDir(from, to, sink)
+				n, failed = n+moved, failed+f
+				continue
+			}
+			if err == nil {
+				err = typeConflict(to, "a file in the target but a staged directory")
+			} else if err = os.Rename(from, to); err == nil {
+				n += countFiles(to)
+				continue
+			}
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to move staged directory into place", Op: events.OpCopy, Path: to, Err: err})
+			failed++
+			continue
+		}
+
+		if err := os.Rename(from, to); err != nil {
+			err = errors.NewFileError(errors.ErrCannotCreateFile, to, err)
+			sink.Error(events.ErrorEvent{Component: "STREAM", Message: "Failed to move staged file into place", Op: events.OpCopy, Path: to, Err: err})
+			failed++
+			continue
+		}
+		n++
+	}
+	return n, failed
+}
+
+// countFiles returns the number of files below dir
+func countFiles(dir string) int {
+	n := 0
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
@@ -0,0 +1,50 @@
+package synchronizer
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"snc/internal/events"
+	"snc/internal/logger"
+	"sync"
+)
+
+// errPermissionDenied is the cause of the cancellation when a permission
+// error aborts the run with config.PermissionErrorsAbort
+var errPermissionDenied = errors.New("permission denied")
+
+// deniedSink collects the paths that could not be read or written for
+// lack of permission, so they can be synced later with more privileges.
+// With abort set, the first of them cancels the run.
+type deniedSink struct {
+	events.Nop
+	abort func(cause error)
+
+	mu    sync.Mutex
+	paths []string
+}
+
+func (d *deniedSink) Error(ev events.ErrorEvent) {
+	if !errors.Is(ev.Err, fs.ErrPermission) {
+		return
+	}
+	d.mu.Lock()
+	d.paths = append(d.paths, ev.Path)
+	d.mu.Unlock()
+	if d.abort != nil {
+		d.abort(fmt.Errorf("%w: %s", errPermissionDenied, ev.Path))
+	}
+}
+
+// report logs the paths collected, if any
+func (d *deniedSink) report() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.paths) == 0 {
+		return
+	}
+	logger.Warn("SYNC", "Permission denied for %d paths; run again as a user allowed to access them, e.g. with sudo, to sync them:", len(d.paths))
+	for _, path := range d.paths {
+		logger.Warn("SYNC", "  %s", logger.EscapePath(path))
+	}
+}
@@ -1,57 +1,417 @@
 package synchronizer
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"snc/internal/auditlog"
 	"snc/internal/config"
+	"snc/internal/events"
 	"snc/internal/logger"
+	"snc/internal/metrics"
+	"snc/internal/report"
+	"snc/internal/snapshot"
 	"snc/internal/stream"
 	"snc/internal/validate/dir"
+	"snc/internal/webhook"
+	"time"
 )
 
 type Synchronizer struct {
-	cfg *config.Config
+	cfg  *config.Config
+	sink events.Multi
+
+	// confirmDelete, if set, is asked before missing files are deleted
+	confirmDelete ConfirmFunc
+
+	// stats of the last Sync call
+	stats *stream.Stats
 }
 
-func NewSynchronizer(provider config.ConfigProvider) *Synchronizer {
-	return &Synchronizer{cfg: provider.Config()}
+// ConfirmFunc decides whether the files in plan may be deleted
+type ConfirmFunc func(plan *stream.DeletePlan) (bool, error)
+
+// Option configures optional Synchronizer behaviour
+type Option func(*Synchronizer)
+
+// WithEventSink registers an additional sink receiving the engine's events.
+// Events are always logged and recorded in metrics as well.
+func WithEventSink(sink events.EventSink) Option {
+	return func(s *Synchronizer) {
+		s.sink = append(s.sink, sink)
+	}
 }
 
-func (s *Synchronizer) Sync() error {
+// WithDeleteConfirmation makes Sync ask confirm before deleting missing
+// files. It is asked once per run, before copying starts, and only if
+// there are files to delete; if it declines, no files are deleted.
+func WithDeleteConfirmation(confirm ConfirmFunc) Option {
+	return func(s *Synchronizer) {
+		s.confirmDelete = confirm
+	}
+}
+
+func NewSynchronizer(provider config.ConfigProvider, opts ...Option) *Synchronizer {
+	s := &Synchronizer{
+		cfg:  provider.Config(),
+		sink: events.Multi{logger.Sink{}, metrics.Sink{}},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Synchronizer) Sync(ctx context.Context) (err error) {
 	var hasErrors bool
 
+	cfg := s.cfg
+	stats := &stream.Stats{}
+	s.stats = stats
+
+	sink := s.sink
+	var errorReport *report.Sink
+	if cfg.ErrorReport != "" {
+		errorReport = &report.Sink{}
+		sink = append(events.Multi{errorReport}, s.sink...)
+	}
+
+	// Permission errors are listed after the summary
+	denied := &deniedSink{}
+	sink = append(events.Multi{denied}, sink...)
+
+	closeAudit := func(*error) {}
+	start := time.Now()
+	defer func() {
+		closeAudit(&err)
+		logger.FlushErrors()
+		if errorReport != nil {
+			writeErrorReport(errorReport, cfg)
+		}
+		logger.Info("SYNC", "Summary: %s in %s", stats, time.Since(start).Round(time.Millisecond))
+		logTiming(stats)
+		denied.report()
+		recordStats(stats)
+		metrics.SyncFinished(time.Since(start), err)
+		postWebhooks(s.cfg, stats, time.Since(start), err)
+	}()
+
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, cfg.MaxDuration, errTimeLimit)
+		defer cancel()
+	}
+	if cfg.PermissionErrors == config.PermissionErrorsAbort {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		denied.abort = cancel
+	}
+
 	logger.Info("SYNC", "Starting synchronization process")
+
+	var auditErr error
+	if sink, closeAudit, auditErr = withAuditLog(cfg, sink); auditErr != nil {
+		logger.Error("SYNC", "%v", auditErr)
+		return fmt.Errorf("sync failed: %w", auditErr)
+	}
 	logger.Debug("SYNC", "Configuration: Source=%s, Target=%s, DeleteMissing=%v",
-		s.cfg.Source, s.cfg.Target, s.cfg.DeleteMissing)
+		cfg.Source, cfg.Target, cfg.DeleteMissing)
+
+	if cfg.DeleteMissing && cfg.StageDeletes {
+		// Deletions left staged by a run that never finished were not
+		// confirmed either. Files that failed to copy leave the run
+		// unconfirmed as well, even though they do not fail it.
+		restoreDeletes(cfg, s.sink)
+		defer func() {
+			finishDeletes(cfg, s.sink, err == nil && ctx.Err() == nil && stats.Errors == 0)
+		}()
+	}
+	if cfg.StageWrites {
+		// Files staged by a run that never finished are complete copies
+		swapWrites(cfg, s.sink)
+		defer swapWrites(cfg, s.sink)
+	}
+
+	// Everything below reads the source from the snapshot, the error
+	// report included
+	if cfg.SnapshotCommand != "" {
+		snap, err := snapshot.Take(ctx, cfg.SnapshotCommand, cfg.SnapshotRelease, cfg.Source)
+		if err != nil {
+			logger.Error("SYNC", "Failed to snapshot the source: %v", err)
+			return fmt.Errorf("sync failed: %w", err)
+		}
+		defer func() {
+			// The snapshot is released even after cancellation
+			if err := snap.Release(context.Background()); err != nil {
+				logger.Warn("SYNC", "%v", err)
+			}
+		}()
+		logger.Info("SYNC", "Syncing from snapshot %s of %s", snap.Path, cfg.Source)
+
+		snapCfg := *cfg
+		snapCfg.Source = snap.Path
+		cfg = &snapCfg
+	}
+
+	if len(cfg.Targets) > 1 {
+		return s.syncTargets(ctx, cfg, sink, stats)
+	}
 
 	// Phase 1: Directory validation
 	logger.Info("SYNC", "Phase 1: Validating directories")
-	if err := dir.ValidateSyncDirs(s.cfg.Source, s.cfg.Target); err != nil {
-		logger.Error("SYNC", "Directory validation failed: %v", err)
-		hasErrors = true
-	} else {
-		logger.Success("SYNC", "Directory validation completed")
+	validateStart := time.Now()
+	validateErr := dir.ValidateSyncDirs(cfg.Source, cfg.Target)
+	stats.ValidateDuration = time.Since(validateStart)
+	if validateErr != nil {
+		// Every file would fail the same way
+		logger.Error("SYNC", "Directory validation failed: %v", validateErr)
+		return fmt.Errorf("sync failed: %w", validateErr)
+	}
+	logger.Success("SYNC", "Directory validation completed")
+
+	if cfg.DeleteMissing && s.confirmDelete != nil {
+		confirmed, err := s.confirmDeletion(ctx, cfg)
+		if err != nil {
+			logger.Error("SYNC", "Failed to confirm deletion: %v", err)
+			hasErrors = true
+		}
+		if !confirmed {
+			logger.Warn("SYNC", "Deletion not confirmed, missing files are kept")
+			noDelete := *cfg
+			noDelete.DeleteMissing = false
+			cfg = &noDelete
+		}
+	}
+
+	deleteMode := cfg.DeleteMode
+	if deleteMode == "" {
+		deleteMode = config.DeleteAfter
+	}
+
+	// Delete before copying frees space on constrained targets
+	if cfg.DeleteMissing && deleteMode == config.DeleteBefore {
+		logger.Info("SYNC", "Phase 2: Removing missing files before copying")
+		if !s.deleteMissing(ctx, cfg, nil, sink, stats) {
+			hasErrors = true
+		}
+		if ctx.Err() != nil {
+			return stopped(ctx, cfg, hasErrors)
+		}
+	}
+
+	// The files found by the sync walk are not looked up again by the
+	// delete phase after it
+	var index *stream.SourceIndex
+	if cfg.DeleteMissing && deleteMode == config.DeleteAfter {
+		index = stream.NewSourceIndex()
 	}
 
 	// Phase 2: File synchronization
 	logger.Info("SYNC", "Phase 2: Synchronizing files")
-	if err := stream.Sync(s.cfg); err != nil {
+	syncStats, err := stream.SyncIndexed(ctx, cfg, index, sink)
+	stats.Add(syncStats)
+	if err != nil && !timeLimitReached(ctx) {
 		logger.Error("SYNC", "File synchronization failed: %v", err)
 		hasErrors = true
 	} else {
 		logger.Success("SYNC", "File synchronization completed")
 	}
 
+	if ctx.Err() != nil {
+		return stopped(ctx, cfg, hasErrors)
+	}
+
 	// Phase 3: Delete missing files (if enabled)
-	if s.cfg.DeleteMissing {
+	switch {
+	case !cfg.DeleteMissing:
+		logger.Debug("SYNC", "Phase 3: Skipped (delete missing disabled)")
+	case deleteMode != config.DeleteAfter:
+		logger.Debug("SYNC", "Phase 3: Skipped (missing files removed %s copying)", deleteMode)
+	default:
 		logger.Info("SYNC", "Phase 3: Removing missing files")
-		if err := stream.DeleteMissing(s.cfg.Source, s.cfg.Target); err != nil {
-			logger.Error("SYNC", "Delete missing operation failed: %v", err)
+		if !s.deleteMissing(ctx, cfg, index, sink, stats) {
+			hasErrors = true
+		}
+	}
+
+	if ctx.Err() != nil {
+		return stopped(ctx, cfg, hasErrors)
+	}
+
+	if cfg.VerifyReport != "" {
+		logger.Info("SYNC", "Phase 4: Verifying the target")
+		if !s.verifyTargets(ctx, cfg.Source, []*config.Config{cfg}) {
+			hasErrors = true
+		}
+		if ctx.Err() != nil {
+			return stopped(ctx, cfg, hasErrors)
+		}
+	}
+
+	if hasErrors {
+		logger.Warn("SYNC", "Synchronization completed with errors - check logs for details")
+		return fmt.Errorf("sync completed with errors - check logs for details")
+	}
+
+	logger.Success("SYNC", "Synchronization completed successfully")
+	return nil
+}
+
+// errTimeLimit is the cause of the cancellation when a run reaches
+// cfg.MaxDuration
+var errTimeLimit = errors.New("time limit reached")
+
+// timeLimitReached reports whether ctx was cancelled by cfg.MaxDuration
+func timeLimitReached(ctx context.Context) bool {
+	return context.Cause(ctx) == errTimeLimit
+}
+
+// stopped logs why the run stopped early and returns its result. Reaching
+// the time limit is not an error by itself: files in flight were finished,
+// the state was saved, and the next run continues with the files left.
+// Missing files are not deleted then, since the source was only partly
+// walked. A permission error aborting the run fails it.
+func stopped(ctx context.Context, cfg *config.Config, hasErrors bool) error {
+	if timeLimitReached(ctx) {
+		logger.Warn("SYNC", "Time limit of %s reached, stopping; the next run continues with the remaining files", cfg.MaxDuration)
+		if hasErrors {
+			return fmt.Errorf("sync stopped with errors - check logs for details")
+		}
+		return nil
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, errPermissionDenied) {
+		logger.Error("SYNC", "Synchronization aborted: %v", cause)
+		return fmt.Errorf("sync aborted: %w", cause)
+	}
+	ctxErr := ctx.Err()
+	logger.Warn("SYNC", "Synchronization cancelled: %v", ctxErr)
+	return ctxErr
+}
+
+// syncTargets mirrors the source to every target in cfg.Targets with a
+// single walk of the source. Every target is validated, confirmed and
+// cleaned up on its own, and a target that fails is left alone for the
+// rest of the run without stopping the others.
+func (s *Synchronizer) syncTargets(ctx context.Context, cfg *config.Config, sink events.EventSink, stats *stream.Stats) error {
+	var hasErrors bool
+
+	deleteMode := cfg.DeleteMode
+	if deleteMode == "" {
+		deleteMode = config.DeleteAfter
+	}
+
+	// Phase 1: Directory validation, targets failing it are skipped
+	logger.Info("SYNC", "Phase 1: Validating %d targets", len(cfg.Targets))
+	var targets []*config.Config
+	targetStats := map[string]*stream.Stats{}
+	validateStart := time.Now()
+	for _, target := range cfg.Targets {
+		if err := dir.ValidateSyncDirs(cfg.Source, target); err != nil {
+			logger.Error("SYNC", "Directory validation failed, skipping %s: %v", target, err)
 			hasErrors = true
-		} else {
-			logger.Success("SYNC", "Delete missing operation completed")
+			continue
+		}
+
+		targetCfg := *cfg
+		targetCfg.Target = target
+		targetCfg.Targets = []string{target}
+		if targetCfg.DeleteMissing && s.confirmDelete != nil {
+			confirmed, err := s.confirmDeletion(ctx, &targetCfg)
+			if err != nil {
+				logger.Error("SYNC", "Failed to confirm deletion in %s: %v", target, err)
+				hasErrors = true
+			}
+			if !confirmed {
+				logger.Warn("SYNC", "Deletion not confirmed, missing files in %s are kept", target)
+				targetCfg.DeleteMissing = false
+			}
+		}
+		targets = append(targets, &targetCfg)
+		targetStats[target] = &stream.Stats{}
+	}
+	stats.ValidateDuration = time.Since(validateStart)
+	if len(targets) == 0 {
+		logger.Error("SYNC", "No target passed validation")
+		return fmt.Errorf("sync failed: no target passed validation")
+	}
+	logger.Success("SYNC", "Directory validation completed")
+
+	defer func() {
+		for _, t := range targets {
+			logger.Info("SYNC", "Target %s: %s", t.Target, targetStats[t.Target])
+			stats.Add(targetStats[t.Target])
+		}
+	}()
+
+	if deleteMode == config.DeleteBefore {
+		logger.Info("SYNC", "Phase 2: Removing missing files before copying")
+		for _, t := range targets {
+			if t.DeleteMissing && !s.deleteMissing(ctx, t, nil, sink, targetStats[t.Target]) {
+				hasErrors = true
+			}
 		}
+		if ctx.Err() != nil {
+			return stopped(ctx, cfg, hasErrors)
+		}
+	}
+
+	// Phase 2: File synchronization, one walk for all targets
+	logger.Info("SYNC", "Phase 2: Synchronizing files to %d targets", len(targets))
+	fanOut := *cfg
+	fanOut.Targets = nil
+	for _, t := range targets {
+		fanOut.Targets = append(fanOut.Targets, t.Target)
+	}
+	var index *stream.SourceIndex
+	if cfg.DeleteMissing && deleteMode == config.DeleteAfter {
+		index = stream.NewSourceIndex()
+	}
+	results, err := stream.SyncTargets(ctx, &fanOut, index, sink)
+	failed := map[string]bool{}
+	for _, r := range results {
+		targetStats[r.Target].Add(r.Stats)
+		if r.Err != nil && !timeLimitReached(ctx) {
+			logger.Error("SYNC", "File synchronization to %s failed: %v", r.Target, r.Err)
+			failed[r.Target] = true
+			hasErrors = true
+		}
+	}
+	if err != nil && !timeLimitReached(ctx) {
+		logger.Error("SYNC", "File synchronization failed: %v", err)
+		hasErrors = true
 	} else {
-		logger.Debug("SYNC", "Phase 3: Skipped (delete missing disabled)")
+		logger.Success("SYNC", "File synchronization completed")
+	}
+
+	if ctx.Err() != nil {
+		return stopped(ctx, cfg, hasErrors)
+	}
+
+	// Phase 3: Delete missing files (if enabled)
+	if cfg.DeleteMissing && deleteMode == config.DeleteAfter {
+		logger.Info("SYNC", "Phase 3: Removing missing files")
+		for _, t := range targets {
+			if !t.DeleteMissing || failed[t.Target] {
+				continue
+			}
+			if !s.deleteMissing(ctx, t, index, sink, targetStats[t.Target]) {
+				hasErrors = true
+			}
+		}
+		if ctx.Err() != nil {
+			return stopped(ctx, cfg, hasErrors)
+		}
+	}
+
+	if cfg.VerifyReport != "" {
+		logger.Info("SYNC", "Phase 4: Verifying %d targets", len(targets))
+		if !s.verifyTargets(ctx, cfg.Source, targets) {
+			hasErrors = true
+		}
+		if ctx.Err() != nil {
+			return stopped(ctx, cfg, hasErrors)
+		}
 	}
 
 	if hasErrors {
@@ -62,3 +422,492 @@ func (s *Synchronizer) Sync() error {
 	logger.Success("SYNC", "Synchronization completed successfully")
 	return nil
 }
+
+// Stats returns the statistics of the last Sync call, or nil if Sync was
+// not called yet
+func (s *Synchronizer) Stats() *stream.Stats {
+	return s.stats
+}
+
+// recordStats exposes the results of a sync run in the metrics
+func recordStats(stats *stream.Stats) {
+	metrics.LastRun(
+		map[string]int{
+			"copied":    stats.Copied,
+			"updated":   stats.Updated,
+			"skipped":   stats.Skipped,
+			"locked":    stats.Locked,
+			"special":   stats.Special,
+			"dangling":  stats.Dangling,
+			"linked":    stats.Linked,
+			"protected": stats.Protected,
+			"deferred":  stats.Deferred,
+			"deleted":   stats.Deleted,
+			"failed":    stats.Errors,
+		},
+		map[string]time.Duration{
+			"validate": stats.ValidateDuration,
+			"scan":     stats.ScanDuration,
+			"copy":     stats.CopyDuration,
+			"delete":   stats.DeleteDuration,
+		},
+	)
+}
+
+// logTiming logs how long the phases of a run took and its slowest files
+func logTiming(stats *stream.Stats) {
+	logger.Info("SYNC", "Timing: %s", stats.Timing())
+	if len(stats.Slowest) > 0 {
+		logger.Info("SYNC", "Slowest files: %s", stats.SlowestString())
+	}
+}
+
+// writeErrorReport writes the failures of a run to cfg.ErrorReport
+func writeErrorReport(errorReport *report.Sink, cfg *config.Config) {
+	if err := errorReport.Write(cfg.ErrorReport, cfg.Source, cfg.Target); err != nil {
+		logger.Error("SYNC", "%v", err)
+		return
+	}
+	logger.Info("SYNC", "Error report with %d failures written to %s", len(errorReport.Failures()), cfg.ErrorReport)
+}
+
+// withAuditLog adds the audit log of cfg, if any, to sink. The returned
+// function closes it and makes an error writing the log the error of the
+// run.
+func withAuditLog(cfg *config.Config, sink events.Multi) (events.Multi, func(*error), error) {
+	if cfg.AuditLog == "" {
+		return sink, func(*error) {}, nil
+	}
+	audit, err := auditlog.Open(cfg.AuditLog)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(events.Multi{audit}, sink...), func(err *error) {
+		if closeErr := audit.Close(); closeErr != nil {
+			logger.Error("SYNC", "%v", closeErr)
+			if *err == nil {
+				*err = closeErr
+			}
+		}
+	}, nil
+}
+
+// restoreDeletes moves the files staged for deletion in every target of
+// cfg back into place
+func restoreDeletes(cfg *config.Config, sink events.EventSink) {
+	for _, target := range targetsOf(cfg) {
+		t := *cfg
+		t.Target = target
+		if _, err := stream.RestoreDeletes(&t, sink); err != nil {
+			logger.Error("SYNC", "Failed to restore the files staged for deletion in %s: %v", target, err)
+		}
+	}
+}
+
+// finishDeletes deletes the files staged for deletion in every target of
+// cfg for good if the run is confirmed, and restores them otherwise
+func finishDeletes(cfg *config.Config, sink events.EventSink, confirmed bool) {
+	if !confirmed {
+		restoreDeletes(cfg, sink)
+		return
+	}
+	for _, target := range targetsOf(cfg) {
+		t := *cfg
+		t.Target = target
+		if err := stream.CommitDeletes(&t, sink); err != nil {
+			logger.Error("SYNC", "Failed to delete the files staged for deletion in %s: %v", target, err)
+		}
+	}
+}
+
+// swapWrites moves the files staged by writes in every target of cfg into
+// place
+func swapWrites(cfg *config.Config, sink events.EventSink) {
+	for _, target := range targetsOf(cfg) {
+		t := *cfg
+		t.Target = target
+		if err := stream.SwapStagedWrites(&t, sink); err != nil {
+			logger.Error("SYNC", "Failed to move the staged files into place in %s: %v", target, err)
+		}
+	}
+}
+
+// targetsOf returns the targets of cfg, which only names Target if it was
+// not built from flags
+func targetsOf(cfg *config.Config) []string {
+	if len(cfg.Targets) == 0 {
+		return []string{cfg.Target}
+	}
+	return cfg.Targets
+}
+
+// postWebhooks posts the summary of a run to the configured webhooks. A
+// failed post is only logged, the run itself is done.
+func postWebhooks(cfg *config.Config, stats *stream.Stats, elapsed time.Duration, err error) {
+	if len(cfg.Webhooks) == 0 || (err == nil && cfg.WebhookOn == config.WebhookFailure) {
+		return
+	}
+	summary := &webhook.Summary{
+		Source:   cfg.Source,
+		Targets:  cfg.Targets,
+		Stats:    stats.String(),
+		Duration: elapsed,
+		Err:      err,
+	}
+	for _, url := range cfg.Webhooks {
+		// Posted after cancellation as well, to report it
+		if postErr := webhook.Post(context.Background(), url, cfg.WebhookFormat, summary); postErr != nil {
+			logger.Warn("SYNC", "Failed to post the summary to a webhook: %v", postErr)
+		}
+	}
+}
+
+// confirmDeletion plans the delete missing phase and asks s.confirmDelete
+// whether to run it
+func (s *Synchronizer) confirmDeletion(ctx context.Context, cfg *config.Config) (bool, error) {
+	plan, err := stream.PlanDelete(ctx, cfg)
+	if err != nil {
+		return false, err
+	}
+	if len(plan.Files) == 0 {
+		return true, nil
+	}
+	return s.confirmDelete(plan)
+}
+
+// deleteMissing runs the delete missing phase, adds its results to stats
+// and reports whether it succeeded. Source files in index, if set, are not
+// looked up again.
+func (s *Synchronizer) deleteMissing(ctx context.Context, cfg *config.Config, index *stream.SourceIndex, sink events.EventSink, stats *stream.Stats) bool {
+	deleteStats, err := stream.DeleteMissingIndexed(ctx, cfg, index, sink)
+	stats.Add(deleteStats)
+	if err != nil {
+		if timeLimitReached(ctx) {
+			return true
+		}
+		logger.Error("SYNC", "Delete missing operation failed: %v", err)
+		return false
+	}
+	logger.Success("SYNC", "Delete missing operation completed")
+	return true
+}
+
+// Check compares source and target without modifying either of them and
+// returns the differences found
+func (s *Synchronizer) Check(ctx context.Context) ([]stream.Difference, error) {
+	logger.Info("SYNC", "Starting comparison")
+
+	if err := dir.ValidateCheckDirs(s.cfg.Source, s.cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return nil, err
+	}
+
+	diffs, err := stream.Check(ctx, s.cfg, s.sink)
+	logger.FlushErrors()
+	if err != nil {
+		logger.Error("SYNC", "Comparison failed: %v", err)
+		return diffs, err
+	}
+
+	logger.Success("SYNC", "Comparison completed: %d differences", len(diffs))
+	return diffs, nil
+}
+
+// Scrub rehashes the files in Target against the checksums in the
+// manifest cfg.Manifest to find files damaged by the storage, independent
+// of any sync
+func (s *Synchronizer) Scrub(ctx context.Context) (*stream.ScrubResult, error) {
+	logger.Info("SYNC", "Starting scrub of %s", s.cfg.Target)
+
+	if err := dir.ValidateSourceDir(s.cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return nil, err
+	}
+
+	result, err := stream.Scrub(ctx, s.cfg, s.sink)
+	logger.FlushErrors()
+	if err != nil {
+		logger.Error("SYNC", "Scrub failed: %v", err)
+		return result, err
+	}
+
+	logger.Info("SYNC", "Summary: %s", result)
+	return result, nil
+}
+
+// Decrypt restores the encrypted tree in Source as plain files in Target
+func (s *Synchronizer) Decrypt(ctx context.Context) error {
+	logger.Info("SYNC", "Starting decryption")
+
+	if err := dir.ValidateSyncDirs(s.cfg.Source, s.cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return err
+	}
+
+	err := stream.Decrypt(ctx, s.cfg, s.sink)
+	logger.FlushErrors()
+	if err != nil {
+		logger.Error("SYNC", "Decryption failed: %v", err)
+		return err
+	}
+
+	logger.Success("SYNC", "Decryption completed")
+	return nil
+}
+
+// Retry re-attempts the failed files listed in the error report
+// cfg.RetryFrom. Source and target default to those recorded in the
+// report; failures are recorded in a new error report like in Sync.
+func (s *Synchronizer) Retry(ctx context.Context) (err error) {
+	r, err := report.Read(s.cfg.RetryFrom)
+	if err != nil {
+		logger.Error("SYNC", "%v", err)
+		return err
+	}
+
+	cfg := *s.cfg
+	if cfg.Source == "" {
+		cfg.Source, cfg.Target = r.Source, r.Target
+	}
+	if cfg.Source == "" || cfg.Target == "" {
+		err := fmt.Errorf("%s does not name the source and target, pass them as arguments", cfg.RetryFrom)
+		logger.Error("SYNC", "%v", err)
+		return err
+	}
+
+	stats := &stream.Stats{}
+	s.stats = stats
+
+	sink := s.sink
+	var errorReport *report.Sink
+	if cfg.ErrorReport != "" {
+		errorReport = &report.Sink{}
+		sink = append(events.Multi{errorReport}, s.sink...)
+	}
+
+	closeAudit := func(*error) {}
+	start := time.Now()
+	defer func() {
+		closeAudit(&err)
+		logger.FlushErrors()
+		if errorReport != nil {
+			writeErrorReport(errorReport, &cfg)
+		}
+		logger.Info("SYNC", "Summary: %s in %s", stats, time.Since(start).Round(time.Millisecond))
+		logTiming(stats)
+		recordStats(stats)
+		metrics.SyncFinished(time.Since(start), err)
+	}()
+
+	logger.Info("SYNC", "Starting retry of the failures in %s", cfg.RetryFrom)
+
+	var auditErr error
+	if sink, closeAudit, auditErr = withAuditLog(&cfg, sink); auditErr != nil {
+		logger.Error("SYNC", "%v", auditErr)
+		return fmt.Errorf("retry failed: %w", auditErr)
+	}
+	if cfg.StageWrites {
+		swapWrites(&cfg, s.sink)
+		defer swapWrites(&cfg, s.sink)
+	}
+
+	if err := dir.ValidateSyncDirs(cfg.Source, cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return err
+	}
+
+	retryStats, err := stream.Retry(ctx, &cfg, r.Paths(cfg.Source, cfg.Target), sink)
+	stats.Add(retryStats)
+	if err != nil {
+		logger.Error("SYNC", "Retry failed: %v", err)
+		return err
+	}
+	if stats.Errors > 0 {
+		logger.Warn("SYNC", "Retry completed, %d files still failing", stats.Errors)
+		return fmt.Errorf("retry completed with errors - %d files still failing", stats.Errors)
+	}
+
+	logger.Success("SYNC", "Retry completed")
+	return nil
+}
+
+// SyncChanges syncs the source paths rels, relative to the source root,
+// that were found changed in watch mode, without walking the rest of
+// either tree
+func (s *Synchronizer) SyncChanges(ctx context.Context, rels []string) (err error) {
+	cfg := s.cfg
+	stats := &stream.Stats{}
+	s.stats = stats
+
+	closeAudit := func(*error) {}
+	start := time.Now()
+	defer func() {
+		closeAudit(&err)
+		logger.FlushErrors()
+		logger.Info("SYNC", "Summary: %s in %s", stats, time.Since(start).Round(time.Millisecond))
+		recordStats(stats)
+		metrics.SyncFinished(time.Since(start), err)
+	}()
+
+	sink, closeLog, err := withAuditLog(cfg, s.sink)
+	if err != nil {
+		logger.Error("SYNC", "%v", err)
+		return fmt.Errorf("sync failed: %w", err)
+	}
+	closeAudit = closeLog
+	if cfg.StageWrites {
+		swapWrites(cfg, s.sink)
+		defer swapWrites(cfg, s.sink)
+	}
+
+	changeStats, err := stream.SyncChanges(ctx, cfg, rels, sink)
+	stats.Add(changeStats)
+	if err != nil {
+		logger.Error("SYNC", "Syncing the changes failed: %v", err)
+		return err
+	}
+	if stats.Errors > 0 {
+		return fmt.Errorf("sync of changes completed with errors - check logs for details")
+	}
+	return nil
+}
+
+// Audit verifies that the target is still a faithful mirror of the source
+// without writing to either tree. It returns the differences a sync would
+// act on; files only present in the target are included only when
+// delete-missing is enabled, since a sync would otherwise keep them.
+func (s *Synchronizer) Audit(ctx context.Context) ([]stream.Difference, error) {
+	diffs, err := s.Check(ctx)
+	if err != nil {
+		return diffs, err
+	}
+
+	return auditedDiffs(diffs, s.cfg), nil
+}
+
+// auditedDiffs returns the differences of diffs a sync with cfg would act
+// on: files only present in the target are left out without
+// delete-missing
+func auditedDiffs(diffs []stream.Difference, cfg *config.Config) []stream.Difference {
+	if cfg.DeleteMissing {
+		return diffs
+	}
+
+	var relevant []stream.Difference
+	for _, d := range diffs {
+		if d.Kind != stream.DiffOnlyInTarget {
+			relevant = append(relevant, d)
+		}
+	}
+	return relevant
+}
+
+// DryRun compares source and target and returns the plan of the changes a
+// sync would make, without making them. With cfg.Plan, the plan is also
+// written there for Apply.
+func (s *Synchronizer) DryRun(ctx context.Context) (*stream.Plan, error) {
+	logger.Info("SYNC", "Starting dry run")
+
+	if err := dir.ValidateCheckDirs(s.cfg.Source, s.cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return nil, err
+	}
+
+	plan, err := stream.MakePlan(ctx, s.cfg, s.sink)
+	logger.FlushErrors()
+	if err != nil {
+		logger.Error("SYNC", "Dry run failed: %v", err)
+		return nil, err
+	}
+	if s.cfg.Plan != "" {
+		if err := stream.WritePlan(s.cfg.Plan, plan); err != nil {
+			logger.Error("SYNC", "%v", err)
+			return plan, err
+		}
+		logger.Info("SYNC", "Plan with %d changes written to %s", len(plan.Entries), s.cfg.Plan)
+	}
+
+	logger.Success("SYNC", "Dry run completed: %d changes planned", len(plan.Entries))
+	return plan, nil
+}
+
+// Apply makes exactly the changes of the plan in cfg.Plan, between the
+// source and target it was made for. Nothing is changed if any of its
+// files changed since.
+func (s *Synchronizer) Apply(ctx context.Context) (err error) {
+	plan, err := stream.ReadPlan(s.cfg.Plan)
+	if err != nil {
+		logger.Error("SYNC", "%v", err)
+		return err
+	}
+
+	cfg := *s.cfg
+	cfg.Source, cfg.Target, cfg.Targets = plan.Source, plan.Target, []string{plan.Target}
+
+	stats := &stream.Stats{}
+	s.stats = stats
+
+	closeAudit := func(*error) {}
+	start := time.Now()
+	defer func() {
+		closeAudit(&err)
+		logger.FlushErrors()
+		logger.Info("SYNC", "Summary: %s in %s", stats, time.Since(start).Round(time.Millisecond))
+		recordStats(stats)
+		metrics.SyncFinished(time.Since(start), err)
+	}()
+
+	logger.Info("SYNC", "Applying the plan in %s, made %s", cfg.Plan, plan.Created.Local().Format(time.DateTime))
+
+	sink := s.sink
+	var auditErr error
+	if sink, closeAudit, auditErr = withAuditLog(&cfg, sink); auditErr != nil {
+		logger.Error("SYNC", "%v", auditErr)
+		return fmt.Errorf("apply failed: %w", auditErr)
+	}
+	if cfg.StageWrites {
+		swapWrites(&cfg, s.sink)
+		defer swapWrites(&cfg, s.sink)
+	}
+
+	if err := dir.ValidateSyncDirs(cfg.Source, cfg.Target); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return err
+	}
+
+	applyStats, err := stream.ApplyPlan(ctx, &cfg, plan, sink)
+	stats.Add(applyStats)
+	if err != nil {
+		logger.Error("SYNC", "Applying the plan failed: %v", err)
+		return err
+	}
+	if stats.Errors > 0 {
+		logger.Warn("SYNC", "Plan applied with %d errors - check logs for details", stats.Errors)
+		return fmt.Errorf("apply completed with errors - check logs for details")
+	}
+
+	logger.Success("SYNC", "Plan applied")
+	return nil
+}
+
+// Doctor checks whether the target can faithfully mirror the source before
+// a sync and returns the findings. It only writes probe files to the
+// target, which need not exist yet.
+func (s *Synchronizer) Doctor(ctx context.Context) ([]stream.Finding, error) {
+	logger.Info("SYNC", "Starting environment checks")
+
+	if err := dir.ValidateSourceDir(s.cfg.Source); err != nil {
+		logger.Error("SYNC", "Directory validation failed: %v", err)
+		return nil, err
+	}
+
+	findings, err := stream.Doctor(ctx, s.cfg, s.sink)
+	logger.FlushErrors()
+	if err != nil {
+		logger.Error("SYNC", "Environment checks failed: %v", err)
+		return findings, err
+	}
+
+	logger.Success("SYNC", "Environment checks completed")
+	return findings, nil
+}
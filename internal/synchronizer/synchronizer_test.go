@@ -1,10 +1,22 @@
 package synchronizer
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
 	"snc/internal/config"
+	"snc/internal/events"
+	"snc/internal/report"
+	"snc/internal/stream"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewSynchronizer(t *testing.T) {
@@ -131,7 +143,7 @@ func TestSynchronizerSync(t *testing.T) {
 			provider := &mockConfigProvider{config: tt.config}
 			synchronizer := NewSynchronizer(provider)
 
-			err := synchronizer.Sync()
+			err := synchronizer.Sync(context.Background())
 
 			if tt.expectError {
 				if err == nil {
@@ -191,7 +203,7 @@ func TestSynchronizerSyncWithDeleteMissing(t *testing.T) {
 	provider := &mockConfigProvider{config: config}
 	synchronizer := NewSynchronizer(provider)
 
-	err = synchronizer.Sync()
+	err = synchronizer.Sync(context.Background())
 	if err != nil {
 		t.Fatalf("Unexpected error during sync: %v", err)
 	}
@@ -202,8 +214,195 @@ func TestSynchronizerSyncWithDeleteMissing(t *testing.T) {
 		t.Error("Expected source file to be copied")
 	}
 
-	// Note: The extra file should be deleted, but we can't easily test this
-	// without implementing the DeleteMissing functionality in the stream package
+	stats := synchronizer.Stats()
+	if stats == nil || stats.Copied != 1 || stats.Deleted != 1 {
+		t.Errorf("Expected 1 copied and 1 deleted file in stats, got %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "extra_file.txt")); !os.IsNotExist(err) {
+		t.Error("Expected extra file to be deleted")
+	}
+}
+
+func TestSynchronizerDeleteConfirmation(t *testing.T) {
+	tests := []struct {
+		name       string
+		extra      bool
+		confirm    bool
+		wantAsked  bool
+		wantDelete bool
+	}{
+		{name: "confirmed", extra: true, confirm: true, wantAsked: true, wantDelete: true},
+		{name: "declined", extra: true, confirm: false, wantAsked: true, wantDelete: false},
+		{name: "nothing to delete", extra: false, wantAsked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcDir := filepath.Join(t.TempDir(), "source")
+			dstDir := filepath.Join(t.TempDir(), "destination")
+			if err := os.MkdirAll(srcDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(dstDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(srcDir, "kept.txt"), []byte("kept"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			extraFile := filepath.Join(dstDir, "extra.txt")
+			if tt.extra {
+				if err := os.WriteFile(extraFile, []byte("extra"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var asked []string
+			confirm := func(plan *stream.DeletePlan) (bool, error) {
+				asked = append(asked, plan.Files...)
+				return tt.confirm, nil
+			}
+			cfg := &config.Config{Source: srcDir, Target: dstDir, DeleteMissing: true, UpdateMethod: "modtime"}
+			sn := NewSynchronizer(&mockConfigProvider{config: cfg}, WithDeleteConfirmation(confirm))
+			if err := sn.Sync(context.Background()); err != nil {
+				t.Fatalf("Unexpected error during sync: %v", err)
+			}
+
+			if tt.wantAsked != (len(asked) > 0) {
+				t.Errorf("Expected asked=%v, got files %v", tt.wantAsked, asked)
+			}
+			if tt.wantAsked && (len(asked) != 1 || asked[0] != "extra.txt") {
+				t.Errorf("Expected confirmation for extra.txt, got %v", asked)
+			}
+			if _, err := os.Stat(extraFile); tt.extra && tt.wantDelete != os.IsNotExist(err) {
+				t.Errorf("Expected deleted=%v, got stat error %v", tt.wantDelete, err)
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, "kept.txt")); err != nil {
+				t.Errorf("Expected the source file to be copied: %v", err)
+			}
+			if !cfg.DeleteMissing {
+				t.Error("Expected the provider's config to be left unchanged")
+			}
+		})
+	}
+}
+
+func TestSynchronizerAudit(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		LogLevel:     "error",
+		UpdateMethod: "sha256",
+	}
+	synchronizer := NewSynchronizer(&mockConfigProvider{config: cfg})
+	if err := synchronizer.Sync(context.Background()); err != nil {
+		t.Fatalf("Unexpected error during sync: %v", err)
+	}
+
+	// Extra files in the target are fine unless delete-missing is enabled
+	os.WriteFile(filepath.Join(dstDir, "extra.txt"), []byte("extra"), 0644)
+	diffs, err := synchronizer.Audit(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error during audit: %v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Expected a faithful mirror, got %v", diffs)
+	}
+
+	cfg.DeleteMissing = true
+	diffs, _ = synchronizer.Audit(context.Background())
+	if len(diffs) != 1 || diffs[0].Path != "extra.txt" {
+		t.Errorf("Expected extra.txt to be reported with delete-missing, got %v", diffs)
+	}
+
+	// Content changes are always reported and nothing is written
+	os.WriteFile(filepath.Join(dstDir, "file.txt"), []byte("tampered"), 0644)
+	diffs, _ = synchronizer.Audit(context.Background())
+	if len(diffs) != 2 {
+		t.Errorf("Expected 2 differences, got %v", diffs)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dstDir, "file.txt")); string(data) != "tampered" {
+		t.Error("Audit must not modify the target")
+	}
+}
+
+func TestSynchronizerDeleteModes(t *testing.T) {
+	for _, mode := range []string{config.DeleteBefore, config.DeleteAfter, config.DeleteDuring} {
+		t.Run(mode, func(t *testing.T) {
+			tempDir := t.TempDir()
+			srcDir := filepath.Join(tempDir, "source")
+			dstDir := filepath.Join(tempDir, "destination")
+			os.MkdirAll(srcDir, 0755)
+			os.MkdirAll(filepath.Join(dstDir, "old"), 0755)
+			os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+			os.WriteFile(filepath.Join(dstDir, "old", "extra.txt"), []byte("extra"), 0644)
+
+			cfg := &config.Config{
+				Source:        srcDir,
+				Target:        dstDir,
+				DeleteMissing: true,
+				DeleteMode:    mode,
+				LogLevel:      "error",
+				UpdateMethod:  "modtime",
+			}
+			if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+				t.Fatalf("Unexpected error during sync: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dstDir, "file.txt")); err != nil {
+				t.Errorf("Expected source file to be copied: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dstDir, "old", "extra.txt")); !os.IsNotExist(err) {
+				t.Errorf("Expected extra file to be deleted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSynchronizerSyncTargets(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	targets := []string{filepath.Join(tempDir, "first"), filepath.Join(tempDir, "second")}
+	for _, dir := range targets {
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "extra.txt"), []byte("extra"), 0644)
+	}
+	// A target below a regular file cannot be created
+	os.WriteFile(filepath.Join(tempDir, "blocker"), nil, 0644)
+	invalid := filepath.Join(tempDir, "blocker", "target")
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        targets[0],
+		Targets:       []string{targets[0], invalid, targets[1]},
+		DeleteMissing: true,
+		LogLevel:      "error",
+		UpdateMethod:  "modtime",
+	}
+	sn := NewSynchronizer(&mockConfigProvider{config: cfg})
+	if err := sn.Sync(context.Background()); err == nil {
+		t.Error("Expected an error for the invalid target")
+	}
+
+	for _, dir := range targets {
+		if _, err := os.Stat(filepath.Join(dir, "file.txt")); err != nil {
+			t.Errorf("Expected source file to be copied to %s: %v", dir, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "extra.txt")); !os.IsNotExist(err) {
+			t.Errorf("Expected extra file to be deleted from %s, got %v", dir, err)
+		}
+	}
+	if stats := sn.Stats(); stats.Copied != 2 || stats.Deleted != 2 {
+		t.Errorf("Expected 2 copied and 2 deleted files in total, got %+v", stats)
+	}
 }
 
 // Mock ConfigProvider for testing
@@ -214,3 +413,273 @@ type mockConfigProvider struct {
 func (m *mockConfigProvider) Config() *config.Config {
 	return m.config
 }
+
+func TestSynchronizerSyncFromSnapshot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands need a POSIX shell")
+	}
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	snapDir := filepath.Join(tempDir, "snapshot")
+	dstDir := filepath.Join(tempDir, "target")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("live"), 0644)
+
+	cfg := &config.Config{
+		Source:          srcDir,
+		Target:          dstDir,
+		LogLevel:        "error",
+		UpdateMethod:    "modtime",
+		SnapshotCommand: `cp -R "$SNC_SOURCE" "` + snapDir + `" && echo "` + snapDir + `"`,
+		SnapshotRelease: `rm -r "$SNC_SNAPSHOT"`,
+	}
+	sn := NewSynchronizer(&mockConfigProvider{config: cfg})
+	if err := sn.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(dstDir, "file.txt")); err != nil || string(data) != "live" {
+		t.Errorf("Expected the file to be copied from the snapshot, got %q, %v", data, err)
+	}
+	if _, err := os.Stat(snapDir); !os.IsNotExist(err) {
+		t.Errorf("Expected the snapshot to be released, got %v", err)
+	}
+}
+
+func TestSynchronizerMaxDuration(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "extra.txt"), []byte("extra"), 0644)
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		MaxDuration:   time.Nanosecond,
+		LogLevel:      "error",
+		UpdateMethod:  "modtime",
+	}
+	if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+		t.Fatalf("Expected reaching the time limit not to be an error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "extra.txt")); err != nil {
+		t.Errorf("Expected missing files to be kept after an incomplete run: %v", err)
+	}
+}
+
+func TestSynchronizerWebhooks(t *testing.T) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       filepath.Join(tempDir, "destination"),
+		Targets:      []string{filepath.Join(tempDir, "destination")},
+		LogLevel:     "error",
+		UpdateMethod: "modtime",
+		Webhooks:     []string{server.URL},
+		WebhookOn:    config.WebhookFailure,
+	}
+	if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if posts != 0 {
+		t.Errorf("Expected no post for a successful run with --webhook-on failure, got %d", posts)
+	}
+
+	cfg.WebhookOn = config.WebhookAlways
+	if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if posts != 1 {
+		t.Errorf("Expected the summary to be posted once, got %d", posts)
+	}
+}
+
+func TestSynchronizerStageDeletes(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(filepath.Join(dstDir, "sub"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "sub", "extra.txt"), []byte("extra"), 0644)
+	// A dangling symlink fails to copy in the first run
+	if err := os.Symlink(filepath.Join(tempDir, "missing"), filepath.Join(srcDir, "link")); err != nil {
+		t.Skipf("Cannot create symlinks: %v", err)
+	}
+
+	cfg := &config.Config{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		DeleteMode:    config.DeleteBefore,
+		StageDeletes:  true,
+		LogLevel:      "error",
+		UpdateMethod:  "modtime",
+
+		DanglingSymlinks: config.DanglingSymlinksError,
+	}
+	NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background())
+	if _, err := os.Stat(filepath.Join(dstDir, "sub", "extra.txt")); err != nil {
+		t.Errorf("Expected the deleted file to be restored after a failed run: %v", err)
+	}
+
+	os.Remove(filepath.Join(srcDir, "link"))
+	if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "sub", "extra.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected the deletion to be confirmed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dstDir); len(entries) != 2 {
+		t.Errorf("Expected only file.txt and sub in the target, got %v", entries)
+	}
+}
+
+func TestSynchronizerStageWrites(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(filepath.Join(dstDir, ".snc-staging"), 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+	// Left staged by a run that never finished
+	os.WriteFile(filepath.Join(srcDir, "left.txt"), []byte("left"), 0644)
+	os.WriteFile(filepath.Join(dstDir, ".snc-staging", "left.txt"), []byte("left"), 0644)
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		StageWrites:  true,
+		LogLevel:     "error",
+		UpdateMethod: "size",
+	}
+	if err := NewSynchronizer(&mockConfigProvider{config: cfg}).Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	for _, name := range []string{"file.txt", "left.txt"} {
+		if _, err := os.Stat(filepath.Join(dstDir, name)); err != nil {
+			t.Errorf("Expected %s to be moved into place: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, ".snc-staging")); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging directory to be removed: %v", err)
+	}
+}
+
+func TestSynchronizerVerifyReport(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+	reportPath := filepath.Join(tempDir, "verify.json")
+
+	cfg := &config.Config{
+		Source:       srcDir,
+		Target:       dstDir,
+		VerifyReport: reportPath,
+		LogLevel:     "error",
+		UpdateMethod: "sha256",
+	}
+	s := NewSynchronizer(&mockConfigProvider{config: cfg})
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	readReport := func() verification {
+		t.Helper()
+		var v verification
+		data, err := os.ReadFile(reportPath)
+		if err != nil {
+			t.Fatalf("Failed to read the report: %v", err)
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			t.Fatalf("Failed to parse the report: %v", err)
+		}
+		return v
+	}
+	if v := readReport(); !v.Passed || len(v.Targets) != 1 || !v.Targets[0].Passed {
+		t.Errorf("Expected the verification to pass, got %+v", v)
+	}
+
+	// Corrupted after the copy, with the same size and modification time
+	info, _ := os.Stat(filepath.Join(dstDir, "file.txt"))
+	os.WriteFile(filepath.Join(dstDir, "file.txt"), []byte("CONTENT"), 0644)
+	os.Chtimes(filepath.Join(dstDir, "file.txt"), info.ModTime(), info.ModTime())
+	if s.verifyTargets(context.Background(), srcDir, []*config.Config{cfg}) {
+		t.Error("Expected the verification to fail")
+	}
+	v := readReport()
+	if v.Passed || len(v.Targets[0].Differences) != 1 || v.Targets[0].Differences[0].Kind != stream.DiffContent {
+		t.Errorf("Expected a content difference in the report, got %+v", v)
+	}
+}
+
+func TestSynchronizerRetryErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	// A path outside the source and target cannot be retried
+	reportPath := filepath.Join(tempDir, "errors.json")
+	data, _ := json.Marshal(report.Report{Source: srcDir, Target: dstDir, Failures: []report.Failure{
+		{Path: filepath.Join(srcDir, "file.txt"), Operation: "copy"},
+		{Path: filepath.Join(tempDir, "elsewhere.txt"), Operation: "copy"},
+	}})
+	os.WriteFile(reportPath, data, 0644)
+
+	cfg := &config.Config{
+		Command:      config.CommandRetry,
+		RetryFrom:    reportPath,
+		LogLevel:     "error",
+		UpdateMethod: "modtime",
+	}
+	s := NewSynchronizer(&mockConfigProvider{config: cfg})
+	err := s.Retry(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "1 files still failing") {
+		t.Errorf("Expected an error for the file still failing, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dstDir, "file.txt")); err != nil {
+		t.Errorf("Expected file.txt to be retried: %v", err)
+	}
+}
+
+func TestDeniedSinkAbort(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	denied := &deniedSink{abort: cancel}
+
+	denied.Error(events.ErrorEvent{Path: "/src/other", Err: errors.New("disk full")})
+	if ctx.Err() != nil || len(denied.paths) != 0 {
+		t.Fatalf("Expected other errors to be ignored, got %v", denied.paths)
+	}
+
+	denied.Error(events.ErrorEvent{Path: "/src/secret", Err: &fs.PathError{Op: "open", Path: "/src/secret", Err: fs.ErrPermission}})
+	if len(denied.paths) != 1 || denied.paths[0] != "/src/secret" {
+		t.Errorf("Expected the denied path to be collected, got %v", denied.paths)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("Expected the run to be cancelled")
+	}
+	if err := stopped(ctx, &config.Config{}, false); !errors.Is(err, errPermissionDenied) {
+		t.Errorf("Expected the run to fail with %v, got %v", errPermissionDenied, err)
+	}
+}
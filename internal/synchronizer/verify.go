@@ -0,0 +1,99 @@
+package synchronizer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"snc/internal/config"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"time"
+)
+
+// verification is the report written to cfg.VerifyReport after a sync
+type verification struct {
+	Source  string    `json:"source"`
+	Created time.Time `json:"created"`
+	// Method is the update method the files were compared with
+	Method  string               `json:"method"`
+	Passed  bool                 `json:"passed"`
+	Targets []targetVerification `json:"targets"`
+}
+
+// targetVerification is the result of verifying a single target
+type targetVerification struct {
+	Target string `json:"target"`
+	Passed bool   `json:"passed"`
+	// Error is why the comparison could not be completed, if it could not
+	Error       string              `json:"error,omitempty"`
+	Differences []stream.Difference `json:"differences"`
+}
+
+// verifyTargets compares every target in targets with the source again
+// after a sync, keeping the differences an audit would report, and writes
+// the result to the report file of the first. source is the source named
+// in the report, the original one when the run synced from a snapshot. It
+// reports whether every target matched.
+func (s *Synchronizer) verifyTargets(ctx context.Context, source string, targets []*config.Config) bool {
+	cfg := targets[0]
+	v := &verification{Source: source, Created: time.Now().UTC(), Method: cfg.UpdateMethod, Passed: true}
+	for _, t := range targets {
+		// Staged files are verified where readers of the target see them
+		if t.StageWrites {
+			if err := stream.SwapStagedWrites(t, s.sink); err != nil {
+				logger.Error("SYNC", "Failed to move the staged files into place in %s: %v", t.Target, err)
+			}
+		}
+
+		diffs, err := stream.Check(ctx, t, s.sink)
+		result := targetVerification{Target: t.Target, Differences: auditedDiffs(diffs, t)}
+		if result.Differences == nil {
+			result.Differences = []stream.Difference{}
+		}
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+			logger.Error("SYNC", "Verification of %s could not be completed: %v", t.Target, err)
+		case len(result.Differences) > 0:
+			logger.Error("SYNC", "Verification of %s failed: %d differences", t.Target, len(result.Differences))
+		default:
+			result.Passed = true
+			logger.Success("SYNC", "Verification of %s passed", t.Target)
+		}
+		v.Passed = v.Passed && result.Passed
+		v.Targets = append(v.Targets, result)
+	}
+
+	if err := writeVerification(cfg.VerifyReport, v); err != nil {
+		logger.Error("SYNC", "%v", err)
+		return false
+	}
+	logger.Info("SYNC", "Verification report written to %s", cfg.VerifyReport)
+	return v.Passed
+}
+
+// writeVerification writes v to path as JSON. The file is replaced as a
+// whole, so it never holds a partial report.
+func writeVerification(path string, v *verification) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write verification report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(v)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write verification report: %w", err)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package dir
 
 import (
+	"io"
 	"os"
 	"snc/internal/errors"
 )
@@ -10,6 +11,10 @@ type configOption func(*config)
 type config struct {
 	allowCreate bool
 	perm        os.FileMode
+	// readProbe lists the directory, writeProbe creates and deletes a file
+	// in it, to find missing permissions before the first file does
+	readProbe  bool
+	writeProbe bool
 }
 
 func newConfig(opts ...configOption) *config {
@@ -31,6 +36,18 @@ func withAllowCreate() configOption {
 	}
 }
 
+func withReadProbe() configOption {
+	return func(cfg *config) {
+		cfg.readProbe = true
+	}
+}
+
+func withWriteProbe() configOption {
+	return func(cfg *config) {
+		cfg.writeProbe = true
+	}
+}
+
 func validateDir(path string, opts ...configOption) error {
 	cfg := newConfig(opts...)
 
@@ -48,17 +65,78 @@ func validateDir(path string, opts ...configOption) error {
 	if !info.IsDir() {
 		return errors.NewDirectoryError(errors.ErrNotADirectory, path, nil)
 	}
+	if cfg.readProbe {
+		if err := probeRead(path); err != nil {
+			return errors.NewDirectoryError(errors.ErrDirectoryNotReadable, path, err)
+		}
+	}
+	if cfg.writeProbe {
+		if err := probeWrite(path); err != nil {
+			return errors.NewDirectoryError(errors.ErrDirectoryNotWritable, path, err)
+		}
+	}
+	return nil
+}
+
+// probeRead lists the first entry of the directory at path
+func probeRead(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.ReadDir(1); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
+// probeWrite creates a temporary file in the directory at path and
+// deletes it again
+func probeWrite(path string) error {
+	f, err := os.CreateTemp(path, ".snc-probe-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	closeErr := f.Close()
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return closeErr
+}
+
 // ValidateSyncDirs validates the source and target directories.
-// Source must exist; target is created if missing.
+// Source must exist and be readable; target is created if missing and
+// must be writable.
 func ValidateSyncDirs(src, dst string) error {
-	if err := validateDir(src); err != nil {
+	if err := validateDir(src, withReadProbe()); err != nil {
 		return errors.NewValidationError(errors.ErrSourceDirValidation, "source directory", err)
 	}
-	if err := validateDir(dst, withAllowCreate()); err != nil {
+	if err := validateDir(dst, withAllowCreate(), withWriteProbe()); err != nil {
 		return errors.NewValidationError(errors.ErrTargetDirValidation, "target directory", err)
 	}
 	return nil
 }
+
+// ValidateCheckDirs validates the source and target directories for a
+// read-only comparison. Both must exist and be readable; nothing is
+// created.
+func ValidateCheckDirs(src, dst string) error {
+	if err := validateDir(src, withReadProbe()); err != nil {
+		return errors.NewValidationError(errors.ErrSourceDirValidation, "source directory", err)
+	}
+	if err := validateDir(dst, withReadProbe()); err != nil {
+		return errors.NewValidationError(errors.ErrTargetDirValidation, "target directory", err)
+	}
+	return nil
+}
+
+// ValidateSourceDir validates the source directory alone, for commands
+// that do not need the target to exist
+func ValidateSourceDir(src string) error {
+	if err := validateDir(src, withReadProbe()); err != nil {
+		return errors.NewValidationError(errors.ErrSourceDirValidation, "source directory", err)
+	}
+	return nil
+}
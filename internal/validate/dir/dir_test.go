@@ -1,8 +1,11 @@
 package dir
 
 import (
+	stderrors "errors"
 	"os"
 	"path/filepath"
+	"runtime"
+	"snc/internal/errors"
 	"testing"
 )
 
@@ -208,3 +211,35 @@ func contains(s, substr string) bool {
 	return len(s) >= len(substr) && s[:len(substr)] == substr ||
 		len(s) > len(substr) && contains(s[1:], substr)
 }
+
+func TestValidateDirProbes(t *testing.T) {
+	tempDir := t.TempDir()
+
+	if err := validateDir(tempDir, withReadProbe(), withWriteProbe()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to list directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected the write probe to leave nothing behind, got %v", entries)
+	}
+
+	// Permissions are not enforced for root, nor by chmod on Windows
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("Cannot revoke permissions")
+	}
+	readOnly := filepath.Join(tempDir, "readonly")
+	os.Mkdir(readOnly, 0555)
+	defer os.Chmod(readOnly, 0755)
+	if err := validateDir(readOnly, withWriteProbe()); !stderrors.Is(err, errors.ErrDirectoryNotWritable) {
+		t.Errorf("Expected %v, got %v", errors.ErrDirectoryNotWritable, err)
+	}
+	unlisted := filepath.Join(tempDir, "unlisted")
+	os.Mkdir(unlisted, 0300)
+	defer os.Chmod(unlisted, 0755)
+	if err := validateDir(unlisted, withReadProbe()); !stderrors.Is(err, errors.ErrDirectoryNotReadable) {
+		t.Errorf("Expected %v, got %v", errors.ErrDirectoryNotReadable, err)
+	}
+}
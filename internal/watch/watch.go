@@ -0,0 +1,178 @@
+// Package watch follows the changes below a source directory, so a daemon
+// can sync the paths that changed without walking the whole tree.
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// meta is the metadata of a path kept in the cache; a change in any of it
+// means the path needs syncing
+type meta struct {
+	size    int64
+	modTime int64
+	mode    fs.FileMode
+}
+
+func metaOf(info fs.FileInfo) meta {
+	return meta{size: info.Size(), modTime: info.ModTime().UnixNano(), mode: info.Mode()}
+}
+
+// Watcher follows the changes below a directory. It keeps the metadata of
+// every path below it in memory, updated by the events of the operating
+// system, so that only paths whose metadata actually changed are reported.
+type Watcher struct {
+	root string
+	platform
+
+	mu sync.Mutex
+	// cache holds the metadata of every path below the root, relative to it
+	cache map[string]meta
+	// children holds the cached paths in every directory, keyed by the
+	// directory, so a removed subtree is dropped without a scan of the
+	// whole cache
+	children map[string]map[string]bool
+	// pending holds the paths changed since the last Take
+	pending map[string]bool
+	// missed is set when changes were missed since the last Take
+	missed bool
+	ready  chan struct{}
+}
+
+// New starts watching root and everything below it
+func New(root string) (*Watcher, error) {
+	w := &Watcher{
+		root:     filepath.Clean(root),
+		cache:    make(map[string]meta),
+		children: make(map[string]map[string]bool),
+		pending:  make(map[string]bool),
+		ready:    make(chan struct{}, 1),
+	}
+	if err := w.start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Ready is signalled when changes are pending
+func (w *Watcher) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Take returns the paths changed since the last call, relative to the
+// root and in lexical order, and clears them. Paths below a changed
+// directory are left out, since syncing the directory covers them. missed
+// reports that changes were lost in the meantime, so the paths are
+// incomplete and the whole tree needs syncing.
+func (w *Watcher) Take() (paths []string, missed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for rel := range w.pending {
+		if !hasParentIn(w.pending, rel) {
+			paths = append(paths, rel)
+		}
+	}
+	clear(w.pending)
+	missed, w.missed = w.missed, false
+	sort.Strings(paths)
+	// A signal still pending refers to the changes taken
+	select {
+	case <-w.ready:
+	default:
+	}
+	return paths, missed
+}
+
+// hasParentIn reports whether a directory above rel is in paths
+func hasParentIn(paths map[string]bool, rel string) bool {
+	for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+		if paths[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// update compares the metadata of rel with the cache and records it as
+// changed if it differs, or if rel was removed. The caller holds w.mu.
+func (w *Watcher) update(rel string) {
+	info, err := os.Lstat(filepath.Join(w.root, rel))
+	if err != nil {
+		if _, ok := w.cache[rel]; ok {
+			w.forget(rel)
+			w.changed(rel)
+		}
+		return
+	}
+
+	m := metaOf(info)
+	if old, ok := w.cache[rel]; ok && old == m {
+		return
+	}
+	w.store(rel, m)
+	w.changed(rel)
+}
+
+// store records the metadata of rel in the cache. The caller holds w.mu.
+func (w *Watcher) store(rel string, m meta) {
+	if _, ok := w.cache[rel]; !ok {
+		dir := parentOf(rel)
+		if w.children[dir] == nil {
+			w.children[dir] = make(map[string]bool)
+		}
+		w.children[dir][rel] = true
+	}
+	w.cache[rel] = m
+}
+
+// forget drops rel and everything below it from the cache. The caller
+// holds w.mu.
+func (w *Watcher) forget(rel string) {
+	w.subtree(rel, func(path string) {
+		delete(w.cache, path)
+		delete(w.children, path)
+	})
+	if siblings := w.children[parentOf(rel)]; siblings != nil {
+		delete(siblings, rel)
+		if len(siblings) == 0 {
+			delete(w.children, parentOf(rel))
+		}
+	}
+}
+
+// subtree calls fn for every cached path below rel, deepest first, and
+// then for rel itself. The caller holds w.mu.
+func (w *Watcher) subtree(rel string, fn func(path string)) {
+	for child := range w.children[rel] {
+		w.subtree(child, fn)
+	}
+	fn(rel)
+}
+
+// parentOf returns the directory of rel, "" for the root
+func parentOf(rel string) string {
+	if dir := filepath.Dir(rel); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// changed records rel as pending and signals Ready. The caller holds
+// w.mu.
+func (w *Watcher) changed(rel string) {
+	w.pending[rel] = true
+	w.signal()
+}
+
+// signal signals Ready, unless it is already. The caller holds w.mu.
+func (w *Watcher) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,203 @@
+package watch
+
+import (
+	"bytes"
+	"encoding/binary"
+	stderrors "errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"snc/internal/logger"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask selects the inotify events that can change what a sync copies
+const watchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM |
+	unix.IN_MOVED_TO | unix.IN_ATTRIB | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK | unix.IN_ONLYDIR
+
+// platform follows the directories below the root with inotify
+type platform struct {
+	fd   int
+	file *os.File
+	// dirs maps watch descriptors to the directories watched, relative to
+	// the root, and watches the reverse
+	dirs    map[int32]string
+	watches map[string]int32
+}
+
+func (w *Watcher) start() error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("cannot watch %s: %w", w.root, err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so
+	// Close interrupts a pending read
+	w.fd, w.file = fd, os.NewFile(uintptr(fd), "inotify")
+	w.dirs = make(map[int32]string)
+	w.watches = make(map[string]int32)
+
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return err
+	}
+	logger.Info("WATCH", "Watching %d directories below %s for changes", len(w.watches), w.root)
+	go w.read()
+	return nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
+
+// addTree watches the directory rel and all directories below it, and
+// adds everything below it to the cache. The caller holds w.mu, unless
+// the watcher is not started yet.
+func (w *Watcher) addTree(rel string) error {
+	top := filepath.Join(w.root, rel)
+	return filepath.WalkDir(top, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == top {
+				return err
+			}
+			// Removed again while walking
+			return nil
+		}
+		sub, _ := filepath.Rel(w.root, path)
+		if sub == "." {
+			sub = ""
+		}
+		if info, err := d.Info(); err == nil && sub != "" {
+			w.store(sub, metaOf(info))
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, path, watchMask)
+		if err != nil {
+			if stderrors.Is(err, unix.ENOSPC) {
+				return fmt.Errorf("cannot watch %s: too many directories, raise fs.inotify.max_user_watches: %w", path, err)
+			}
+			return fmt.Errorf("cannot watch %s: %w", path, err)
+		}
+		w.dirs[int32(wd)] = sub
+		w.watches[sub] = int32(wd)
+		return nil
+	})
+}
+
+// removeTree stops watching rel and the directories below it, as found in
+// the cache, so it is called before forget. The caller holds w.mu.
+func (w *Watcher) removeTree(rel string) {
+	w.subtree(rel, func(sub string) {
+		if wd, ok := w.watches[sub]; ok {
+			// Watches of removed directories are already gone
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, sub)
+			delete(w.dirs, wd)
+		}
+	})
+}
+
+// rescan rebuilds the cache and the watches from scratch after events were
+// lost, and records that changes were missed. The caller holds w.mu.
+func (w *Watcher) rescan() {
+	old := w.dirs
+	clear(w.cache)
+	clear(w.children)
+	w.dirs = make(map[int32]string)
+	clear(w.watches)
+	if err := w.addTree(""); err != nil {
+		logger.Warn("WATCH", "%v", err)
+	}
+	// Directories moved out of the root in the meantime are still watched
+	for wd := range old {
+		if _, ok := w.dirs[wd]; !ok {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+		}
+	}
+	w.missed = true
+	w.signal()
+}
+
+// read handles the events of the watched directories until Close
+func (w *Watcher) read() {
+	buf := make([]byte, 256*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !stderrors.Is(err, os.ErrClosed) {
+				logger.Error("WATCH", "Stopped watching %s: %v", w.root, err)
+			}
+			return
+		}
+
+		w.mu.Lock()
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+size]
+			if i := bytes.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+			off += unix.SizeofInotifyEvent + size
+			w.handle(wd, mask, string(name))
+		}
+		w.mu.Unlock()
+	}
+}
+
+// handle updates the cache and the watches for a single event. The caller
+// holds w.mu.
+func (w *Watcher) handle(wd int32, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		logger.Warn("WATCH", "Too many changes at once, the event queue overflowed; rescanning %s", w.root)
+		w.rescan()
+		return
+	}
+
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		delete(w.watches, dir)
+		if dir == "" {
+			logger.Warn("WATCH", "%s was removed, no longer watching it", w.root)
+		}
+		return
+	}
+	if name == "" {
+		return
+	}
+
+	rel := filepath.Join(dir, name)
+	isDir := mask&unix.IN_ISDIR != 0
+	switch {
+	case isDir && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		if err := w.addTree(rel); err != nil {
+			logger.Warn("WATCH", "%v", err)
+		}
+		w.changed(rel)
+	case isDir && mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		w.removeTree(rel)
+		w.forget(rel)
+		w.changed(rel)
+	case isDir:
+		// Directory metadata is not synced
+	case mask&unix.IN_CREATE != 0:
+		// New files are reported once written, other new entries such as
+		// symbolic links right away
+		if info, err := os.Lstat(filepath.Join(w.root, rel)); err == nil && info.Mode().IsRegular() {
+			return
+		}
+		w.update(rel)
+	default:
+		w.update(rel)
+	}
+}
@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// nextChanges waits for changes and returns them once related events had
+// time to arrive
+func nextChanges(t *testing.T, w *Watcher) ([]string, bool) {
+	t.Helper()
+	select {
+	case <-w.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for changes")
+	}
+	time.Sleep(100 * time.Millisecond)
+	return w.Take()
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("b"), 0644)
+
+	w, err := New(root)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.Close()
+
+	steps := []struct {
+		name     string
+		change   func()
+		expected []string
+	}{
+		{"modified file", func() { os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed"), 0644) }, []string{"a.txt"}},
+		{"new directory", func() {
+			os.Mkdir(filepath.Join(root, "new"), 0755)
+			os.WriteFile(filepath.Join(root, "new", "c.txt"), []byte("c"), 0644)
+		}, []string{"new"}},
+		{"file in new directory", func() { os.WriteFile(filepath.Join(root, "new", "d.txt"), []byte("d"), 0644) }, []string{filepath.Join("new", "d.txt")}},
+		{"removed directory", func() { os.RemoveAll(filepath.Join(root, "sub")) }, []string{"sub"}},
+		{"renamed file", func() { os.Rename(filepath.Join(root, "a.txt"), filepath.Join(root, "e.txt")) }, []string{"a.txt", "e.txt"}},
+	}
+	for _, step := range steps {
+		step.change()
+		if paths, missed := nextChanges(t, w); !reflect.DeepEqual(paths, step.expected) || missed {
+			t.Errorf("%s: expected %v, got %v (missed %v)", step.name, step.expected, paths, missed)
+		}
+	}
+
+	// Metadata that did not change is not reported
+	os.Chmod(filepath.Join(root, "e.txt"), 0644)
+	select {
+	case <-w.Ready():
+		paths, _ := w.Take()
+		t.Errorf("Expected no changes, got %v", paths)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	root := t.TempDir()
+	w, err := New(root)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer w.Close()
+
+	// A directory whose events were lost with the overflow
+	w.mu.Lock()
+	os.MkdirAll(filepath.Join(root, "lost"), 0755)
+	w.handle(-1, unix.IN_Q_OVERFLOW, "")
+	w.mu.Unlock()
+
+	if _, missed := nextChanges(t, w); !missed {
+		t.Error("Expected an overflow to be reported as missed changes")
+	}
+	// The rescan watches what was created in the meantime
+	os.WriteFile(filepath.Join(root, "lost", "a.txt"), []byte("a"), 0644)
+	if paths, _ := nextChanges(t, w); !reflect.DeepEqual(paths, []string{filepath.Join("lost", "a.txt")}) {
+		t.Errorf("Expected changes below the rescanned directory, got %v", paths)
+	}
+}
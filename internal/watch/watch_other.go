@@ -0,0 +1,20 @@
+//go:build !linux
+
+package watch
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// platform is empty, watching is only supported on Linux
+type platform struct{}
+
+func (w *Watcher) start() error {
+	return fmt.Errorf("watching for changes is not supported on %s", runtime.GOOS)
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return nil
+}
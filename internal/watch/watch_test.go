@@ -0,0 +1,52 @@
+package watch
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTake(t *testing.T) {
+	w := &Watcher{ready: make(chan struct{}, 1), pending: map[string]bool{
+		"b.txt":                          true,
+		"dir":                            true,
+		filepath.Join("dir", "x.txt"):    true,
+		filepath.Join("dir", "sub", "y"): true,
+		"dir x":                          true,
+	}}
+
+	expected := []string{"b.txt", "dir", "dir x"}
+	if paths, missed := w.Take(); !reflect.DeepEqual(paths, expected) || missed {
+		t.Errorf("Expected %v, got %v (missed %v)", expected, paths, missed)
+	}
+	if paths, _ := w.Take(); len(paths) != 0 {
+		t.Errorf("Expected the changes to be cleared, got %v", paths)
+	}
+}
+
+func TestForget(t *testing.T) {
+	w := &Watcher{cache: map[string]meta{}, children: map[string]map[string]bool{}}
+	for _, rel := range []string{"b.txt", "dir", filepath.Join("dir", "x.txt"), filepath.Join("dir", "sub"), filepath.Join("dir", "sub", "y"), "dir x", filepath.Join("dir x", "z")} {
+		w.store(rel, meta{})
+	}
+
+	w.forget("dir")
+	var cached []string
+	for rel := range w.cache {
+		cached = append(cached, rel)
+	}
+	sort.Strings(cached)
+	expected := []string{"b.txt", "dir x", filepath.Join("dir x", "z")}
+	if !reflect.DeepEqual(cached, expected) {
+		t.Errorf("Expected %v cached, got %v", expected, cached)
+	}
+	if w.children[""]["dir"] || w.children["dir"] != nil || w.children[filepath.Join("dir", "sub")] != nil {
+		t.Errorf("Expected the removed subtree to be dropped from the directory index, got %v", w.children)
+	}
+
+	w.forget(filepath.Join("dir x", "z"))
+	if w.children["dir x"] != nil || !w.children[""]["dir x"] {
+		t.Errorf("Expected only the removed file to be dropped from the directory index, got %v", w.children)
+	}
+}
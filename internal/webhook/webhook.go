@@ -0,0 +1,176 @@
+// Package webhook posts the summary of a sync to chat webhooks of Slack,
+// Discord and Microsoft Teams, colored by success or failure.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Formats of the message posted
+const (
+	// FormatAuto picks the format from the host of the webhook URL
+	FormatAuto    = "auto"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+	FormatTeams   = "teams"
+)
+
+// Colors of the message, as RGB
+const (
+	colorSuccess = 0x2eb886
+	colorFailure = 0xa30200
+)
+
+// timeout limits a post, so a slow chat service cannot hold up the sync
+const timeout = 10 * time.Second
+
+// Summary is the outcome of a sync run
+type Summary struct {
+	Source  string
+	Targets []string
+	// Stats describes what was done, e.g. "12 copied, 3 deleted"
+	Stats    string
+	Duration time.Duration
+	// Err is the error the run failed with, nil on success
+	Err error
+}
+
+// title returns the first line of the message
+func (s *Summary) title() string {
+	if s.Err != nil {
+		return "snc: sync failed"
+	}
+	return "snc: sync completed"
+}
+
+// lines returns the body of the message
+func (s *Summary) lines() []string {
+	lines := []string{
+		fmt.Sprintf("%s → %s", s.Source, strings.Join(s.Targets, ", ")),
+		fmt.Sprintf("%s in %s", s.Stats, s.Duration.Round(time.Second)),
+	}
+	if s.Err != nil {
+		lines = append(lines, s.Err.Error())
+	}
+	return lines
+}
+
+func (s *Summary) color() int {
+	if s.Err != nil {
+		return colorFailure
+	}
+	return colorSuccess
+}
+
+// Post sends the summary to the webhook at rawURL in format, one of the
+// Format constants; empty means FormatAuto
+func Post(ctx context.Context, rawURL, format string, s *Summary) error {
+	if format == FormatAuto || format == "" {
+		format = DetectFormat(rawURL)
+	}
+	body, err := payload(format, s)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL holds the secret of the webhook, so only its host is shown
+		return fmt.Errorf("failed to post to %s: %w", req.URL.Host, unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook at %s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Redact returns rawURL with everything after the host hidden, since the
+// path and query of a webhook URL hold its secret
+func Redact(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "<redacted>"
+	}
+	return u.Scheme + "://" + u.Host + "/<redacted>"
+}
+
+// unwrapURLError drops the URL that net/http adds to errors
+func unwrapURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
+
+// DetectFormat returns the format for the webhook at rawURL by its host,
+// or FormatSlack, whose payload many other services accept, if the host
+// is unknown
+func DetectFormat(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return FormatSlack
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com"):
+		return FormatDiscord
+	case strings.HasSuffix(host, ".webhook.office.com") || strings.HasSuffix(host, ".logic.azure.com"):
+		return FormatTeams
+	}
+	return FormatSlack
+}
+
+// payload returns the JSON message for the summary in format
+func payload(format string, s *Summary) ([]byte, error) {
+	var msg any
+	switch format {
+	case FormatSlack:
+		msg = map[string]any{
+			"text": s.title(),
+			"attachments": []map[string]any{{
+				"color":    fmt.Sprintf("#%06x", s.color()),
+				"text":     strings.Join(s.lines(), "\n"),
+				"fallback": s.title(),
+			}},
+		}
+	case FormatDiscord:
+		msg = map[string]any{
+			"embeds": []map[string]any{{
+				"title":       s.title(),
+				"description": strings.Join(s.lines(), "\n"),
+				"color":       s.color(),
+			}},
+		}
+	case FormatTeams:
+		// Teams renders the text as Markdown, where paragraphs need a
+		// blank line between them
+		msg = map[string]any{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    s.title(),
+			"title":      s.title(),
+			"themeColor": fmt.Sprintf("%06X", s.color()),
+			"text":       strings.Join(s.lines(), "\n\n"),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported webhook format %q", format)
+	}
+	return json.Marshal(msg)
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPost(t *testing.T) {
+	var got map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("Expected a JSON body, got %q", body)
+		}
+	}))
+	defer server.Close()
+
+	summary := &Summary{
+		Source:   "/data",
+		Targets:  []string{"/mirror"},
+		Stats:    "2 copied",
+		Duration: 3 * time.Second,
+		Err:      errors.New("sync completed with errors"),
+	}
+	if err := Post(context.Background(), server.URL, FormatDiscord, summary); err != nil {
+		t.Fatalf("Post failed: %v", err)
+	}
+
+	embeds, _ := got["embeds"].([]any)
+	if len(embeds) != 1 {
+		t.Fatalf("Expected one embed, got %v", got)
+	}
+	embed := embeds[0].(map[string]any)
+	if embed["title"] != "snc: sync failed" || embed["color"] != float64(colorFailure) {
+		t.Errorf("Expected a red failure message, got %v", embed)
+	}
+	if desc, _ := embed["description"].(string); !strings.Contains(desc, "2 copied in 3s") || !strings.Contains(desc, "with errors") {
+		t.Errorf("Expected the stats and the error in the message, got %q", desc)
+	}
+}
+
+func TestPostRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := Post(context.Background(), server.URL+"/secret", FormatSlack, &Summary{})
+	if err == nil || !strings.Contains(err.Error(), "invalid_token") {
+		t.Fatalf("Expected the response in the error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected the webhook path to be left out of the error, got %v", err)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		"https://hooks.slack.com/services/T0/B0/x":          FormatSlack,
+		"https://discord.com/api/webhooks/1/x":              FormatDiscord,
+		"https://contoso.webhook.office.com/webhookb2/x":    FormatTeams,
+		"https://prod-1.westus.logic.azure.com/workflows/x": FormatTeams,
+		"https://chat.example.com/hooks/x":                  FormatSlack,
+	}
+	for url, want := range tests {
+		if got := DetectFormat(url); got != want {
+			t.Errorf("DetectFormat(%s) = %s, expected %s", url, got, want)
+		}
+	}
+}
+
+func TestPayloadColors(t *testing.T) {
+	ok := &Summary{Stats: "1 copied"}
+	for _, format := range []string{FormatSlack, FormatDiscord, FormatTeams} {
+		data, err := payload(format, ok)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !strings.Contains(strings.ToLower(string(data)), "2eb886") && !strings.Contains(string(data), "3061894") {
+			t.Errorf("%s: expected the success color, got %s", format, data)
+		}
+	}
+	if _, err := payload("irc", ok); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,236 @@
+// Package snc exposes the snc synchronization engine to other Go programs.
+//
+// A minimal embedding looks like this:
+//
+//	s, err := snc.New(snc.Options{
+//		Source:        "/data",
+//		Target:        "/backup/data",
+//		DeleteMissing: true,
+//		Progress: func(p snc.Progress) {
+//			fmt.Println(p.Operation, p.Path)
+//		},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := s.Run(ctx)
+//
+// Programs that need more detail (skipped files, itemized change codes,
+// status messages) can implement EventSink and pass it in Options.Events.
+//
+// The engine still writes its log through the process-wide logger used by
+// the snc binary; Options.LogOutput and Options.LogLevel configure it.
+package snc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"snc/internal/config"
+	"snc/internal/events"
+	"snc/internal/logger"
+	"snc/internal/stream"
+	"snc/internal/synchronizer"
+	"sync"
+	"time"
+)
+
+// Update methods accepted in Options.UpdateMethod
+const (
+	UpdateModTime = "modtime"
+	UpdateSHA256  = "sha256"
+	UpdateSize    = "size"
+)
+
+// Options configures a Syncer
+type Options struct {
+	// Source and Target are the directories to synchronize. Source must
+	// exist; Target is created if missing.
+	Source string
+	Target string
+
+	// DeleteMissing removes files from Target that do not exist in Source
+	DeleteMissing bool
+
+	// UpdateMethod selects how changed files are detected. Defaults to
+	// UpdateModTime.
+	UpdateMethod string
+
+	// StrategyMap selects update methods per file pattern, e.g.
+	// "*.iso=size,*.db=sha256". Files matching no pattern use UpdateMethod.
+	StrategyMap string
+
+	// Progress, if set, is called for every file copied, updated, deleted
+	// or failed. Calls are made from the goroutine running Run.
+	Progress func(Progress)
+
+	// Events, if set, receives every event emitted by the engine
+	Events EventSink
+
+	// LogOutput receives the engine's log messages. Defaults to io.Discard.
+	LogOutput io.Writer
+	// LogLevel is one of error, warn, info, debug, trace. Defaults to info.
+	LogLevel string
+}
+
+// Operation identifies what happened to a file
+type Operation string
+
+const (
+	OperationCopy   Operation = "copy"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+	OperationError  Operation = "error"
+)
+
+// Progress reports a single file operation
+type Progress struct {
+	Operation Operation
+	// Path is relative to Source (or Target for deletions); for errors
+	// raised before a relative path is known it may be absolute
+	Path string
+	// Bytes written to the target, for copies and updates
+	Bytes int64
+	// Err is set for OperationError
+	Err error
+}
+
+// EventSink receives the events emitted by the engine. Methods are called
+// synchronously from the goroutine running Run.
+type EventSink = events.EventSink
+
+// Event types delivered to an EventSink
+type (
+	FileEvent     = events.FileEvent
+	ErrorEvent    = events.ErrorEvent
+	ProgressEvent = events.ProgressEvent
+	Level         = events.Level
+)
+
+// Severity levels of a ProgressEvent
+const (
+	LevelWarn  = events.LevelWarn
+	LevelInfo  = events.LevelInfo
+	LevelDebug = events.LevelDebug
+	LevelTrace = events.LevelTrace
+)
+
+// Result summarizes a completed run
+type Result struct {
+	Copied      int
+	Updated     int
+	Deleted     int
+	Errors      int
+	BytesCopied int64
+	Duration    time.Duration
+}
+
+// Syncer runs synchronizations with a fixed set of options
+type Syncer struct {
+	cfg  *config.Config
+	opts Options
+}
+
+// runMu serializes runs, as the engine's logger is process-wide
+var runMu sync.Mutex
+
+// New validates opts and returns a Syncer
+func New(opts Options) (*Syncer, error) {
+	if opts.Source == "" || opts.Target == "" {
+		return nil, fmt.Errorf("snc: source and target are required")
+	}
+	if opts.UpdateMethod == "" {
+		opts.UpdateMethod = UpdateModTime
+	}
+	if _, err := stream.NewStrategySelector(opts.StrategyMap, opts.UpdateMethod); err != nil {
+		return nil, fmt.Errorf("snc: %w", err)
+	}
+	if opts.LogOutput == nil {
+		opts.LogOutput = io.Discard
+	}
+	if opts.LogLevel == "" {
+		opts.LogLevel = "info"
+	}
+
+	cfg := &config.Config{
+		Command:       config.CommandSync,
+		Source:        opts.Source,
+		Target:        opts.Target,
+		DeleteMissing: opts.DeleteMissing,
+		LogLevel:      opts.LogLevel,
+		UpdateMethod:  opts.UpdateMethod,
+		StrategyMap:   opts.StrategyMap,
+	}
+	return &Syncer{cfg: cfg, opts: opts}, nil
+}
+
+// staticProvider adapts a fixed config to config.ConfigProvider
+type staticProvider struct {
+	cfg *config.Config
+}
+
+func (p staticProvider) Config() *config.Config {
+	return p.cfg
+}
+
+// Run performs one synchronization. It stops early when ctx is cancelled,
+// returning ctx's error together with the partial result.
+func (s *Syncer) Run(ctx context.Context) (*Result, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	logger.SetOutput(s.opts.LogOutput)
+	logger.SetLevelFromString(s.opts.LogLevel)
+
+	result := &Result{}
+	opts := []synchronizer.Option{
+		synchronizer.WithEventSink(&resultSink{result: result, progress: s.opts.Progress}),
+	}
+	if s.opts.Events != nil {
+		opts = append(opts, synchronizer.WithEventSink(s.opts.Events))
+	}
+
+	start := time.Now()
+	err := synchronizer.NewSynchronizer(staticProvider{cfg: s.cfg}, opts...).Sync(ctx)
+	result.Duration = time.Since(start)
+	return result, err
+}
+
+// resultSink tallies events into a Result and forwards them to the
+// Progress callback
+type resultSink struct {
+	result   *Result
+	progress func(Progress)
+}
+
+func (r *resultSink) FileCopied(ev events.FileEvent) {
+	op := OperationCopy
+	if ev.Update {
+		op = OperationUpdate
+		r.result.Updated++
+	} else {
+		r.result.Copied++
+	}
+	r.result.BytesCopied += ev.Bytes
+	r.emit(Progress{Operation: op, Path: ev.Path, Bytes: ev.Bytes})
+}
+
+func (r *resultSink) FileSkipped(events.FileEvent) {}
+
+func (r *resultSink) FileDeleted(ev events.FileEvent) {
+	r.result.Deleted++
+	r.emit(Progress{Operation: OperationDelete, Path: ev.Path})
+}
+
+func (r *resultSink) Error(ev events.ErrorEvent) {
+	r.result.Errors++
+	r.emit(Progress{Operation: OperationError, Path: ev.Path, Err: ev.Err})
+}
+
+func (r *resultSink) Progress(events.ProgressEvent) {}
+
+func (r *resultSink) emit(p Progress) {
+	if r.progress != nil {
+		r.progress(p)
+	}
+}
@@ -0,0 +1,174 @@
+package snc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"snc/internal/events"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Options
+		expectError bool
+	}{
+		{
+			name: "defaults",
+			opts: Options{Source: "/source", Target: "/target"},
+		},
+		{
+			name: "sha256 method",
+			opts: Options{Source: "/source", Target: "/target", UpdateMethod: UpdateSHA256},
+		},
+		{
+			name:        "missing target",
+			opts:        Options{Source: "/source"},
+			expectError: true,
+		},
+		{
+			name:        "invalid method",
+			opts:        Options{Source: "/source", Target: "/target", UpdateMethod: "invalid"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.opts)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if s.cfg.UpdateMethod == "" {
+				t.Error("Expected default update method to be set")
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	// Create temporary test directories
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.MkdirAll(dstDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "new.txt"), []byte("12345"), 0644)
+	os.WriteFile(filepath.Join(dstDir, "extra.txt"), []byte("extra"), 0644)
+
+	var events []Progress
+	s, err := New(Options{
+		Source:        srcDir,
+		Target:        dstDir,
+		DeleteMissing: true,
+		Progress: func(p Progress) {
+			events = append(events, p)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	result, err := s.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if result.Copied != 1 || result.Deleted != 1 || result.Errors != 0 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if result.BytesCopied != 5 {
+		t.Errorf("Expected 5 bytes copied, got %d", result.BytesCopied)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 progress events, got %d: %v", len(events), events)
+	}
+	if events[0].Operation != OperationCopy || events[0].Path != "new.txt" {
+		t.Errorf("Unexpected first event: %+v", events[0])
+	}
+	if events[1].Operation != OperationDelete || events[1].Path != "extra.txt" {
+		t.Errorf("Unexpected second event: %+v", events[1])
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	// Create temporary test directories
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "source")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	s, err := New(Options{Source: srcDir, Target: filepath.Join(tempDir, "destination")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := s.Run(ctx)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if result.Copied != 0 {
+		t.Errorf("Expected no files copied after cancellation, got %d", result.Copied)
+	}
+}
+
+// recordingSink collects the events it receives
+type recordingSink struct {
+	events.Nop
+	skipped []FileEvent
+}
+
+func (r *recordingSink) FileSkipped(ev FileEvent) {
+	r.skipped = append(r.skipped, ev)
+}
+
+func TestRunWithEventSink(t *testing.T) {
+	// Create temporary test directories
+	tempDir, err := os.MkdirTemp("", "sync_test_*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	srcDir := filepath.Join(tempDir, "source")
+	dstDir := filepath.Join(tempDir, "destination")
+	os.MkdirAll(srcDir, 0755)
+	os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("content"), 0644)
+
+	sink := &recordingSink{}
+	s, err := New(Options{Source: srcDir, Target: dstDir, Events: sink})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The first run copies, the second finds the file unchanged
+	for i := 0; i < 2; i++ {
+		if _, err := s.Run(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if len(sink.skipped) != 1 || sink.skipped[0].Path != "file.txt" {
+		t.Errorf("Expected file.txt to be skipped once, got %v", sink.skipped)
+	}
+}